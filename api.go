@@ -4,27 +4,20 @@ import (
     "fmt"
     "net/http"
     "strings"
-    "time"
 
     // 3rd-party
     assetfs "github.com/elazarl/go-bindata-assetfs"
     "github.com/gin-gonic/contrib/static"
     "github.com/gin-gonic/gin"
     "github.com/jmoiron/sqlx"
-    sqlite "github.com/mattn/go-sqlite3"
 )
 
-func bootAPI(db *Database, portNum int, appPath string, mathjaxPath string) {
-
-    var err error
-
-    /* bootstrap data */
-    _, err = GetRootDeck(db.instance)
-    exitIfErr(err, 1)
+func bootAPI(profiles *Profiles, portNum int, appPath string, mathjaxPath string, syncDir string) {
 
     /* set up REST api */
 
-    injectDB := bindDB(db.instance)
+    injectDB := bindDB(profiles)
+    injectProfiles := bindProfiles(profiles)
 
     api := gin.Default()
 
@@ -49,95 +42,7 @@ func bootAPI(db *Database, portNum int, appPath string, mathjaxPath string) {
         })
     })
 
-    api.POST("/backup", func(ctx *gin.Context) {
-
-        var (
-            err           error
-            backupRequest *StringMap         = &StringMap{}
-            sqliteConnSrc *sqlite.SQLiteConn = db.sqliteConn // source of backup
-        )
-
-        err = ctx.BindJSON(backupRequest)
-        if err != nil {
-
-            ctx.JSON(http.StatusBadRequest, gin.H{
-                "status":           http.StatusBadRequest,
-                "developerMessage": err.Error(),
-                "userMessage":      "bad JSON input",
-            })
-            ctx.Error(err)
-            return
-        }
-
-        // set and validate backup db file name
-        // if _, hasDeckKey := (*patch)["deck"]; hasDeckKey == true {
-        // }
-
-        // create backup filename
-        backupName := fmt.Sprintf("%s.%s", db.name, time.Now().Format("2006-01-02T15.04.05.999999999Z07.00")) // filename compat RFC3339
-
-        // create backup db
-        var dbDest *Database
-        dbDest, err = FetchDatabase(backupName)
-        defer dbDest.CleanUp()
-
-        if err != nil {
-
-            ctx.JSON(http.StatusInternalServerError, gin.H{
-                "status":           http.StatusInternalServerError,
-                "developerMessage": err.Error(),
-                "userMessage":      "unable to create backup db",
-            })
-            ctx.Error(err)
-            return
-        }
-
-        // begin backing up
-        // ref: https://www.sqlite.org/c3ref/backup_finish.html#sqlite3backupinit
-        var backupDest *sqlite.SQLiteBackup
-        backupDest, err = dbDest.sqliteConn.Backup("main", sqliteConnSrc, "main")
-
-        if err != nil {
-
-            ctx.JSON(http.StatusInternalServerError, gin.H{
-                "status":           http.StatusInternalServerError,
-                "developerMessage": err.Error(),
-                "userMessage":      "unable to backup db",
-            })
-            ctx.Error(err)
-            return
-        }
-
-        _, err = backupDest.Step(-1)
-
-        if err != nil {
-
-            ctx.JSON(http.StatusInternalServerError, gin.H{
-                "status":           http.StatusInternalServerError,
-                "developerMessage": err.Error(),
-                "userMessage":      "unable to backup db",
-            })
-            ctx.Error(err)
-            return
-        }
-
-        err = backupDest.Finish()
-
-        if err != nil {
-
-            ctx.JSON(http.StatusInternalServerError, gin.H{
-                "status":           http.StatusInternalServerError,
-                "developerMessage": err.Error(),
-                "userMessage":      "unable to backup db",
-            })
-            ctx.Error(err)
-            return
-        }
-
-        // success
-        ctx.Writer.WriteHeader(http.StatusOK)
-        return
-    })
+    api.POST("/backup", injectProfiles(BackupPOST))
 
     // decks group
     decksAPI := api.Group("/decks")
@@ -165,6 +70,18 @@ func bootAPI(db *Database, portNum int, appPath string, mathjaxPath string) {
 
         // get card within the deck to be reviewed
         decksAPI.GET("/:id/review", injectDB(ReviewDeckGET))
+
+        // export cards within the deck subtree as CSV/TSV
+        decksAPI.GET("/:id/export", injectDB(DeckExportGET))
+
+        // clusters of near-duplicate cards within the deck subtree
+        decksAPI.GET("/:id/duplicates", injectDB(DeckDuplicatesGET))
+
+        // move cards and child decks into another deck
+        decksAPI.POST("/:id/merge", injectDB(DeckMergePOST))
+
+        // copy the deck subtree
+        decksAPI.POST("/:id/clone", injectDB(DeckClonePOST))
     }
 
     cardsAPI := api.Group("/cards")
@@ -172,6 +89,12 @@ func bootAPI(db *Database, portNum int, appPath string, mathjaxPath string) {
 
         cardsAPI.POST("/", injectDB(CardPOST))
 
+        // create cards from CSV/TSV data
+        cardsAPI.POST("/import", injectDB(CardsImportPOST))
+
+        // apply an operation onto many cards at once
+        cardsAPI.POST("/bulk", injectDB(CardsBulkPOST))
+
         cardsAPI.GET("/:id", injectDB(CardGET))
 
         // TODO: implement
@@ -184,6 +107,14 @@ func bootAPI(db *Database, portNum int, appPath string, mathjaxPath string) {
         cardsAPI.DELETE("/:id", injectDB(CardDELETE))
 
         cardsAPI.PATCH("/:id/review", injectDB(ReviewCardPATCH))
+
+        // previous versions of the card
+        cardsAPI.GET("/:id/revisions", injectDB(CardRevisionsGET))
+
+        cardsAPI.POST("/:id/revisions/:rev/revert", injectDB(CardRevisionRevertPOST))
+
+        // merge another card into the card
+        cardsAPI.POST("/:id/merge", injectDB(CardMergePOST))
     }
 
     stashesAPI := api.Group("/stashes")
@@ -200,11 +131,101 @@ func bootAPI(db *Database, portNum int, appPath string, mathjaxPath string) {
 
         stashesAPI.PUT("/:id", injectDB(StashPUT))
 
+        // evaluate set expression over stashes and decks
+        stashesAPI.POST("/query", injectDB(StashSetQueryPOST))
+
+        // replace the saved filter of a smart stash
+        stashesAPI.PUT("/:id/filter", injectDB(StashFilterPUT))
+
+        // review deadline of a stash; stashes are archived once their deadline passes
+        stashesAPI.PUT("/:id/deadline", injectDB(StashDeadlinePUT))
+
+        stashesAPI.DELETE("/:id/deadline", injectDB(StashDeadlineDELETE))
+
+        // order of cards within a manual stash
+        stashesAPI.PUT("/:id/order", injectDB(StashOrderPUT))
+
+        stashesAPI.PATCH("/:id/cards/:card", injectDB(StashCardPositionPATCH))
+
         stashesAPI.GET("/:id/cards", injectDB(StashCardsGET))
 
         stashesAPI.GET("/:id/cards/count", injectDB(StashCardsCountGET))
 
         stashesAPI.GET("/:id/review", injectDB(ReviewStashGET))
+
+        stashesAPI.GET("/:id/export", injectDB(StashExportGET))
+    }
+
+    // links between cards
+    linksAPI := api.Group("/links")
+    {
+        linksAPI.GET("/dangling", injectDB(DanglingLinksGET))
+    }
+
+    mediaAPI := api.Group("/media")
+    {
+        mediaAPI.GET("/", injectDB(MediaListGET))
+
+        mediaAPI.POST("/", injectDB(MediaPOST))
+
+        // delete unreferenced media
+        mediaAPI.POST("/gc", injectDB(MediaGCPOST))
+
+        mediaAPI.GET("/:hash", injectDB(MediaGET))
+    }
+
+    trashAPI := api.Group("/trash")
+    {
+        trashAPI.GET("/", injectDB(TrashListGET))
+
+        // permanently delete every trashed item
+        trashAPI.DELETE("/", injectDB(TrashEmptyDELETE))
+
+        trashAPI.POST("/:id/restore", injectDB(TrashRestorePOST))
+
+        // permanently delete trashed item
+        trashAPI.DELETE("/:id", injectDB(TrashDELETE))
+    }
+
+    syncAPI := api.Group("/sync")
+    {
+        // sync decks and cards with the markdown sync directory (if any)
+        syncAPI.POST("/markdown", injectDB(MarkdownSyncPOST(syncDir)))
+
+        // merge-based sync with another grokdb database
+        syncAPI.GET("/snapshot", injectDB(SyncSnapshotGET))
+
+        syncAPI.POST("/snapshot", injectDB(SyncSnapshotPOST))
+
+        syncAPI.POST("/peer", injectDB(SyncPeerPOST))
+    }
+
+    // profile databases; switch the profile in use
+    profilesAPI := api.Group("/profiles")
+    {
+        profilesAPI.GET("/", injectProfiles(ProfilesGET))
+
+        profilesAPI.POST("/", injectProfiles(ProfilePOST))
+
+        profilesAPI.PUT("/current", injectProfiles(ProfileCurrentPUT))
+    }
+
+    // backups of the profile in use
+    backupsAPI := api.Group("/backups")
+    {
+        backupsAPI.GET("/", injectProfiles(BackupsGET))
+
+        backupsAPI.POST("/prune", injectProfiles(BackupsPrunePOST))
+
+        backupsAPI.POST("/:name/restore", injectProfiles(BackupRestorePOST))
+    }
+
+    // database maintenance
+    adminAPI := api.Group("/admin")
+    {
+        adminAPI.GET("/fsck", injectDB(FsckGET))
+
+        adminAPI.POST("/fsck", injectDB(FsckPOST))
     }
 
     configsAPI := api.Group("/configs")
@@ -219,10 +240,21 @@ func bootAPI(db *Database, portNum int, appPath string, mathjaxPath string) {
 
 /* helpers */
 
-func bindDB(db *sqlx.DB) func(func(*sqlx.DB, *gin.Context)) func(*gin.Context) {
+// handlers are given the database of the profile in use
+func bindDB(profiles *Profiles) func(func(*sqlx.DB, *gin.Context)) func(*gin.Context) {
     return func(handler func(*sqlx.DB, *gin.Context)) func(*gin.Context) {
         return func(ctx *gin.Context) {
-            handler(db, ctx)
+            profiles.Use(func(db *Database) {
+                handler(db.instance, ctx)
+            })
+        }
+    }
+}
+
+func bindProfiles(profiles *Profiles) func(func(*Profiles, *gin.Context)) func(*gin.Context) {
+    return func(handler func(*Profiles, *gin.Context)) func(*gin.Context) {
+        return func(ctx *gin.Context) {
+            handler(profiles, ctx)
         }
     }
 }
@@ -0,0 +1,181 @@
+package main
+
+import (
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "mime"
+    "path/filepath"
+    "regexp"
+    "strings"
+
+    // 3rd-party
+    "github.com/gin-gonic/gin"
+    "github.com/jmoiron/sqlx"
+)
+
+/* variables */
+
+// errors
+var ErrAudioNoSuchMedia = errors.New("audio: no such media of given file name or hash")
+var ErrAudioInvalidMedia = errors.New("audio: imported media must be base64 encoded")
+
+// markers within a card's front/back that are played during review:
+//
+// [audio:<hash>]: audio media; see POST /media
+// [tts:<lang>:<text>]: text to be spoken by the client's text-to-speech; e.g. [tts:es:hola]
+var cardAssetMarkerRegexp = regexp.MustCompile(`\[(audio|tts):([^\]\n]+)\]`)
+
+// anki's audio marker; e.g. [sound:hola.mp3]
+var ankiSoundMarkerRegexp = regexp.MustCompile(`\[sound:([^\]\n]+)\]`)
+
+/* helpers */
+
+func AudioMarker(hash string) string {
+    return fmt.Sprintf("[audio:%s]", hash)
+}
+
+// list playable assets of the card's front and back; in order of appearance.
+// audio markers are resolved to media urls.
+func CardAssets(db sqlx.Ext, card *CardRow) []gin.H {
+
+    var assets []gin.H = []gin.H{}
+
+    var sides = []struct {
+        name string
+        text string
+    }{
+        {"front", card.Front},
+        {"back", card.Back},
+    }
+
+    for _, side := range sides {
+        for _, match := range cardAssetMarkerRegexp.FindAllStringSubmatch(side.text, -1) {
+
+            var (
+                kind    string = match[1]
+                payload string = strings.TrimSpace(match[2])
+            )
+
+            switch kind {
+            case "audio":
+
+                var hash string = strings.ToLower(payload)
+
+                if !mediaHashRegexp.MatchString(hash) {
+                    continue
+                }
+
+                var asset gin.H = gin.H{
+                    "side":      side.name,
+                    "kind":      kind,
+                    "marker":    match[0],
+                    "hash":      hash,
+                    "url":       MediaURL(hash),
+                    "available": false,
+                }
+
+                // swallow error; missing media is reported as unavailable
+                media, err := GetMediaMeta(db, hash)
+                if err == nil {
+                    asset["available"] = true
+                    asset["mime"] = media.MIME
+                }
+
+                assets = append(assets, asset)
+
+            case "tts":
+
+                var parts []string = strings.SplitN(payload, ":", 2)
+
+                if len(parts) != 2 || len(strings.TrimSpace(parts[1])) <= 0 {
+                    continue
+                }
+
+                assets = append(assets, gin.H{
+                    "side":   side.name,
+                    "kind":   kind,
+                    "marker": match[0],
+                    "lang":   strings.TrimSpace(parts[0]),
+                    "text":   strings.TrimSpace(parts[1]),
+                })
+            }
+        }
+    }
+
+    return assets
+}
+
+// store media given for an import; keyed by file name. media is base64 encoded.
+// returns hashes of the stored media keyed by file name.
+func ImportMedia(db sqlx.Ext, files map[string]string) (map[string]string, error) {
+
+    var hashes map[string]string = make(map[string]string, len(files))
+
+    for name, encoded := range files {
+
+        data, err := base64.StdEncoding.DecodeString(encoded)
+        if err != nil || len(data) <= 0 {
+            return nil, ErrAudioInvalidMedia
+        }
+
+        var media *MediaRow
+        media, _, err = CreateMedia(db, data, NormalizeMediaMIME(mime.TypeByExtension(filepath.Ext(name)), data))
+        if err != nil {
+            return nil, err
+        }
+
+        hashes[name] = media.Hash
+    }
+
+    return hashes, nil
+}
+
+// resolve the given media file name or hash into an audio marker
+func ResolveAudioMarker(db sqlx.Ext, media map[string]string, ref string) (string, error) {
+
+    ref = strings.TrimSpace(ref)
+
+    if hash, hasFile := media[ref]; hasFile {
+        return AudioMarker(hash), nil
+    }
+
+    var hash string = strings.ToLower(ref)
+
+    if !mediaHashRegexp.MatchString(hash) {
+        return "", ErrAudioNoSuchMedia
+    }
+
+    _, err := GetMediaMeta(db, hash)
+    switch {
+    case err == ErrMediaNoSuchMedia:
+        return "", ErrAudioNoSuchMedia
+    case err != nil:
+        return "", err
+    }
+
+    return AudioMarker(hash), nil
+}
+
+// replace anki's [sound:<file>] markers with audio markers
+func ResolveAnkiSoundMarkers(db sqlx.Ext, media map[string]string, text string) (string, error) {
+
+    var resolveErr error
+
+    text = ankiSoundMarkerRegexp.ReplaceAllStringFunc(text, func(marker string) string {
+
+        var ref string = ankiSoundMarkerRegexp.FindStringSubmatch(marker)[1]
+
+        resolved, err := ResolveAudioMarker(db, media, ref)
+        if err != nil {
+            if resolveErr == nil {
+                resolveErr = fmt.Errorf("%s: %s", err.Error(), ref)
+            }
+            return marker
+        }
+
+        return resolved
+    })
+
+    return text, resolveErr
+}
@@ -0,0 +1,778 @@
+package main
+
+import (
+    "compress/gzip"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "hash"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    // 3rd-party
+    "github.com/gin-gonic/gin"
+    "github.com/jmoiron/sqlx"
+)
+
+/* variables */
+
+// errors
+var ErrBackupNoSuchBackup = errors.New("backups: no backup of given name")
+var ErrBackupInvalidSetting = errors.New("backups: setting must be a non-negative integer")
+var ErrBackupInvalidName = errors.New("backups: name must be a file name without a directory")
+var ErrBackupInvalidDir = errors.New("backups: dir must be an existing directory")
+var ErrBackupNotVerified = errors.New("backups: backup failed its integrity check")
+
+// extension of gzip-compressed backups; e.g. <name>.db.gz
+const BACKUP_GZIP_EXT string = ".gz"
+
+// retention of backups; the newest backup of each of the last n days (or weeks) is kept
+const CONFIG_BACKUP_KEEP_DAILY string = "backup_keep_daily"
+const DEFAULT_BACKUP_KEEP_DAILY uint64 = 7
+const CONFIG_BACKUP_KEEP_WEEKLY string = "backup_keep_weekly"
+const DEFAULT_BACKUP_KEEP_WEEKLY uint64 = 4
+
+// scheduled backups; 0 disables either
+const CONFIG_BACKUP_INTERVAL_HOURS string = "backup_interval_hours"
+const CONFIG_BACKUP_AFTER_REVIEWS string = "backup_after_reviews"
+
+/* types */
+
+type BackupRequest struct {
+    Dir  string `json:"dir"`
+    Name string `json:"name"`
+    Gzip bool   `json:"gzip"`
+}
+
+type BackupFile struct {
+    Name       string
+    Filename   string
+    Size       int64
+    Compressed bool
+    CreatedAt  time.Time
+}
+
+/* REST Handlers */
+
+// POST /backup
+//
+// Back up the profile in use. The backup is verified with an integrity check; a backup
+// that fails it is removed. Backups into another directory, or of another name, aren't
+// listed by GET /backups, nor are they pruned.
+//
+// Input:
+// dir (optional): existing directory to back up into; by default, the database's directory
+// name (optional): file name of the backup; by default, <database name>.<timestamp>.db
+// gzip (optional): if true, the backup is gzip-compressed; i.e. <name>.gz
+func BackupPOST(profiles *Profiles, ctx *gin.Context) {
+
+    var (
+        err           error
+        backupRequest BackupRequest
+    )
+
+    err = ctx.BindJSON(&backupRequest)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "bad JSON input",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    // set and validate backup db file name
+    backupRequest.Name, err = ValidateBackupName(backupRequest.Name)
+    if err == nil {
+        err = ValidateBackupDir(backupRequest.Dir)
+    }
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      err.Error(),
+        })
+        ctx.Error(err)
+        return
+    }
+
+    if backupRequest.Gzip && len(backupRequest.Name) > 0 {
+        // the uncompressed backup is removed once compressed
+        _, err = os.Stat(filepath.Join(backupRequest.Dir, backupRequest.Name+BACKUP_GZIP_EXT))
+        if err == nil {
+            err = ErrDatabaseBackupExists
+        } else if os.IsNotExist(err) {
+            err = nil
+        }
+    }
+
+    var backupName string
+    if err == nil {
+        profiles.Use(func(db *Database) {
+            backupName, err = db.BackupTo(backupRequest.Dir, backupRequest.Name)
+        })
+    }
+    switch {
+    case err == ErrDatabaseBackupExists:
+        ctx.JSON(http.StatusConflict, gin.H{
+            "status":           http.StatusConflict,
+            "developerMessage": err.Error(),
+            "userMessage":      "a file of given name already exists",
+        })
+        ctx.Error(err)
+        return
+    case err != nil:
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to backup db",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var verified bool
+    verified, err = VerifyBackup(backupName)
+    if err == nil && !verified {
+        // a corrupt backup isn't kept; it'd be mistaken for a good one
+        err = ErrBackupNotVerified
+        if removeErr := os.Remove(backupName); removeErr != nil {
+            err = removeErr
+        }
+    }
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to verify backup",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    if backupRequest.Gzip {
+        backupName, err = CompressBackup(backupName)
+        if err != nil {
+            ctx.JSON(http.StatusInternalServerError, gin.H{
+                "status":           http.StatusInternalServerError,
+                "developerMessage": err.Error(),
+                "userMessage":      "unable to compress backup",
+            })
+            ctx.Error(err)
+            return
+        }
+    }
+
+    var size int64
+    var checksum string
+    size, checksum, err = FileChecksum(backupName)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to checksum backup",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    // success
+    ctx.JSON(http.StatusOK, gin.H{
+        "name":     filepath.Base(backupName),
+        "path":     backupName,
+        "size":     size,
+        "checksum": checksum,
+        "verified": verified,
+        "gzip":     backupRequest.Gzip,
+    })
+}
+
+// GET /backups
+//
+// List backups of the profile in use; newest first.
+func BackupsGET(profiles *Profiles, ctx *gin.Context) {
+
+    var (
+        err     error
+        backups []BackupFile
+    )
+
+    profiles.Use(func(db *Database) {
+        backups, err = ListBackups(db)
+    })
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to list backups",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    ctx.JSON(http.StatusOK, BackupFilesToResponse(backups))
+}
+
+// POST /backups/:name/restore
+//
+// Replace the profile in use with the backup. A backup is taken beforehand, so the
+// restore can be undone. Other requests wait until the restore is done.
+//
+// Params:
+// name: file name of the backup; see GET /backups
+func BackupRestorePOST(profiles *Profiles, ctx *gin.Context) {
+
+    var (
+        err          error
+        backupName   string = ctx.Param("name")
+        preRestore   string
+        notFound     bool = false
+        restoredFrom *BackupFile
+    )
+
+    profiles.UseExclusive(func(db *Database) {
+
+        restoredFrom, err = GetBackup(db, backupName)
+        if err != nil {
+            notFound = err == ErrBackupNoSuchBackup
+            return
+        }
+
+        preRestore, err = db.Backup()
+        if err != nil {
+            return
+        }
+
+        // markdown sync runs apart from requests; it mustn't use the database while it's replaced
+        PauseMarkdownSync(func() {
+            err = RestoreBackup(db, restoredFrom)
+        })
+    })
+
+    switch {
+    case notFound:
+        ctx.JSON(http.StatusNotFound, gin.H{
+            "status":           http.StatusNotFound,
+            "developerMessage": err.Error(),
+            "userMessage":      "cannot find backup by name",
+        })
+        ctx.Error(err)
+        return
+    case err == ErrDatabaseCorrupt:
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "backup is corrupt",
+        })
+        ctx.Error(err)
+        return
+    case err != nil:
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to restore backup",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    ctx.JSON(http.StatusOK, gin.H{
+        "restored":    restoredFrom.Name,
+        "pre_restore": filepath.Base(preRestore),
+    })
+}
+
+// POST /backups/prune
+//
+// Delete backups beyond the retention of the backup_keep_daily (default: 7) and
+// backup_keep_weekly (default: 4) config settings; i.e. keep the newest backup of
+// each of the last 7 days, and of each of the last 4 weeks. The newest backup is
+// always kept.
+//
+// Query params:
+// dry_run: if true, list backups that would be deleted without deleting them
+func BackupsPrunePOST(profiles *Profiles, ctx *gin.Context) {
+
+    var (
+        err     error
+        dryRun  bool = strings.ToLower(ctx.Query("dry_run")) == "true"
+        pruned  []BackupFile
+        kept    []BackupFile
+        backups []BackupFile
+    )
+
+    profiles.Use(func(db *Database) {
+
+        backups, err = ListBackups(db)
+        if err != nil {
+            return
+        }
+
+        var keepDaily, keepWeekly uint64
+        keepDaily, keepWeekly, err = GetBackupRetention(db.instance)
+        if err != nil {
+            return
+        }
+
+        kept, pruned = SelectBackupsToPrune(backups, keepDaily, keepWeekly)
+
+        if !dryRun {
+            err = DeleteBackups(pruned)
+        }
+    })
+
+    switch {
+    case err == ErrBackupInvalidSetting:
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "backup retention settings are invalid",
+        })
+        ctx.Error(err)
+        return
+    case err != nil:
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to prune backups",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    ctx.JSON(http.StatusOK, gin.H{
+        "dry_run": dryRun,
+        "pruned":  BackupFilesToResponse(pruned),
+        "kept":    BackupFilesToResponse(kept),
+    })
+}
+
+/* helpers */
+
+func BackupFilesToResponse(backups []BackupFile) []gin.H {
+
+    var response []gin.H = make([]gin.H, 0, len(backups))
+
+    for _, backup := range backups {
+        response = append(response, gin.H{
+            "name":       backup.Name,
+            "size":       backup.Size,
+            "gzip":       backup.Compressed,
+            "created_at": backup.CreatedAt.Unix(),
+        })
+    }
+
+    return response
+}
+
+// backups of the database; i.e. <name>.<timestamp>.db (or .db.gz) alongside it. newest first.
+func ListBackups(db *Database) ([]BackupFile, error) {
+
+    var (
+        dir    string = filepath.Dir(db.filename)
+        prefix string = strings.TrimSuffix(filepath.Base(db.filename), DATABASE_FILE_EXT) + "."
+    )
+
+    files, err := ioutil.ReadDir(dir)
+    if err != nil {
+        return nil, err
+    }
+
+    var backups []BackupFile = []BackupFile{}
+
+    for _, file := range files {
+
+        var name string = file.Name()
+        var compressed bool = strings.HasSuffix(name, DATABASE_FILE_EXT+BACKUP_GZIP_EXT)
+
+        if file.IsDir() || !strings.HasPrefix(name, prefix) ||
+            !(compressed || strings.HasSuffix(name, DATABASE_FILE_EXT)) {
+            continue
+        }
+
+        // backups are named by the time they're taken; e.g. 2006-01-02T15.04.05...
+        var timestamp string = strings.TrimPrefix(name, prefix)
+        timestamp = strings.TrimSuffix(strings.TrimSuffix(timestamp, BACKUP_GZIP_EXT), DATABASE_FILE_EXT)
+        if len(timestamp) < 10 || timestamp[4] != '-' || timestamp[7] != '-' {
+            continue
+        }
+
+        backups = append(backups, BackupFile{
+            Name:       name,
+            Filename:   filepath.Join(dir, name),
+            Size:       file.Size(),
+            Compressed: compressed,
+            CreatedAt:  file.ModTime(),
+        })
+    }
+
+    sort.Slice(backups, func(i, j int) bool {
+        return backups[i].CreatedAt.After(backups[j].CreatedAt)
+    })
+
+    return backups, nil
+}
+
+func GetBackup(db *Database, name string) (*BackupFile, error) {
+
+    backups, err := ListBackups(db)
+    if err != nil {
+        return nil, err
+    }
+
+    for idx := range backups {
+        if backups[idx].Name == name {
+            return &backups[idx], nil
+        }
+    }
+
+    return nil, ErrBackupNoSuchBackup
+}
+
+// restore the database from the backup; compressed backups are decompressed into a
+// temporary file alongside it first
+func RestoreBackup(db *Database, backup *BackupFile) error {
+
+    if !backup.Compressed {
+        return db.RestoreFrom(backup.Filename)
+    }
+
+    src, err := os.Open(backup.Filename)
+    if err != nil {
+        return err
+    }
+    defer src.Close()
+
+    reader, err := gzip.NewReader(src)
+    if err != nil {
+        return ErrDatabaseCorrupt
+    }
+    defer reader.Close()
+
+    dest, err := ioutil.TempFile(filepath.Dir(backup.Filename), ".restore-")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(dest.Name())
+
+    _, err = io.Copy(dest, reader)
+    if err != nil {
+        dest.Close()
+        if err == gzip.ErrChecksum || err == gzip.ErrHeader || err == io.ErrUnexpectedEOF {
+            return ErrDatabaseCorrupt
+        }
+        return err
+    }
+
+    err = dest.Close()
+    if err != nil {
+        return err
+    }
+
+    return db.RestoreFrom(dest.Name())
+}
+
+// the file name of a backup, with the .db extension; empty for the default name
+func ValidateBackupName(name string) (string, error) {
+
+    name = strings.TrimSpace(name)
+
+    if len(name) <= 0 {
+        return "", nil
+    }
+
+    if name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+        return "", ErrBackupInvalidName
+    }
+
+    if !strings.HasSuffix(name, DATABASE_FILE_EXT) {
+        name = name + DATABASE_FILE_EXT
+    }
+
+    return name, nil
+}
+
+func ValidateBackupDir(dir string) error {
+
+    if len(dir) <= 0 {
+        return nil
+    }
+
+    info, err := os.Stat(dir)
+    if err != nil || !info.IsDir() {
+        return ErrBackupInvalidDir
+    }
+
+    return nil
+}
+
+// run an integrity check on the backup; false if the backup is corrupt
+func VerifyBackup(filename string) (bool, error) {
+
+    backup, err := OpenDatabaseReadOnly(filename)
+    if err == ErrDatabaseCorrupt {
+        return false, nil
+    }
+    if err != nil {
+        return false, err
+    }
+    defer backup.CleanUp()
+
+    return backup.IntegrityCheck()
+}
+
+// gzip-compress the backup into <filename>.gz; the uncompressed backup is removed
+func CompressBackup(filename string) (string, error) {
+
+    var compressedName string = filename + BACKUP_GZIP_EXT
+
+    src, err := os.Open(filename)
+    if err != nil {
+        return "", err
+    }
+    defer src.Close()
+
+    dest, err := os.OpenFile(compressedName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+    if err != nil {
+        return "", err
+    }
+
+    var writer *gzip.Writer = gzip.NewWriter(dest)
+
+    _, err = io.Copy(writer, src)
+    if err == nil {
+        err = writer.Close()
+    }
+    if err == nil {
+        err = dest.Sync()
+    }
+    if closeErr := dest.Close(); err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        os.Remove(compressedName)
+        return "", err
+    }
+
+    err = os.Remove(filename)
+    if err != nil {
+        return "", err
+    }
+
+    return compressedName, nil
+}
+
+// size and hex-encoded sha256 checksum of the file
+func FileChecksum(filename string) (int64, string, error) {
+
+    file, err := os.Open(filename)
+    if err != nil {
+        return 0, "", err
+    }
+    defer file.Close()
+
+    var checksum hash.Hash = sha256.New()
+
+    size, err := io.Copy(checksum, file)
+    if err != nil {
+        return 0, "", err
+    }
+
+    return size, hex.EncodeToString(checksum.Sum(nil)), nil
+}
+
+func getBackupSetting(db *sqlx.DB, setting string, defaultValue uint64) (uint64, error) {
+
+    config, err := GetConfig(db, setting)
+    switch {
+    case err == ErrConfigNoSuchSetting:
+        return defaultValue, nil
+    case err != nil:
+        return 0, err
+    }
+
+    value, err := strconv.ParseUint(strings.TrimSpace(config.Value), 10, 32)
+    if err != nil {
+        return 0, ErrBackupInvalidSetting
+    }
+
+    return value, nil
+}
+
+func GetBackupRetention(db *sqlx.DB) (uint64, uint64, error) {
+
+    keepDaily, err := getBackupSetting(db, CONFIG_BACKUP_KEEP_DAILY, DEFAULT_BACKUP_KEEP_DAILY)
+    if err != nil {
+        return 0, 0, err
+    }
+
+    keepWeekly, err := getBackupSetting(db, CONFIG_BACKUP_KEEP_WEEKLY, DEFAULT_BACKUP_KEEP_WEEKLY)
+    if err != nil {
+        return 0, 0, err
+    }
+
+    return keepDaily, keepWeekly, nil
+}
+
+// split backups (newest first) into those kept and those to be pruned. the newest backup
+// of each of the last keepDaily days with backups is kept; likewise for keepWeekly weeks.
+func SelectBackupsToPrune(backups []BackupFile, keepDaily uint64, keepWeekly uint64) ([]BackupFile, []BackupFile) {
+
+    var (
+        keep   map[int]bool    = make(map[int]bool)
+        days   map[string]bool = make(map[string]bool)
+        weeks  map[string]bool = make(map[string]bool)
+        kept   []BackupFile    = []BackupFile{}
+        pruned []BackupFile    = []BackupFile{}
+    )
+
+    for idx, backup := range backups {
+
+        var day string = backup.CreatedAt.Format("2006-01-02")
+        if !days[day] && uint64(len(days)) < keepDaily {
+            days[day] = true
+            keep[idx] = true
+        }
+
+        year, week := backup.CreatedAt.ISOWeek()
+        var weekKey string = fmt.Sprintf("%d-%d", year, week)
+        if !weeks[weekKey] && uint64(len(weeks)) < keepWeekly {
+            weeks[weekKey] = true
+            keep[idx] = true
+        }
+    }
+
+    for idx, backup := range backups {
+        if idx == 0 || keep[idx] {
+            kept = append(kept, backup)
+        } else {
+            pruned = append(pruned, backup)
+        }
+    }
+
+    return kept, pruned
+}
+
+func DeleteBackups(backups []BackupFile) error {
+
+    for _, backup := range backups {
+        for _, suffix := range append([]string{""}, databaseSideFileSuffixes...) {
+            err := os.Remove(backup.Filename + suffix)
+            if err != nil && !os.IsNotExist(err) {
+                return err
+            }
+        }
+    }
+
+    return nil
+}
+
+// back up the database if the backup_interval_hours config setting has passed since
+// the last backup, or if backup_after_reviews reviews were made since.
+// returns the name of the backup; or an empty string if none was due.
+func BackupIfDue(db *Database) (string, error) {
+
+    var err error
+
+    var intervalHours, afterReviews uint64
+    intervalHours, err = getBackupSetting(db.instance, CONFIG_BACKUP_INTERVAL_HOURS, 0)
+    if err != nil {
+        return "", err
+    }
+
+    afterReviews, err = getBackupSetting(db.instance, CONFIG_BACKUP_AFTER_REVIEWS, 0)
+    if err != nil {
+        return "", err
+    }
+
+    if intervalHours <= 0 && afterReviews <= 0 {
+        return "", nil
+    }
+
+    var backups []BackupFile
+    backups, err = ListBackups(db)
+    if err != nil {
+        return "", err
+    }
+
+    var lastBackup time.Time = time.Unix(0, 0)
+    if len(backups) > 0 {
+        lastBackup = backups[0].CreatedAt
+    }
+
+    var due bool = intervalHours > 0 && time.Since(lastBackup) >= time.Duration(intervalHours)*time.Hour
+
+    if !due && afterReviews > 0 {
+
+        var query string
+        var args []interface{}
+        query, args, err = QueryApply(COUNT_REVIEWS_SINCE_QUERY, &StringMap{"since": lastBackup.Unix()})
+        if err != nil {
+            return "", err
+        }
+
+        var reviews uint64
+        err = db.instance.QueryRowx(query, args...).Scan(&reviews)
+        if err != nil {
+            return "", err
+        }
+
+        due = reviews >= afterReviews
+    }
+
+    if !due {
+        return "", nil
+    }
+
+    return db.Backup()
+}
+
+// periodically back up the profile in use when a backup is due (see BackupIfDue);
+// and prune its backups afterwards
+func RunBackupSchedule(profiles *Profiles, interval time.Duration) {
+
+    for {
+        profiles.Use(func(db *Database) {
+
+            backupName, err := BackupIfDue(db)
+            if err != nil {
+                fmt.Println("scheduled backup:", err)
+                return
+            }
+
+            if len(backupName) <= 0 {
+                return
+            }
+
+            backups, err := ListBackups(db)
+            if err != nil {
+                fmt.Println("scheduled backup:", err)
+                return
+            }
+
+            keepDaily, keepWeekly, err := GetBackupRetention(db.instance)
+            if err != nil {
+                fmt.Println("scheduled backup:", err)
+                return
+            }
+
+            _, pruned := SelectBackupsToPrune(backups, keepDaily, keepWeekly)
+
+            err = DeleteBackups(pruned)
+            if err != nil {
+                fmt.Println("scheduled backup:", err)
+            }
+        })
+
+        time.Sleep(interval)
+    }
+}
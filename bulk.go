@@ -0,0 +1,432 @@
+package main
+
+import (
+    "errors"
+    "net/http"
+    "strings"
+
+    // 3rd-party
+    "github.com/gin-gonic/gin"
+    "github.com/jmoiron/sqlx"
+)
+
+/* variables */
+
+// errors
+var ErrBulkInvalidOperation = errors.New("bulk: operation must be one of: move, delete, add_to_stash, remove_from_stash, reset_review, suspend, unsuspend, add_tag, remove_tag")
+var ErrBulkNoSelection = errors.New("bulk: either cards or filter must be given")
+var ErrBulkAmbiguousSelection = errors.New("bulk: only one of cards or filter may be given")
+var ErrBulkEmptyFilter = errors.New("bulk: filter must have at least one of: deck, stash, tag, search")
+var ErrBulkNoDeck = errors.New("bulk: deck is required for the move operation")
+var ErrBulkNoStash = errors.New("bulk: stash is required for stash operations")
+
+// operations that may be applied onto cards in bulk
+const (
+    BULK_MOVE              string = "move"
+    BULK_DELETE            string = "delete"
+    BULK_ADD_TO_STASH      string = "add_to_stash"
+    BULK_REMOVE_FROM_STASH string = "remove_from_stash"
+    BULK_RESET_REVIEW      string = "reset_review"
+    BULK_SUSPEND           string = "suspend"
+    BULK_UNSUSPEND         string = "unsuspend"
+    BULK_ADD_TAG           string = "add_tag"
+    BULK_REMOVE_TAG        string = "remove_tag"
+)
+
+/* types */
+
+type CardsFilter struct {
+    Deck   uint   `json:"deck"`
+    Stash  uint   `json:"stash"`
+    Tag    string `json:"tag"`
+    Search string `json:"search"`
+}
+
+type CardsBulkRequest struct {
+    Operation string       `json:"operation" binding:"required"`
+    Cards     []uint       `json:"cards"`
+    Filter    *CardsFilter `json:"filter"`
+    Deck      uint         `json:"deck"`
+    Stash     uint         `json:"stash"`
+    Tag       string       `json:"tag"`
+}
+
+/* REST Handlers */
+
+// POST /cards/bulk
+//
+// Apply an operation onto many cards within a single transaction. If the operation
+// fails for any card, then no card is changed. Deleted cards are moved into the trash.
+//
+// Input:
+// operation: one of: move, delete, add_to_stash, remove_from_stash, reset_review,
+//            suspend, unsuspend, add_tag, remove_tag
+// cards: list of card ids
+// filter: select cards matching every given criteria; instead of cards.
+//         deck: cards within the deck subtree
+//         stash: cards within the stash
+//         tag: cards with the tag
+//         search: full-text search query over the cards' content
+// deck: target deck; required for move
+// stash: target stash; required for add_to_stash and remove_from_stash
+// tag: required for add_tag and remove_tag
+func CardsBulkPOST(db *sqlx.DB, ctx *gin.Context) {
+
+    // parse request
+    var (
+        err         error
+        jsonRequest CardsBulkRequest
+    )
+
+    err = ctx.BindJSON(&jsonRequest)
+    if err != nil {
+
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "bad JSON input",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    err = ValidateCardsBulkRequest(&jsonRequest)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      err.Error(),
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var tx *sqlx.Tx
+    tx, err = db.Beginx()
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to begin bulk operation",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    // verify target deck or stash exists
+
+    switch jsonRequest.Operation {
+    case BULK_MOVE:
+
+        _, err = GetDeck(tx, jsonRequest.Deck)
+        switch {
+        case err == ErrDeckNoSuchDeck:
+            tx.Rollback()
+            ctx.JSON(http.StatusNotFound, gin.H{
+                "status":           http.StatusNotFound,
+                "developerMessage": err.Error(),
+                "userMessage":      "cannot find deck by id",
+            })
+            ctx.Error(err)
+            return
+        case err != nil:
+            tx.Rollback()
+            ctx.JSON(http.StatusInternalServerError, gin.H{
+                "status":           http.StatusInternalServerError,
+                "developerMessage": err.Error(),
+                "userMessage":      "unable to retrieve deck",
+            })
+            ctx.Error(err)
+            return
+        }
+
+    case BULK_ADD_TO_STASH, BULK_REMOVE_FROM_STASH:
+
+        _, err = GetStash(tx, jsonRequest.Stash)
+        switch {
+        case err == ErrStashNoSuchStash:
+            tx.Rollback()
+            ctx.JSON(http.StatusNotFound, gin.H{
+                "status":           http.StatusNotFound,
+                "developerMessage": err.Error(),
+                "userMessage":      "cannot find stash by id",
+            })
+            ctx.Error(err)
+            return
+        case err != nil:
+            tx.Rollback()
+            ctx.JSON(http.StatusInternalServerError, gin.H{
+                "status":           http.StatusInternalServerError,
+                "developerMessage": err.Error(),
+                "userMessage":      "unable to retrieve stash",
+            })
+            ctx.Error(err)
+            return
+        }
+
+        var smart bool
+        smart, err = StashIsSmart(tx, jsonRequest.Stash)
+        switch {
+        case err != nil:
+            tx.Rollback()
+            ctx.JSON(http.StatusInternalServerError, gin.H{
+                "status":           http.StatusInternalServerError,
+                "developerMessage": err.Error(),
+                "userMessage":      "unable to retrieve stash",
+            })
+            ctx.Error(err)
+            return
+        case smart:
+            tx.Rollback()
+            err = ErrStashIsSmart
+            ctx.JSON(http.StatusBadRequest, gin.H{
+                "status":           http.StatusBadRequest,
+                "developerMessage": err.Error(),
+                "userMessage":      err.Error(),
+            })
+            ctx.Error(err)
+            return
+        }
+    }
+
+    // resolve cards to operate on
+
+    var cardIDs []uint = dedupeCardIDs(jsonRequest.Cards)
+
+    if jsonRequest.Filter != nil {
+        var filter *CardsFilter = jsonRequest.Filter
+
+        cardIDs, err = CardIDsByFilter(tx, filter.Deck, filter.Stash, filter.Tag, filter.Search)
+        if err != nil {
+            tx.Rollback()
+            ctx.JSON(http.StatusBadRequest, gin.H{
+                "status":           http.StatusBadRequest,
+                "developerMessage": err.Error(),
+                "userMessage":      "unable to find cards with given filter",
+            })
+            ctx.Error(err)
+            return
+        }
+    }
+
+    var (
+        results   []gin.H = make([]gin.H, 0, len(cardIDs))
+        changed   uint    = 0
+        unchanged uint    = 0
+        failed    uint    = 0
+    )
+
+    for _, cardID := range cardIDs {
+
+        var (
+            cardChanged bool
+            cardErr     error
+        )
+
+        cardChanged, cardErr = ApplyBulkCardOperation(tx, &jsonRequest, cardID)
+
+        switch {
+        case cardErr != nil:
+            failed++
+            results = append(results, gin.H{
+                "card":   cardID,
+                "status": "error",
+                "error":  cardErr.Error(),
+            })
+        case cardChanged:
+            changed++
+            results = append(results, gin.H{
+                "card":   cardID,
+                "status": "ok",
+            })
+        default:
+            unchanged++
+            results = append(results, gin.H{
+                "card":   cardID,
+                "status": "unchanged",
+            })
+        }
+    }
+
+    if failed > 0 {
+        tx.Rollback()
+
+        err = errors.New("bulk operation failed for some cards")
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "bulk operation failed for some cards; no cards were changed",
+            "failed":           failed,
+            "results":          results,
+        })
+        ctx.Error(err)
+        return
+    }
+
+    err = tx.Commit()
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to finish bulk operation",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    ctx.JSON(http.StatusOK, gin.H{
+        "operation": jsonRequest.Operation,
+        "total":     len(cardIDs),
+        "changed":   changed,
+        "unchanged": unchanged,
+        "failed":    failed,
+        "results":   results,
+    })
+}
+
+/* helpers */
+
+// validate and normalize bulk request in-place
+func ValidateCardsBulkRequest(request *CardsBulkRequest) error {
+
+    var err error
+
+    request.Operation = strings.ToLower(request.Operation)
+
+    switch request.Operation {
+    case BULK_MOVE:
+        if request.Deck <= 0 {
+            return ErrBulkNoDeck
+        }
+    case BULK_ADD_TO_STASH, BULK_REMOVE_FROM_STASH:
+        if request.Stash <= 0 {
+            return ErrBulkNoStash
+        }
+    case BULK_ADD_TAG, BULK_REMOVE_TAG:
+        request.Tag, err = NormalizeTag(request.Tag)
+        if err != nil {
+            return err
+        }
+    case BULK_DELETE:
+    case BULK_RESET_REVIEW:
+    case BULK_SUSPEND:
+    case BULK_UNSUSPEND:
+    default:
+        return ErrBulkInvalidOperation
+    }
+
+    var filter *CardsFilter = request.Filter
+
+    switch {
+    case filter == nil && len(request.Cards) <= 0:
+        return ErrBulkNoSelection
+    case filter != nil && len(request.Cards) > 0:
+        return ErrBulkAmbiguousSelection
+    case filter != nil:
+        filter.Tag = strings.TrimSpace(filter.Tag)
+        filter.Search = strings.TrimSpace(filter.Search)
+
+        if filter.Deck <= 0 && filter.Stash <= 0 && len(filter.Tag) <= 0 && len(filter.Search) <= 0 {
+            return ErrBulkEmptyFilter
+        }
+    }
+
+    return nil
+}
+
+// apply bulk operation onto the card; returns true if the card was changed.
+// the request is assumed to be validated.
+func ApplyBulkCardOperation(db sqlx.Ext, request *CardsBulkRequest, cardID uint) (bool, error) {
+
+    var err error
+
+    var card *CardRow
+    card, err = GetCard(db, cardID)
+    if err != nil {
+        return false, err
+    }
+
+    switch request.Operation {
+    case BULK_MOVE:
+
+        if card.Deck == request.Deck {
+            return false, nil
+        }
+
+        err = PatchCard(db, cardID, &StringMap{"deck": request.Deck})
+        if err != nil {
+            return false, err
+        }
+
+        return true, nil
+
+    case BULK_DELETE:
+
+        _, err = TrashCard(db, cardID)
+        if err != nil {
+            return false, err
+        }
+
+        return true, nil
+
+    case BULK_ADD_TO_STASH, BULK_REMOVE_FROM_STASH:
+
+        var connected bool
+        connected, err = CardConnectedWithStash(db, request.Stash, cardID)
+        if err != nil {
+            return false, err
+        }
+
+        var action string = "add"
+        if request.Operation == BULK_REMOVE_FROM_STASH {
+            action = "remove"
+        }
+
+        if connected == (action == "add") {
+            return false, nil
+        }
+
+        err = ProcessCardWithStash(db, request.Stash, action, cardID)
+        if err != nil {
+            return false, err
+        }
+
+        return true, nil
+
+    case BULK_RESET_REVIEW:
+
+        err = ResetCardScore(db, cardID)
+        if err != nil {
+            return false, err
+        }
+
+        return true, nil
+
+    case BULK_SUSPEND, BULK_UNSUSPEND:
+        return SetCardSuspended(db, cardID, request.Operation == BULK_SUSPEND)
+
+    case BULK_ADD_TAG, BULK_REMOVE_TAG:
+        return SetCardTag(db, cardID, request.Tag, request.Operation == BULK_ADD_TAG)
+    }
+
+    return false, ErrBulkInvalidOperation
+}
+
+// remove duplicate card ids; preserving order
+func dedupeCardIDs(cardIDs []uint) []uint {
+
+    var (
+        seen   map[uint]bool = make(map[uint]bool, len(cardIDs))
+        result []uint        = make([]uint, 0, len(cardIDs))
+    )
+
+    for _, cardID := range cardIDs {
+        if seen[cardID] {
+            continue
+        }
+        seen[cardID] = true
+        result = append(result, cardID)
+    }
+
+    return result
+}
@@ -18,6 +18,8 @@ import (
 var ErrCardNoSuchCard = errors.New("cards: no such card of given id")
 var ErrCardNoCardsByDeck = errors.New("cards: deck has no cards")
 var ErrCardPageOutOfBounds = errors.New("cards: page is out of bounds")
+var ErrCardNotPatched = errors.New("cards: card not patched")
+var ErrCardInvalidTag = errors.New("cards: tag must be non-empty string")
 
 /* types */
 
@@ -121,6 +123,18 @@ func CardGET(db *sqlx.DB, ctx *gin.Context) {
         return
     }
 
+    var links gin.H
+    links, err = CardLinksToResponse(db, cardID)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve card links",
+        })
+        ctx.Error(err)
+        return
+    }
+
     var cardrow gin.H = CardRowToResponse(db, fetchedCardRow)
     var cardscore gin.H = CardScoreToResponse(fetchedCardScore)
 
@@ -128,11 +142,21 @@ func CardGET(db *sqlx.DB, ctx *gin.Context) {
         &cardrow,
         &gin.H{"review": cardscore},
         &gin.H{"stashes": fetchedStashes},
+        &links,
     ))
 }
 
 // DELETE /cards/:id
 //
+// Move card into the trash. The ids of the cards whose links to this card are left
+// dangling, and of the cards whose links were unlinked, are comma-separated within the
+// X-Dangling-Links and X-Unlinked-Cards headers.
+//
+// Query params:
+// dangling: how links from other cards to this card are handled; one of:
+//           keep (default): links are kept; see GET /links/dangling. they're restored
+//                           if the card is restored from the trash.
+//           unlink: link markers are replaced with the card's title
 func CardDELETE(db *sqlx.DB, ctx *gin.Context) {
 
     var err error
@@ -152,9 +176,24 @@ func CardDELETE(db *sqlx.DB, ctx *gin.Context) {
     }
     var cardID uint = uint(_cardID)
 
+    var dangling string = ctx.DefaultQuery("dangling", CARD_DANGLING_LINKS_KEEP)
+
+    switch dangling {
+    case CARD_DANGLING_LINKS_KEEP:
+    case CARD_DANGLING_LINKS_UNLINK:
+    default:
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": "invalid dangling query",
+            "userMessage":      "invalid dangling query",
+        })
+        return
+    }
+
     // fetch card row from the db
 
-    _, err = GetCard(db, cardID)
+    var fetchedCardRow *CardRow
+    fetchedCardRow, err = GetCard(db, cardID)
     switch {
     case err == ErrCardNoSuchCard:
         ctx.JSON(http.StatusNotFound, gin.H{
@@ -174,8 +213,40 @@ func CardDELETE(db *sqlx.DB, ctx *gin.Context) {
         return
     }
 
-    // delete card
-    err = DeleteCard(db, cardID)
+    // move card into the trash
+    var tx *sqlx.Tx
+    tx, err = db.Beginx()
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to delete card",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var (
+        danglingLinks []CardLinkRow = []CardLinkRow{}
+        unlinked      []uint        = []uint{}
+    )
+
+    if dangling == CARD_DANGLING_LINKS_UNLINK {
+        unlinked, err = UnlinkCard(tx, fetchedCardRow)
+    } else {
+        danglingLinks, err = CardBacklinks(tx, cardID)
+    }
+
+    if err == nil {
+        _, err = TrashCard(tx, cardID)
+    }
+
+    if err == nil {
+        err = tx.Commit()
+    } else {
+        tx.Rollback()
+    }
+
     if err != nil {
         ctx.JSON(http.StatusInternalServerError, gin.H{
             "status":           http.StatusInternalServerError,
@@ -192,6 +263,14 @@ func CardDELETE(db *sqlx.DB, ctx *gin.Context) {
     switch {
     case err == ErrCardNoSuchCard:
         // success
+
+        var danglingIDs []uint = make([]uint, 0, len(danglingLinks))
+        for _, link := range danglingLinks {
+            danglingIDs = append(danglingIDs, link.Card)
+        }
+
+        ctx.Header("X-Dangling-Links", joinCardIDs(danglingIDs))
+        ctx.Header("X-Unlinked-Cards", joinCardIDs(unlinked))
         ctx.Writer.WriteHeader(http.StatusNoContent)
         return
     case err != nil:
@@ -215,6 +294,11 @@ func CardDELETE(db *sqlx.DB, ctx *gin.Context) {
 //
 // Params:
 // id: a unique, positive integer that is the identifier of the assocoated deck
+//
+// Query params:
+// check_duplicates: if true, list existing cards that are likely duplicates of the new
+//                   card as duplicates; the card is created regardless (default: false)
+// threshold: minimum similarity of duplicates; within (0, 1] (default: 0.8)
 func CardPOST(db *sqlx.DB, ctx *gin.Context) {
 
     // parse request
@@ -223,6 +307,30 @@ func CardPOST(db *sqlx.DB, ctx *gin.Context) {
         jsonRequest CardPOSTRequest
     )
 
+    var checkDuplicates bool
+    checkDuplicates, err = strconv.ParseBool(ctx.DefaultQuery("check_duplicates", "false"))
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "check_duplicates must be a boolean",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var threshold float64
+    threshold, err = ParseDuplicatesThreshold(ctx)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      err.Error(),
+        })
+        ctx.Error(err)
+        return
+    }
+
     err = ctx.BindJSON(&jsonRequest)
     if err != nil {
 
@@ -256,6 +364,21 @@ func CardPOST(db *sqlx.DB, ctx *gin.Context) {
         return
     }
 
+    // find likely duplicates before the new card is one of them
+    var duplicates []DuplicateMatch
+    if checkDuplicates {
+        duplicates, err = FindDuplicateCards(db, jsonRequest.Title, jsonRequest.Front, threshold)
+        if err != nil {
+            ctx.JSON(http.StatusInternalServerError, gin.H{
+                "status":           http.StatusInternalServerError,
+                "developerMessage": err.Error(),
+                "userMessage":      "unable to check for duplicate cards",
+            })
+            ctx.Error(err)
+            return
+        }
+    }
+
     // create card
     var newCardRow *CardRow
 
@@ -290,11 +413,17 @@ func CardPOST(db *sqlx.DB, ctx *gin.Context) {
     var cardrow gin.H = CardRowToResponse(db, newCardRow)
     var cardscore gin.H = CardScoreToResponse(fetchedCardScore)
 
-    ctx.JSON(http.StatusCreated, MergeResponses(
+    var response gin.H = MergeResponses(
         &cardrow,
         &gin.H{"review": cardscore},
         &gin.H{"stashes": []uint{}},
-    ))
+    )
+
+    if checkDuplicates {
+        response["duplicates"] = DuplicateMatchesToResponse(duplicates)
+    }
+
+    ctx.JSON(http.StatusCreated, response)
 }
 
 // PATCH /cards/:id
@@ -439,40 +568,17 @@ func CardPATCH(db *sqlx.DB, ctx *gin.Context) {
         }
     }
 
-    // generate SQL to patch card
-    var (
-        query string
-        args  []interface{}
-    )
-
-    query, args, err = QueryApply(UPDATE_CARD_QUERY, &StringMap{"card_id": cardID}, patch)
-    if err != nil {
-        ctx.JSON(http.StatusInternalServerError, gin.H{
-            "status":           http.StatusInternalServerError,
-            "developerMessage": err.Error(),
-            "userMessage":      "unable to generate patch card SQL",
-        })
-        ctx.Error(err)
-        return
-    }
-
-    var res sql.Result
-    res, err = db.Exec(query, args...)
-    if err != nil {
-        // TODO: transaction rollback
-        ctx.JSON(http.StatusInternalServerError, gin.H{
-            "status":           http.StatusInternalServerError,
-            "developerMessage": err.Error(),
-            "userMessage":      "unable to patch card",
+    // patch card
+    err = PatchCard(db, cardID, patch)
+    switch {
+    case err == ErrCardNotPatched:
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": "given JSON is invalid",
+            "userMessage":      "given JSON is invalid",
         })
-        ctx.Error(err)
         return
-    }
-
-    // ensure card is patched
-    num, err := res.RowsAffected()
-    if err != nil {
-        // TODO: transaction rollback
+    case err != nil:
         ctx.JSON(http.StatusInternalServerError, gin.H{
             "status":           http.StatusInternalServerError,
             "developerMessage": err.Error(),
@@ -482,16 +588,6 @@ func CardPATCH(db *sqlx.DB, ctx *gin.Context) {
         return
     }
 
-    if num <= 0 {
-        // TODO: transaction rollback
-        ctx.JSON(http.StatusBadRequest, gin.H{
-            "status":           http.StatusBadRequest,
-            "developerMessage": "given JSON is invalid",
-            "userMessage":      "given JSON is invalid",
-        })
-        return
-    }
-
     fetchedCardRow, err = GetCard(db, cardID)
     switch {
     case err == ErrCardNoSuchCard:
@@ -560,6 +656,8 @@ func CardResponse(overrides *gin.H) gin.H {
         "created_at":  0,
         "updated_at":  0,
         "deck_path":   []uint{},
+        "tags":        []string{},
+        "suspended":   false,
     }
 
     return MergeResponse(defaultResponse, overrides)
@@ -576,6 +674,13 @@ func CardRowToResponse(db *sqlx.DB, cardrow *CardRow) gin.H {
 
     deck_path = append(deck_path, cardrow.Deck)
 
+    // swallow errors
+    // TODO: error handling
+    var tags []string
+    tags, _ = CardTags(db, cardrow.ID)
+    var suspended bool
+    suspended, _ = CardIsSuspended(db, cardrow.ID)
+
     return CardResponse(&gin.H{
         "id":          cardrow.ID,
         "title":       cardrow.Title,
@@ -586,6 +691,8 @@ func CardRowToResponse(db *sqlx.DB, cardrow *CardRow) gin.H {
         "created_at":  cardrow.CreatedAt,
         "updated_at":  cardrow.UpdatedAt,
         "deck_path":   deck_path,
+        "tags":        tags,
+        "suspended":   suspended,
     })
 }
 
@@ -602,7 +709,7 @@ func ValidateCardProps(props *CardProps) error {
     return nil
 }
 
-func GetCard(db *sqlx.DB, cardID uint) (*CardRow, error) {
+func GetCard(db sqlx.Ext, cardID uint) (*CardRow, error) {
 
     var (
         err   error
@@ -629,7 +736,7 @@ func GetCard(db *sqlx.DB, cardID uint) (*CardRow, error) {
     }
 }
 
-func CreateCard(db *sqlx.DB, props *CardProps) (*CardRow, error) {
+func CreateCard(db sqlx.Ext, props *CardProps) (*CardRow, error) {
 
     var err error
 
@@ -668,9 +775,58 @@ func CreateCard(db *sqlx.DB, props *CardProps) (*CardRow, error) {
         return nil, err
     }
 
+    err = SyncCardMedia(db, uint(insertID))
+    if err != nil {
+        return nil, err
+    }
+
+    err = SyncCardLinks(db, uint(insertID))
+    if err != nil {
+        return nil, err
+    }
+
     return GetCard(db, uint(insertID))
 }
 
+// apply patch onto the card; only whitelisted cols of UPDATE_CARD_QUERY are patched.
+// the patch is assumed to be validated.
+func PatchCard(db sqlx.Ext, cardID uint, patch *StringMap) error {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+        res   sql.Result
+    )
+
+    query, args, err = QueryApply(UPDATE_CARD_QUERY, &StringMap{"card_id": cardID}, patch)
+    if err != nil {
+        return err
+    }
+
+    res, err = db.Exec(query, args...)
+    if err != nil {
+        return err
+    }
+
+    // ensure card is patched
+    num, err := res.RowsAffected()
+    if err != nil {
+        return err
+    }
+
+    if num <= 0 {
+        return ErrCardNotPatched
+    }
+
+    err = SyncCardMedia(db, cardID)
+    if err != nil {
+        return err
+    }
+
+    return SyncCardLinks(db, cardID)
+}
+
 func CountCardsByDeck(db *sqlx.DB, deckID uint) (uint, error) {
 
     var (
@@ -743,7 +899,7 @@ func CardsByDeck(db *sqlx.DB, queryTransform PipeInput, deckID uint, page uint,
     return &cards, nil
 }
 
-func DeleteCard(db *sqlx.DB, cardID uint) error {
+func DeleteCard(db sqlx.Ext, cardID uint) error {
 
     var (
         err   error
@@ -763,3 +919,186 @@ func DeleteCard(db *sqlx.DB, cardID uint) error {
 
     return nil
 }
+
+// fetch every card within the deck subtree
+func AllCardsByDeck(db sqlx.Ext, deckID uint) ([]CardRow, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(FETCH_ALL_CARDS_BY_DECK_QUERY, &StringMap{"deck_id": deckID})
+    if err != nil {
+        return nil, err
+    }
+
+    var cards []CardRow = []CardRow{}
+    err = sqlx.Select(db, &cards, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    return cards, nil
+}
+
+// fetch ids of cards matching every non-zero criteria
+func CardIDsByFilter(db sqlx.Ext, deckID uint, stashID uint, tag string, search string) ([]uint, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(FETCH_CARD_IDS_BY_FILTER_QUERY, &StringMap{
+        "deck_id":  deckID,
+        "stash_id": stashID,
+        "tag":      tag,
+        "search":   search,
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    var cardIDs []uint = []uint{}
+    err = sqlx.Select(db, &cardIDs, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    return cardIDs, nil
+}
+
+func CardIsSuspended(db sqlx.Ext, cardID uint) (bool, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(CARD_IS_SUSPENDED_QUERY, &StringMap{"card_id": cardID})
+    if err != nil {
+        return false, err
+    }
+
+    var count int
+    err = db.QueryRowx(query, args...).Scan(&count)
+    if err != nil {
+        return false, err
+    }
+
+    return (count > 0), nil
+}
+
+// suspend or unsuspend the card; returns true if the card was changed.
+func SetCardSuspended(db sqlx.Ext, cardID uint, suspend bool) (bool, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+        res   sql.Result
+    )
+
+    var queryfn PipeInput = UNSUSPEND_CARD_QUERY
+    if suspend {
+        queryfn = SUSPEND_CARD_QUERY
+    }
+
+    query, args, err = QueryApply(queryfn, &StringMap{"card_id": cardID})
+    if err != nil {
+        return false, err
+    }
+
+    res, err = db.Exec(query, args...)
+    if err != nil {
+        return false, err
+    }
+
+    num, err := res.RowsAffected()
+    if err != nil {
+        return false, err
+    }
+
+    if num <= 0 || !suspend {
+        return (num > 0), nil
+    }
+
+    // suspended card shall no longer be reviewed
+    err = DeleteCachedReviewCard(db, cardID)
+    if err != nil {
+        return false, err
+    }
+
+    return true, nil
+}
+
+func CardTags(db sqlx.Ext, cardID uint) ([]string, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(FETCH_CARD_TAGS_QUERY, &StringMap{"card_id": cardID})
+    if err != nil {
+        return nil, err
+    }
+
+    var tags []string = []string{}
+    err = sqlx.Select(db, &tags, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    return tags, nil
+}
+
+func NormalizeTag(tag string) (string, error) {
+
+    tag = strings.TrimSpace(tag)
+
+    if len(tag) <= 0 {
+        return "", ErrCardInvalidTag
+    }
+
+    return tag, nil
+}
+
+// add or remove tag from the card; returns true if the card was changed.
+// the tag is assumed to be normalized.
+func SetCardTag(db sqlx.Ext, cardID uint, tag string, add bool) (bool, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+        res   sql.Result
+    )
+
+    var queryfn PipeInput = REMOVE_CARD_TAG_QUERY
+    if add {
+        queryfn = ADD_CARD_TAG_QUERY
+    }
+
+    query, args, err = QueryApply(queryfn, &StringMap{"card_id": cardID, "tag": tag})
+    if err != nil {
+        return false, err
+    }
+
+    res, err = db.Exec(query, args...)
+    if err != nil {
+        return false, err
+    }
+
+    num, err := res.RowsAffected()
+    if err != nil {
+        return false, err
+    }
+
+    return (num > 0), nil
+}
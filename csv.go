@@ -0,0 +1,701 @@
+package main
+
+import (
+    "bytes"
+    "encoding/csv"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "strconv"
+    "strings"
+
+    // 3rd-party
+    "github.com/gin-gonic/gin"
+    "github.com/jmoiron/sqlx"
+)
+
+/* variables */
+
+// errors
+var ErrImportInvalidFormat = errors.New("import: format must be one of: csv, tsv")
+var ErrImportInvalidColumn = errors.New("import: unknown column")
+var ErrImportNoTitleColumn = errors.New("import: no column is mapped to title")
+var ErrImportNoRows = errors.New("import: no rows to import")
+
+// card fields that an imported column may be mapped onto.
+// an empty column name means that the column is ignored.
+var importableCardCols []string = []string{"title", "description", "front", "back", "deck"}
+
+// columns of audio attached onto the card's front/back as audio markers
+var importableAudioCols []string = []string{"front_audio", "back_audio"}
+
+/* types */
+
+type CardsImportRequest struct {
+    Data    string            `json:"data" binding:"required"`
+    Format  string            `json:"format"`
+    Header  bool              `json:"header"`
+    Columns []string          `json:"columns"`
+    Media   map[string]string `json:"media"`
+    DryRun  bool              `json:"dry_run"`
+}
+
+/* REST Handlers */
+
+// POST /cards/import
+//
+// Create cards from CSV/TSV data. Decks are given as paths relative to the root deck
+// (e.g. "Spanish/Verbs"); any missing decks along the path are created.
+//
+// Input:
+// data: CSV/TSV text
+// format: one of: csv, tsv (default: csv)
+// header: if true, the first row is a header row (default: false)
+// columns: card field for each column; one of: title, description, front, back, deck,
+//          front_audio, back_audio, or "" to ignore the column. (default: the header row
+//          if given; otherwise title, description, front, back, deck)
+// media: base64 encoded media files keyed by file name. front_audio/back_audio columns and
+//        [sound:<file name>] markers within front/back refer to these files (or to the hash
+//        of existing media), and are imported as [audio:<hash>] markers.
+// dry_run: if true, report what would be imported without saving anything (default: false)
+func CardsImportPOST(db *sqlx.DB, ctx *gin.Context) {
+
+    // parse request
+    var (
+        err         error
+        jsonRequest CardsImportRequest
+    )
+
+    err = ctx.BindJSON(&jsonRequest)
+    if err != nil {
+
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "bad JSON input",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var delimiter rune
+    delimiter, _, err = ParseDelimitedFormat(jsonRequest.Format)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      err.Error(),
+        })
+        ctx.Error(err)
+        return
+    }
+
+    // read rows
+    var records [][]string
+    records, err = ReadDelimited(jsonRequest.Data, delimiter)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to parse given data",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    // resolve column mapping
+    var (
+        columns   []string = jsonRequest.Columns
+        firstRow  int      = 1
+        dataStart int      = 0
+    )
+
+    if jsonRequest.Header && len(records) > 0 {
+        if len(columns) <= 0 {
+            columns = records[0]
+        }
+
+        dataStart = 1
+        firstRow = 2
+    }
+
+    if len(columns) <= 0 {
+        columns = importableCardCols
+    }
+
+    columns, err = NormalizeImportColumns(columns)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      err.Error(),
+        })
+        ctx.Error(err)
+        return
+    }
+
+    if len(records) <= dataStart {
+        err = ErrImportNoRows
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      err.Error(),
+        })
+        ctx.Error(err)
+        return
+    }
+
+    // fetch root deck; deck paths are relative to it
+    var rootDeck *DeckRow
+    rootDeck, err = GetRootDeck(db)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve root deck",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var tx *sqlx.Tx
+    tx, err = db.Beginx()
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to begin import",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var media map[string]string
+    media, err = ImportMedia(tx, jsonRequest.Media)
+    if err != nil {
+        tx.Rollback()
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to import media",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var (
+        rows         []gin.H = make([]gin.H, 0, len(records)-dataStart)
+        createdDecks []gin.H = []gin.H{}
+        imported     uint    = 0
+        failed       uint    = 0
+    )
+
+    for idx, record := range records[dataStart:] {
+
+        var rowNum int = firstRow + idx
+
+        var (
+            cardID   uint
+            deckID   uint
+            newDecks []DeckRow
+        )
+
+        // each row is imported within a savepoint; so that a failed row doesn't
+        // leave behind any decks created for it
+        _, err = tx.Exec("SAVEPOINT import_row;")
+        if err != nil {
+            tx.Rollback()
+            ctx.JSON(http.StatusInternalServerError, gin.H{
+                "status":           http.StatusInternalServerError,
+                "developerMessage": err.Error(),
+                "userMessage":      "unable to import row",
+            })
+            ctx.Error(err)
+            return
+        }
+
+        cardID, deckID, newDecks, err = ImportCardRecord(tx, rootDeck.ID, columns, media, record)
+
+        if err != nil {
+            tx.Exec("ROLLBACK TO SAVEPOINT import_row;")
+            tx.Exec("RELEASE SAVEPOINT import_row;")
+
+            failed++
+            rows = append(rows, gin.H{
+                "row":    rowNum,
+                "status": "error",
+                "error":  err.Error(),
+            })
+            continue
+        }
+
+        for _, newDeck := range newDecks {
+            var path []string
+            path, _ = GetDeckPath(tx, rootDeck.ID, newDeck.ID)
+
+            createdDecks = append(createdDecks, gin.H{
+                "row":  rowNum,
+                "name": newDeck.Name,
+                "path": JoinDeckPath(path),
+            })
+        }
+
+        _, err = tx.Exec("RELEASE SAVEPOINT import_row;")
+        if err != nil {
+            tx.Rollback()
+            ctx.JSON(http.StatusInternalServerError, gin.H{
+                "status":           http.StatusInternalServerError,
+                "developerMessage": err.Error(),
+                "userMessage":      "unable to import row",
+            })
+            ctx.Error(err)
+            return
+        }
+
+        imported++
+        var row gin.H = gin.H{
+            "row":    rowNum,
+            "status": "ok",
+        }
+
+        // ids assigned within a dry run are discarded
+        if !jsonRequest.DryRun {
+            row["card"] = cardID
+            row["deck"] = deckID
+        }
+
+        rows = append(rows, row)
+    }
+
+    if jsonRequest.DryRun {
+        err = tx.Rollback()
+    } else {
+        err = tx.Commit()
+    }
+
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to finish import",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    ctx.JSON(http.StatusOK, gin.H{
+        "dry_run":       jsonRequest.DryRun,
+        "total":         imported + failed,
+        "imported":      imported,
+        "failed":        failed,
+        "rows":          rows,
+        "created_decks": createdDecks,
+    })
+}
+
+// GET /decks/:id/export
+//
+// Export every card within the deck subtree.
+//
+// Params:
+// id: a unique, positive integer that is the identifier of the assocoated deck
+//
+// Query params:
+// format: one of: csv, tsv (default: csv)
+func DeckExportGET(db *sqlx.DB, ctx *gin.Context) {
+
+    // parse id param
+    var deckIDString string = strings.ToLower(ctx.Param("id"))
+
+    _deckID, err := strconv.ParseUint(deckIDString, 10, 32)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "given id is invalid",
+        })
+        ctx.Error(err)
+        return
+    }
+    var deckID uint = uint(_deckID)
+
+    // parse format query
+    var (
+        delimiter rune
+        extension string
+    )
+    delimiter, extension, err = ParseDelimitedFormat(ctx.DefaultQuery("format", "csv"))
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      err.Error(),
+        })
+        ctx.Error(err)
+        return
+    }
+
+    // verify deck id exists
+    _, err = GetDeck(db, deckID)
+
+    switch {
+    case err == ErrDeckNoSuchDeck:
+        ctx.JSON(http.StatusNotFound, gin.H{
+            "status":           http.StatusNotFound,
+            "developerMessage": err.Error(),
+            "userMessage":      "cannot find deck by id",
+        })
+        ctx.Error(err)
+        return
+    case err != nil:
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve deck",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var cards []CardRow
+    cards, err = AllCardsByDeck(db, deckID)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve cards for deck",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var output []byte
+    output, err = WriteCardsDelimited(db, cards, delimiter)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to export cards",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var filename string = fmt.Sprintf("deck-%d.%s", deckID, extension)
+    ctx.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+    ctx.Data(http.StatusOK, DelimitedContentType(extension), output)
+}
+
+// GET /stashes/:id/export
+//
+// Export every card within the stash.
+//
+// Query params:
+// format: one of: csv, tsv (default: csv)
+func StashExportGET(db *sqlx.DB, ctx *gin.Context) {
+
+    // parse and validate id param
+    var stashIDString string = strings.ToLower(ctx.Param("id"))
+
+    _stashID, err := strconv.ParseUint(stashIDString, 10, 32)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "given id is invalid",
+        })
+        ctx.Error(err)
+        return
+    }
+    var stashID uint = uint(_stashID)
+
+    // parse format query
+    var (
+        delimiter rune
+        extension string
+    )
+    delimiter, extension, err = ParseDelimitedFormat(ctx.DefaultQuery("format", "csv"))
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      err.Error(),
+        })
+        ctx.Error(err)
+        return
+    }
+
+    // ensure stash exists
+    _, err = GetStash(db, stashID)
+    switch {
+    case err == ErrStashNoSuchStash:
+        ctx.JSON(http.StatusNotFound, gin.H{
+            "status":           http.StatusNotFound,
+            "developerMessage": err.Error(),
+            "userMessage":      "cannot find stash by id",
+        })
+        ctx.Error(err)
+        return
+    case err != nil:
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve stash",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var cards []CardRow
+    cards, err = AllCardsByStash(db, stashID)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve cards for stash",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var output []byte
+    output, err = WriteCardsDelimited(db, cards, delimiter)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to export cards",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var filename string = fmt.Sprintf("stash-%d.%s", stashID, extension)
+    ctx.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+    ctx.Data(http.StatusOK, DelimitedContentType(extension), output)
+}
+
+/* helpers */
+
+// returns the delimiter and file extension of the given format
+func ParseDelimitedFormat(format string) (rune, string, error) {
+
+    switch strings.ToLower(strings.TrimSpace(format)) {
+    case "", "csv":
+        return ',', "csv", nil
+    case "tsv":
+        return '\t', "tsv", nil
+    }
+
+    return 0, "", ErrImportInvalidFormat
+}
+
+func DelimitedContentType(extension string) string {
+
+    if extension == "tsv" {
+        return "text/tab-separated-values; charset=utf-8"
+    }
+
+    return "text/csv; charset=utf-8"
+}
+
+func ReadDelimited(data string, delimiter rune) ([][]string, error) {
+
+    var reader *csv.Reader = csv.NewReader(strings.NewReader(data))
+    reader.Comma = delimiter
+
+    // rows may have differing number of columns; missing columns are treated as empty
+    reader.FieldsPerRecord = -1
+
+    // TSV files are rarely quoted
+    if delimiter == '\t' {
+        reader.LazyQuotes = true
+    }
+
+    var records [][]string = [][]string{}
+
+    for {
+        record, err := reader.Read()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return nil, err
+        }
+
+        records = append(records, record)
+    }
+
+    return records, nil
+}
+
+// validate and normalize the given column mapping
+func NormalizeImportColumns(columns []string) ([]string, error) {
+
+    var (
+        normalized []string = make([]string, 0, len(columns))
+        hasTitle   bool     = false
+    )
+
+    var importableCols []string = append(append([]string{}, importableCardCols...), importableAudioCols...)
+
+    for _, given := range columns {
+
+        var column string = strings.ToLower(strings.TrimSpace(given))
+
+        var known bool = len(column) <= 0
+        for _, col := range importableCols {
+            if col == column {
+                known = true
+                break
+            }
+        }
+
+        if !known {
+            return nil, fmt.Errorf("%s %q; must be one of: %s",
+                ErrImportInvalidColumn.Error(), given, strings.Join(importableCols, ", "))
+        }
+
+        if column == "title" {
+            hasTitle = true
+        }
+
+        normalized = append(normalized, column)
+    }
+
+    if !hasTitle {
+        return nil, ErrImportNoTitleColumn
+    }
+
+    return normalized, nil
+}
+
+// create a card from an imported record. any missing decks along the card's deck path
+// are created and returned. media are hashes of imported media keyed by file name.
+func ImportCardRecord(db sqlx.Ext, rootID uint, columns []string, media map[string]string, record []string) (uint, uint, []DeckRow, error) {
+
+    var (
+        err      error
+        fields   map[string]string = map[string]string{}
+        deckID   uint
+        newDecks []DeckRow
+    )
+
+    for idx, column := range columns {
+
+        if len(column) <= 0 || idx >= len(record) {
+            continue
+        }
+
+        fields[column] = record[idx]
+    }
+
+    if len(strings.TrimSpace(fields["title"])) <= 0 {
+        return 0, 0, nil, errors.New("title must be non-empty string")
+    }
+
+    // resolve audio onto the card's front/back
+    for _, side := range []string{"front", "back"} {
+
+        fields[side], err = ResolveAnkiSoundMarkers(db, media, fields[side])
+        if err != nil {
+            return 0, 0, nil, err
+        }
+
+        var ref string = strings.TrimSpace(fields[side+"_audio"])
+        if len(ref) <= 0 {
+            continue
+        }
+
+        var marker string
+        marker, err = ResolveAudioMarker(db, media, ref)
+        if err != nil {
+            return 0, 0, nil, fmt.Errorf("%s: %s", err.Error(), ref)
+        }
+
+        if len(fields[side]) > 0 {
+            fields[side] += "\n\n"
+        }
+        fields[side] += marker
+    }
+
+    deckID, newDecks, err = ResolveDeckPath(db, rootID, ParseDeckPath(fields["deck"]), true)
+    if err != nil {
+        return 0, 0, newDecks, err
+    }
+
+    var newCardRow *CardRow
+    newCardRow, err = CreateCard(db, &CardProps{
+        Title:       fields["title"],
+        Description: fields["description"],
+        Front:       fields["front"],
+        Back:        fields["back"],
+        Deck:        deckID,
+    })
+    if err != nil {
+        return 0, 0, newDecks, err
+    }
+
+    return newCardRow.ID, deckID, newDecks, nil
+}
+
+// write the given cards as CSV/TSV with a header row.
+// decks are written as paths relative to the root deck; see JoinDeckPath.
+func WriteCardsDelimited(db *sqlx.DB, cards []CardRow, delimiter rune) ([]byte, error) {
+
+    var (
+        err       error
+        buffer    bytes.Buffer
+        deckPaths map[uint]string = map[uint]string{}
+    )
+
+    var rootDeck *DeckRow
+    rootDeck, err = GetRootDeck(db)
+    if err != nil {
+        return nil, err
+    }
+
+    var writer *csv.Writer = csv.NewWriter(&buffer)
+    writer.Comma = delimiter
+
+    err = writer.Write(importableCardCols)
+    if err != nil {
+        return nil, err
+    }
+
+    for _, card := range cards {
+
+        deckPath, cached := deckPaths[card.Deck]
+        if !cached {
+            var path []string
+            path, err = GetDeckPath(db, rootDeck.ID, card.Deck)
+            if err != nil {
+                return nil, err
+            }
+
+            deckPath = JoinDeckPath(path)
+            deckPaths[card.Deck] = deckPath
+        }
+
+        err = writer.Write([]string{
+            card.Title,
+            card.Description,
+            card.Front,
+            card.Back,
+            deckPath,
+        })
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    writer.Flush()
+
+    err = writer.Error()
+    if err != nil {
+        return nil, err
+    }
+
+    return buffer.Bytes(), nil
+}
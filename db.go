@@ -2,11 +2,18 @@ package main
 
 import (
     // _ "encoding/binary"
-    // _ "errors"
     // _ "os"
+    "context"
     "database/sql"
+    "errors"
+    "fmt"
     "math"
-    "sync"
+    "net/url"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
 
     // 3rd-party
     "github.com/jmoiron/sqlx" // replacement for "database/sql"
@@ -14,53 +21,103 @@ import (
 )
 
 type Database struct {
-    name       string
-    filename   string
-    instance   *sqlx.DB
-    sqliteConn *sqlite.SQLiteConn
+    name     string
+    filename string
+    options  DatabaseOptions
+    instance *sqlx.DB
     // dbFilePointer *os.File // used for data syncing. (wip)
 }
 
-var once sync.Once
-var mutex = &sync.Mutex{}
-var sqlite3Conn *sqlite.SQLiteConn
+// name of the sqlite driver whose connections are set up by setupConnection
+const SQLITE_DRIVER_NAME string = "sqlite3_custom"
+
+// errors
+var ErrDatabaseNotSQLite = errors.New("database: connection is not a sqlite connection")
+var ErrDatabaseCorrupt = errors.New("database: database file is corrupt or not a database")
+var ErrDatabaseBackupExists = errors.New("database: backup file already exists")
+var ErrDatabaseInvalidJournalMode = errors.New("database: journal mode must be one of: DELETE, TRUNCATE, PERSIST, MEMORY, WAL, OFF")
+var ErrDatabaseInvalidSynchronous = errors.New("database: synchronous must be one of: OFF, NORMAL, FULL, EXTRA")
+var ErrDatabaseInvalidBusyTimeout = errors.New("database: busy timeout must be non-negative")
+
+// options of connections to a database; applied to each connection of the pool
+type DatabaseOptions struct {
+    DataDir     string // folder of databases opened by profile name; see ProfileFileName
+    JournalMode string // see: https://www.sqlite.org/pragma.html#pragma_journal_mode
+    BusyTimeout int    // milliseconds to wait on a locked database
+    Synchronous string // see: https://www.sqlite.org/pragma.html#pragma_synchronous
+    CacheSize   int    // pages if positive; KiB if negative
+}
 
-func FetchDatabase(name string) (*Database, error) {
+// in WAL mode, readers don't block the writer and the writer doesn't block readers
+var DefaultDatabaseOptions DatabaseOptions = DatabaseOptions{
+    JournalMode: "WAL",
+    BusyTimeout: 5000,
+    Synchronous: "NORMAL",
+    CacheSize:   -2000,
+}
 
-    mutex.Lock()
+func init() {
+    // adapted from: https://github.com/mattn/go-sqlite3/blob/master/_example/custom_func/main.go
+    sql.Register(SQLITE_DRIVER_NAME, &sqlite.SQLiteDriver{
+        ConnectHook: setupConnection,
+    })
+}
 
-    once.Do(func() {
-        // adapted from: https://github.com/mattn/go-sqlite3/blob/master/_example/custom_func/main.go
-        sql.Register("sqlite3_custom", &sqlite.SQLiteDriver{
-            ConnectHook: func(conn *sqlite.SQLiteConn) error {
+// set up each connection opened by the connection pool of any database
+func setupConnection(conn *sqlite.SQLiteConn) error {
 
-                // register custom user defined function.
-                // this calculates the normalized score of a card with respect to its
-                // metadata attributes.
-                if err := conn.RegisterFunc("norm_score", norm_score, true); err != nil {
-                    return err
-                }
+    var err error
 
-                // source: https://github.com/mattn/go-sqlite3/issues/104#issuecomment-33213801
-                sqlite3Conn = conn
+    // register custom user defined function.
+    // this calculates the normalized score of a card with respect to its
+    // metadata attributes.
+    err = conn.RegisterFunc("norm_score", norm_score, true)
+    if err != nil {
+        return err
+    }
 
-                return nil
-            },
-        })
-    })
+    // pragmas such as foreign_keys are per connection
+    _, err = conn.Exec(BOOTSTRAP_QUERY, nil)
+    if err != nil {
+        return err
+    }
 
-    var db *Database = &Database{name: name}
+    return nil
+}
+
+// fetch database of the given name; applying any pending schema migrations.
+// DefaultDatabaseOptions is used if options is nil.
+func FetchDatabase(name string, options *DatabaseOptions) (*Database, error) {
+    return fetchDatabase(name, options, true)
+}
 
-    // if necessary, bootstrap database
+// fetch database of the given name without migrating its schema
+func OpenDatabase(name string, options *DatabaseOptions) (*Database, error) {
+    return fetchDatabase(name, options, false)
+}
+
+func fetchDatabase(name string, options *DatabaseOptions, migrate bool) (*Database, error) {
+
+    if options == nil {
+        options = &DefaultDatabaseOptions
+    }
+
+    var db *Database = &Database{name: name, options: *options}
+
+    // set up connection
     var err error = db.Init()
     if err != nil {
         return nil, err
     }
 
-    db.sqliteConn = sqlite3Conn
-    sqlite3Conn = nil
-
-    mutex.Unlock()
+    // if necessary, bootstrap or upgrade database
+    if migrate {
+        _, err = db.Migrate(false)
+        if err != nil {
+            db.CleanUp()
+            return nil, err
+        }
+    }
 
     return db, nil
 }
@@ -71,7 +128,12 @@ func (db *Database) Init() error {
 
     db.NormalizeFileName()
 
-    db.instance, err = sqlx.Connect("sqlite3_custom", db.filename)
+    err = ValidateDatabaseOptions(&db.options)
+    if err != nil {
+        return err
+    }
+
+    db.instance, err = sqlx.Connect(SQLITE_DRIVER_NAME, db.DataSourceName())
     if err != nil {
         return err
     }
@@ -82,22 +144,17 @@ func (db *Database) Init() error {
     //     return err
     // }
 
-    // set up connection and create any necessary tables
+    // create the table tracking the schema version.
+    // tables are created and changed by migrations; see migrations.go
 
     var queries []string = []string{
-        BOOTSTRAP_QUERY,
-        SETUP_CONFIG_TABLE_QUERY,
-        SETUP_DECKS_TABLE_QUERY,
-        SETUP_CARDS_TABLE_QUERY,
-        STASHES_TABLE_QUERY,
+        SCHEMA_VERSION_TABLE_QUERY,
     }
 
     var instance = db.instance
 
     for _, query := range queries {
 
-        // TODO: run queries in transaction??
-
         _, err = instance.Exec(query)
         if err != nil {
             return err
@@ -107,12 +164,242 @@ func (db *Database) Init() error {
     return nil
 }
 
-func (db *Database) NormalizeFileName() {
+// copy the database into a new database; returns the name of the copy.
+// media is stored within the database, so it's included.
+func (db *Database) Backup() (string, error) {
+    return db.BackupTo("", "")
+}
+
+// back up the database into the file name within the directory; by default,
+// <database name>.<timestamp>.db alongside the database. an existing file isn't replaced.
+func (db *Database) BackupTo(dir string, name string) (string, error) {
+
+    var err error
+
+    if len(dir) <= 0 {
+        dir = filepath.Dir(db.filename)
+    }
+
+    if len(name) <= 0 {
+        name = fmt.Sprintf("%s.%s%s",
+            strings.TrimSuffix(filepath.Base(db.filename), DATABASE_FILE_EXT),
+            time.Now().Format("2006-01-02T15.04.05.999999999Z07.00"), // filename compat RFC3339
+            DATABASE_FILE_EXT)
+    }
+
+    var backupName string = filepath.Join(dir, name)
+
+    _, err = os.Stat(backupName)
+    switch {
+    case err == nil:
+        return "", ErrDatabaseBackupExists
+    case !os.IsNotExist(err):
+        return "", err
+    }
+
+    // create backup db; its schema is replaced by the backup
+    var dbDest *Database
+    dbDest, err = OpenDatabase(backupName, &db.options)
+    if err != nil {
+        return "", err
+    }
+    defer dbDest.CleanUp()
+
+    // ref: https://www.sqlite.org/c3ref/backup_finish.html#sqlite3backupinit
+    err = db.WithRawConn(func(srcConn *sqlite.SQLiteConn) error {
+        return dbDest.WithRawConn(func(destConn *sqlite.SQLiteConn) error {
+
+            backupDest, err := destConn.Backup("main", srcConn, "main")
+            if err != nil {
+                return err
+            }
+
+            _, err = backupDest.Step(-1)
+            if err != nil {
+                backupDest.Finish()
+                return err
+            }
+
+            return backupDest.Finish()
+        })
+    })
+    if err != nil {
+        return "", err
+    }
+
+    return backupName, nil
+}
+
+// replace the contents of the database with the database file (e.g. a backup), and
+// migrate it. the database file is opened read-only and immutable, so it's left untouched.
+func (db *Database) RestoreFrom(filename string) error {
+
+    var err error
+
+    var src *Database
+    src, err = OpenDatabaseReadOnly(filename)
+    if err != nil {
+        return err
+    }
+    defer src.CleanUp()
+
+    var ok bool
+    ok, err = src.QuickCheck()
+    if err != nil {
+        return err
+    }
+    if !ok {
+        return ErrDatabaseCorrupt
+    }
 
-    // TODO: be able to set any filename
-    // TODO: check if db.filename needs to be populated
+    err = db.WithRawConn(func(destConn *sqlite.SQLiteConn) error {
+        return src.WithRawConn(func(srcConn *sqlite.SQLiteConn) error {
 
-    db.filename = db.name + ".db"
+            backupDest, err := destConn.Backup("main", srcConn, "main")
+            if err != nil {
+                return err
+            }
+
+            _, err = backupDest.Step(-1)
+            if err != nil {
+                backupDest.Finish()
+                return err
+            }
+
+            return backupDest.Finish()
+        })
+    })
+    if err != nil {
+        return err
+    }
+
+    // databases from before migrations don't track their schema version
+    _, err = db.instance.Exec(SCHEMA_VERSION_TABLE_QUERY)
+    if err != nil {
+        return err
+    }
+
+    _, err = db.Migrate(false)
+    return err
+}
+
+// open the database file read-only and immutable; i.e. without side files (e.g. -wal).
+// it's neither bootstrapped nor migrated.
+func OpenDatabaseReadOnly(filename string) (*Database, error) {
+
+    var err error
+
+    var db *Database = &Database{name: filename, filename: filename, options: DefaultDatabaseOptions}
+    db.instance, err = sqlx.Connect(SQLITE_DRIVER_NAME, "file:"+filename+"?mode=ro&immutable=1")
+    if sqliteErr, ok := err.(sqlite.Error); ok && (sqliteErr.Code == sqlite.ErrNotADB || sqliteErr.Code == sqlite.ErrCorrupt) {
+        return nil, ErrDatabaseCorrupt
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    return db, nil
+}
+
+// true if the database file isn't corrupt; see: https://www.sqlite.org/pragma.html#pragma_integrity_check
+func (db *Database) IntegrityCheck() (bool, error) {
+
+    query, args, err := QueryApply(INTEGRITY_CHECK_QUERY)
+    if err != nil {
+        return false, err
+    }
+
+    // each problem is a row; a single "ok" row if there are none
+    var results []string = []string{}
+    err = sqlx.Select(db.instance, &results, query, args...)
+    if err != nil {
+        // e.g. file is not a database
+        return false, nil
+    }
+
+    return len(results) == 1 && results[0] == "ok", nil
+}
+
+// like IntegrityCheck, but faster; see: https://www.sqlite.org/pragma.html#pragma_quick_check
+func (db *Database) QuickCheck() (bool, error) {
+
+    query, args, err := QueryApply(QUICK_CHECK_QUERY)
+    if err != nil {
+        return false, err
+    }
+
+    var result string
+    err = db.instance.QueryRowx(query, args...).Scan(&result)
+    if err != nil {
+        // e.g. file is not a database
+        return false, nil
+    }
+
+    return result == "ok", nil
+}
+
+// run f with a raw connection from the connection pool; the connection is reserved
+// for f until it returns.
+func (db *Database) WithRawConn(f func(conn *sqlite.SQLiteConn) error) error {
+
+    conn, err := db.instance.Conn(context.Background())
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+
+    return conn.Raw(func(driverConn interface{}) error {
+
+        sqliteConn, ok := driverConn.(*sqlite.SQLiteConn)
+        if !ok {
+            return ErrDatabaseNotSQLite
+        }
+
+        return f(sqliteConn)
+    })
+}
+
+// validate and normalize options in-place
+func ValidateDatabaseOptions(options *DatabaseOptions) error {
+
+    options.JournalMode = strings.ToUpper(options.JournalMode)
+    switch options.JournalMode {
+    case "DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF":
+    default:
+        return ErrDatabaseInvalidJournalMode
+    }
+
+    options.Synchronous = strings.ToUpper(options.Synchronous)
+    switch options.Synchronous {
+    case "OFF", "NORMAL", "FULL", "EXTRA":
+    default:
+        return ErrDatabaseInvalidSynchronous
+    }
+
+    if options.BusyTimeout < 0 {
+        return ErrDatabaseInvalidBusyTimeout
+    }
+
+    return nil
+}
+
+// the driver applies these pragmas to each connection it opens.
+// transactions take the write lock when they begin (rather than on their first write),
+// so that they wait on busy_timeout instead of failing when another connection writes.
+func (db *Database) DataSourceName() string {
+
+    var params url.Values = url.Values{}
+    params.Set("_journal_mode", db.options.JournalMode)
+    params.Set("_busy_timeout", strconv.Itoa(db.options.BusyTimeout))
+    params.Set("_synchronous", db.options.Synchronous)
+    params.Set("_cache_size", strconv.Itoa(db.options.CacheSize))
+    params.Set("_txlock", "immediate")
+
+    return db.filename + "?" + params.Encode()
+}
+
+func (db *Database) NormalizeFileName() {
+    db.filename = ProfileFileName(db.options.DataDir, db.name)
 }
 
 func (db *Database) CleanUp() {
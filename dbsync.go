@@ -0,0 +1,1650 @@
+package main
+
+import (
+    "bytes"
+    "crypto/rand"
+    "database/sql"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net"
+    "net/http"
+    "net/url"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+
+    // 3rd-party
+    "github.com/gin-gonic/gin"
+    "github.com/jmoiron/sqlx"
+)
+
+/* variables */
+
+// errors
+var ErrSyncSelf = errors.New("sync: database cannot be synced with itself")
+var ErrSyncPeerNotLocal = errors.New("sync: peer must be a grokdb url on localhost; e.g. http://localhost:8081")
+var ErrSyncNoSuchDatabase = errors.New("sync: no database of given name")
+
+// identifies the database to the databases it's synced with
+const CONFIG_SYNC_ID string = "sync_id"
+
+// deck of the filter of a smart stash that no longer exists; such a filter matches no cards
+const SYNC_DELETED_DECK string = "deleted"
+
+// identifies this process; a running grokdb can't sync with itself
+var syncInstance string = newSyncUID()
+
+var syncHTTPClient *http.Client = &http.Client{Timeout: 5 * time.Minute}
+
+/* types */
+
+// every synced row of a database. decks, cards and stashes are identified by their uid
+// across databases; the root deck is never synced. media is identified by its hash.
+type SyncSnapshot struct {
+    Database   string                  `json:"database"`
+    Instance   string                  `json:"instance"`
+    Decks      []SyncDeck              `json:"decks"`
+    Cards      []SyncCard              `json:"cards"`
+    History    []SyncScoreHistoryPoint `json:"history"`
+    Stashes    []SyncStash             `json:"stashes"`
+    Tombstones []SyncTombstone         `json:"tombstones"`
+    Media      []SyncMedia             `json:"media"`
+}
+
+type SyncDeck struct {
+    ID          uint   `json:"-" db:"deck_id"`
+    UID         string `json:"uid" db:"uid"`
+    Parent      string `json:"parent" db:"parent"` // empty for children of the root deck
+    Name        string `json:"name" db:"name"`
+    Description string `json:"description" db:"description"`
+    UpdatedAt   int64  `json:"updated_at" db:"updated_at"`
+
+    // latest modification within the deck's subtree; a deck isn't deleted by a sync
+    // while cards within it were modified after it was deleted elsewhere
+    ContentUpdatedAt int64 `json:"content_updated_at" db:"content_updated_at"`
+}
+
+type SyncCard struct {
+    ID            uint   `json:"-" db:"card_id"`
+    UID           string `json:"uid" db:"uid"`
+    Deck          string `json:"deck" db:"deck"` // empty for cards of the root deck
+    Title         string `json:"title" db:"title"`
+    Description   string `json:"description" db:"description"`
+    Front         string `json:"front" db:"front"`
+    Back          string `json:"back" db:"back"`
+    CreatedAt     int64  `json:"created_at" db:"created_at"`
+    UpdatedAt     int64  `json:"updated_at" db:"updated_at"`
+    TimesReviewed uint   `json:"times_reviewed" db:"times_reviewed"`
+}
+
+type SyncScoreHistoryPoint struct {
+    UID       string  `json:"uid" db:"uid"`
+    Card      string  `json:"card" db:"card"`
+    OccuredAt int64   `json:"occured_at" db:"occured_at"`
+    Success   uint    `json:"success" db:"success"`
+    Fail      uint    `json:"fail" db:"fail"`
+    Score     float64 `json:"score" db:"score"`
+    Changelog string  `json:"changelog" db:"changelog"`
+
+    // counts the point was recorded over; nil for points recorded before migration 4
+    PrevSuccess *uint `json:"prev_success" db:"prev_success"`
+    PrevFail    *uint `json:"prev_fail" db:"prev_fail"`
+
+    // whether the point came from a review; nil for points recorded before migration 7
+    Reviewed *bool `json:"reviewed" db:"reviewed"`
+}
+
+type SyncStash struct {
+    ID          uint               `json:"-" db:"stash_id"`
+    UID         string             `json:"uid" db:"uid"`
+    Name        string             `json:"name" db:"name"`
+    Description string             `json:"description" db:"description"`
+    CreatedAt   int64              `json:"created_at" db:"created_at"`
+    UpdatedAt   int64              `json:"updated_at" db:"updated_at"`
+    Parent      string             `json:"parent" db:"parent"` // empty for top-level stashes
+    Cards       []string           `json:"cards" db:"-"`       // by position
+    Filter      *SyncStashFilter   `json:"filter" db:"-"`      // nil for manual stashes
+    Deadline    *SyncStashDeadline `json:"deadline" db:"-"`
+}
+
+type SyncStashCardRow struct {
+    Stash string `db:"stash"`
+    Card  string `db:"card"`
+}
+
+// filter of a smart stash; see StashFilter
+type SyncStashFilter struct {
+    Stash            string   `json:"-" db:"stash"`
+    DeckID           *uint    `json:"-" db:"deck"`
+    Deck             *string  `json:"deck" db:"-"` // deck uid; empty for the root deck
+    Tags             []string `json:"tags" db:"-"`
+    Search           *string  `json:"search" db:"search"`
+    ScoreMin         *float64 `json:"score_min" db:"score_min"`
+    ScoreMax         *float64 `json:"score_max" db:"score_max"`
+    TimesReviewedMin *uint    `json:"times_reviewed_min" db:"times_reviewed_min"`
+    TimesReviewedMax *uint    `json:"times_reviewed_max" db:"times_reviewed_max"`
+    ReviewedBefore   *int64   `json:"reviewed_before" db:"reviewed_before"`
+    ReviewedAfter    *int64   `json:"reviewed_after" db:"reviewed_after"`
+}
+
+type SyncStashFilterTagRow struct {
+    Stash string `db:"stash"`
+    Tag   string `db:"tag"`
+}
+
+type SyncStashDeadline struct {
+    Stash      string `json:"-" db:"stash"`
+    Deadline   int64  `json:"deadline" db:"deadline"`
+    MinReviews uint   `json:"min_reviews" db:"min_reviews"`
+    CreatedAt  int64  `json:"created_at" db:"created_at"`
+}
+
+// media referenced by cards
+type SyncMedia struct {
+    Hash string `json:"hash" db:"hash"`
+    MIME string `json:"mime" db:"mime"`
+    Data []byte `json:"data" db:"data"`
+}
+
+type SyncTombstone struct {
+    UID       string `json:"uid" db:"uid"`
+    Kind      string `json:"kind" db:"kind"`
+    DeletedAt int64  `json:"deleted_at" db:"deleted_at"`
+}
+
+type SyncCounts struct {
+    Created int `json:"created"`
+    Updated int `json:"updated"`
+    Deleted int `json:"deleted"`
+}
+
+// card edited within both databases since they were last synced
+type SyncConflict struct {
+    Card   uint   `json:"card"`
+    UID    string `json:"uid"`
+    Title  string `json:"title"`
+    Winner string `json:"winner"` // local or remote; the most recently edited
+}
+
+// changes to the local database by merging a remote database into it
+type SyncReport struct {
+    Peer         string         `json:"peer"`
+    Decks        SyncCounts     `json:"decks"`
+    Cards        SyncCounts     `json:"cards"`
+    Stashes      SyncCounts     `json:"stashes"`
+    History      int            `json:"history"`
+    Media        int            `json:"media"`
+    MissingMedia []string       `json:"missing_media"` // referenced by cards, but within neither database
+    Conflicts    []SyncConflict `json:"conflicts"`
+}
+
+type SyncPeerRequest struct {
+    URL string `json:"url" binding:"required"`
+}
+
+// state of merging a remote snapshot into the local database
+type syncMerge struct {
+    tx         *sqlx.Tx
+    rootDeckID uint
+    remote     *SyncSnapshot
+    report     *SyncReport
+    lastSynced int64
+
+    localDecks      map[string]SyncDeck
+    localCards      map[string]SyncCard
+    localStashes    map[string]SyncStash
+    localPoints     map[string]string // history uid to card uid
+    localTombstones map[string]SyncTombstone
+
+    // uid to local id; including rows created by the merge
+    deckIDs  map[string]uint
+    cardIDs  map[string]uint
+    stashIDs map[string]uint
+
+    // cards whose score is replayed from their merged history
+    replayCards map[uint]bool
+}
+
+/* REST Handlers */
+
+// GET /sync/snapshot
+//
+// Every synced row of the database; see POST /sync/snapshot.
+func SyncSnapshotGET(db *sqlx.DB, ctx *gin.Context) {
+
+    snapshot, err := ExportSyncSnapshot(db)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to export database",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    ctx.JSON(http.StatusOK, snapshot)
+}
+
+// POST /sync/snapshot
+//
+// Merge the snapshot of another database (see GET /sync/snapshot) into the database.
+// Responds with the changes made, and conflicts found.
+func SyncSnapshotPOST(db *sqlx.DB, ctx *gin.Context) {
+
+    var (
+        err      error
+        snapshot SyncSnapshot
+    )
+
+    err = ctx.BindJSON(&snapshot)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "bad JSON input",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    if snapshot.Instance == syncInstance {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": ErrSyncSelf.Error(),
+            "userMessage":      ErrSyncSelf.Error(),
+        })
+        ctx.Error(ErrSyncSelf)
+        return
+    }
+
+    report, err := MergeSyncSnapshot(db, &snapshot)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to merge database",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    ctx.JSON(http.StatusOK, report)
+}
+
+// POST /sync/peer
+//
+// Sync with another running grokdb on localhost. The peer's decks, cards, stashes and
+// review history are merged into this database, and then this database is merged into
+// the peer's. Both end up with the same decks, cards, stashes and review state.
+//
+// Input:
+// url: url of the peer; e.g. http://localhost:8081
+func SyncPeerPOST(db *sqlx.DB, ctx *gin.Context) {
+
+    var (
+        err         error
+        jsonRequest SyncPeerRequest
+    )
+
+    err = ctx.BindJSON(&jsonRequest)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "bad JSON input",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var peerURL *url.URL
+    peerURL, err = ParseSyncPeerURL(jsonRequest.URL)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      err.Error(),
+        })
+        ctx.Error(err)
+        return
+    }
+
+    pulled, pushed, err := SyncWithPeer(db, peerURL)
+    switch {
+    case err == ErrSyncSelf:
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      err.Error(),
+        })
+        ctx.Error(err)
+        return
+    case err != nil:
+        ctx.JSON(http.StatusBadGateway, gin.H{
+            "status":           http.StatusBadGateway,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to sync with peer",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    ctx.JSON(http.StatusOK, gin.H{
+        "pulled": pulled,
+        "pushed": pushed,
+    })
+}
+
+/* helpers */
+
+func newSyncUID() string {
+
+    var buf []byte = make([]byte, 16)
+
+    _, err := rand.Read(buf)
+    if err != nil {
+        panic(err)
+    }
+
+    return hex.EncodeToString(buf)
+}
+
+// id of the database; created on first use
+func GetSyncID(db *sqlx.DB) (string, error) {
+
+    config, err := GetConfig(db, CONFIG_SYNC_ID)
+    switch {
+    case err == ErrConfigNoSuchSetting:
+    case err != nil:
+        return "", err
+    case len(config.Value) > 0:
+        return config.Value, nil
+    }
+
+    var syncID string = newSyncUID()
+
+    err = SetConfig(db, CONFIG_SYNC_ID, syncID)
+    if err != nil {
+        return "", err
+    }
+
+    return syncID, nil
+}
+
+func ExportSyncSnapshot(db *sqlx.DB) (*SyncSnapshot, error) {
+
+    syncID, err := GetSyncID(db)
+    if err != nil {
+        return nil, err
+    }
+
+    rootDeck, err := GetRootDeck(db)
+    if err != nil {
+        return nil, err
+    }
+
+    snapshot, err := exportSyncRows(db, rootDeck.ID)
+    if err != nil {
+        return nil, err
+    }
+
+    // media is only exported to be merged; not compared with the local database
+    query, args, err := QueryApply(FETCH_SYNC_MEDIA_QUERY)
+    if err != nil {
+        return nil, err
+    }
+
+    snapshot.Media = []SyncMedia{}
+    err = sqlx.Select(db, &snapshot.Media, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    snapshot.Database = syncID
+    snapshot.Instance = syncInstance
+
+    return snapshot, nil
+}
+
+func exportSyncRows(db sqlx.Ext, rootDeckID uint) (*SyncSnapshot, error) {
+
+    var (
+        err      error
+        query    string
+        args     []interface{}
+        snapshot *SyncSnapshot = &SyncSnapshot{}
+    )
+
+    query, args, err = QueryApply(FETCH_SYNC_DECKS_QUERY, &StringMap{"root_deck_id": rootDeckID})
+    if err != nil {
+        return nil, err
+    }
+
+    snapshot.Decks = []SyncDeck{}
+    err = sqlx.Select(db, &snapshot.Decks, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    query, args, err = QueryApply(FETCH_SYNC_CARDS_QUERY, &StringMap{"root_deck_id": rootDeckID})
+    if err != nil {
+        return nil, err
+    }
+
+    snapshot.Cards = []SyncCard{}
+    err = sqlx.Select(db, &snapshot.Cards, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    query, args, err = QueryApply(FETCH_SYNC_CARD_SCORE_HISTORY_QUERY)
+    if err != nil {
+        return nil, err
+    }
+
+    snapshot.History = []SyncScoreHistoryPoint{}
+    err = sqlx.Select(db, &snapshot.History, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    query, args, err = QueryApply(FETCH_SYNC_STASHES_QUERY)
+    if err != nil {
+        return nil, err
+    }
+
+    snapshot.Stashes = []SyncStash{}
+    err = sqlx.Select(db, &snapshot.Stashes, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    query, args, err = QueryApply(FETCH_SYNC_STASH_CARDS_QUERY)
+    if err != nil {
+        return nil, err
+    }
+
+    var stashCards []SyncStashCardRow = []SyncStashCardRow{}
+    err = sqlx.Select(db, &stashCards, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    var cardsByStash map[string][]string = make(map[string][]string)
+    for _, row := range stashCards {
+        cardsByStash[row.Stash] = append(cardsByStash[row.Stash], row.Card)
+    }
+
+    filtersByStash, err := exportSyncStashFilters(db, rootDeckID, snapshot.Decks)
+    if err != nil {
+        return nil, err
+    }
+
+    query, args, err = QueryApply(FETCH_SYNC_STASH_DEADLINES_QUERY)
+    if err != nil {
+        return nil, err
+    }
+
+    var deadlines []SyncStashDeadline = []SyncStashDeadline{}
+    err = sqlx.Select(db, &deadlines, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    var deadlinesByStash map[string]*SyncStashDeadline = make(map[string]*SyncStashDeadline)
+    for idx := range deadlines {
+        deadlinesByStash[deadlines[idx].Stash] = &deadlines[idx]
+    }
+
+    for idx := range snapshot.Stashes {
+        var stash *SyncStash = &snapshot.Stashes[idx]
+
+        stash.Cards = cardsByStash[stash.UID]
+        if stash.Cards == nil {
+            stash.Cards = []string{}
+        }
+
+        stash.Filter = filtersByStash[stash.UID]
+        stash.Deadline = deadlinesByStash[stash.UID]
+    }
+
+    query, args, err = QueryApply(FETCH_SYNC_TOMBSTONES_QUERY)
+    if err != nil {
+        return nil, err
+    }
+
+    snapshot.Tombstones = []SyncTombstone{}
+    err = sqlx.Select(db, &snapshot.Tombstones, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    return snapshot, nil
+}
+
+// filters of smart stashes by stash uid; decks are given by their uid
+func exportSyncStashFilters(db sqlx.Ext, rootDeckID uint, decks []SyncDeck) (map[string]*SyncStashFilter, error) {
+
+    query, args, err := QueryApply(FETCH_SYNC_STASH_FILTERS_QUERY)
+    if err != nil {
+        return nil, err
+    }
+
+    var filters []SyncStashFilter = []SyncStashFilter{}
+    err = sqlx.Select(db, &filters, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    query, args, err = QueryApply(FETCH_SYNC_STASH_FILTER_TAGS_QUERY)
+    if err != nil {
+        return nil, err
+    }
+
+    var tags []SyncStashFilterTagRow = []SyncStashFilterTagRow{}
+    err = sqlx.Select(db, &tags, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    var deckUIDs map[uint]string = map[uint]string{rootDeckID: ""}
+    for _, deck := range decks {
+        deckUIDs[deck.ID] = deck.UID
+    }
+
+    var filtersByStash map[string]*SyncStashFilter = make(map[string]*SyncStashFilter)
+    for idx := range filters {
+        var filter *SyncStashFilter = &filters[idx]
+
+        if filter.DeckID != nil {
+            deckUID, exists := deckUIDs[*filter.DeckID]
+            if !exists {
+                deckUID = SYNC_DELETED_DECK
+            }
+            filter.Deck = &deckUID
+        }
+
+        filter.Tags = []string{}
+        filtersByStash[filter.Stash] = filter
+    }
+
+    for _, tag := range tags {
+        if filter, exists := filtersByStash[tag.Stash]; exists {
+            filter.Tags = append(filter.Tags, tag.Tag)
+        }
+    }
+
+    return filtersByStash, nil
+}
+
+// merge the snapshot of another database into the database, within a transaction.
+//
+// rows are matched by uid. if a row differs, the most recently modified one wins; deletes
+// win over older modifications. review history is combined, and the score of each card
+// is that of its latest review. merging is repeatable; merging each database into the
+// other leaves both with the same rows.
+func MergeSyncSnapshot(db *sqlx.DB, remote *SyncSnapshot) (*SyncReport, error) {
+
+    rootDeck, err := GetRootDeck(db)
+    if err != nil {
+        return nil, err
+    }
+
+    tx, err := db.Beginx()
+    if err != nil {
+        return nil, err
+    }
+
+    report, err := mergeSyncSnapshot(tx, rootDeck.ID, remote)
+    if err != nil {
+        tx.Rollback()
+        return nil, err
+    }
+
+    err = tx.Commit()
+    if err != nil {
+        return nil, err
+    }
+
+    return report, nil
+}
+
+func mergeSyncSnapshot(tx *sqlx.Tx, rootDeckID uint, remote *SyncSnapshot) (*SyncReport, error) {
+
+    var err error
+
+    local, err := exportSyncRows(tx, rootDeckID)
+    if err != nil {
+        return nil, err
+    }
+
+    var merge *syncMerge = &syncMerge{
+        tx:         tx,
+        rootDeckID: rootDeckID,
+        remote:     remote,
+        report: &SyncReport{
+            Peer:         remote.Database,
+            MissingMedia: []string{},
+            Conflicts:    []SyncConflict{},
+        },
+
+        localDecks:      make(map[string]SyncDeck),
+        localCards:      make(map[string]SyncCard),
+        localStashes:    make(map[string]SyncStash),
+        localPoints:     make(map[string]string),
+        localTombstones: make(map[string]SyncTombstone),
+
+        deckIDs:     make(map[string]uint),
+        cardIDs:     make(map[string]uint),
+        stashIDs:    make(map[string]uint),
+        replayCards: make(map[uint]bool),
+    }
+
+    for _, deck := range local.Decks {
+        merge.localDecks[deck.UID] = deck
+        merge.deckIDs[deck.UID] = deck.ID
+    }
+    for _, card := range local.Cards {
+        merge.localCards[card.UID] = card
+        merge.cardIDs[card.UID] = card.ID
+    }
+    for _, stash := range local.Stashes {
+        merge.localStashes[stash.UID] = stash
+        merge.stashIDs[stash.UID] = stash.ID
+    }
+    for _, point := range local.History {
+        merge.localPoints[point.UID] = point.Card
+    }
+    for _, tombstone := range local.Tombstones {
+        merge.localTombstones[tombstone.UID] = tombstone
+    }
+
+    merge.lastSynced, err = getLastSynced(tx, remote.Database)
+    if err != nil {
+        return nil, err
+    }
+
+    // history may be moved from cards deleted remotely (e.g. merged cards); so deletes
+    // are merged after history. stashes refer to cards that may be deleted.
+    for _, step := range []func() error{
+        merge.mergeMedia,
+        merge.mergeDecks,
+        merge.mergeCards,
+        merge.mergeHistory,
+        merge.mergeTombstones,
+        merge.replayScores,
+        merge.mergeStashes,
+        merge.reportMissingMedia,
+    } {
+        err = step()
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    _, err = syncExec(tx, UPSERT_SYNC_PEER_QUERY, &StringMap{"peer": remote.Database})
+    if err != nil {
+        return nil, err
+    }
+
+    return merge.report, nil
+}
+
+func getLastSynced(db sqlx.Ext, peer string) (int64, error) {
+
+    query, args, err := QueryApply(FETCH_SYNC_PEER_QUERY, &StringMap{"peer": peer})
+    if err != nil {
+        return 0, err
+    }
+
+    var syncedAt int64
+    err = db.QueryRowx(query, args...).Scan(&syncedAt)
+    switch {
+    case err == sql.ErrNoRows:
+        return 0, nil
+    case err != nil:
+        return 0, err
+    }
+
+    return syncedAt, nil
+}
+
+func syncExec(db sqlx.Ext, pipe PipeInput, params *StringMap) (sql.Result, error) {
+
+    query, args, err := QueryApply(pipe, params)
+    if err != nil {
+        return nil, err
+    }
+
+    return db.Exec(query, args...)
+}
+
+// true if the remote row wins over the local row; i.e. it's more recently modified.
+// ties are broken by content, such that both databases pick the same row.
+func syncRemoteWins(localUpdatedAt int64, remoteUpdatedAt int64, localKey string, remoteKey string) bool {
+    if localUpdatedAt != remoteUpdatedAt {
+        return remoteUpdatedAt > localUpdatedAt
+    }
+    return remoteKey > localKey
+}
+
+// true if the row was deleted locally after the remote row was last modified
+func (merge *syncMerge) deletedLocally(uid string, remoteUpdatedAt int64) bool {
+    tombstone, isDeleted := merge.localTombstones[uid]
+    return isDeleted && tombstone.DeletedAt >= remoteUpdatedAt
+}
+
+func (deck SyncDeck) key() string {
+    return strings.Join([]string{deck.Parent, deck.Name, deck.Description}, "\x00")
+}
+
+func (card SyncCard) key() string {
+    return strings.Join([]string{card.Deck, card.Title, card.Description, card.Front, card.Back}, "\x00")
+}
+
+func (stash SyncStash) key() string {
+
+    // settings are compared by their json; nil settings are null
+    filter, _ := json.Marshal(stash.Filter)
+    deadline, _ := json.Marshal(stash.Deadline)
+
+    return strings.Join(append([]string{stash.Name, stash.Description, stash.Parent, string(filter), string(deadline)},
+        stash.Cards...), "\x00")
+}
+
+// media is content-addressed; media that doesn't match its hash is skipped
+func (merge *syncMerge) mergeMedia() error {
+
+    for _, media := range merge.remote.Media {
+
+        if MediaHash(media.Data) != media.Hash {
+            continue
+        }
+
+        _, created, err := CreateMedia(merge.tx, media.Data, media.MIME)
+        if err != nil {
+            return err
+        }
+
+        if created {
+            merge.report.Media++
+        }
+    }
+
+    return nil
+}
+
+// media referenced by cards that exists within neither database
+func (merge *syncMerge) reportMissingMedia() error {
+
+    query, args, err := QueryApply(FETCH_MISSING_CARD_MEDIA_QUERY)
+    if err != nil {
+        return err
+    }
+
+    return sqlx.Select(merge.tx, &merge.report.MissingMedia, query, args...)
+}
+
+// remote decks come before their children
+func (merge *syncMerge) mergeDecks() error {
+
+    var err error
+
+    for _, remoteDeck := range merge.remote.Decks {
+
+        var parentID uint = merge.rootDeckID
+        if len(remoteDeck.Parent) > 0 {
+            var hasParent bool
+            parentID, hasParent = merge.deckIDs[remoteDeck.Parent]
+            if !hasParent {
+                // parent was deleted locally
+                continue
+            }
+        }
+
+        localDeck, exists := merge.localDecks[remoteDeck.UID]
+
+        if !exists {
+
+            if merge.deletedLocally(remoteDeck.UID, remoteDeck.ContentUpdatedAt) {
+                continue
+            }
+
+            var res sql.Result
+            res, err = syncExec(merge.tx, CREATE_SYNC_DECK_QUERY, &StringMap{
+                "uid":         remoteDeck.UID,
+                "name":        remoteDeck.Name,
+                "description": remoteDeck.Description,
+            })
+            if err != nil {
+                return err
+            }
+
+            insertID, err := res.LastInsertId()
+            if err != nil {
+                return err
+            }
+
+            var deckID uint = uint(insertID)
+
+            err = CreateDeckRelationship(merge.tx, parentID, deckID)
+            if err != nil {
+                return err
+            }
+
+            _, err = syncExec(merge.tx, SET_DECK_UPDATED_AT_QUERY, &StringMap{
+                "deck_id":    deckID,
+                "updated_at": remoteDeck.UpdatedAt,
+            })
+            if err != nil {
+                return err
+            }
+
+            merge.deckIDs[remoteDeck.UID] = deckID
+            merge.report.Decks.Created++
+            continue
+        }
+
+        if localDeck.key() == remoteDeck.key() ||
+            !syncRemoteWins(localDeck.UpdatedAt, remoteDeck.UpdatedAt, localDeck.key(), remoteDeck.key()) {
+            continue
+        }
+
+        _, err = syncExec(merge.tx, UPDATE_SYNC_DECK_QUERY, &StringMap{
+            "deck_id":     localDeck.ID,
+            "name":        remoteDeck.Name,
+            "description": remoteDeck.Description,
+        })
+        if err != nil {
+            return err
+        }
+
+        if localDeck.Parent != remoteDeck.Parent {
+
+            // a deck can't be moved into its own subtree
+            var isDescendent bool
+            isDescendent, err = DeckHasDescendent(merge.tx, localDeck.ID, parentID)
+            if err != nil {
+                return err
+            }
+
+            if !isDescendent {
+                err = MoveDeck(merge.tx, localDeck.ID, parentID)
+                if err != nil {
+                    return err
+                }
+            }
+        }
+
+        _, err = syncExec(merge.tx, SET_DECK_UPDATED_AT_QUERY, &StringMap{
+            "deck_id":    localDeck.ID,
+            "updated_at": remoteDeck.UpdatedAt,
+        })
+        if err != nil {
+            return err
+        }
+
+        merge.report.Decks.Updated++
+    }
+
+    return nil
+}
+
+func (merge *syncMerge) mergeCards() error {
+
+    var err error
+
+    for _, remoteCard := range merge.remote.Cards {
+
+        var deckID uint = merge.rootDeckID
+        if len(remoteCard.Deck) > 0 {
+            var hasDeck bool
+            deckID, hasDeck = merge.deckIDs[remoteCard.Deck]
+            if !hasDeck {
+                // deck was deleted locally; after the card was last modified, or the
+                // deck would have been recreated
+                continue
+            }
+        }
+
+        localCard, exists := merge.localCards[remoteCard.UID]
+
+        if !exists {
+
+            if merge.deletedLocally(remoteCard.UID, remoteCard.UpdatedAt) {
+                continue
+            }
+
+            var res sql.Result
+            res, err = syncExec(merge.tx, CREATE_SYNC_CARD_QUERY, &StringMap{
+                "uid":         remoteCard.UID,
+                "title":       remoteCard.Title,
+                "description": remoteCard.Description,
+                "front":       remoteCard.Front,
+                "back":        remoteCard.Back,
+                "deck":        deckID,
+                "created_at":  remoteCard.CreatedAt,
+                "updated_at":  remoteCard.UpdatedAt,
+            })
+            if err != nil {
+                return err
+            }
+
+            insertID, err := res.LastInsertId()
+            if err != nil {
+                return err
+            }
+
+            err = syncCardReferences(merge.tx, uint(insertID))
+            if err != nil {
+                return err
+            }
+
+            merge.cardIDs[remoteCard.UID] = uint(insertID)
+            merge.replayCards[uint(insertID)] = true
+            merge.report.Cards.Created++
+            continue
+        }
+
+        if localCard.key() == remoteCard.key() {
+            continue
+        }
+
+        var remoteWins bool = syncRemoteWins(localCard.UpdatedAt, remoteCard.UpdatedAt, localCard.key(), remoteCard.key())
+
+        if localCard.UpdatedAt > merge.lastSynced && remoteCard.UpdatedAt > merge.lastSynced {
+
+            var conflict SyncConflict = SyncConflict{
+                Card:   localCard.ID,
+                UID:    localCard.UID,
+                Title:  localCard.Title,
+                Winner: "local",
+            }
+            if remoteWins {
+                conflict.Winner = "remote"
+            }
+
+            merge.report.Conflicts = append(merge.report.Conflicts, conflict)
+        }
+
+        if !remoteWins {
+            continue
+        }
+
+        _, err = syncExec(merge.tx, UPDATE_SYNC_CARD_QUERY, &StringMap{
+            "card_id":     localCard.ID,
+            "title":       remoteCard.Title,
+            "description": remoteCard.Description,
+            "front":       remoteCard.Front,
+            "back":        remoteCard.Back,
+            "deck":        deckID,
+        })
+        if err != nil {
+            return err
+        }
+
+        _, err = syncExec(merge.tx, SET_CARD_UPDATED_AT_QUERY, &StringMap{
+            "card_id":    localCard.ID,
+            "updated_at": remoteCard.UpdatedAt,
+        })
+        if err != nil {
+            return err
+        }
+
+        err = syncCardReferences(merge.tx, localCard.ID)
+        if err != nil {
+            return err
+        }
+
+        merge.report.Cards.Updated++
+    }
+
+    return nil
+}
+
+// synced cards are written as rows; the media and cards referenced within their fields
+// are tracked as they are for cards created or edited through the api
+func syncCardReferences(db sqlx.Ext, cardID uint) error {
+
+    err := SyncCardMedia(db, cardID)
+    if err != nil {
+        return err
+    }
+
+    return SyncCardLinks(db, cardID)
+}
+
+func (merge *syncMerge) mergeHistory() error {
+
+    var remoteTombstones map[string]bool = make(map[string]bool)
+    for _, tombstone := range merge.remote.Tombstones {
+        remoteTombstones[tombstone.UID] = true
+    }
+
+    for _, point := range merge.remote.History {
+
+        cardID, hasCard := merge.cardIDs[point.Card]
+        if !hasCard {
+            continue
+        }
+
+        localCardUID, exists := merge.localPoints[point.UID]
+
+        switch {
+        case !exists:
+
+            _, err := syncExec(merge.tx, CREATE_SYNC_CARD_SCORE_HISTORY_QUERY, &StringMap{
+                "uid":          point.UID,
+                "card":         cardID,
+                "occured_at":   point.OccuredAt,
+                "success":      point.Success,
+                "fail":         point.Fail,
+                "score":        point.Score,
+                "changelog":    point.Changelog,
+                "prev_success": point.PrevSuccess,
+                "prev_fail":    point.PrevFail,
+                "reviewed":     point.Reviewed,
+            })
+            if err != nil {
+                return err
+            }
+
+            merge.report.History++
+
+        case localCardUID != point.Card && remoteTombstones[localCardUID]:
+
+            // the card was merged into another card remotely
+            _, err := syncExec(merge.tx, MOVE_SYNC_CARD_SCORE_HISTORY_QUERY, &StringMap{
+                "uid":  point.UID,
+                "card": cardID,
+            })
+            if err != nil {
+                return err
+            }
+
+        default:
+            continue
+        }
+
+        merge.replayCards[cardID] = true
+    }
+
+    return nil
+}
+
+func (merge *syncMerge) mergeTombstones() error {
+
+    var err error
+
+    // cards and stashes are deleted before decks; deleting a deck deletes its cards
+    var tombstones []SyncTombstone = append([]SyncTombstone{}, merge.remote.Tombstones...)
+    sort.SliceStable(tombstones, func(i, j int) bool {
+        return tombstones[i].Kind != "deck" && tombstones[j].Kind == "deck"
+    })
+
+    for _, tombstone := range tombstones {
+
+        switch tombstone.Kind {
+        case "card":
+
+            localCard, exists := merge.localCards[tombstone.UID]
+            if exists && localCard.UpdatedAt > tombstone.DeletedAt {
+                // modified after it was deleted remotely
+                continue
+            }
+
+            if exists {
+                err = DeleteCard(merge.tx, localCard.ID)
+                if err != nil {
+                    return err
+                }
+
+                delete(merge.cardIDs, tombstone.UID)
+                merge.report.Cards.Deleted++
+            }
+
+        case "stash":
+
+            localStash, exists := merge.localStashes[tombstone.UID]
+            if exists && localStash.UpdatedAt > tombstone.DeletedAt {
+                continue
+            }
+
+            if exists {
+                err = DeleteStash(merge.tx, localStash.ID)
+                if err != nil {
+                    return err
+                }
+
+                delete(merge.localStashes, tombstone.UID)
+                merge.report.Stashes.Deleted++
+            }
+
+        case "deck":
+
+            // cards within the deck (or its descendents) modified after it was deleted
+            // remotely are kept along with it
+            localDeck, exists := merge.localDecks[tombstone.UID]
+            if exists && localDeck.ContentUpdatedAt > tombstone.DeletedAt {
+                continue
+            }
+
+            if exists {
+                _, err = GetDeck(merge.tx, localDeck.ID)
+                switch {
+                case err == ErrDeckNoSuchDeck:
+                    // deleted along with its parent
+                case err != nil:
+                    return err
+                default:
+                    err = DeleteDeck(merge.tx, localDeck.ID)
+                    if err != nil {
+                        return err
+                    }
+
+                    merge.report.Decks.Deleted++
+                }
+            }
+
+        default:
+            continue
+        }
+
+        // both databases keep the tombstone; such that the row isn't recreated by other databases
+        _, err = syncExec(merge.tx, MERGE_SYNC_TOMBSTONE_QUERY, &StringMap{
+            "uid":        tombstone.UID,
+            "kind":       tombstone.Kind,
+            "deleted_at": tombstone.DeletedAt,
+        })
+        if err != nil {
+            return err
+        }
+    }
+
+    if merge.report.Decks.Deleted <= 0 {
+        return nil
+    }
+
+    // forget cards deleted along with their deck
+    query, args, err := QueryApply(FETCH_SYNC_CARDS_QUERY, &StringMap{"root_deck_id": merge.rootDeckID})
+    if err != nil {
+        return err
+    }
+
+    var cards []SyncCard = []SyncCard{}
+    err = sqlx.Select(merge.tx, &cards, query, args...)
+    if err != nil {
+        return err
+    }
+
+    merge.cardIDs = make(map[string]uint)
+    for _, card := range cards {
+        merge.cardIDs[card.UID] = card.ID
+    }
+
+    return nil
+}
+
+// recompute the score of cards with new review history from their merged history; such
+// that reviews made within either database add up. every database replays the same
+// history in the same order, and so arrives at the same score.
+func (merge *syncMerge) replayScores() error {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    for cardID := range merge.replayCards {
+
+        query, args, err = QueryApply(FETCH_SYNC_CARD_REPLAY_HISTORY_QUERY, &StringMap{"card": cardID})
+        if err != nil {
+            return err
+        }
+
+        var history []CardScoreHistoryPointRow = []CardScoreHistoryPointRow{}
+        err = sqlx.Select(merge.tx, &history, query, args...)
+        if err != nil {
+            return err
+        }
+
+        if len(history) <= 0 {
+            continue
+        }
+
+        var success, fail uint = ReplayCardScoreHistory(history)
+
+        _, err = syncExec(merge.tx, REPLAY_SYNC_CARD_SCORE_QUERY, &StringMap{
+            "card":           cardID,
+            "success":        success,
+            "fail":           fail,
+            "score":          calculateScore(success, fail),
+            "times_reviewed": CountCardScoreReviews(history),
+        })
+        if err != nil {
+            return err
+        }
+
+        err = DeleteCachedReviewCard(merge.tx, cardID)
+        if err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+func (merge *syncMerge) mergeStashes() error {
+
+    var err error
+
+    for _, remoteStash := range merge.remote.Stashes {
+
+        localStash, exists := merge.localStashes[remoteStash.UID]
+
+        var stashID uint
+
+        switch {
+        case !exists:
+
+            if merge.deletedLocally(remoteStash.UID, remoteStash.UpdatedAt) {
+                continue
+            }
+
+            var res sql.Result
+            res, err = syncExec(merge.tx, CREATE_SYNC_STASH_QUERY, &StringMap{
+                "uid":         remoteStash.UID,
+                "name":        remoteStash.Name,
+                "description": remoteStash.Description,
+                "created_at":  remoteStash.CreatedAt,
+            })
+            if err != nil {
+                return err
+            }
+
+            insertID, err := res.LastInsertId()
+            if err != nil {
+                return err
+            }
+
+            stashID = uint(insertID)
+            merge.stashIDs[remoteStash.UID] = stashID
+            merge.report.Stashes.Created++
+
+        case localStash.key() == remoteStash.key():
+            continue
+
+        case !syncRemoteWins(localStash.UpdatedAt, remoteStash.UpdatedAt, localStash.key(), remoteStash.key()):
+            continue
+
+        default:
+
+            stashID = localStash.ID
+
+            _, err = syncExec(merge.tx, UPDATE_SYNC_STASH_QUERY, &StringMap{
+                "stash_id":    stashID,
+                "name":        remoteStash.Name,
+                "description": remoteStash.Description,
+            })
+            if err != nil {
+                return err
+            }
+
+            merge.report.Stashes.Updated++
+        }
+
+        err = merge.mergeStashSettings(stashID, localStash, remoteStash)
+        if err != nil {
+            return err
+        }
+
+        // only cards added or removed remotely are changed; such that the remaining cards
+        // keep the time they were added
+        var (
+            localCards  map[string]bool = make(map[string]bool)
+            remoteCards map[string]bool = make(map[string]bool)
+        )
+
+        for _, cardUID := range localStash.Cards {
+            localCards[cardUID] = true
+        }
+        for _, cardUID := range remoteStash.Cards {
+            remoteCards[cardUID] = true
+        }
+
+        for _, cardUID := range localStash.Cards {
+
+            cardID, hasCard := merge.cardIDs[cardUID]
+            if !hasCard || remoteCards[cardUID] {
+                continue
+            }
+
+            _, err = syncExec(merge.tx, DISCONNECT_STASH_FROM_CARD_QUERY, &StringMap{
+                "stash_id": stashID,
+                "card_id":  cardID,
+            })
+            if err != nil {
+                return err
+            }
+        }
+
+        for _, cardUID := range remoteStash.Cards {
+
+            cardID, hasCard := merge.cardIDs[cardUID]
+            if !hasCard || localCards[cardUID] {
+                continue
+            }
+
+            _, err = syncExec(merge.tx, CONNECT_STASH_TO_CARD_QUERY, &StringMap{
+                "stash_id": stashID,
+                "card_id":  cardID,
+            })
+            if err != nil {
+                return err
+            }
+        }
+
+        // cards are ordered as they're ordered remotely; cards only within the local
+        // stash (i.e. of cards the remote database doesn't have) keep their order after them
+        var current []uint
+        current, err = StashCardIDsByPosition(merge.tx, stashID)
+        if err != nil {
+            return err
+        }
+
+        var inStash map[uint]bool = cardIDSet(current)
+        var front []uint = make([]uint, 0, len(remoteStash.Cards))
+        for _, cardUID := range remoteStash.Cards {
+            cardID, hasCard := merge.cardIDs[cardUID]
+            if hasCard && inStash[cardID] {
+                front = append(front, cardID)
+            }
+        }
+
+        var order []uint
+        order, err = ReorderStashCards(current, front)
+        if err != nil {
+            return err
+        }
+
+        err = SetStashCardOrder(merge.tx, stashID, order)
+        if err != nil {
+            return err
+        }
+
+        _, err = syncExec(merge.tx, SET_STASH_UPDATED_AT_QUERY, &StringMap{
+            "stash_id":   stashID,
+            "updated_at": remoteStash.UpdatedAt,
+        })
+        if err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// set the parent, filter and deadline of the stash to those of the remote stash
+func (merge *syncMerge) mergeStashSettings(stashID uint, localStash SyncStash, remoteStash SyncStash) error {
+
+    var err error
+
+    // parent; stashes whose parent was deleted locally are top-level stashes
+    if localStash.ID <= 0 || localStash.Parent != remoteStash.Parent {
+
+        var parentID uint = 0
+        if len(remoteStash.Parent) > 0 {
+            parentID = merge.stashIDs[remoteStash.Parent]
+        }
+
+        // a stash can't be moved into its own subtree
+        var isDescendent bool = false
+        if parentID > 0 {
+            isDescendent, err = StashHasDescendent(merge.tx, stashID, parentID)
+            if err != nil {
+                return err
+            }
+        }
+
+        if !isDescendent {
+            err = MoveStash(merge.tx, stashID, parentID)
+            if err != nil {
+                return err
+            }
+        }
+    }
+
+    // filter
+    switch {
+    case remoteStash.Filter != nil:
+
+        var filter *StashFilter = &StashFilter{
+            Tags:             remoteStash.Filter.Tags,
+            Search:           remoteStash.Filter.Search,
+            ScoreMin:         remoteStash.Filter.ScoreMin,
+            ScoreMax:         remoteStash.Filter.ScoreMax,
+            TimesReviewedMin: remoteStash.Filter.TimesReviewedMin,
+            TimesReviewedMax: remoteStash.Filter.TimesReviewedMax,
+            ReviewedBefore:   remoteStash.Filter.ReviewedBefore,
+            ReviewedAfter:    remoteStash.Filter.ReviewedAfter,
+        }
+
+        if remoteStash.Filter.Deck != nil {
+
+            // a filter on a deck that doesn't exist locally matches no cards
+            var deckID uint = merge.deckIDs[*remoteStash.Filter.Deck]
+            if len(*remoteStash.Filter.Deck) <= 0 {
+                deckID = merge.rootDeckID
+            }
+
+            filter.Deck = &deckID
+        }
+
+        err = SetStashFilter(merge.tx, stashID, filter)
+        if err != nil {
+            return err
+        }
+
+    case localStash.Filter != nil:
+
+        _, err = syncExec(merge.tx, DELETE_SYNC_STASH_FILTER_QUERY, &StringMap{"stash_id": stashID})
+        if err != nil {
+            return err
+        }
+    }
+
+    // deadline
+    switch {
+    case remoteStash.Deadline != nil:
+
+        _, err = syncExec(merge.tx, SET_SYNC_STASH_DEADLINE_QUERY, &StringMap{
+            "stash_id":    stashID,
+            "deadline":    remoteStash.Deadline.Deadline,
+            "min_reviews": remoteStash.Deadline.MinReviews,
+            "created_at":  remoteStash.Deadline.CreatedAt,
+        })
+        if err != nil {
+            return err
+        }
+
+    case localStash.Deadline != nil:
+
+        err = DeleteStashDeadline(merge.tx, stashID)
+        if err != nil {
+            return err
+        }
+    }
+
+    // the cached review card may have been chosen by other settings
+    return DeleteCachedReviewCardByStash(merge.tx, stashID)
+}
+
+// merge each database into the other
+func SyncDatabases(db *sqlx.DB, other *sqlx.DB) (*SyncReport, *SyncReport, error) {
+
+    otherSnapshot, err := ExportSyncSnapshot(other)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    pulled, err := MergeSyncSnapshot(db, otherSnapshot)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    snapshot, err := ExportSyncSnapshot(db)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    pushed, err := MergeSyncSnapshot(other, snapshot)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    return pulled, pushed, nil
+}
+
+// the url of a grokdb running on localhost
+func ParseSyncPeerURL(rawURL string) (*url.URL, error) {
+
+    peerURL, err := url.Parse(strings.TrimSpace(rawURL))
+    if err != nil || (peerURL.Scheme != "http" && peerURL.Scheme != "https") {
+        return nil, ErrSyncPeerNotLocal
+    }
+
+    var host string = peerURL.Hostname()
+    if host != "localhost" {
+        ip := net.ParseIP(host)
+        if ip == nil || !ip.IsLoopback() {
+            return nil, ErrSyncPeerNotLocal
+        }
+    }
+
+    return peerURL, nil
+}
+
+// merge the peer's database into the database, then the database into the peer's
+func SyncWithPeer(db *sqlx.DB, peerURL *url.URL) (*SyncReport, *SyncReport, error) {
+
+    var snapshotURL string = strings.TrimSuffix(peerURL.String(), "/") + "/sync/snapshot"
+
+    res, err := syncHTTPClient.Get(snapshotURL)
+    if err != nil {
+        return nil, nil, err
+    }
+    defer res.Body.Close()
+
+    if res.StatusCode != http.StatusOK {
+        return nil, nil, fmt.Errorf("sync: peer responded with %s", res.Status)
+    }
+
+    var peerSnapshot SyncSnapshot
+    err = json.NewDecoder(res.Body).Decode(&peerSnapshot)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    if peerSnapshot.Instance == syncInstance {
+        return nil, nil, ErrSyncSelf
+    }
+
+    pulled, err := MergeSyncSnapshot(db, &peerSnapshot)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    snapshot, err := ExportSyncSnapshot(db)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    body, err := json.Marshal(snapshot)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    res, err = syncHTTPClient.Post(snapshotURL, "application/json", bytes.NewReader(body))
+    if err != nil {
+        return nil, nil, err
+    }
+    defer res.Body.Close()
+
+    if res.StatusCode != http.StatusOK {
+        return nil, nil, fmt.Errorf("sync: peer responded with %s", res.Status)
+    }
+
+    var pushed SyncReport
+    err = json.NewDecoder(res.Body).Decode(&pushed)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    return pulled, &pushed, nil
+}
+
+func printSyncReport(name string, report *SyncReport) {
+
+    fmt.Printf("%s: decks +%d ~%d -%d, cards +%d ~%d -%d, stashes +%d ~%d -%d, %d reviews, %d media\n",
+        name,
+        report.Decks.Created, report.Decks.Updated, report.Decks.Deleted,
+        report.Cards.Created, report.Cards.Updated, report.Cards.Deleted,
+        report.Stashes.Created, report.Stashes.Updated, report.Stashes.Deleted,
+        report.History, report.Media)
+
+    for _, hash := range report.MissingMedia {
+        fmt.Printf("  missing media: %s is referenced by cards, but exists within neither database\n", hash)
+    }
+
+    for _, conflict := range report.Conflicts {
+        fmt.Printf("  conflict: card %d (%s) was edited in both; kept the %s edit\n",
+            conflict.Card, conflict.Title, conflict.Winner)
+    }
+}
+
+// grokdb sync <database> <database>
+func syncCommand(name string, otherName string, options *DatabaseOptions) {
+
+    var err error
+
+    var filename string = ProfileFileName(options.DataDir, name)
+    var otherFilename string = ProfileFileName(options.DataDir, otherName)
+
+    // don't create a database for a mistyped name
+    for _, file := range []string{filename, otherFilename} {
+        _, err = os.Stat(file)
+        if os.IsNotExist(err) {
+            exitIfErr(fmt.Errorf("%s: %s", ErrSyncNoSuchDatabase.Error(), file), 1)
+        }
+        exitIfErr(err, 1)
+    }
+
+    absFilename, err := filepath.Abs(filename)
+    exitIfErr(err, 1)
+    absOtherFilename, err := filepath.Abs(otherFilename)
+    exitIfErr(err, 1)
+
+    if absFilename == absOtherFilename {
+        exitIfErr(ErrSyncSelf, 1)
+    }
+
+    db, err := FetchDatabase(name, options)
+    exitIfErr(err, 1)
+    defer db.CleanUp()
+
+    other, err := FetchDatabase(otherName, options)
+    exitIfErr(err, 1)
+    defer other.CleanUp()
+
+    pulled, pushed, err := SyncDatabases(db.instance, other.instance)
+    exitIfErr(err, 1)
+
+    printSyncReport(name, pulled)
+    printSyncReport(otherName, pushed)
+}
@@ -0,0 +1,596 @@
+package main
+
+import (
+    "errors"
+    "net/http"
+    "strconv"
+    "strings"
+
+    // 3rd-party
+    "github.com/gin-gonic/gin"
+    "github.com/jmoiron/sqlx"
+)
+
+/* variables */
+
+// errors
+var ErrDeckMergeSelf = errors.New("decks: deck cannot be merged into itself")
+var ErrDeckMergeDescendent = errors.New("decks: deck cannot be merged into its descendent")
+var ErrDeckMergeRoot = errors.New("decks: root deck cannot be merged")
+var ErrDeckCloneNoParent = errors.New("decks: parent is required to clone a deck without a parent")
+
+// review state of cloned cards
+const (
+    DECK_CLONE_REVIEW_RESET string = "reset"
+    DECK_CLONE_REVIEW_COPY  string = "copy"
+)
+
+/* types */
+
+type DeckMergeRequest struct {
+    Into uint `json:"into" binding:"required,min=1"`
+}
+
+type DeckCloneRequest struct {
+    Parent uint   `json:"parent"`
+    Name   string `json:"name"`
+    Review string `json:"review"`
+}
+
+/* REST Handlers */
+
+// POST /decks/:id/merge
+//
+// Merge the deck into another deck. The deck's cards and child decks are moved into
+// the other deck, and the deck is then deleted.
+//
+// Params:
+// id: a unique, positive integer that is the identifier of the deck to merge
+//
+// Input:
+// into: a unique, positive integer that is the identifier of the deck to merge into;
+//       it cannot be a descendent of the merged deck
+func DeckMergePOST(db *sqlx.DB, ctx *gin.Context) {
+
+    var err error
+
+    // parse id param
+    var deckIDString string = strings.ToLower(ctx.Param("id"))
+
+    _deckID, err := strconv.ParseUint(deckIDString, 10, 32)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "given id is invalid",
+        })
+        ctx.Error(err)
+        return
+    }
+    var deckID uint = uint(_deckID)
+
+    var jsonRequest DeckMergeRequest
+    err = ctx.BindJSON(&jsonRequest)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "bad JSON input",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    if jsonRequest.Into == deckID {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": ErrDeckMergeSelf.Error(),
+            "userMessage":      ErrDeckMergeSelf.Error(),
+        })
+        ctx.Error(ErrDeckMergeSelf)
+        return
+    }
+
+    // ensure decks exist
+
+    _, err = GetDeck(db, deckID)
+    switch {
+    case err == ErrDeckNoSuchDeck:
+        ctx.JSON(http.StatusNotFound, gin.H{
+            "status":           http.StatusNotFound,
+            "developerMessage": err.Error(),
+            "userMessage":      "cannot find deck with given id",
+        })
+        ctx.Error(err)
+        return
+    case err != nil:
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve deck",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var target *DeckRow
+    target, err = GetDeck(db, jsonRequest.Into)
+    switch {
+    case err == ErrDeckNoSuchDeck:
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "given into id is invalid",
+        })
+        ctx.Error(err)
+        return
+    case err != nil:
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve deck",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    // the root deck has no parent
+    _, err = GetDeckParent(db, deckID)
+    switch {
+    case err == ErrDeckHasNoParent:
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": ErrDeckMergeRoot.Error(),
+            "userMessage":      ErrDeckMergeRoot.Error(),
+        })
+        ctx.Error(ErrDeckMergeRoot)
+        return
+    case err != nil:
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve parent deck",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var isDescendent bool
+    isDescendent, err = DeckHasDescendent(db, deckID, target.ID)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve deck lineage",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    if isDescendent {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": ErrDeckMergeDescendent.Error(),
+            "userMessage":      ErrDeckMergeDescendent.Error(),
+        })
+        ctx.Error(ErrDeckMergeDescendent)
+        return
+    }
+
+    var tx *sqlx.Tx
+    tx, err = db.Beginx()
+    if err == nil {
+        err = MergeDecks(tx, deckID, target.ID)
+        if err == nil {
+            err = tx.Commit()
+        } else {
+            tx.Rollback()
+        }
+    }
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to merge decks",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var response gin.H
+    response, err = DeckRowToResponse(db, target)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve deck",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    ctx.JSON(http.StatusOK, MergeResponse(&response, &gin.H{"merged": deckID}))
+}
+
+// POST /decks/:id/clone
+//
+// Copy the deck subtree, including its cards, into a parent deck.
+//
+// Params:
+// id: a unique, positive integer that is the identifier of the deck to clone
+//
+// Input:
+// parent: deck to put the copy into (default: the parent of the deck)
+// name: name of the copy (default: the name of the deck)
+// review: review state of the copied cards; one of:
+//         reset (default): copied cards are new cards that have yet to be reviewed
+//         copy: copied cards have the score and suspension of the original cards
+func DeckClonePOST(db *sqlx.DB, ctx *gin.Context) {
+
+    var err error
+
+    // parse id param
+    var deckIDString string = strings.ToLower(ctx.Param("id"))
+
+    _deckID, err := strconv.ParseUint(deckIDString, 10, 32)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "given id is invalid",
+        })
+        ctx.Error(err)
+        return
+    }
+    var deckID uint = uint(_deckID)
+
+    var jsonRequest DeckCloneRequest
+    err = ctx.BindJSON(&jsonRequest)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "bad JSON input",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    switch jsonRequest.Review {
+    case "":
+        jsonRequest.Review = DECK_CLONE_REVIEW_RESET
+    case DECK_CLONE_REVIEW_RESET:
+    case DECK_CLONE_REVIEW_COPY:
+    default:
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": "invalid review option",
+            "userMessage":      "review must be one of: reset, copy",
+        })
+        return
+    }
+
+    var source *DeckRow
+    source, err = GetDeck(db, deckID)
+    switch {
+    case err == ErrDeckNoSuchDeck:
+        ctx.JSON(http.StatusNotFound, gin.H{
+            "status":           http.StatusNotFound,
+            "developerMessage": err.Error(),
+            "userMessage":      "cannot find deck with given id",
+        })
+        ctx.Error(err)
+        return
+    case err != nil:
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve deck",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    if len(strings.TrimSpace(jsonRequest.Name)) <= 0 {
+        jsonRequest.Name = source.Name
+    }
+
+    // resolve parent of the copy
+
+    if jsonRequest.Parent <= 0 {
+        jsonRequest.Parent, err = GetDeckParent(db, deckID)
+        switch {
+        case err == ErrDeckHasNoParent:
+            ctx.JSON(http.StatusBadRequest, gin.H{
+                "status":           http.StatusBadRequest,
+                "developerMessage": ErrDeckCloneNoParent.Error(),
+                "userMessage":      ErrDeckCloneNoParent.Error(),
+            })
+            ctx.Error(ErrDeckCloneNoParent)
+            return
+        case err != nil:
+            ctx.JSON(http.StatusInternalServerError, gin.H{
+                "status":           http.StatusInternalServerError,
+                "developerMessage": err.Error(),
+                "userMessage":      "unable to retrieve parent deck",
+            })
+            ctx.Error(err)
+            return
+        }
+    } else {
+        _, err = GetDeck(db, jsonRequest.Parent)
+        switch {
+        case err == ErrDeckNoSuchDeck:
+            ctx.JSON(http.StatusBadRequest, gin.H{
+                "status":           http.StatusBadRequest,
+                "developerMessage": err.Error(),
+                "userMessage":      "given parent id is invalid",
+            })
+            ctx.Error(err)
+            return
+        case err != nil:
+            ctx.JSON(http.StatusInternalServerError, gin.H{
+                "status":           http.StatusInternalServerError,
+                "developerMessage": err.Error(),
+                "userMessage":      "unable to retrieve deck",
+            })
+            ctx.Error(err)
+            return
+        }
+    }
+
+    var clone *DeckRow
+
+    var tx *sqlx.Tx
+    tx, err = db.Beginx()
+    if err == nil {
+        clone, err = CloneDeck(tx, source, jsonRequest.Parent, jsonRequest.Name,
+            jsonRequest.Review == DECK_CLONE_REVIEW_COPY)
+        if err == nil {
+            err = tx.Commit()
+        } else {
+            tx.Rollback()
+        }
+    }
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to clone deck",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var response gin.H
+    response, err = DeckRowToResponse(db, clone)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve deck",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    ctx.JSON(http.StatusCreated, response)
+}
+
+/* helpers */
+
+func DeckRowToResponse(db sqlx.Ext, deck *DeckRow) (gin.H, error) {
+
+    var err error
+
+    var children []uint
+    children, err = GetDeckChildren(db, deck.ID)
+    switch {
+    case err == ErrDeckNoChildren:
+        children = []uint{}
+    case err != nil:
+        return nil, err
+    }
+
+    var parentID uint
+    var hasParent bool = true
+    parentID, err = GetDeckParent(db, deck.ID)
+    switch {
+    case err == ErrDeckHasNoParent:
+        parentID = 0
+        hasParent = false
+    case err != nil:
+        return nil, err
+    }
+
+    return DeckResponse(&gin.H{
+        "id":          deck.ID,
+        "name":        deck.Name,
+        "description": deck.Description,
+        "children":    children,
+        "parent":      parentID,
+        "hasParent":   hasParent,
+    }), nil
+}
+
+// move cards and child decks of the deck into the target deck, and delete the deck.
+// the target is assumed to be neither the deck nor one of its descendents.
+func MergeDecks(db sqlx.Ext, deckID uint, targetID uint) error {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    // review cards cached for either deck subtree may no longer be within it
+    for _, id := range []uint{deckID, targetID} {
+
+        query, args, err = QueryApply(DELETE_CACHED_REVIEWCARD_BY_DECK_ANCESTORS_QUERY, &StringMap{"deck_id": id})
+        if err != nil {
+            return err
+        }
+
+        _, err = db.Exec(query, args...)
+        if err != nil {
+            return err
+        }
+    }
+
+    query, args, err = QueryApply(MOVE_CARDS_OF_DECK_QUERY, &StringMap{
+        "deck_id": deckID,
+        "target":  targetID,
+    })
+    if err != nil {
+        return err
+    }
+
+    _, err = db.Exec(query, args...)
+    if err != nil {
+        return err
+    }
+
+    var children []uint
+    children, err = GetDeckChildren(db, deckID)
+    switch {
+    case err == ErrDeckNoChildren:
+        children = []uint{}
+    case err != nil:
+        return err
+    }
+
+    for _, childID := range children {
+        err = MoveDeck(db, childID, targetID)
+        if err != nil {
+            return err
+        }
+    }
+
+    return DeleteDeck(db, deckID)
+}
+
+// copy the deck subtree, including its cards, as a child of the parent deck.
+// tags of cards are copied; scores and suspensions are copied only if copyReview is true.
+func CloneDeck(db sqlx.Ext, source *DeckRow, parentID uint, name string, copyReview bool) (*DeckRow, error) {
+
+    var err error
+
+    // fetch the subtree before copying; the copy may be placed within it
+    var subtree map[uint][]uint = make(map[uint][]uint)
+    var descendents []uint
+    descendents, err = GetDeckDescendents(db, source.ID)
+    if err != nil {
+        return nil, err
+    }
+
+    for _, deckID := range descendents {
+
+        var children []uint
+        children, err = GetDeckChildren(db, deckID)
+        switch {
+        case err == ErrDeckNoChildren:
+            children = []uint{}
+        case err != nil:
+            return nil, err
+        }
+
+        subtree[deckID] = children
+    }
+
+    var cloneSubtree func(deck *DeckRow, parentID uint, name string) (*DeckRow, error)
+    cloneSubtree = func(deck *DeckRow, parentID uint, name string) (*DeckRow, error) {
+
+        clone, err := CreateDeck(db, &DeckProps{
+            Name:        name,
+            Description: deck.Description,
+        })
+        if err != nil {
+            return nil, err
+        }
+
+        err = CreateDeckRelationship(db, parentID, clone.ID)
+        if err != nil {
+            return nil, err
+        }
+
+        err = cloneCardsOfDeck(db, deck.ID, clone.ID, copyReview)
+        if err != nil {
+            return nil, err
+        }
+
+        for _, childID := range subtree[deck.ID] {
+
+            child, err := GetDeck(db, childID)
+            if err != nil {
+                return nil, err
+            }
+
+            _, err = cloneSubtree(child, clone.ID, child.Name)
+            if err != nil {
+                return nil, err
+            }
+        }
+
+        return clone, nil
+    }
+
+    return cloneSubtree(source, parentID, name)
+}
+
+func cloneCardsOfDeck(db sqlx.Ext, deckID uint, cloneID uint, copyReview bool) error {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(FETCH_CARDS_OF_DECK_QUERY, &StringMap{"deck_id": deckID})
+    if err != nil {
+        return err
+    }
+
+    var cards []CardRow = []CardRow{}
+    err = sqlx.Select(db, &cards, query, args...)
+    if err != nil {
+        return err
+    }
+
+    for _, card := range cards {
+
+        var clone *CardRow
+        clone, err = CreateCard(db, &CardProps{
+            Title:       card.Title,
+            Description: card.Description,
+            Front:       card.Front,
+            Back:        card.Back,
+            Deck:        cloneID,
+        })
+        if err != nil {
+            return err
+        }
+
+        var pipes []PipeInput = []PipeInput{COPY_CARD_TAGS_QUERY}
+        if copyReview {
+            pipes = append(pipes, COPY_CARD_REVIEW_STATE_QUERY)
+        }
+
+        for _, pipe := range pipes {
+
+            query, args, err = QueryApply(pipe, &StringMap{
+                "card_id": clone.ID,
+                "source":  card.ID,
+            })
+            if err != nil {
+                return err
+            }
+
+            _, err = db.Exec(query, args...)
+            if err != nil {
+                return err
+            }
+        }
+    }
+
+    return nil
+}
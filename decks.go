@@ -22,6 +22,13 @@ var ErrDeckNoChildren = errors.New("decks: deck has no children")
 var ErrQueryDeckNotPatched = errors.New("decks: deck not patched")
 var ErrDeckHasNoParent = errors.New("decks: deck has no parent")
 var ErrDeckNoAncestors = errors.New("decks: deck has no ancestors")
+var ErrDeckPathNotFound = errors.New("decks: no deck at given path")
+
+// separator of deck names within a deck path; e.g. "Spanish/Verbs"
+const DECK_PATH_SEPARATOR string = "/"
+
+// escapes a separator (or an escape) within a deck name of a deck path; e.g. "Verbs\/Nouns"
+const DECK_PATH_ESCAPE string = `\`
 
 /* types */
 
@@ -840,7 +847,7 @@ func DeckPOST(db *sqlx.DB, ctx *gin.Context) {
 
 // DELETE /decks/:id
 //
-// Delete deck by id
+// Move deck subtree, and the cards within it, into the trash
 //
 // Params:
 // id: a unique, positive integer that is the identifier of the assocoated deck
@@ -885,8 +892,26 @@ func DeckDELETE(db *sqlx.DB, ctx *gin.Context) {
         return
     }
 
-    // delete deck
-    err = DeleteDeck(db, deckID)
+    // move deck subtree into the trash
+    var tx *sqlx.Tx
+    tx, err = db.Beginx()
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to delete deck",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    _, err = TrashDeck(tx, deckID)
+    if err == nil {
+        err = tx.Commit()
+    } else {
+        tx.Rollback()
+    }
+
     if err != nil {
         ctx.JSON(http.StatusInternalServerError, gin.H{
             "status":           http.StatusInternalServerError,
@@ -1228,7 +1253,7 @@ func DeckResponse(overrides *gin.H) gin.H {
     return MergeResponse(defaultResponse, overrides)
 }
 
-func GetDeck(db *sqlx.DB, deckID uint) (*DeckRow, error) {
+func GetDeck(db sqlx.Ext, deckID uint) (*DeckRow, error) {
 
     var (
         err   error
@@ -1255,7 +1280,7 @@ func GetDeck(db *sqlx.DB, deckID uint) (*DeckRow, error) {
     }
 }
 
-func CreateDeck(db *sqlx.DB, props *DeckProps) (*DeckRow, error) {
+func CreateDeck(db sqlx.Ext, props *DeckProps) (*DeckRow, error) {
 
     // TODO: validation on props
 
@@ -1360,7 +1385,7 @@ func GetRootDeck(db *sqlx.DB) (*DeckRow, error) {
     }
 }
 
-func DeleteDeck(db *sqlx.DB, deckID uint) error {
+func DeleteDeck(db sqlx.Ext, deckID uint) error {
 
     var (
         err      error
@@ -1391,7 +1416,7 @@ func DeleteDeck(db *sqlx.DB, deckID uint) error {
     return nil
 }
 
-func GetDeckChildren(db *sqlx.DB, parentID uint) ([]uint, error) {
+func GetDeckChildren(db sqlx.Ext, parentID uint) ([]uint, error) {
 
     var (
         err      error
@@ -1424,7 +1449,7 @@ func GetDeckChildren(db *sqlx.DB, parentID uint) ([]uint, error) {
     return children, nil
 }
 
-func GetDeckParent(db *sqlx.DB, childID uint) (uint, error) {
+func GetDeckParent(db sqlx.Ext, childID uint) (uint, error) {
 
     var (
         err   error
@@ -1452,7 +1477,7 @@ func GetDeckParent(db *sqlx.DB, childID uint) (uint, error) {
 }
 
 // fetch ancestors from farthest to nearest
-func GetDeckAncestors(db *sqlx.DB, childID uint) ([]uint, error) {
+func GetDeckAncestors(db sqlx.Ext, childID uint) ([]uint, error) {
 
     var (
         err       error
@@ -1486,7 +1511,7 @@ func GetDeckAncestors(db *sqlx.DB, childID uint) ([]uint, error) {
     return ancestors, nil
 }
 
-func CreateDeckRelationship(db *sqlx.DB, parent uint, child uint) error {
+func CreateDeckRelationship(db sqlx.Ext, parent uint, child uint) error {
 
     var (
         err   error
@@ -1507,7 +1532,7 @@ func CreateDeckRelationship(db *sqlx.DB, parent uint, child uint) error {
     return nil
 }
 
-func MoveDeck(db *sqlx.DB, child uint, newParent uint) error {
+func MoveDeck(db sqlx.Ext, child uint, newParent uint) error {
 
     var (
         err   error
@@ -1545,7 +1570,7 @@ func MoveDeck(db *sqlx.DB, child uint, newParent uint) error {
     return nil
 }
 
-func DeckHasDescendent(db *sqlx.DB, parentID uint, childID uint) (bool, error) {
+func DeckHasDescendent(db sqlx.Ext, parentID uint, childID uint) (bool, error) {
 
     var (
         err   error
@@ -1570,3 +1595,212 @@ func DeckHasDescendent(db *sqlx.DB, parentID uint, childID uint) (bool, error) {
 
     return (count > 0), nil
 }
+
+func GetDeckChildByName(db sqlx.Ext, parentID uint, name string) (*DeckRow, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(DECK_CHILD_BY_NAME_QUERY, &StringMap{
+        "parent": parentID,
+        "name":   name,
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    var fetchedDeck *DeckRow = &DeckRow{}
+
+    err = db.QueryRowx(query, args...).StructScan(fetchedDeck)
+
+    switch {
+    case err == sql.ErrNoRows:
+        return nil, ErrDeckNoSuchDeck
+    case err != nil:
+        return nil, err
+    default:
+        return fetchedDeck, nil
+    }
+}
+
+// split a deck path into its deck names; empty names are dropped.
+// escaped separators (and escapes) are kept within their deck name.
+func ParseDeckPath(path string) []string {
+
+    var (
+        names   []string = []string{}
+        name    []rune   = []rune{}
+        escaped bool     = false
+    )
+
+    var appendName = func() {
+        var trimmed string = strings.TrimSpace(string(name))
+        if len(trimmed) > 0 {
+            names = append(names, trimmed)
+        }
+        name = []rune{}
+    }
+
+    for _, char := range path {
+        switch {
+        case escaped:
+            name = append(name, char)
+            escaped = false
+        case string(char) == DECK_PATH_ESCAPE:
+            escaped = true
+        case string(char) == DECK_PATH_SEPARATOR:
+            appendName()
+        default:
+            name = append(name, char)
+        }
+    }
+
+    appendName()
+
+    return names
+}
+
+// join deck names into a deck path; separators (and escapes) within names are escaped
+func JoinDeckPath(names []string) string {
+
+    var escaper *strings.Replacer = strings.NewReplacer(
+        DECK_PATH_ESCAPE, DECK_PATH_ESCAPE+DECK_PATH_ESCAPE,
+        DECK_PATH_SEPARATOR, DECK_PATH_ESCAPE+DECK_PATH_SEPARATOR,
+    )
+
+    var escaped []string = make([]string, 0, len(names))
+    for _, name := range names {
+        escaped = append(escaped, escaper.Replace(name))
+    }
+
+    return strings.Join(escaped, DECK_PATH_SEPARATOR)
+}
+
+// resolve the deck at the given path relative to baseID.
+// if create is true, any missing decks along the path are created; the newly created
+// decks are returned in the order they were created.
+func ResolveDeckPath(db sqlx.Ext, baseID uint, path []string, create bool) (uint, []DeckRow, error) {
+
+    var (
+        err     error
+        current uint      = baseID
+        created []DeckRow = []DeckRow{}
+    )
+
+    for _, name := range path {
+
+        var child *DeckRow
+        child, err = GetDeckChildByName(db, current, name)
+
+        switch {
+        case err == ErrDeckNoSuchDeck:
+
+            if !create {
+                return 0, nil, ErrDeckPathNotFound
+            }
+
+            child, err = CreateDeck(db, &DeckProps{
+                Name:        name,
+                Description: "",
+            })
+            if err != nil {
+                return 0, nil, err
+            }
+
+            err = CreateDeckRelationship(db, current, child.ID)
+            if err != nil {
+                return 0, nil, err
+            }
+
+            created = append(created, *child)
+
+        case err != nil:
+            return 0, nil, err
+        }
+
+        current = child.ID
+    }
+
+    return current, created, nil
+}
+
+// fetch deck names from baseID (exclusive) to deckID (inclusive).
+// if deckID is not a descendent of baseID, the path starts from the top-most ancestor.
+func GetDeckPath(db sqlx.Ext, baseID uint, deckID uint) ([]string, error) {
+
+    var (
+        err       error
+        ancestors []uint
+        names     []string = []string{}
+    )
+
+    if deckID == baseID {
+        return names, nil
+    }
+
+    ancestors, err = GetDeckAncestors(db, deckID)
+    if err != nil && err != ErrDeckNoAncestors {
+        return nil, err
+    }
+
+    var lineage []uint = append(ancestors, deckID)
+
+    // skip baseID and its ancestors
+    for idx, ancestorID := range lineage {
+        if ancestorID == baseID {
+            lineage = lineage[idx+1:]
+            break
+        }
+    }
+
+    for _, id := range lineage {
+
+        var fetchedDeck *DeckRow
+        fetchedDeck, err = GetDeck(db, id)
+        if err != nil {
+            return nil, err
+        }
+
+        names = append(names, fetchedDeck.Name)
+    }
+
+    return names, nil
+}
+
+// fetch all descendents of the deck (including itself) from nearest to farthest
+func GetDeckDescendents(db sqlx.Ext, parentID uint) ([]uint, error) {
+
+    var (
+        err         error
+        query       string
+        rows        *sqlx.Rows
+        args        []interface{}
+        dr          DeckRelationship = DeckRelationship{}
+        descendents []uint           = []uint{}
+    )
+
+    query, args, err = QueryApply(DECK_DESCENDENTS_QUERY, &StringMap{"parent": parentID})
+    if err != nil {
+        return nil, err
+    }
+
+    rows, err = db.Queryx(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        err := rows.StructScan(&dr)
+        if err != nil {
+            return nil, err
+        }
+
+        descendents = append(descendents, dr.Descendent)
+    }
+
+    return descendents, nil
+}
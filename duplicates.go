@@ -0,0 +1,484 @@
+package main
+
+import (
+    "errors"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "unicode"
+    "unicode/utf8"
+
+    // 3rd-party
+    "github.com/gin-gonic/gin"
+    "github.com/jmoiron/sqlx"
+)
+
+/* variables */
+
+// errors
+var ErrDuplicatesInvalidThreshold = errors.New("duplicates: threshold must be within (0, 1]")
+
+// cards at least this similar are likely duplicates
+const DUPLICATES_DEFAULT_THRESHOLD float64 = 0.8
+
+// upper bound of cards compared against a new card
+const DUPLICATES_MAX_CANDIDATES uint = 500
+
+// upper bound of words of a new card searched for candidates
+const DUPLICATES_MAX_SEARCH_TERMS int = 16
+
+// words shared by more cards than this aren't used to pair up cards when scanning a deck
+const DUPLICATES_MAX_WORD_FREQUENCY int = 50
+
+/* types */
+
+type DuplicateCardRow struct {
+    CardRow
+    TimesReviewed int64 `db:"times_reviewed"`
+}
+
+type DuplicateMatch struct {
+    Card       DuplicateCardRow
+    Similarity float64
+}
+
+type DuplicatePair struct {
+    Cards      [2]uint `json:"cards"`
+    Similarity float64 `json:"similarity"`
+}
+
+type DuplicateCluster struct {
+    Cards    []DuplicateCardRow
+    Pairs    []DuplicatePair
+    Survivor uint
+    Victims  []uint
+}
+
+/* REST Handlers */
+
+// GET /decks/:id/duplicates
+//
+// Scan cards within the deck subtree for near-duplicates; by title and front. Cards are
+// clustered with a suggested merge keeping the most reviewed card; see POST /cards/:id/merge.
+//
+// Params:
+// id: a unique, positive integer that is the identifier of the deck
+//
+// Query params:
+// threshold: minimum similarity of duplicates; within (0, 1] (default: 0.8)
+func DeckDuplicatesGET(db *sqlx.DB, ctx *gin.Context) {
+
+    var err error
+
+    // parse and validate id param
+    var deckIDString string = strings.ToLower(ctx.Param("id"))
+
+    _deckID, err := strconv.ParseUint(deckIDString, 10, 32)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "given id is invalid",
+        })
+        ctx.Error(err)
+        return
+    }
+    var deckID uint = uint(_deckID)
+
+    var threshold float64
+    threshold, err = ParseDuplicatesThreshold(ctx)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      err.Error(),
+        })
+        ctx.Error(err)
+        return
+    }
+
+    _, err = GetDeck(db, deckID)
+    switch {
+    case err == ErrDeckNoSuchDeck:
+        ctx.JSON(http.StatusNotFound, gin.H{
+            "status":           http.StatusNotFound,
+            "developerMessage": err.Error(),
+            "userMessage":      "cannot find deck by id",
+        })
+        ctx.Error(err)
+        return
+    case err != nil:
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve deck",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var clusters []DuplicateCluster
+    clusters, err = ScanDuplicateCards(db, deckID, threshold)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to scan deck for duplicates",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var response []gin.H = make([]gin.H, 0, len(clusters))
+
+    for _, cluster := range clusters {
+
+        var cards []gin.H = make([]gin.H, 0, len(cluster.Cards))
+        for _, card := range cluster.Cards {
+            cards = append(cards, gin.H{
+                "id":             card.ID,
+                "title":          card.Title,
+                "deck":           card.Deck,
+                "times_reviewed": card.TimesReviewed,
+            })
+        }
+
+        response = append(response, gin.H{
+            "cards": cards,
+            "pairs": cluster.Pairs,
+            "suggested_merge": gin.H{
+                "survivor": cluster.Survivor,
+                "victims":  cluster.Victims,
+            },
+        })
+    }
+
+    ctx.JSON(http.StatusOK, gin.H{
+        "deck":      deckID,
+        "threshold": threshold,
+        "clusters":  response,
+    })
+}
+
+/* helpers */
+
+func ParseDuplicatesThreshold(ctx *gin.Context) (float64, error) {
+
+    threshold, err := strconv.ParseFloat(ctx.DefaultQuery("threshold", strconv.FormatFloat(DUPLICATES_DEFAULT_THRESHOLD, 'f', -1, 64)), 64)
+    if err != nil || threshold <= 0 || threshold > 1 {
+        return 0, ErrDuplicatesInvalidThreshold
+    }
+
+    return threshold, nil
+}
+
+// lowercase letters and digits of the text; separated by single spaces
+func NormalizeCardText(text string) string {
+
+    var words []string = strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+        return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+    })
+
+    return strings.Join(words, " ")
+}
+
+// text of the card compared for duplicates
+func duplicateText(title string, front string) string {
+    return NormalizeCardText(title + " " + front)
+}
+
+func trigrams(text string) map[string]bool {
+
+    var (
+        runes []rune          = []rune(" " + text + " ")
+        set   map[string]bool = make(map[string]bool, len(runes))
+    )
+
+    for idx := 0; idx+3 <= len(runes); idx++ {
+        set[string(runes[idx:idx+3])] = true
+    }
+
+    return set
+}
+
+// dice coefficient of character trigrams of normalized texts; within [0, 1]
+func TextSimilarity(a string, b string) float64 {
+
+    if len(a) <= 0 || len(b) <= 0 {
+        return 0
+    }
+
+    if a == b {
+        return 1
+    }
+
+    var (
+        setA map[string]bool = trigrams(a)
+        setB map[string]bool = trigrams(b)
+    )
+
+    var shared int = 0
+    for trigram := range setA {
+        if setB[trigram] {
+            shared++
+        }
+    }
+
+    return 2 * float64(shared) / float64(len(setA)+len(setB))
+}
+
+// full-text search query matching cards sharing any word of the title or front with the text
+func duplicateSearchQuery(text string) string {
+
+    var (
+        seen  map[string]bool = make(map[string]bool)
+        terms []string        = []string{}
+    )
+
+    for _, word := range strings.Fields(text) {
+
+        if utf8.RuneCountInString(word) < 3 || seen[word] {
+            continue
+        }
+        seen[word] = true
+
+        terms = append(terms, "title:"+word, "front:"+word)
+
+        if len(terms) >= 2*DUPLICATES_MAX_SEARCH_TERMS {
+            break
+        }
+    }
+
+    return strings.Join(terms, " OR ")
+}
+
+// find cards that are likely duplicates of a card with the given title and front; most
+// similar first
+func FindDuplicateCards(db sqlx.Ext, title string, front string, threshold float64) ([]DuplicateMatch, error) {
+
+    var matches []DuplicateMatch = []DuplicateMatch{}
+
+    var text string = duplicateText(title, front)
+    var search string = duplicateSearchQuery(text)
+
+    if len(search) <= 0 {
+        return matches, nil
+    }
+
+    query, args, err := QueryApply(FETCH_DUPLICATE_CANDIDATES_QUERY, &StringMap{
+        "search": search,
+        "limit":  DUPLICATES_MAX_CANDIDATES,
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    var candidates []DuplicateCardRow = []DuplicateCardRow{}
+    err = sqlx.Select(db, &candidates, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    for _, candidate := range candidates {
+
+        var similarity float64 = TextSimilarity(text, duplicateText(candidate.Title, candidate.Front))
+
+        if similarity >= threshold {
+            matches = append(matches, DuplicateMatch{
+                Card:       candidate,
+                Similarity: similarity,
+            })
+        }
+    }
+
+    sort.SliceStable(matches, func(i, j int) bool {
+        if matches[i].Similarity != matches[j].Similarity {
+            return matches[i].Similarity > matches[j].Similarity
+        }
+        return matches[i].Card.ID < matches[j].Card.ID
+    })
+
+    return matches, nil
+}
+
+// cluster near-duplicate cards within the deck subtree; largest clusters first
+func ScanDuplicateCards(db sqlx.Ext, deckID uint, threshold float64) ([]DuplicateCluster, error) {
+
+    query, args, err := QueryApply(FETCH_DUPLICATE_CANDIDATES_BY_DECK_QUERY, &StringMap{"deck_id": deckID})
+    if err != nil {
+        return nil, err
+    }
+
+    var cards []DuplicateCardRow = []DuplicateCardRow{}
+    err = sqlx.Select(db, &cards, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    var texts []string = make([]string, len(cards))
+
+    // cards are paired up if they share a word that isn't too common, or have the same text
+    var (
+        byWord map[string][]int = make(map[string][]int)
+        byText map[string][]int = make(map[string][]int)
+    )
+
+    for idx, card := range cards {
+
+        texts[idx] = duplicateText(card.Title, card.Front)
+
+        if len(texts[idx]) <= 0 {
+            continue
+        }
+
+        byText[texts[idx]] = append(byText[texts[idx]], idx)
+
+        var seen map[string]bool = make(map[string]bool)
+        for _, word := range strings.Fields(texts[idx]) {
+            if utf8.RuneCountInString(word) < 3 || seen[word] {
+                continue
+            }
+            seen[word] = true
+            byWord[word] = append(byWord[word], idx)
+        }
+    }
+
+    type pairKey struct{ a, b int }
+
+    var candidates map[pairKey]bool = make(map[pairKey]bool)
+
+    var addCandidates = func(group []int) {
+        for i := 0; i < len(group); i++ {
+            for j := i + 1; j < len(group); j++ {
+                candidates[pairKey{group[i], group[j]}] = true
+            }
+        }
+    }
+
+    for _, group := range byText {
+        addCandidates(group)
+    }
+
+    for _, group := range byWord {
+        if len(group) <= DUPLICATES_MAX_WORD_FREQUENCY {
+            addCandidates(group)
+        }
+    }
+
+    // union-find over similar pairs
+
+    var parent []int = make([]int, len(cards))
+    for idx := range parent {
+        parent[idx] = idx
+    }
+
+    var find func(int) int
+    find = func(idx int) int {
+        for parent[idx] != idx {
+            parent[idx] = parent[parent[idx]]
+            idx = parent[idx]
+        }
+        return idx
+    }
+
+    var pairs []pairKey = []pairKey{}
+    var similarities map[pairKey]float64 = make(map[pairKey]float64)
+
+    for pair := range candidates {
+
+        var similarity float64 = TextSimilarity(texts[pair.a], texts[pair.b])
+        if similarity < threshold {
+            continue
+        }
+
+        pairs = append(pairs, pair)
+        similarities[pair] = similarity
+
+        parent[find(pair.a)] = find(pair.b)
+    }
+
+    sort.Slice(pairs, func(i, j int) bool {
+        if pairs[i].a != pairs[j].a {
+            return pairs[i].a < pairs[j].a
+        }
+        return pairs[i].b < pairs[j].b
+    })
+
+    var (
+        clusterOf map[int]int = make(map[int]int)
+        clusters  []DuplicateCluster
+    )
+
+    for _, pair := range pairs {
+
+        var root int = find(pair.a)
+
+        clusterIdx, has := clusterOf[root]
+        if !has {
+            clusterIdx = len(clusters)
+            clusterOf[root] = clusterIdx
+            clusters = append(clusters, DuplicateCluster{})
+        }
+
+        clusters[clusterIdx].Pairs = append(clusters[clusterIdx].Pairs, DuplicatePair{
+            Cards:      [2]uint{cards[pair.a].ID, cards[pair.b].ID},
+            Similarity: similarities[pair],
+        })
+    }
+
+    for idx, card := range cards {
+        if clusterIdx, has := clusterOf[find(idx)]; has {
+            clusters[clusterIdx].Cards = append(clusters[clusterIdx].Cards, card)
+        }
+    }
+
+    // suggest keeping the most reviewed card; the oldest card if tied
+    for idx := range clusters {
+
+        var survivor DuplicateCardRow = clusters[idx].Cards[0]
+        for _, card := range clusters[idx].Cards[1:] {
+            if card.TimesReviewed > survivor.TimesReviewed {
+                survivor = card
+            }
+        }
+
+        clusters[idx].Survivor = survivor.ID
+        clusters[idx].Victims = []uint{}
+
+        for _, card := range clusters[idx].Cards {
+            if card.ID != survivor.ID {
+                clusters[idx].Victims = append(clusters[idx].Victims, card.ID)
+            }
+        }
+    }
+
+    sort.SliceStable(clusters, func(i, j int) bool {
+        if len(clusters[i].Cards) != len(clusters[j].Cards) {
+            return len(clusters[i].Cards) > len(clusters[j].Cards)
+        }
+        return clusters[i].Cards[0].ID < clusters[j].Cards[0].ID
+    })
+
+    if clusters == nil {
+        clusters = []DuplicateCluster{}
+    }
+
+    return clusters, nil
+}
+
+func DuplicateMatchesToResponse(matches []DuplicateMatch) []gin.H {
+
+    var response []gin.H = make([]gin.H, 0, len(matches))
+
+    for _, match := range matches {
+        response = append(response, gin.H{
+            "id":         match.Card.ID,
+            "title":      match.Card.Title,
+            "deck":       match.Card.Deck,
+            "similarity": match.Similarity,
+        })
+    }
+
+    return response
+}
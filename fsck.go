@@ -0,0 +1,996 @@
+package main
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "net/http"
+    "os"
+    "strings"
+
+    // 3rd-party
+    "github.com/gin-gonic/gin"
+    "github.com/jmoiron/sqlx"
+)
+
+/* variables */
+
+// errors
+var ErrFsckNoSuchProfile = errors.New("fsck: no database of given profile name")
+
+// classes of inconsistencies
+const (
+    // pending migrations of the schema; not checked further, nor repaired. see: grokdb migrate
+    FSCK_SCHEMA string = "schema"
+    // the database file itself; see: https://www.sqlite.org/pragma.html#pragma_integrity_check
+    // not repaired; restore a backup instead
+    FSCK_INTEGRITY string = "integrity"
+    // rows that refer to rows that don't exist; e.g. score rows of deleted cards.
+    // cards of a deck that doesn't exist are moved into the root deck
+    FSCK_FOREIGN_KEYS string = "foreign_keys"
+    // missing, extra or wrong DecksClosure rows; decks without a parent are moved into the root deck
+    FSCK_DECKS_CLOSURE string = "decks_closure"
+    // cards without a CardsScore row
+    FSCK_CARDS_SCORE string = "cards_score"
+    // cached review cards no longer within their deck, or suspended
+    FSCK_REVIEW_CARD_CACHE string = "review_card_cache"
+    // CardsFTS out of sync with Cards
+    FSCK_CARDS_FTS string = "cards_fts"
+    // missing, extra or wrong StashesClosure rows; stashes without a parent are made top-level
+    FSCK_STASHES_CLOSURE string = "stashes_closure"
+    // cached review cards of stashes no longer within their stash, or suspended
+    FSCK_REVIEW_CARD_STASH_CACHE string = "review_card_stash_cache"
+)
+
+var fsckClasses []string = []string{
+    FSCK_INTEGRITY,
+    FSCK_FOREIGN_KEYS,
+    FSCK_DECKS_CLOSURE,
+    FSCK_CARDS_SCORE,
+    FSCK_REVIEW_CARD_CACHE,
+    FSCK_CARDS_FTS,
+    FSCK_STASHES_CLOSURE,
+    FSCK_REVIEW_CARD_STASH_CACHE,
+}
+
+/* types */
+
+type FsckIssue struct {
+    Class   string `json:"class"`
+    Message string `json:"message"`
+}
+
+type ForeignKeyViolationRow struct {
+    Table  string        `db:"table"`
+    RowID  sql.NullInt64 `db:"rowid"`
+    Parent string        `db:"parent"`
+    FKID   int           `db:"fkid"`
+}
+
+type DeckClosureRow struct {
+    Ancestor   uint `db:"ancestor"`
+    Descendent uint `db:"descendent"`
+    Depth      uint `db:"depth"`
+}
+
+type ReviewCardCacheRow struct {
+    Deck      uint  `db:"deck"`
+    Card      uint  `db:"card"`
+    CreatedAt int64 `db:"created_at"`
+}
+
+type StashClosureRow struct {
+    Ancestor   uint `db:"ancestor"`
+    Descendent uint `db:"descendent"`
+    Depth      uint `db:"depth"`
+}
+
+type ReviewCardStashCacheRow struct {
+    Stash     uint  `db:"stash"`
+    Card      uint  `db:"card"`
+    CreatedAt int64 `db:"created_at"`
+}
+
+// repairs of inconsistencies of a class
+type fsckRepairs struct {
+    issues []FsckIssue
+    repair func(tx *sqlx.Tx) error
+}
+
+/* REST Handlers */
+
+// GET /admin/fsck
+//
+// Check the database for inconsistencies. Nothing is changed.
+func FsckGET(db *sqlx.DB, ctx *gin.Context) {
+
+    issues, err := Fsck(db)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to check database",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    ctx.JSON(http.StatusOK, FsckIssuesToResponse(issues))
+}
+
+// POST /admin/fsck
+//
+// Check the database for inconsistencies, and repair them. Responds with the
+// inconsistencies that were found, and those that remain after the repair.
+func FsckPOST(db *sqlx.DB, ctx *gin.Context) {
+
+    found, err := FsckRepair(db)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to repair database",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    remaining, err := Fsck(db)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to check database",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    ctx.JSON(http.StatusOK, gin.H{
+        "found":     FsckIssuesToResponse(found),
+        "remaining": FsckIssuesToResponse(remaining),
+    })
+}
+
+/* helpers */
+
+func FsckIssuesToResponse(issues []FsckIssue) gin.H {
+
+    var counts map[string]int = make(map[string]int)
+    for _, class := range fsckClasses {
+        counts[class] = 0
+    }
+    for _, issue := range issues {
+        counts[issue.Class]++
+    }
+
+    if issues == nil {
+        issues = []FsckIssue{}
+    }
+
+    return gin.H{
+        "ok":     len(issues) <= 0,
+        "counts": counts,
+        "issues": issues,
+    }
+}
+
+// inconsistencies of every class
+func Fsck(db *sqlx.DB) ([]FsckIssue, error) {
+
+    var issues []FsckIssue = []FsckIssue{}
+
+    integrity, err := checkIntegrity(db)
+    if err != nil {
+        return nil, err
+    }
+    issues = append(issues, integrity...)
+
+    rootDeck, err := GetRootDeck(db)
+    if err != nil {
+        return nil, err
+    }
+
+    for _, check := range fsckChecks(rootDeck.ID) {
+
+        repairs, err := check(db)
+        if err != nil {
+            return nil, err
+        }
+
+        issues = append(issues, repairs.issues...)
+    }
+
+    return issues, nil
+}
+
+// repair inconsistencies within a transaction; returns the inconsistencies that were found.
+// the integrity of the database file itself isn't repaired.
+func FsckRepair(db *sqlx.DB) ([]FsckIssue, error) {
+
+    var issues []FsckIssue = []FsckIssue{}
+
+    integrity, err := checkIntegrity(db)
+    if err != nil {
+        return nil, err
+    }
+    issues = append(issues, integrity...)
+
+    rootDeck, err := GetRootDeck(db)
+    if err != nil {
+        return nil, err
+    }
+
+    tx, err := db.Beginx()
+    if err != nil {
+        return nil, err
+    }
+
+    // each class is checked after the previous classes are repaired;
+    // e.g. stale cached review cards are found with the repaired closure table
+    for _, check := range fsckChecks(rootDeck.ID) {
+
+        repairs, err := check(tx)
+        if err == nil && len(repairs.issues) > 0 {
+            err = repairs.repair(tx)
+        }
+        if err != nil {
+            tx.Rollback()
+            return nil, err
+        }
+
+        issues = append(issues, repairs.issues...)
+    }
+
+    err = tx.Commit()
+    if err != nil {
+        return nil, err
+    }
+
+    return issues, nil
+}
+
+// repairable checks, in order of repair
+func fsckChecks(rootDeckID uint) []func(db sqlx.Ext) (*fsckRepairs, error) {
+    return []func(db sqlx.Ext) (*fsckRepairs, error){
+        func(db sqlx.Ext) (*fsckRepairs, error) {
+            return checkForeignKeys(db, rootDeckID)
+        },
+        func(db sqlx.Ext) (*fsckRepairs, error) {
+            return checkDecksClosure(db, rootDeckID)
+        },
+        checkCardsScore,
+        checkReviewCardCache,
+        checkCardsFTS,
+        checkStashesClosure,
+        checkReviewCardStashCache,
+    }
+}
+
+func checkIntegrity(db *sqlx.DB) ([]FsckIssue, error) {
+
+    query, args, err := QueryApply(INTEGRITY_CHECK_QUERY)
+    if err != nil {
+        return nil, err
+    }
+
+    var results []string = []string{}
+    err = sqlx.Select(db, &results, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    var issues []FsckIssue = []FsckIssue{}
+    for _, result := range results {
+        if result == "ok" {
+            continue
+        }
+        issues = append(issues, FsckIssue{Class: FSCK_INTEGRITY, Message: result})
+    }
+
+    return issues, nil
+}
+
+// rows that refer to missing rows. cards within a missing deck are moved into the root deck;
+// other such rows depend on the missing row (e.g. closure, score, cache and history rows
+// of deleted decks and cards) and are deleted, as they would have been along with it.
+func checkForeignKeys(db sqlx.Ext, rootDeckID uint) (*fsckRepairs, error) {
+
+    query, args, err := QueryApply(FOREIGN_KEY_CHECK_QUERY)
+    if err != nil {
+        return nil, err
+    }
+
+    var violations []ForeignKeyViolationRow = []ForeignKeyViolationRow{}
+    err = sqlx.Select(db, &violations, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    // a row is reported once for each of its foreign keys that refers to a missing row
+    var (
+        rows    []ForeignKeyViolationRow = []ForeignKeyViolationRow{}
+        parents map[string][]string      = make(map[string][]string)
+    )
+
+    var rowKey = func(row ForeignKeyViolationRow) string {
+        return fmt.Sprintf("%s:%d", row.Table, row.RowID.Int64)
+    }
+
+    for _, violation := range violations {
+
+        var key string = rowKey(violation)
+
+        if _, seen := parents[key]; !seen {
+            rows = append(rows, violation)
+        }
+
+        var isListed bool = false
+        for _, parent := range parents[key] {
+            if parent == violation.Parent {
+                isListed = true
+                break
+            }
+        }
+        if !isListed {
+            parents[key] = append(parents[key], violation.Parent)
+        }
+    }
+
+    var issues []FsckIssue = make([]FsckIssue, 0, len(rows))
+    for _, row := range rows {
+
+        var message string = fmt.Sprintf("row %d of %s refers to a missing row of %s",
+            row.RowID.Int64, row.Table, strings.Join(parents[rowKey(row)], ", "))
+
+        if row.Table == "Cards" {
+            message = fmt.Sprintf("card %d is within a missing deck", row.RowID.Int64)
+        }
+
+        issues = append(issues, FsckIssue{Class: FSCK_FOREIGN_KEYS, Message: message})
+    }
+
+    return &fsckRepairs{
+        issues: issues,
+        repair: func(tx *sqlx.Tx) error {
+
+            for _, row := range rows {
+
+                // every table with foreign keys has a rowid
+                if !row.RowID.Valid {
+                    continue
+                }
+
+                if row.Table == "Cards" {
+
+                    query, args, err := QueryApply(MOVE_CARD_INTO_DECK_QUERY, &StringMap{
+                        "card_id": row.RowID.Int64,
+                        "deck_id": rootDeckID,
+                    })
+                    if err != nil {
+                        return err
+                    }
+
+                    _, err = tx.Exec(query, args...)
+                    if err != nil {
+                        return err
+                    }
+
+                    continue
+                }
+
+                _, err := tx.Exec(fmt.Sprintf(DELETE_ROW_BY_ROWID_QUERY_FORMAT, row.Table), row.RowID.Int64)
+                if err != nil {
+                    return err
+                }
+            }
+
+            return nil
+        },
+    }, nil
+}
+
+func checkDecksClosure(db sqlx.Ext, rootDeckID uint) (*fsckRepairs, error) {
+
+    query, args, err := QueryApply(FETCH_DECK_IDS_QUERY)
+    if err != nil {
+        return nil, err
+    }
+
+    var deckIDs []uint = []uint{}
+    err = sqlx.Select(db, &deckIDs, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    query, args, err = QueryApply(FETCH_DECKS_CLOSURE_QUERY)
+    if err != nil {
+        return nil, err
+    }
+
+    var closure []DeckClosureRow = []DeckClosureRow{}
+    err = sqlx.Select(db, &closure, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    var issues []FsckIssue = []FsckIssue{}
+
+    // the parent of each deck is its ancestor at depth 1
+    var parents map[uint]uint = make(map[uint]uint)
+    for _, row := range closure {
+
+        if row.Depth != 1 {
+            continue
+        }
+
+        parent, hasParent := parents[row.Descendent]
+        if hasParent {
+            issues = append(issues, FsckIssue{
+                Class: FSCK_DECKS_CLOSURE,
+                Message: fmt.Sprintf("deck %d has more than one parent: %d, %d; keeping %d",
+                    row.Descendent, parent, row.Ancestor, parent),
+            })
+            continue
+        }
+
+        parents[row.Descendent] = row.Ancestor
+    }
+
+    var decks map[uint]bool = make(map[uint]bool)
+    for _, deckID := range deckIDs {
+        decks[deckID] = true
+    }
+
+    for _, deckID := range deckIDs {
+
+        parent, hasParent := parents[deckID]
+
+        switch {
+        case deckID == rootDeckID && hasParent:
+            issues = append(issues, FsckIssue{
+                Class:   FSCK_DECKS_CLOSURE,
+                Message: fmt.Sprintf("root deck %d has a parent: %d", deckID, parent),
+            })
+            delete(parents, deckID)
+        case deckID == rootDeckID:
+        case !hasParent || !decks[parent]:
+            issues = append(issues, FsckIssue{
+                Class:   FSCK_DECKS_CLOSURE,
+                Message: fmt.Sprintf("deck %d has no parent; moving it into the root deck", deckID),
+            })
+            parents[deckID] = rootDeckID
+        }
+    }
+
+    // decks whose ancestors loop are moved into the root deck
+    for _, deckID := range deckIDs {
+
+        var visited map[uint]bool = map[uint]bool{deckID: true}
+
+        for current := deckID; current != rootDeckID; current = parents[current] {
+            if visited[parents[current]] {
+                issues = append(issues, FsckIssue{
+                    Class:   FSCK_DECKS_CLOSURE,
+                    Message: fmt.Sprintf("deck %d is its own ancestor; moving it into the root deck", current),
+                })
+                parents[current] = rootDeckID
+                break
+            }
+            visited[parents[current]] = true
+        }
+    }
+
+    // closure rows expected of the parents
+    var expectedRows []DeckClosureRow = []DeckClosureRow{}
+    var expected map[DeckClosureRow]bool = make(map[DeckClosureRow]bool)
+    for _, deckID := range deckIDs {
+
+        var depth uint = 0
+        var current uint = deckID
+
+        for {
+            var row DeckClosureRow = DeckClosureRow{Ancestor: current, Descendent: deckID, Depth: depth}
+            expectedRows = append(expectedRows, row)
+            expected[row] = true
+
+            if current == rootDeckID {
+                break
+            }
+
+            current = parents[current]
+            depth++
+        }
+    }
+
+    var extra []DeckClosureRow = []DeckClosureRow{}
+    var actual map[DeckClosureRow]bool = make(map[DeckClosureRow]bool)
+    for _, row := range closure {
+
+        actual[row] = true
+
+        if !expected[row] {
+            extra = append(extra, row)
+        }
+    }
+
+    var missing []DeckClosureRow = []DeckClosureRow{}
+    for _, row := range expectedRows {
+        if !actual[row] {
+            missing = append(missing, row)
+        }
+    }
+
+    for _, row := range extra {
+        issues = append(issues, FsckIssue{
+            Class: FSCK_DECKS_CLOSURE,
+            Message: fmt.Sprintf("extra closure row: ancestor %d, descendent %d, depth %d",
+                row.Ancestor, row.Descendent, row.Depth),
+        })
+    }
+
+    for _, row := range missing {
+        issues = append(issues, FsckIssue{
+            Class: FSCK_DECKS_CLOSURE,
+            Message: fmt.Sprintf("missing closure row: ancestor %d, descendent %d, depth %d",
+                row.Ancestor, row.Descendent, row.Depth),
+        })
+    }
+
+    return &fsckRepairs{
+        issues: issues,
+        repair: func(tx *sqlx.Tx) error {
+
+            for _, row := range extra {
+
+                query, args, err := QueryApply(DELETE_DECKS_CLOSURE_ROW_QUERY, &StringMap{
+                    "ancestor":   row.Ancestor,
+                    "descendent": row.Descendent,
+                })
+                if err != nil {
+                    return err
+                }
+
+                _, err = tx.Exec(query, args...)
+                if err != nil {
+                    return err
+                }
+            }
+
+            for _, row := range missing {
+
+                query, args, err := QueryApply(INSERT_DECKS_CLOSURE_ROW_QUERY, &StringMap{
+                    "ancestor":   row.Ancestor,
+                    "descendent": row.Descendent,
+                    "depth":      row.Depth,
+                })
+                if err != nil {
+                    return err
+                }
+
+                _, err = tx.Exec(query, args...)
+                if err != nil {
+                    return err
+                }
+            }
+
+            return nil
+        },
+    }, nil
+}
+
+func checkCardsScore(db sqlx.Ext) (*fsckRepairs, error) {
+
+    query, args, err := QueryApply(FETCH_CARDS_WITHOUT_SCORE_QUERY)
+    if err != nil {
+        return nil, err
+    }
+
+    var cardIDs []uint = []uint{}
+    err = sqlx.Select(db, &cardIDs, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    var issues []FsckIssue = make([]FsckIssue, 0, len(cardIDs))
+    for _, cardID := range cardIDs {
+        issues = append(issues, FsckIssue{
+            Class:   FSCK_CARDS_SCORE,
+            Message: fmt.Sprintf("card %d has no score", cardID),
+        })
+    }
+
+    return &fsckRepairs{
+        issues: issues,
+        repair: func(tx *sqlx.Tx) error {
+
+            query, args, err := QueryApply(INSERT_MISSING_CARDS_SCORE_QUERY)
+            if err != nil {
+                return err
+            }
+
+            _, err = tx.Exec(query, args...)
+            return err
+        },
+    }, nil
+}
+
+func checkReviewCardCache(db sqlx.Ext) (*fsckRepairs, error) {
+
+    query, args, err := QueryApply(FETCH_STALE_REVIEW_CARD_CACHE_QUERY)
+    if err != nil {
+        return nil, err
+    }
+
+    var stale []ReviewCardCacheRow = []ReviewCardCacheRow{}
+    err = sqlx.Select(db, &stale, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    var issues []FsckIssue = make([]FsckIssue, 0, len(stale))
+    for _, row := range stale {
+        issues = append(issues, FsckIssue{
+            Class:   FSCK_REVIEW_CARD_CACHE,
+            Message: fmt.Sprintf("cached review card %d of deck %d is stale", row.Card, row.Deck),
+        })
+    }
+
+    return &fsckRepairs{
+        issues: issues,
+        repair: func(tx *sqlx.Tx) error {
+
+            for _, row := range stale {
+
+                query, args, err := QueryApply(DELETE_CACHED_REVIEWCARD_BY_DECK_QUERY, &StringMap{
+                    "deck_id": row.Deck,
+                })
+                if err != nil {
+                    return err
+                }
+
+                _, err = tx.Exec(query, args...)
+                if err != nil {
+                    return err
+                }
+            }
+
+            return nil
+        },
+    }, nil
+}
+
+func checkCardsFTS(db sqlx.Ext) (*fsckRepairs, error) {
+
+    query, args, err := QueryApply(FETCH_CARDS_FTS_MISMATCH_QUERY)
+    if err != nil {
+        return nil, err
+    }
+
+    var cardIDs []uint = []uint{}
+    err = sqlx.Select(db, &cardIDs, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    var issues []FsckIssue = make([]FsckIssue, 0, len(cardIDs))
+    for _, cardID := range cardIDs {
+        issues = append(issues, FsckIssue{
+            Class:   FSCK_CARDS_FTS,
+            Message: fmt.Sprintf("full-text index of card %d is out of sync", cardID),
+        })
+    }
+
+    return &fsckRepairs{
+        issues: issues,
+        repair: func(tx *sqlx.Tx) error {
+
+            query, args, err := QueryApply(REBUILD_CARDS_FTS_QUERY)
+            if err != nil {
+                return err
+            }
+
+            _, err = tx.Exec(query, args...)
+            return err
+        },
+    }, nil
+}
+
+func checkStashesClosure(db sqlx.Ext) (*fsckRepairs, error) {
+
+    query, args, err := QueryApply(FETCH_STASH_IDS_QUERY)
+    if err != nil {
+        return nil, err
+    }
+
+    var stashIDs []uint = []uint{}
+    err = sqlx.Select(db, &stashIDs, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    query, args, err = QueryApply(FETCH_STASHES_CLOSURE_QUERY)
+    if err != nil {
+        return nil, err
+    }
+
+    var closure []StashClosureRow = []StashClosureRow{}
+    err = sqlx.Select(db, &closure, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    var issues []FsckIssue = []FsckIssue{}
+
+    // the parent of each stash is its ancestor at depth 1; top-level stashes have none
+    var parents map[uint]uint = make(map[uint]uint)
+    for _, row := range closure {
+
+        if row.Depth != 1 {
+            continue
+        }
+
+        parent, hasParent := parents[row.Descendent]
+        if hasParent {
+            issues = append(issues, FsckIssue{
+                Class: FSCK_STASHES_CLOSURE,
+                Message: fmt.Sprintf("stash %d has more than one parent: %d, %d; keeping %d",
+                    row.Descendent, parent, row.Ancestor, parent),
+            })
+            continue
+        }
+
+        parents[row.Descendent] = row.Ancestor
+    }
+
+    var stashes map[uint]bool = make(map[uint]bool)
+    for _, stashID := range stashIDs {
+        stashes[stashID] = true
+    }
+
+    for _, stashID := range stashIDs {
+
+        parent, hasParent := parents[stashID]
+
+        if hasParent && !stashes[parent] {
+            issues = append(issues, FsckIssue{
+                Class:   FSCK_STASHES_CLOSURE,
+                Message: fmt.Sprintf("parent of stash %d is missing; making it top-level", stashID),
+            })
+            delete(parents, stashID)
+        }
+    }
+
+    // stashes whose ancestors loop are made top-level
+    for _, stashID := range stashIDs {
+
+        var visited map[uint]bool = map[uint]bool{stashID: true}
+
+        for current := stashID; ; {
+
+            parent, hasParent := parents[current]
+            if !hasParent {
+                break
+            }
+
+            if visited[parent] {
+                issues = append(issues, FsckIssue{
+                    Class:   FSCK_STASHES_CLOSURE,
+                    Message: fmt.Sprintf("stash %d is its own ancestor; making it top-level", current),
+                })
+                delete(parents, current)
+                break
+            }
+
+            visited[parent] = true
+            current = parent
+        }
+    }
+
+    // closure rows expected of the parents
+    var expectedRows []StashClosureRow = []StashClosureRow{}
+    var expected map[StashClosureRow]bool = make(map[StashClosureRow]bool)
+    for _, stashID := range stashIDs {
+
+        var depth uint = 0
+        var current uint = stashID
+
+        for {
+            var row StashClosureRow = StashClosureRow{Ancestor: current, Descendent: stashID, Depth: depth}
+            expectedRows = append(expectedRows, row)
+            expected[row] = true
+
+            parent, hasParent := parents[current]
+            if !hasParent {
+                break
+            }
+
+            current = parent
+            depth++
+        }
+    }
+
+    var extra []StashClosureRow = []StashClosureRow{}
+    var actual map[StashClosureRow]bool = make(map[StashClosureRow]bool)
+    for _, row := range closure {
+
+        actual[row] = true
+
+        if !expected[row] {
+            extra = append(extra, row)
+        }
+    }
+
+    var missing []StashClosureRow = []StashClosureRow{}
+    for _, row := range expectedRows {
+        if !actual[row] {
+            missing = append(missing, row)
+        }
+    }
+
+    for _, row := range extra {
+        issues = append(issues, FsckIssue{
+            Class: FSCK_STASHES_CLOSURE,
+            Message: fmt.Sprintf("extra stash closure row: ancestor %d, descendent %d, depth %d",
+                row.Ancestor, row.Descendent, row.Depth),
+        })
+    }
+
+    for _, row := range missing {
+        issues = append(issues, FsckIssue{
+            Class: FSCK_STASHES_CLOSURE,
+            Message: fmt.Sprintf("missing stash closure row: ancestor %d, descendent %d, depth %d",
+                row.Ancestor, row.Descendent, row.Depth),
+        })
+    }
+
+    return &fsckRepairs{
+        issues: issues,
+        repair: func(tx *sqlx.Tx) error {
+
+            for _, row := range extra {
+
+                query, args, err := QueryApply(DELETE_STASHES_CLOSURE_ROW_QUERY, &StringMap{
+                    "ancestor":   row.Ancestor,
+                    "descendent": row.Descendent,
+                })
+                if err != nil {
+                    return err
+                }
+
+                _, err = tx.Exec(query, args...)
+                if err != nil {
+                    return err
+                }
+            }
+
+            for _, row := range missing {
+
+                query, args, err := QueryApply(INSERT_STASHES_CLOSURE_ROW_QUERY, &StringMap{
+                    "ancestor":   row.Ancestor,
+                    "descendent": row.Descendent,
+                    "depth":      row.Depth,
+                })
+                if err != nil {
+                    return err
+                }
+
+                _, err = tx.Exec(query, args...)
+                if err != nil {
+                    return err
+                }
+            }
+
+            return nil
+        },
+    }, nil
+}
+
+func checkReviewCardStashCache(db sqlx.Ext) (*fsckRepairs, error) {
+
+    query, args, err := QueryApply(FETCH_STALE_REVIEW_CARD_STASH_CACHE_QUERY)
+    if err != nil {
+        return nil, err
+    }
+
+    var stale []ReviewCardStashCacheRow = []ReviewCardStashCacheRow{}
+    err = sqlx.Select(db, &stale, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    var issues []FsckIssue = make([]FsckIssue, 0, len(stale))
+    for _, row := range stale {
+        issues = append(issues, FsckIssue{
+            Class:   FSCK_REVIEW_CARD_STASH_CACHE,
+            Message: fmt.Sprintf("cached review card %d of stash %d is stale", row.Card, row.Stash),
+        })
+    }
+
+    return &fsckRepairs{
+        issues: issues,
+        repair: func(tx *sqlx.Tx) error {
+
+            for _, row := range stale {
+
+                query, args, err := QueryApply(DELETE_CACHED_REVIEWCARD_BY_STASH_QUERY, &StringMap{
+                    "stash_id": row.Stash,
+                })
+                if err != nil {
+                    return err
+                }
+
+                _, err = tx.Exec(query, args...)
+                if err != nil {
+                    return err
+                }
+            }
+
+            return nil
+        },
+    }, nil
+}
+
+// grokdb fsck [--repair] <profile>
+func fsckCommand(profileName string, options *DatabaseOptions, repair bool) {
+
+    var err error
+
+    // don't create a database for a mistyped profile name
+    _, err = os.Stat(ProfileFileName(options.DataDir, profileName))
+    if os.IsNotExist(err) {
+        exitIfErr(ErrFsckNoSuchProfile, 1)
+    }
+    exitIfErr(err, 1)
+
+    // checking (let alone repairing) doesn't migrate the database; the checks expect the
+    // latest schema
+    var db *Database
+    db, err = OpenDatabase(profileName, options)
+    exitIfErr(err, 1)
+    defer db.CleanUp()
+
+    var pending []Migration
+    pending, err = db.PendingMigrations()
+    exitIfErr(err, 1)
+
+    if len(pending) > 0 {
+
+        for _, migration := range pending {
+            fmt.Printf("%s: migration %d (%s) is pending\n", FSCK_SCHEMA, migration.Version, migration.Name)
+        }
+
+        fmt.Printf("schema is out of date; migrate it before checking with: grokdb migrate %s\n", profileName)
+        os.Exit(1)
+    }
+
+    var issues []FsckIssue
+    if repair {
+        issues, err = FsckRepair(db.instance)
+    } else {
+        issues, err = Fsck(db.instance)
+    }
+    exitIfErr(err, 1)
+
+    for _, issue := range issues {
+        fmt.Printf("%s: %s\n", issue.Class, issue.Message)
+    }
+
+    switch {
+    case len(issues) <= 0:
+        fmt.Println("no inconsistencies found")
+    case repair:
+        remaining, err := Fsck(db.instance)
+        exitIfErr(err, 1)
+
+        fmt.Printf("found %d inconsistencies; %d remain\n", len(issues), len(remaining))
+
+        if len(remaining) > 0 {
+            os.Exit(1)
+        }
+    default:
+        fmt.Printf("found %d inconsistencies; repair them with: grokdb fsck --repair %s\n",
+            len(issues), profileName)
+        os.Exit(1)
+    }
+}
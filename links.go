@@ -0,0 +1,319 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "regexp"
+    "strconv"
+    "strings"
+
+    // 3rd-party
+    "github.com/gin-gonic/gin"
+    "github.com/jmoiron/sqlx"
+)
+
+/* variables */
+
+// cards reference other cards by link marker; e.g. [[card:123]]
+var cardLinkRegexp = regexp.MustCompile(`\[\[card:(\d+)\]\]`)
+
+// how links to a deleted card are handled
+const (
+    CARD_DANGLING_LINKS_KEEP   string = "keep"
+    CARD_DANGLING_LINKS_UNLINK string = "unlink"
+)
+
+/* types */
+
+type CardLinkRow struct {
+    Card   uint    `db:"card"`
+    Target uint    `db:"target"`
+    Title  *string `db:"title"`
+}
+
+/* REST Handlers */
+
+// GET /links/dangling
+//
+// List links of cards to cards that no longer exist; e.g. deleted cards.
+func DanglingLinksGET(db *sqlx.DB, ctx *gin.Context) {
+
+    links, err := DanglingCardLinks(db)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve dangling links",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var response []gin.H = make([]gin.H, 0, len(links))
+
+    for _, link := range links {
+        response = append(response, gin.H{
+            "card":   link.Card,
+            "title":  link.Title,
+            "target": link.Target,
+        })
+    }
+
+    ctx.JSON(http.StatusOK, response)
+}
+
+/* helpers */
+
+func CardLinkMarker(cardID uint) string {
+    return fmt.Sprintf("[[card:%d]]", cardID)
+}
+
+// comma-separated card ids; e.g. for a header
+func joinCardIDs(cardIDs []uint) string {
+
+    var ids []string = make([]string, 0, len(cardIDs))
+    for _, cardID := range cardIDs {
+        ids = append(ids, strconv.FormatUint(uint64(cardID), 10))
+    }
+
+    return strings.Join(ids, ",")
+}
+
+// ids of cards referenced by link markers; in order of appearance, without duplicates
+func ParseCardLinks(texts ...string) []uint {
+
+    var (
+        seen    map[uint]bool = make(map[uint]bool)
+        cardIDs []uint        = []uint{}
+    )
+
+    for _, text := range texts {
+        for _, match := range cardLinkRegexp.FindAllStringSubmatch(text, -1) {
+
+            _cardID, err := strconv.ParseUint(match[1], 10, 32)
+            if err != nil || _cardID <= 0 {
+                continue
+            }
+            var cardID uint = uint(_cardID)
+
+            if seen[cardID] {
+                continue
+            }
+            seen[cardID] = true
+            cardIDs = append(cardIDs, cardID)
+        }
+    }
+
+    return cardIDs
+}
+
+// update the card's links from its link markers; links to itself are ignored
+func SyncCardLinks(db sqlx.Ext, cardID uint) error {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    var card *CardRow
+    card, err = GetCard(db, cardID)
+    if err != nil {
+        return err
+    }
+
+    query, args, err = QueryApply(DELETE_CARD_LINKS_QUERY, &StringMap{"card_id": cardID})
+    if err != nil {
+        return err
+    }
+
+    _, err = db.Exec(query, args...)
+    if err != nil {
+        return err
+    }
+
+    for _, target := range ParseCardLinks(card.Title, card.Description, card.Front, card.Back) {
+
+        if target == cardID {
+            continue
+        }
+
+        query, args, err = QueryApply(INSERT_CARD_LINK_QUERY, &StringMap{
+            "card_id": cardID,
+            "target":  target,
+        })
+        if err != nil {
+            return err
+        }
+
+        _, err = db.Exec(query, args...)
+        if err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+func fetchCardLinks(db sqlx.Ext, pipe PipeInput, stringmaps ...*StringMap) ([]CardLinkRow, error) {
+
+    query, args, err := QueryApply(pipe, stringmaps...)
+    if err != nil {
+        return nil, err
+    }
+
+    var links []CardLinkRow = []CardLinkRow{}
+    err = sqlx.Select(db, &links, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    return links, nil
+}
+
+// links from the card to other cards
+func CardLinks(db sqlx.Ext, cardID uint) ([]CardLinkRow, error) {
+    return fetchCardLinks(db, FETCH_CARD_LINKS_QUERY, &StringMap{"card_id": cardID})
+}
+
+// links from other cards to the card
+func CardBacklinks(db sqlx.Ext, cardID uint) ([]CardLinkRow, error) {
+    return fetchCardLinks(db, FETCH_CARD_BACKLINKS_QUERY, &StringMap{"card_id": cardID})
+}
+
+func DanglingCardLinks(db sqlx.Ext) ([]CardLinkRow, error) {
+    return fetchCardLinks(db, FETCH_DANGLING_CARD_LINKS_QUERY)
+}
+
+func CardLinksToResponse(db sqlx.Ext, cardID uint) (gin.H, error) {
+
+    links, err := CardLinks(db, cardID)
+    if err != nil {
+        return nil, err
+    }
+
+    backlinks, err := CardBacklinks(db, cardID)
+    if err != nil {
+        return nil, err
+    }
+
+    var linksResponse []gin.H = make([]gin.H, 0, len(links))
+    for _, link := range links {
+        linksResponse = append(linksResponse, gin.H{
+            "id":     link.Target,
+            "title":  link.Title,
+            "exists": link.Title != nil,
+        })
+    }
+
+    var backlinksResponse []gin.H = make([]gin.H, 0, len(backlinks))
+    for _, link := range backlinks {
+        backlinksResponse = append(backlinksResponse, gin.H{
+            "id":    link.Card,
+            "title": link.Title,
+        })
+    }
+
+    return gin.H{
+        "links":     linksResponse,
+        "backlinks": backlinksResponse,
+    }, nil
+}
+
+// replace link markers to the card, within cards linking to it, with the card's title.
+// returns ids of the cards that were changed.
+func UnlinkCard(db sqlx.Ext, card *CardRow) ([]uint, error) {
+    return replaceCardLinkMarkers(db, card.ID, card.Title)
+}
+
+// point link markers to the card, within cards linking to it, to another card.
+// returns ids of the cards that were changed.
+func RetargetCardLinks(db sqlx.Ext, cardID uint, target uint) ([]uint, error) {
+    return replaceCardLinkMarkers(db, cardID, CardLinkMarker(target))
+}
+
+// point link markers to the card, within only the given cards, to another card; e.g.
+// when a trashed card is restored under a new id, only the cards that linked to it
+// before it was trashed are retargeted. cards that no longer exist are skipped.
+// returns ids of the cards that were changed.
+func RetargetCardLinksFrom(db sqlx.Ext, cardID uint, target uint, linkingIDs []uint) ([]uint, error) {
+    return replaceCardLinkMarkersFrom(db, cardID, CardLinkMarker(target), linkingIDs)
+}
+
+// ids of the cards linking to the card
+func CardBacklinkIDs(db sqlx.Ext, cardID uint) ([]uint, error) {
+
+    backlinks, err := CardBacklinks(db, cardID)
+    if err != nil {
+        return nil, err
+    }
+
+    var linkingIDs []uint = make([]uint, 0, len(backlinks))
+    for _, link := range backlinks {
+        linkingIDs = append(linkingIDs, link.Card)
+    }
+
+    return linkingIDs, nil
+}
+
+func replaceCardLinkMarkers(db sqlx.Ext, cardID uint, replacement string) ([]uint, error) {
+
+    linkingIDs, err := CardBacklinkIDs(db, cardID)
+    if err != nil {
+        return nil, err
+    }
+
+    return replaceCardLinkMarkersFrom(db, cardID, replacement, linkingIDs)
+}
+
+func replaceCardLinkMarkersFrom(db sqlx.Ext, cardID uint, replacement string, linkingIDs []uint) ([]uint, error) {
+
+    var (
+        err     error
+        marker  *regexp.Regexp = regexp.MustCompile(regexp.QuoteMeta(CardLinkMarker(cardID)))
+        changed []uint         = make([]uint, 0, len(linkingIDs))
+    )
+
+    for _, linkingID := range linkingIDs {
+
+        var linking *CardRow
+        linking, err = GetCard(db, linkingID)
+        switch {
+        case err == ErrCardNoSuchCard:
+            continue
+        case err != nil:
+            return nil, err
+        }
+
+        var patch *StringMap = &StringMap{}
+
+        var fields = []struct {
+            name string
+            text string
+        }{
+            {"title", linking.Title},
+            {"description", linking.Description},
+            {"front", linking.Front},
+            {"back", linking.Back},
+        }
+
+        for _, field := range fields {
+            if marker.MatchString(field.text) {
+                (*patch)[field.name] = marker.ReplaceAllLiteralString(field.text, replacement)
+            }
+        }
+
+        if len(*patch) <= 0 {
+            continue
+        }
+
+        err = PatchCard(db, linking.ID, patch)
+        if err != nil {
+            return nil, err
+        }
+
+        changed = append(changed, linking.ID)
+    }
+
+    return changed, nil
+}
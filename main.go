@@ -4,6 +4,7 @@ import (
     "errors"
     "fmt"
     "os"
+    "time"
 
     // 3rd-party
     "github.com/codegangsta/cli"
@@ -38,6 +39,139 @@ func main() {
             Value: "",
             Usage: "Alternative source folder of app to serve",
         },
+        cli.StringFlag{
+            Name:  "sync-dir",
+            Value: "",
+            Usage: "Folder to sync decks and cards with as Markdown files",
+        },
+        cli.IntFlag{
+            Name:  "sync-interval",
+            Value: 10,
+            Usage: "Seconds between each Markdown sync (0 to only sync on request)",
+        },
+        cli.StringFlag{
+            Name:  "data-dir",
+            Value: "",
+            Usage: "Folder of profile databases (default: current folder)",
+        },
+        cli.StringFlag{
+            Name:  "journal-mode",
+            Value: DefaultDatabaseOptions.JournalMode,
+            Usage: "Journal mode of the database (DELETE, TRUNCATE, PERSIST, MEMORY, WAL, OFF)",
+        },
+        cli.IntFlag{
+            Name:  "busy-timeout",
+            Value: DefaultDatabaseOptions.BusyTimeout,
+            Usage: "Milliseconds to wait for a locked database",
+        },
+        cli.StringFlag{
+            Name:  "synchronous",
+            Value: DefaultDatabaseOptions.Synchronous,
+            Usage: "Synchronous level of the database (OFF, NORMAL, FULL, EXTRA)",
+        },
+        cli.IntFlag{
+            Name:  "cache-size",
+            Value: DefaultDatabaseOptions.CacheSize,
+            Usage: "Cache size of each database connection; pages if positive, KiB if negative",
+        },
+    }
+
+    cmd.Commands = []cli.Command{
+        {
+            Name:  "migrate",
+            Usage: "Apply pending schema migrations to the database of a profile",
+            Flags: []cli.Flag{
+                cli.BoolFlag{
+                    Name:  "status",
+                    Usage: "List applied and pending migrations",
+                },
+                cli.BoolFlag{
+                    Name:  "dry-run",
+                    Usage: "List migrations that would be applied without applying them",
+                },
+            },
+            Action: func(ctx *cli.Context) {
+
+                var args cli.Args = ctx.Args()
+
+                if len(args) <= 0 {
+                    var err error = errors.New("Error: No profile name given")
+                    exitIfErr(err, 1)
+                }
+
+                migrateCommand(args.First(), globalDatabaseOptions(ctx), ctx.Bool("status"), ctx.Bool("dry-run"))
+            },
+        },
+        {
+            Name:  "fsck",
+            Usage: "Check the database of a profile for inconsistencies",
+            Flags: []cli.Flag{
+                cli.BoolFlag{
+                    Name:  "repair",
+                    Usage: "Repair inconsistencies; e.g. rebuild closure rows, score rows and the full-text index",
+                },
+            },
+            Action: func(ctx *cli.Context) {
+
+                var args cli.Args = ctx.Args()
+
+                if len(args) <= 0 {
+                    var err error = errors.New("Error: No profile name given")
+                    exitIfErr(err, 1)
+                }
+
+                fsckCommand(args.First(), globalDatabaseOptions(ctx), ctx.Bool("repair"))
+            },
+        },
+        {
+            Name:  "sync",
+            Usage: "Merge two databases into each other: sync <database> <database>",
+            Action: func(ctx *cli.Context) {
+
+                var args cli.Args = ctx.Args()
+
+                if len(args) < 2 {
+                    var err error = errors.New("Error: sync expects two database names")
+                    exitIfErr(err, 1)
+                }
+
+                syncCommand(args[0], args[1], globalDatabaseOptions(ctx))
+            },
+        },
+        {
+            Name:  "profiles",
+            Usage: "Manage profile databases within the data folder",
+            Subcommands: []cli.Command{
+                {
+                    Name:  "list",
+                    Usage: "List profiles",
+                    Action: func(ctx *cli.Context) {
+                        profilesCommand(globalDatabaseOptions(ctx), "list", ctx.Args())
+                    },
+                },
+                {
+                    Name:  "create",
+                    Usage: "Create a profile: create <name>",
+                    Action: func(ctx *cli.Context) {
+                        profilesCommand(globalDatabaseOptions(ctx), "create", ctx.Args())
+                    },
+                },
+                {
+                    Name:  "rename",
+                    Usage: "Rename a profile: rename <name> <new name>",
+                    Action: func(ctx *cli.Context) {
+                        profilesCommand(globalDatabaseOptions(ctx), "rename", ctx.Args())
+                    },
+                },
+                {
+                    Name:  "delete",
+                    Usage: "Delete a profile (its backups are kept): delete <name>",
+                    Action: func(ctx *cli.Context) {
+                        profilesCommand(globalDatabaseOptions(ctx), "delete", ctx.Args())
+                    },
+                },
+            },
+        },
     }
 
     cmd.Action = func(ctx *cli.Context) {
@@ -56,13 +190,26 @@ func main() {
         var portNum int = ctx.Int("port")
         var mathJax string = ctx.String("mathjax")
         var appPath string = ctx.String("app")
+        var syncDir string = ctx.String("sync-dir")
+        var syncInterval int = ctx.Int("sync-interval")
 
-        app(profileName, portNum, appPath, mathJax)
+        app(profileName, globalDatabaseOptions(ctx), portNum, appPath, mathJax, syncDir, syncInterval)
     }
 
     cmd.Run(os.Args)
 }
 
+// database options given by global flags
+func globalDatabaseOptions(ctx *cli.Context) *DatabaseOptions {
+    return &DatabaseOptions{
+        DataDir:     ctx.GlobalString("data-dir"),
+        JournalMode: ctx.GlobalString("journal-mode"),
+        BusyTimeout: ctx.GlobalInt("busy-timeout"),
+        Synchronous: ctx.GlobalString("synchronous"),
+        CacheSize:   ctx.GlobalInt("cache-size"),
+    }
+}
+
 func exitIfErr(err error, code int) {
     if err != nil {
         fmt.Println(err)
@@ -70,19 +217,49 @@ func exitIfErr(err error, code int) {
     }
 }
 
-func app(profileName string, portNum int, appPath string, mathJax string) {
+func app(profileName string, options *DatabaseOptions, portNum int, appPath string, mathJax string, syncDir string, syncInterval int) {
 
     var (
-        err error
-        db  *Database
+        err      error
+        profiles *Profiles
     )
 
     /* database */
 
-    db, err = FetchDatabase(profileName)
+    if len(options.DataDir) > 0 {
+        err = os.MkdirAll(options.DataDir, 0755)
+        exitIfErr(err, 1)
+    }
+
+    profiles, err = NewProfiles(options, profileName)
     exitIfErr(err, 1)
 
-    defer db.CleanUp()
+    defer profiles.CleanUp()
+
+    /* trash */
+
+    go RunTrashPurge(profiles, time.Hour)
+
+    /* stash deadlines */
+
+    go RunStashDeadlines(profiles, time.Minute)
+
+    /* backups */
+
+    go RunBackupSchedule(profiles, time.Minute)
+
+    /* markdown sync */
+
+    if len(syncDir) > 0 {
+        // the folder is synced with the decks of one profile
+        profiles.Pin()
+    }
+
+    if len(syncDir) > 0 && syncInterval > 0 {
+        profiles.Use(func(db *Database) {
+            go RunMarkdownSync(db.instance, syncDir, time.Duration(syncInterval)*time.Second)
+        })
+    }
 
-    bootAPI(db, portNum, appPath, mathJax)
+    bootAPI(profiles, portNum, appPath, mathJax, syncDir)
 }
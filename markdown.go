@@ -0,0 +1,1327 @@
+package main
+
+import (
+    "bytes"
+    "crypto/sha1"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "path"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    // 3rd-party
+    "github.com/gin-gonic/gin"
+    "github.com/jmoiron/sqlx"
+)
+
+/* variables */
+
+// errors
+var ErrMarkdownNoFrontMatter = errors.New("markdown: card file has no front-matter")
+var ErrMarkdownNoSyncDir = errors.New("markdown: no sync directory is set")
+var ErrMarkdownDeckDeleted = errors.New("markdown: deck of directory no longer exists within the app")
+
+const MARKDOWN_FRONT_MATTER_DELIMITER string = "---"
+const MARKDOWN_FRONT_HEADING string = "# Front"
+const MARKDOWN_BACK_HEADING string = "# Back"
+
+// characters not allowed within deck directory names and card file names
+var markdownUnsafeChars = regexp.MustCompile(`[/\\:*?"<>|\x00-\x1f]+`)
+var markdownSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// lines within card content that would be read as a section heading; and their escaped form.
+// e.g. "# Back" is escaped as "\# Back", and "\# Back" as "\\# Back"
+var markdownHeadingLine = regexp.MustCompile(`^(\s*)(\\*# (?:Front|Back)\s*)$`)
+var markdownEscapedHeadingLine = regexp.MustCompile(`^(\s*)\\(\\*# (?:Front|Back)\s*)$`)
+
+// only one sync may run at a time
+var markdownSyncMutex = &sync.Mutex{}
+
+/* types */
+
+type MarkdownCard struct {
+    ID          uint
+    Title       string
+    Description string
+    Front       string
+    Back        string
+}
+
+type MarkdownSyncStateRow struct {
+    Card          uint   `db:"card"`
+    Path          string `db:"path"`
+    CardUpdatedAt int64  `db:"card_updated_at"`
+    CardHash      string `db:"card_hash"`
+    FileHash      string `db:"file_hash"`
+    SyncedAt      int64  `db:"synced_at"`
+}
+
+type MarkdownSyncDeckRow struct {
+    Deck uint   `db:"deck"`
+    Path string `db:"path"`
+}
+
+type MarkdownSyncReport struct {
+    Written   []string `json:"written"`
+    Updated   []uint   `json:"updated"`
+    Created   []uint   `json:"created"`
+    Removed   []string `json:"removed"`
+    Conflicts []gin.H  `json:"conflicts"`
+    Errors    []gin.H  `json:"errors"`
+}
+
+// card file found within the sync directory
+type markdownFile struct {
+    path    string // relative to the sync directory
+    dir     string // relative to the sync directory
+    card    *MarkdownCard
+    hash    string
+    modTime int64
+}
+
+/* REST Handlers */
+
+// POST /sync/markdown
+//
+// Sync the deck tree with the markdown sync directory.
+//
+// Query params:
+// prefer: one of: disk, app. resolves conflicts in favour of the given side.
+//         (default: conflicts are reported and left untouched)
+func MarkdownSyncPOST(syncDir string) func(*sqlx.DB, *gin.Context) {
+    return func(db *sqlx.DB, ctx *gin.Context) {
+
+        if len(syncDir) <= 0 {
+            ctx.JSON(http.StatusNotFound, gin.H{
+                "status":           http.StatusNotFound,
+                "developerMessage": ErrMarkdownNoSyncDir.Error(),
+                "userMessage":      "markdown sync is not enabled",
+            })
+            return
+        }
+
+        var prefer string = strings.ToLower(ctx.DefaultQuery("prefer", ""))
+
+        switch prefer {
+        case "":
+        case "disk":
+        case "app":
+        default:
+            ctx.JSON(http.StatusBadRequest, gin.H{
+                "status":           http.StatusBadRequest,
+                "developerMessage": "invalid prefer query",
+                "userMessage":      "invalid prefer query",
+            })
+            return
+        }
+
+        report, err := SyncMarkdownDirectory(db, syncDir, prefer)
+        if err != nil {
+            ctx.JSON(http.StatusInternalServerError, gin.H{
+                "status":           http.StatusInternalServerError,
+                "developerMessage": err.Error(),
+                "userMessage":      "unable to sync markdown directory",
+            })
+            ctx.Error(err)
+            return
+        }
+
+        ctx.JSON(http.StatusOK, report)
+    }
+}
+
+/* helpers */
+
+// periodically sync the deck tree with the markdown sync directory
+func RunMarkdownSync(db *sqlx.DB, syncDir string, interval time.Duration) {
+
+    for {
+        report, err := SyncMarkdownDirectory(db, syncDir, "")
+
+        switch {
+        case err != nil:
+            fmt.Println("markdown sync:", err)
+        case len(report.Conflicts) > 0:
+            fmt.Printf("markdown sync: %d conflict(s); resolve with POST /sync/markdown?prefer=disk or ?prefer=app\n", len(report.Conflicts))
+        }
+
+        time.Sleep(interval)
+    }
+}
+
+// two-way sync between the deck tree under the root deck and the given directory.
+// each deck maps onto a directory, and each card maps onto a markdown file.
+//
+// a side is considered changed when it differs from the state recorded at the last sync;
+// i.e. the card's updated_at (or content), or the hash (or path) of the card file.
+// cards changed on both sides are conflicts, unless prefer is given.
+//
+// changes to the database are made within a transaction; such that a failed sync leaves
+// the decks, cards and recorded state as they were. files on disk aren't rolled back; they
+// show up as changed on disk on the next sync.
+func SyncMarkdownDirectory(db *sqlx.DB, syncDir string, prefer string) (*MarkdownSyncReport, error) {
+
+    markdownSyncMutex.Lock()
+    defer markdownSyncMutex.Unlock()
+
+    var err error
+
+    var rootDeck *DeckRow
+    rootDeck, err = GetRootDeck(db)
+    if err != nil {
+        return nil, err
+    }
+
+    var tx *sqlx.Tx
+    tx, err = db.Beginx()
+    if err != nil {
+        return nil, err
+    }
+
+    var report *MarkdownSyncReport
+    report, err = syncMarkdownDirectory(tx, syncDir, prefer, rootDeck)
+    if err != nil {
+        tx.Rollback()
+        return nil, err
+    }
+
+    err = tx.Commit()
+    if err != nil {
+        return nil, err
+    }
+
+    return report, nil
+}
+
+// run f while markdown sync is paused; e.g. while the database file is replaced
+func PauseMarkdownSync(f func()) {
+
+    markdownSyncMutex.Lock()
+    defer markdownSyncMutex.Unlock()
+
+    f()
+}
+
+func syncMarkdownDirectory(db sqlx.Ext, syncDir string, prefer string, rootDeck *DeckRow) (*MarkdownSyncReport, error) {
+
+    var (
+        err    error
+        report *MarkdownSyncReport = &MarkdownSyncReport{
+            Written:   []string{},
+            Updated:   []uint{},
+            Created:   []uint{},
+            Removed:   []string{},
+            Conflicts: []gin.H{},
+            Errors:    []gin.H{},
+        }
+    )
+
+    err = os.MkdirAll(syncDir, 0755)
+    if err != nil {
+        return nil, err
+    }
+
+    // map decks onto directories.
+    // the directory of each deck is recorded; such that directories of decks renamed or
+    // deleted within the app aren't mistaken for new directories on disk.
+
+    var (
+        deckDirs map[uint]string = map[uint]string{}
+        dirDecks map[string]uint = map[string]uint{}
+        deckIDs  []uint
+        prevDirs map[uint]string
+    )
+
+    deckIDs, err = GetDeckDescendents(db, rootDeck.ID)
+    if err != nil {
+        return nil, err
+    }
+
+    prevDirs, err = GetMarkdownSyncDecks(db)
+    if err != nil {
+        return nil, err
+    }
+
+    deckDirs, err = markdownDeckDirs(db, rootDeck.ID, deckIDs)
+    if err != nil {
+        return nil, err
+    }
+
+    // directories that no longer belong to a deck; i.e. of decks deleted or moved within the app
+    var staleDirs map[string]uint = map[string]uint{}
+
+    for deckID, dir := range deckDirs {
+        dirDecks[dir] = deckID
+    }
+
+    for _, deckID := range deckIDs {
+
+        var (
+            dir     string = deckDirs[deckID]
+            prevDir string
+            hasPrev bool
+        )
+
+        prevDir, hasPrev = prevDirs[deckID]
+        if !hasPrev || prevDir == dir {
+            continue
+        }
+
+        // deck was renamed or moved within the app; move its directory along with it.
+        // decks are visited from nearest to farthest, such that directories of
+        // descendents are moved along with their ancestor's directory.
+        var moved bool
+        moved, err = moveMarkdownDeckDir(syncDir, prevDir, dir)
+        if err != nil {
+            return nil, err
+        }
+
+        if !moved {
+            staleDirs[prevDir] = deckID
+            continue
+        }
+
+        err = RenameMarkdownSyncPaths(db, prevDir, dir)
+        if err != nil {
+            return nil, err
+        }
+
+        for otherID, otherDir := range prevDirs {
+            if otherDir == prevDir || strings.HasPrefix(otherDir, prevDir+"/") {
+                prevDirs[otherID] = dir + strings.TrimPrefix(otherDir, prevDir)
+            }
+        }
+    }
+
+    for deckID, prevDir := range prevDirs {
+
+        if _, exists := deckDirs[deckID]; exists {
+            continue
+        }
+
+        // deck was deleted within the app; unless its directory is now used by another deck
+        if _, taken := dirDecks[prevDir]; taken {
+            err = DeleteMarkdownSyncDeck(db, deckID)
+            if err != nil {
+                return nil, err
+            }
+            continue
+        }
+
+        staleDirs[prevDir] = deckID
+    }
+
+    for _, deckID := range deckIDs {
+
+        var dir string = deckDirs[deckID]
+
+        err = os.MkdirAll(filepath.Join(syncDir, filepath.FromSlash(dir)), 0755)
+        if err != nil {
+            return nil, err
+        }
+
+        err = SetMarkdownSyncDeck(db, deckID, dir)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    // resolve deck of a directory; creating decks for new directories
+    var deckOfDir func(dir string) (uint, error)
+    deckOfDir = func(dir string) (uint, error) {
+
+        if deckID, exists := dirDecks[dir]; exists {
+            return deckID, nil
+        }
+
+        // cards aren't added into decks deleted within the app
+        for staleDir := range staleDirs {
+            if dir == staleDir || strings.HasPrefix(dir, staleDir+"/") {
+                return 0, ErrMarkdownDeckDeleted
+            }
+        }
+
+        parentID, err := deckOfDir(path.Dir(dir))
+        if err != nil {
+            return 0, err
+        }
+
+        newDeck, err := CreateDeck(db, &DeckProps{
+            Name:        path.Base(dir),
+            Description: "",
+        })
+        if err != nil {
+            return 0, err
+        }
+
+        err = CreateDeckRelationship(db, parentID, newDeck.ID)
+        if err != nil {
+            return 0, err
+        }
+
+        err = SetMarkdownSyncDeck(db, newDeck.ID, dir)
+        if err != nil {
+            return 0, err
+        }
+
+        deckDirs[newDeck.ID] = dir
+        dirDecks[dir] = newDeck.ID
+
+        return newDeck.ID, nil
+    }
+
+    // scan card files
+
+    var (
+        filesByID map[uint]*markdownFile = map[uint]*markdownFile{}
+        newFiles  []*markdownFile        = []*markdownFile{}
+    )
+
+    err = filepath.Walk(syncDir, func(fullPath string, info os.FileInfo, err error) error {
+
+        if err != nil {
+            return err
+        }
+
+        relPath, err := filepath.Rel(syncDir, fullPath)
+        if err != nil {
+            return err
+        }
+        relPath = filepath.ToSlash(relPath)
+
+        if info.IsDir() {
+
+            // skip hidden directories; e.g. .git
+            if relPath != "." && strings.HasPrefix(info.Name(), ".") {
+                return filepath.SkipDir
+            }
+
+            // new directories on disk become decks
+            _, err = deckOfDir(relPath)
+            if err == ErrMarkdownDeckDeleted {
+                return nil
+            }
+            return err
+        }
+
+        if !strings.HasSuffix(info.Name(), ".md") {
+            return nil
+        }
+
+        raw, err := ioutil.ReadFile(fullPath)
+        if err != nil {
+            return err
+        }
+
+        card, err := ParseMarkdownCard(raw)
+        if err != nil {
+            report.Errors = append(report.Errors, gin.H{
+                "path":  relPath,
+                "error": err.Error(),
+            })
+            return nil
+        }
+
+        var file *markdownFile = &markdownFile{
+            path:    relPath,
+            dir:     path.Dir(relPath),
+            card:    card,
+            hash:    MarkdownHash(raw),
+            modTime: info.ModTime().Unix(),
+        }
+
+        if card.ID <= 0 {
+            newFiles = append(newFiles, file)
+            return nil
+        }
+
+        if other, exists := filesByID[card.ID]; exists {
+            report.Conflicts = append(report.Conflicts, gin.H{
+                "card":   card.ID,
+                "path":   relPath,
+                "reason": fmt.Sprintf("card id is also used by %s", other.path),
+            })
+            return nil
+        }
+
+        filesByID[card.ID] = file
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    // fetch cards and sync states
+
+    var cards []CardRow
+    cards, err = AllCardsByDeck(db, rootDeck.ID)
+    if err != nil {
+        return nil, err
+    }
+
+    var states map[uint]MarkdownSyncStateRow
+    states, err = GetMarkdownSyncStates(db)
+    if err != nil {
+        return nil, err
+    }
+
+    var seenCards map[uint]bool = map[uint]bool{}
+
+    for idx := range cards {
+
+        var card *CardRow = &cards[idx]
+        seenCards[card.ID] = true
+
+        var (
+            expectedPath string = path.Join(deckDirs[card.Deck], MarkdownCardFileName(card))
+            file         *markdownFile
+            state        MarkdownSyncStateRow
+            hasFile      bool
+            hasState     bool
+        )
+
+        file, hasFile = filesByID[card.ID]
+        state, hasState = states[card.ID]
+
+        // case: card file doesn't exist; e.g. new card within the app
+        if !hasFile {
+            err = writeMarkdownSyncCard(db, syncDir, card, expectedPath, "", report)
+            if err != nil {
+                return nil, err
+            }
+            continue
+        }
+
+        // note: updated_at has a resolution of seconds; so the card's content is also
+        // compared in case it was changed within the same second as the last sync
+        var (
+            appChanged bool = !hasState ||
+                card.UpdatedAt != state.CardUpdatedAt ||
+                MarkdownHash(RenderMarkdownCard(card)) != state.CardHash ||
+                expectedPath != state.Path
+            diskChanged bool = !hasState ||
+                file.hash != state.FileHash ||
+                file.path != state.Path
+        )
+
+        // case: never synced; e.g. sync directory cloned from elsewhere
+        if !hasState {
+            switch {
+            case markdownCardMatches(file, card, deckDirs[card.Deck]):
+                appChanged = false
+                diskChanged = false
+            case file.modTime > card.UpdatedAt:
+                appChanged = false
+            default:
+                diskChanged = false
+            }
+        }
+
+        if appChanged && diskChanged {
+            switch prefer {
+            case "disk":
+                appChanged = false
+            case "app":
+                diskChanged = false
+            default:
+                report.Conflicts = append(report.Conflicts, gin.H{
+                    "card":   card.ID,
+                    "path":   file.path,
+                    "reason": "card was changed both within the app and on disk",
+                })
+                continue
+            }
+        }
+
+        switch {
+        case diskChanged:
+
+            var patch *StringMap = &StringMap{}
+
+            if file.card.Title != card.Title {
+                (*patch)["title"] = file.card.Title
+            }
+            if file.card.Description != card.Description {
+                (*patch)["description"] = file.card.Description
+            }
+            if file.card.Front != card.Front {
+                (*patch)["front"] = file.card.Front
+            }
+            if file.card.Back != card.Back {
+                (*patch)["back"] = file.card.Back
+            }
+
+            // card file was moved into another directory
+            if file.dir != deckDirs[card.Deck] {
+
+                var deckID uint
+                deckID, err = deckOfDir(file.dir)
+                if err == ErrMarkdownDeckDeleted {
+                    report.Conflicts = append(report.Conflicts, gin.H{
+                        "card":   card.ID,
+                        "path":   file.path,
+                        "reason": "card file was moved into a deck that no longer exists within the app",
+                    })
+                    continue
+                }
+                if err != nil {
+                    return nil, err
+                }
+
+                (*patch)["deck"] = deckID
+            }
+
+            if len(*patch) > 0 {
+
+                if title, has := (*patch)["title"]; has && len(strings.TrimSpace(title.(string))) <= 0 {
+                    report.Errors = append(report.Errors, gin.H{
+                        "card":  card.ID,
+                        "path":  file.path,
+                        "error": "title must be non-empty string",
+                    })
+                    continue
+                }
+
+                err = PatchCard(db, card.ID, patch)
+                if err != nil {
+                    return nil, err
+                }
+
+                card, err = GetCard(db, card.ID)
+                if err != nil {
+                    return nil, err
+                }
+
+                report.Updated = append(report.Updated, card.ID)
+            }
+
+            // rewrite card file in its canonical form
+            expectedPath = path.Join(deckDirs[card.Deck], MarkdownCardFileName(card))
+            err = writeMarkdownSyncCard(db, syncDir, card, expectedPath, file.path, report)
+            if err != nil {
+                return nil, err
+            }
+
+        case appChanged:
+
+            err = writeMarkdownSyncCard(db, syncDir, card, expectedPath, file.path, report)
+            if err != nil {
+                return nil, err
+            }
+
+        default:
+
+            err = SetMarkdownSyncState(db, card, file.path, file.hash)
+            if err != nil {
+                return nil, err
+            }
+        }
+    }
+
+    // card files of cards that no longer exist within the app
+
+    for cardID, file := range filesByID {
+
+        if seenCards[cardID] {
+            continue
+        }
+
+        state, hasState := states[cardID]
+
+        if !hasState || state.FileHash != file.hash {
+            report.Conflicts = append(report.Conflicts, gin.H{
+                "card":   cardID,
+                "path":   file.path,
+                "reason": "card no longer exists within the app",
+            })
+            continue
+        }
+
+        // card was deleted within the app
+        err = os.Remove(filepath.Join(syncDir, filepath.FromSlash(file.path)))
+        if err != nil {
+            return nil, err
+        }
+
+        err = DeleteMarkdownSyncState(db, cardID)
+        if err != nil {
+            return nil, err
+        }
+
+        report.Removed = append(report.Removed, file.path)
+    }
+
+    // forget sync states of cards deleted within the app, and whose files are gone
+
+    for cardID := range states {
+
+        if _, hasFile := filesByID[cardID]; seenCards[cardID] || hasFile {
+            continue
+        }
+
+        err = DeleteMarkdownSyncState(db, cardID)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    // new card files on disk
+
+    for _, file := range newFiles {
+
+        var deckID uint
+        deckID, err = deckOfDir(file.dir)
+        if err == ErrMarkdownDeckDeleted {
+            report.Conflicts = append(report.Conflicts, gin.H{
+                "path":   file.path,
+                "reason": "deck no longer exists within the app",
+            })
+            continue
+        }
+        if err != nil {
+            return nil, err
+        }
+
+        var title string = file.card.Title
+        if len(strings.TrimSpace(title)) <= 0 {
+            title = strings.TrimSuffix(path.Base(file.path), ".md")
+        }
+
+        var newCardRow *CardRow
+        newCardRow, err = CreateCard(db, &CardProps{
+            Title:       title,
+            Description: file.card.Description,
+            Front:       file.card.Front,
+            Back:        file.card.Back,
+            Deck:        deckID,
+        })
+        if err != nil {
+            report.Errors = append(report.Errors, gin.H{
+                "path":  file.path,
+                "error": err.Error(),
+            })
+            continue
+        }
+
+        report.Created = append(report.Created, newCardRow.ID)
+
+        // rewrite card file with its id
+        var expectedPath string = path.Join(file.dir, MarkdownCardFileName(newCardRow))
+        err = writeMarkdownSyncCard(db, syncDir, newCardRow, expectedPath, file.path, report)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    // remove directories of decks deleted or moved within the app; unless files remain within them
+
+    for staleDir, deckID := range staleDirs {
+
+        _, isLive := deckDirs[deckID]
+
+        if _, taken := dirDecks[staleDir]; taken {
+            continue
+        }
+
+        var removed bool
+        removed, err = removeEmptyMarkdownDirs(filepath.Join(syncDir, filepath.FromSlash(staleDir)))
+        if err != nil {
+            return nil, err
+        }
+
+        if !removed {
+
+            var reason string = "deck no longer exists within the app"
+            if isLive {
+                reason = "deck was moved within the app"
+            }
+
+            report.Conflicts = append(report.Conflicts, gin.H{
+                "deck":   deckID,
+                "path":   staleDir,
+                "reason": reason,
+            })
+            continue
+        }
+
+        if !isLive {
+            err = DeleteMarkdownSyncDeck(db, deckID)
+            if err != nil {
+                return nil, err
+            }
+        }
+
+        report.Removed = append(report.Removed, staleDir+"/")
+    }
+
+    return report, nil
+}
+
+// write card file at the given path; removing the card file at oldPath (if any) when it differs
+func writeMarkdownSyncCard(db sqlx.Ext, syncDir string, card *CardRow, relPath string, oldPath string, report *MarkdownSyncReport) error {
+
+    var (
+        err      error
+        raw      []byte = RenderMarkdownCard(card)
+        fullPath string = filepath.Join(syncDir, filepath.FromSlash(relPath))
+    )
+
+    err = os.MkdirAll(filepath.Dir(fullPath), 0755)
+    if err != nil {
+        return err
+    }
+
+    err = ioutil.WriteFile(fullPath, raw, 0644)
+    if err != nil {
+        return err
+    }
+
+    if len(oldPath) > 0 && oldPath != relPath {
+        err = os.Remove(filepath.Join(syncDir, filepath.FromSlash(oldPath)))
+        if err != nil && !os.IsNotExist(err) {
+            return err
+        }
+    }
+
+    report.Written = append(report.Written, relPath)
+
+    return SetMarkdownSyncState(db, card, relPath, MarkdownHash(raw))
+}
+
+func markdownCardMatches(file *markdownFile, card *CardRow, deckDir string) bool {
+    return file.dir == deckDir &&
+        file.card.Title == card.Title &&
+        file.card.Description == card.Description &&
+        file.card.Front == card.Front &&
+        file.card.Back == card.Back
+}
+
+// directories of decks relative to the sync directory; given decks from nearest to farthest.
+// sibling decks whose directory names collide (e.g. names that differ only in unsafe
+// characters or in case) are told apart by their deck id; the oldest deck keeps the plain name.
+func markdownDeckDirs(db sqlx.Ext, rootID uint, deckIDs []uint) (map[uint]string, error) {
+
+    var (
+        err     error
+        parents map[uint]uint   = map[uint]uint{}
+        depths  map[uint]int    = map[uint]int{rootID: 0}
+        names   map[uint]string = map[uint]string{}
+        ordered []uint          = make([]uint, 0, len(deckIDs))
+    )
+
+    for _, deckID := range deckIDs {
+
+        if deckID == rootID {
+            continue
+        }
+
+        var parentID uint
+        parentID, err = GetDeckParent(db, deckID)
+        if err != nil {
+            return nil, err
+        }
+
+        var deck *DeckRow
+        deck, err = GetDeck(db, deckID)
+        if err != nil {
+            return nil, err
+        }
+
+        parents[deckID] = parentID
+        depths[deckID] = depths[parentID] + 1
+        names[deckID] = deck.Name
+        ordered = append(ordered, deckID)
+    }
+
+    sort.SliceStable(ordered, func(i, j int) bool {
+        if depths[ordered[i]] != depths[ordered[j]] {
+            return depths[ordered[i]] < depths[ordered[j]]
+        }
+        return ordered[i] < ordered[j]
+    })
+
+    var (
+        dirs  map[uint]string = map[uint]string{rootID: "."}
+        taken map[string]bool = map[string]bool{".": true}
+    )
+
+    for _, deckID := range ordered {
+
+        var (
+            parentDir string = dirs[parents[deckID]]
+            name      string = MarkdownDeckDirName(names[deckID])
+            dir       string = path.Join(parentDir, name)
+        )
+
+        if taken[strings.ToLower(dir)] {
+            dir = path.Join(parentDir, fmt.Sprintf("%s-%d", name, deckID))
+        }
+
+        dirs[deckID] = dir
+        taken[strings.ToLower(dir)] = true
+    }
+
+    return dirs, nil
+}
+
+// directory name of a deck
+func MarkdownDeckDirName(name string) string {
+
+    var dir string = strings.TrimSpace(markdownUnsafeChars.ReplaceAllString(name, "-"))
+
+    // disallow hidden or relative directories
+    dir = strings.TrimLeft(dir, ".")
+    if len(dir) <= 0 {
+        dir = "-"
+    }
+
+    return dir
+}
+
+// move directory of a deck from oldDir to newDir (relative to the sync directory).
+// returns false if the directory couldn't be moved; i.e. it no longer exists, or newDir already exists.
+func moveMarkdownDeckDir(syncDir string, oldDir string, newDir string) (bool, error) {
+
+    var (
+        err     error
+        oldPath string = filepath.Join(syncDir, filepath.FromSlash(oldDir))
+        newPath string = filepath.Join(syncDir, filepath.FromSlash(newDir))
+    )
+
+    // a directory can't be moved within itself, nor can the sync directory be moved
+    if oldDir == "." || strings.HasPrefix(newDir, oldDir+"/") {
+        return false, nil
+    }
+
+    _, err = os.Stat(oldPath)
+    if os.IsNotExist(err) {
+        return false, nil
+    }
+    if err != nil {
+        return false, err
+    }
+
+    _, err = os.Stat(newPath)
+    if err == nil {
+        return false, nil
+    }
+    if !os.IsNotExist(err) {
+        return false, err
+    }
+
+    err = os.MkdirAll(filepath.Dir(newPath), 0755)
+    if err != nil {
+        return false, err
+    }
+
+    err = os.Rename(oldPath, newPath)
+    if err != nil {
+        return false, err
+    }
+
+    return true, nil
+}
+
+// remove directory if it (and its sub-directories) contain no files.
+// returns true if the directory was removed, or didn't exist.
+func removeEmptyMarkdownDirs(fullPath string) (bool, error) {
+
+    entries, err := ioutil.ReadDir(fullPath)
+    if os.IsNotExist(err) {
+        return true, nil
+    }
+    if err != nil {
+        return false, err
+    }
+
+    var empty bool = true
+
+    for _, entry := range entries {
+
+        if !entry.IsDir() {
+            empty = false
+            continue
+        }
+
+        removed, err := removeEmptyMarkdownDirs(filepath.Join(fullPath, entry.Name()))
+        if err != nil {
+            return false, err
+        }
+
+        if !removed {
+            empty = false
+        }
+    }
+
+    if !empty {
+        return false, nil
+    }
+
+    err = os.Remove(fullPath)
+    if err != nil {
+        return false, err
+    }
+
+    return true, nil
+}
+
+// e.g. 12-hola-mundo.md
+func MarkdownCardFileName(card *CardRow) string {
+
+    var slug string = strings.Trim(markdownSlugChars.ReplaceAllString(strings.ToLower(card.Title), "-"), "-")
+
+    if len(slug) > 48 {
+        slug = strings.TrimRight(slug[:48], "-")
+    }
+
+    if len(slug) <= 0 {
+        return fmt.Sprintf("%d.md", card.ID)
+    }
+
+    return fmt.Sprintf("%d-%s.md", card.ID, slug)
+}
+
+func MarkdownHash(raw []byte) string {
+    return fmt.Sprintf("%x", sha1.Sum(raw))
+}
+
+// render card as a markdown file. e.g.
+//
+// ---
+// id: 12
+// title: "Hola mundo"
+// description: ""
+// ---
+//
+// # Front
+//
+// hello world
+//
+// # Back
+//
+// ...
+func RenderMarkdownCard(card *CardRow) []byte {
+
+    var buffer bytes.Buffer
+
+    buffer.WriteString(MARKDOWN_FRONT_MATTER_DELIMITER + "\n")
+    buffer.WriteString(fmt.Sprintf("id: %d\n", card.ID))
+    buffer.WriteString(fmt.Sprintf("title: %s\n", strconv.Quote(card.Title)))
+    buffer.WriteString(fmt.Sprintf("description: %s\n", strconv.Quote(card.Description)))
+    buffer.WriteString(MARKDOWN_FRONT_MATTER_DELIMITER + "\n")
+
+    buffer.WriteString("\n" + MARKDOWN_FRONT_HEADING + "\n\n")
+    if len(card.Front) > 0 {
+        buffer.WriteString(escapeMarkdownHeadings(card.Front) + "\n\n")
+    }
+
+    buffer.WriteString(MARKDOWN_BACK_HEADING + "\n\n")
+    if len(card.Back) > 0 {
+        buffer.WriteString(escapeMarkdownHeadings(card.Back) + "\n")
+    }
+
+    return buffer.Bytes()
+}
+
+// parse card file rendered by RenderMarkdownCard.
+// a card file without an id (or with an id of 0) is a new card.
+func ParseMarkdownCard(raw []byte) (*MarkdownCard, error) {
+
+    var (
+        content string        = strings.Replace(string(raw), "\r\n", "\n", -1)
+        card    *MarkdownCard = &MarkdownCard{}
+    )
+
+    // front-matter
+
+    if !strings.HasPrefix(content, MARKDOWN_FRONT_MATTER_DELIMITER+"\n") {
+        return nil, ErrMarkdownNoFrontMatter
+    }
+    content = content[len(MARKDOWN_FRONT_MATTER_DELIMITER)+1:]
+
+    var end int = strings.Index(content, "\n"+MARKDOWN_FRONT_MATTER_DELIMITER+"\n")
+    if end < 0 {
+        return nil, ErrMarkdownNoFrontMatter
+    }
+
+    var frontMatter string = content[:end]
+    content = content[end+len(MARKDOWN_FRONT_MATTER_DELIMITER)+2:]
+
+    for _, line := range strings.Split(frontMatter, "\n") {
+
+        var parts []string = strings.SplitN(line, ":", 2)
+        if len(parts) != 2 {
+            continue
+        }
+
+        var (
+            key   string = strings.ToLower(strings.TrimSpace(parts[0]))
+            value string = unquoteMarkdownValue(parts[1])
+        )
+
+        switch key {
+        case "id":
+            if len(value) <= 0 {
+                continue
+            }
+
+            id, err := strconv.ParseUint(value, 10, 32)
+            if err != nil {
+                return nil, errors.New("markdown: card file has invalid id")
+            }
+            card.ID = uint(id)
+        case "title":
+            card.Title = value
+        case "description":
+            card.Description = value
+        }
+    }
+
+    // front and back sections
+
+    var lines []string = strings.Split(content, "\n")
+    var (
+        frontLines []string = []string{}
+        backLines  []string = []string{}
+        section    *[]string
+    )
+
+    for _, line := range lines {
+
+        switch {
+        case section == nil && strings.TrimSpace(line) == MARKDOWN_FRONT_HEADING:
+            section = &frontLines
+            continue
+        case section != &backLines && strings.TrimSpace(line) == MARKDOWN_BACK_HEADING:
+            section = &backLines
+            continue
+        }
+
+        if section != nil {
+            *section = append(*section, line)
+        }
+    }
+
+    card.Front = unescapeMarkdownHeadings(strings.Trim(strings.Join(frontLines, "\n"), "\n"))
+    card.Back = unescapeMarkdownHeadings(strings.Trim(strings.Join(backLines, "\n"), "\n"))
+
+    return card, nil
+}
+
+// escape lines of card content that would otherwise be read as section headings
+func escapeMarkdownHeadings(content string) string {
+
+    var lines []string = strings.Split(content, "\n")
+
+    for idx, line := range lines {
+        lines[idx] = markdownHeadingLine.ReplaceAllString(line, "${1}\\${2}")
+    }
+
+    return strings.Join(lines, "\n")
+}
+
+func unescapeMarkdownHeadings(content string) string {
+
+    var lines []string = strings.Split(content, "\n")
+
+    for idx, line := range lines {
+        lines[idx] = markdownEscapedHeadingLine.ReplaceAllString(line, "${1}${2}")
+    }
+
+    return strings.Join(lines, "\n")
+}
+
+func unquoteMarkdownValue(value string) string {
+
+    value = strings.TrimSpace(value)
+
+    if unquoted, err := strconv.Unquote(value); err == nil {
+        return unquoted
+    }
+
+    return value
+}
+
+func GetMarkdownSyncStates(db sqlx.Ext) (map[uint]MarkdownSyncStateRow, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(FETCH_MARKDOWN_SYNC_STATES_QUERY)
+    if err != nil {
+        return nil, err
+    }
+
+    var rows []MarkdownSyncStateRow = []MarkdownSyncStateRow{}
+    err = sqlx.Select(db, &rows, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    var states map[uint]MarkdownSyncStateRow = make(map[uint]MarkdownSyncStateRow, len(rows))
+    for _, row := range rows {
+        states[row.Card] = row
+    }
+
+    return states, nil
+}
+
+func SetMarkdownSyncState(db sqlx.Ext, card *CardRow, relPath string, fileHash string) error {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(SET_MARKDOWN_SYNC_STATE_QUERY, &StringMap{
+        "card_id":         card.ID,
+        "path":            relPath,
+        "card_updated_at": card.UpdatedAt,
+        "card_hash":       MarkdownHash(RenderMarkdownCard(card)),
+        "file_hash":       fileHash,
+    })
+    if err != nil {
+        return err
+    }
+
+    _, err = db.Exec(query, args...)
+    if err != nil {
+        return err
+    }
+
+    return nil
+}
+
+func DeleteMarkdownSyncState(db sqlx.Ext, cardID uint) error {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(DELETE_MARKDOWN_SYNC_STATE_QUERY, &StringMap{"card_id": cardID})
+    if err != nil {
+        return err
+    }
+
+    _, err = db.Exec(query, args...)
+    if err != nil {
+        return err
+    }
+
+    return nil
+}
+
+// directory of each deck as of the last markdown directory sync
+func GetMarkdownSyncDecks(db sqlx.Ext) (map[uint]string, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(FETCH_MARKDOWN_SYNC_DECKS_QUERY)
+    if err != nil {
+        return nil, err
+    }
+
+    var rows []MarkdownSyncDeckRow = []MarkdownSyncDeckRow{}
+    err = sqlx.Select(db, &rows, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    var dirs map[uint]string = make(map[uint]string, len(rows))
+    for _, row := range rows {
+        dirs[row.Deck] = row.Path
+    }
+
+    return dirs, nil
+}
+
+func SetMarkdownSyncDeck(db sqlx.Ext, deckID uint, dir string) error {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(SET_MARKDOWN_SYNC_DECK_QUERY, &StringMap{
+        "deck_id": deckID,
+        "path":    dir,
+    })
+    if err != nil {
+        return err
+    }
+
+    _, err = db.Exec(query, args...)
+    if err != nil {
+        return err
+    }
+
+    return nil
+}
+
+func DeleteMarkdownSyncDeck(db sqlx.Ext, deckID uint) error {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(DELETE_MARKDOWN_SYNC_DECK_QUERY, &StringMap{"deck_id": deckID})
+    if err != nil {
+        return err
+    }
+
+    _, err = db.Exec(query, args...)
+    if err != nil {
+        return err
+    }
+
+    return nil
+}
+
+// update recorded paths of card files and deck directories within the moved directory oldDir
+func RenameMarkdownSyncPaths(db sqlx.Ext, oldDir string, newDir string) error {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    for _, renameQuery := range []PipeInput{RENAME_MARKDOWN_SYNC_CARD_PATHS_QUERY, RENAME_MARKDOWN_SYNC_DECK_PATHS_QUERY} {
+
+        query, args, err = QueryApply(renameQuery, &StringMap{
+            "old_path": oldDir,
+            "new_path": newDir,
+        })
+        if err != nil {
+            return err
+        }
+
+        _, err = db.Exec(query, args...)
+        if err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
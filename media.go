@@ -0,0 +1,600 @@
+package main
+
+import (
+    "crypto/sha256"
+    "database/sql"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "mime"
+    "net/http"
+    "regexp"
+    "strconv"
+    "strings"
+
+    // 3rd-party
+    "github.com/gin-gonic/gin"
+    "github.com/jmoiron/sqlx"
+)
+
+/* variables */
+
+// errors
+var ErrMediaNoSuchMedia = errors.New("media: no such media of given hash")
+var ErrMediaInvalidHash = errors.New("media: hash must be a hex encoded sha-256 digest")
+var ErrMediaEmpty = errors.New("media: uploaded media is empty")
+var ErrMediaTooLarge = errors.New("media: uploaded media is too large")
+
+// maximum size of uploaded media in bytes
+const MEDIA_MAX_SIZE int64 = 32 << 20
+
+// unreferenced media younger than this (in seconds) is not garbage collected;
+// media is uploaded before the card referencing it is saved
+const MEDIA_GC_GRACE_PERIOD int64 = 60 * 60 * 24
+
+// cards reference media by url; e.g. ![diagram](/media/<hash>), or by audio marker;
+// e.g. [audio:<hash>]
+var mediaReferenceRegexp = regexp.MustCompile(`(?:/media/|\[audio:)([0-9a-fA-F]{64})`)
+var mediaHashRegexp = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+/* types */
+
+type MediaRow struct {
+    Hash      string `db:"hash"`
+    MIME      string `db:"mime"`
+    Size      int64  `db:"size"`
+    Data      []byte `db:"data"`
+    CreatedAt int64  `db:"created_at"`
+    Refs      uint   `db:"refs"`
+}
+
+/* REST Handlers */
+
+// POST /media
+//
+// Upload media. Media is content-addressed; uploading existing media is a no-op.
+// Cards reference media by its url within their fields; e.g. ![diagram](/media/<hash>)
+//
+// Input:
+// either a multipart/form-data body with the media as the file field;
+// or the raw media as the request body with its Content-Type.
+func MediaPOST(db *sqlx.DB, ctx *gin.Context) {
+
+    var (
+        err      error
+        data     []byte
+        mimeType string
+    )
+
+    ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, MEDIA_MAX_SIZE+1)
+
+    data, mimeType, err = readMediaUpload(ctx.Request)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to read uploaded media",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    switch {
+    case len(data) <= 0:
+        err = ErrMediaEmpty
+    case int64(len(data)) > MEDIA_MAX_SIZE:
+        err = ErrMediaTooLarge
+    }
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      err.Error(),
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var (
+        media   *MediaRow
+        created bool
+    )
+
+    media, created, err = CreateMedia(db, data, mimeType)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to store media",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var status int = http.StatusOK
+    if created {
+        status = http.StatusCreated
+    }
+
+    ctx.JSON(status, MediaRowToResponse(media))
+}
+
+// GET /media
+//
+// List media without their content; newest first.
+func MediaListGET(db *sqlx.DB, ctx *gin.Context) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(FETCH_MEDIA_LIST_QUERY)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve media",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var mediaList []MediaRow = []MediaRow{}
+    err = db.Select(&mediaList, query, args...)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve media",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var response []gin.H = make([]gin.H, 0, len(mediaList))
+    for idx := range mediaList {
+        response = append(response, MediaRowToResponse(&mediaList[idx]))
+    }
+
+    ctx.JSON(http.StatusOK, response)
+}
+
+// GET /media/:hash
+//
+// Serve media. Media is immutable; so it may be cached indefinitely.
+//
+// Params:
+// hash: hex encoded sha-256 digest of the media
+func MediaGET(db *sqlx.DB, ctx *gin.Context) {
+
+    var err error
+
+    var hash string = strings.ToLower(ctx.Param("hash"))
+
+    if !mediaHashRegexp.MatchString(hash) {
+        err = ErrMediaInvalidHash
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "given hash is invalid",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var etag string = fmt.Sprintf("%q", hash)
+
+    ctx.Writer.Header().Set("ETag", etag)
+    ctx.Writer.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+
+    // content is addressed by its hash; so a matching etag is never stale
+    if ctx.Request.Header.Get("If-None-Match") == etag {
+        ctx.Writer.WriteHeader(http.StatusNotModified)
+        return
+    }
+
+    var media *MediaRow
+    media, err = GetMedia(db, hash)
+    switch {
+    case err == ErrMediaNoSuchMedia:
+        ctx.Writer.Header().Del("ETag")
+        ctx.Writer.Header().Del("Cache-Control")
+        ctx.JSON(http.StatusNotFound, gin.H{
+            "status":           http.StatusNotFound,
+            "developerMessage": err.Error(),
+            "userMessage":      "cannot find media by hash",
+        })
+        ctx.Error(err)
+        return
+    case err != nil:
+        ctx.Writer.Header().Del("ETag")
+        ctx.Writer.Header().Del("Cache-Control")
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve media",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    ctx.Writer.Header().Set("Content-Length", strconv.FormatInt(media.Size, 10))
+    ctx.Data(http.StatusOK, media.MIME, media.Data)
+}
+
+// POST /media/gc
+//
+// Permanently delete media that isn't referenced by any card, card revision or
+// trashed item. Media uploaded within the past day is kept.
+//
+// Query params:
+// dry_run: if true, list media that would be deleted without deleting them
+func MediaGCPOST(db *sqlx.DB, ctx *gin.Context) {
+
+    var err error
+
+    var dryRun bool
+    dryRun, err = strconv.ParseBool(ctx.DefaultQuery("dry_run", "false"))
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "dry_run must be a boolean",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var collected []MediaRow
+    collected, err = CollectMedia(db, dryRun)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to garbage collect media",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var (
+        response []gin.H = make([]gin.H, 0, len(collected))
+        freed    int64   = 0
+    )
+
+    for idx := range collected {
+        freed += collected[idx].Size
+        response = append(response, MediaRowToResponse(&collected[idx]))
+    }
+
+    ctx.JSON(http.StatusOK, gin.H{
+        "dry_run": dryRun,
+        "deleted": response,
+        "freed":   freed,
+    })
+}
+
+/* helpers */
+
+func MediaRowToResponse(media *MediaRow) gin.H {
+    return gin.H{
+        "hash":       media.Hash,
+        "mime":       media.MIME,
+        "size":       media.Size,
+        "created_at": media.CreatedAt,
+        "references": media.Refs,
+        "url":        MediaURL(media.Hash),
+    }
+}
+
+func MediaURL(hash string) string {
+    return "/media/" + hash
+}
+
+// read uploaded media and its mime type from the request
+func readMediaUpload(request *http.Request) ([]byte, string, error) {
+
+    var (
+        err      error
+        data     []byte
+        mimeType string
+    )
+
+    var contentType string = request.Header.Get("Content-Type")
+    mediaType, _, _ := mime.ParseMediaType(contentType)
+
+    if mediaType == "multipart/form-data" {
+
+        err = request.ParseMultipartForm(MEDIA_MAX_SIZE)
+        if err != nil {
+            return nil, "", err
+        }
+
+        file, header, err := request.FormFile("file")
+        if err != nil {
+            return nil, "", err
+        }
+        defer file.Close()
+
+        data, err = ioutil.ReadAll(io.LimitReader(file, MEDIA_MAX_SIZE+1))
+        if err != nil {
+            return nil, "", err
+        }
+
+        mimeType = header.Header.Get("Content-Type")
+    } else {
+
+        data, err = ioutil.ReadAll(request.Body)
+        if err != nil {
+            return nil, "", err
+        }
+
+        mimeType = contentType
+    }
+
+    return data, NormalizeMediaMIME(mimeType, data), nil
+}
+
+// use given mime type unless it's missing or generic; otherwise sniff it from the data
+func NormalizeMediaMIME(mimeType string, data []byte) string {
+
+    mediaType, params, err := mime.ParseMediaType(mimeType)
+
+    if err != nil || mediaType == "application/octet-stream" {
+        return http.DetectContentType(data)
+    }
+
+    return mime.FormatMediaType(mediaType, params)
+}
+
+func MediaHash(data []byte) string {
+    var digest [sha256.Size]byte = sha256.Sum256(data)
+    return hex.EncodeToString(digest[:])
+}
+
+// store media; returns true if the media didn't exist before
+func CreateMedia(db sqlx.Ext, data []byte, mimeType string) (*MediaRow, bool, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+        res   sql.Result
+    )
+
+    var hash string = MediaHash(data)
+
+    query, args, err = QueryApply(INSERT_MEDIA_QUERY, &StringMap{
+        "hash": hash,
+        "mime": mimeType,
+        "size": len(data),
+        "data": data,
+    })
+    if err != nil {
+        return nil, false, err
+    }
+
+    res, err = db.Exec(query, args...)
+    if err != nil {
+        return nil, false, err
+    }
+
+    num, err := res.RowsAffected()
+    if err != nil {
+        return nil, false, err
+    }
+
+    var media *MediaRow
+    media, err = GetMediaMeta(db, hash)
+    if err != nil {
+        return nil, false, err
+    }
+
+    return media, num > 0, nil
+}
+
+func GetMedia(db sqlx.Ext, hash string) (*MediaRow, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(FETCH_MEDIA_QUERY, &StringMap{"hash": hash})
+    if err != nil {
+        return nil, err
+    }
+
+    var fetchedMedia *MediaRow = &MediaRow{}
+
+    err = db.QueryRowx(query, args...).StructScan(fetchedMedia)
+
+    switch {
+    case err == sql.ErrNoRows:
+        return nil, ErrMediaNoSuchMedia
+    case err != nil:
+        return nil, err
+    default:
+        return fetchedMedia, nil
+    }
+}
+
+// fetch media without its data
+func GetMediaMeta(db sqlx.Ext, hash string) (*MediaRow, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(FETCH_MEDIA_META_QUERY, &StringMap{"hash": hash})
+    if err != nil {
+        return nil, err
+    }
+
+    var fetchedMedia *MediaRow = &MediaRow{}
+
+    err = db.QueryRowx(query, args...).StructScan(fetchedMedia)
+
+    switch {
+    case err == sql.ErrNoRows:
+        return nil, ErrMediaNoSuchMedia
+    case err != nil:
+        return nil, err
+    default:
+        return fetchedMedia, nil
+    }
+}
+
+// hashes of media referenced within the text; in order of first appearance
+func ParseMediaReferences(texts ...string) []string {
+
+    var (
+        seen   map[string]bool = make(map[string]bool)
+        hashes []string        = []string{}
+    )
+
+    for _, text := range texts {
+        for _, match := range mediaReferenceRegexp.FindAllStringSubmatch(text, -1) {
+            var hash string = strings.ToLower(match[1])
+            if seen[hash] {
+                continue
+            }
+            seen[hash] = true
+            hashes = append(hashes, hash)
+        }
+    }
+
+    return hashes
+}
+
+// hashes of media referenced by the card
+func MediaByCard(db sqlx.Ext, cardID uint) ([]string, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(FETCH_MEDIA_BY_CARD_QUERY, &StringMap{"card_id": cardID})
+    if err != nil {
+        return nil, err
+    }
+
+    var hashes []string = []string{}
+    err = sqlx.Select(db, &hashes, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    return hashes, nil
+}
+
+// rebuild the card's media references from its fields
+func SyncCardMedia(db sqlx.Ext, cardID uint) error {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    var card *CardRow
+    card, err = GetCard(db, cardID)
+    if err != nil {
+        return err
+    }
+
+    query, args, err = QueryApply(DELETE_CARD_MEDIA_QUERY, &StringMap{"card_id": cardID})
+    if err != nil {
+        return err
+    }
+
+    _, err = db.Exec(query, args...)
+    if err != nil {
+        return err
+    }
+
+    for _, hash := range ParseMediaReferences(card.Title, card.Description, card.Front, card.Back) {
+
+        query, args, err = QueryApply(INSERT_CARD_MEDIA_QUERY, &StringMap{
+            "card_id": cardID,
+            "hash":    hash,
+        })
+        if err != nil {
+            return err
+        }
+
+        _, err = db.Exec(query, args...)
+        if err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// delete unreferenced media; returns the deleted media (without content)
+func CollectMedia(db *sqlx.DB, dryRun bool) ([]MediaRow, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    var tx *sqlx.Tx
+    tx, err = db.Beginx()
+    if err != nil {
+        return nil, err
+    }
+
+    query, args, err = QueryApply(FETCH_UNREFERENCED_MEDIA_QUERY, &StringMap{
+        "grace_period": MEDIA_GC_GRACE_PERIOD,
+    })
+    if err != nil {
+        tx.Rollback()
+        return nil, err
+    }
+
+    var collected []MediaRow = []MediaRow{}
+    err = tx.Select(&collected, query, args...)
+    if err != nil {
+        tx.Rollback()
+        return nil, err
+    }
+
+    if dryRun {
+        tx.Rollback()
+        return collected, nil
+    }
+
+    for _, media := range collected {
+
+        query, args, err = QueryApply(DELETE_MEDIA_QUERY, &StringMap{"hash": media.Hash})
+        if err != nil {
+            tx.Rollback()
+            return nil, err
+        }
+
+        _, err = tx.Exec(query, args...)
+        if err != nil {
+            tx.Rollback()
+            return nil, err
+        }
+    }
+
+    err = tx.Commit()
+    if err != nil {
+        return nil, err
+    }
+
+    return collected, nil
+}
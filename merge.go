@@ -0,0 +1,438 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+
+    // 3rd-party
+    "github.com/gin-gonic/gin"
+    "github.com/jmoiron/sqlx"
+)
+
+/* variables */
+
+// errors
+var ErrCardMergeSelf = errors.New("cards: card cannot be merged into itself")
+
+// how the content of the merged cards is combined
+const (
+    CARD_MERGE_CONTENT_KEEP        string = "keep"
+    CARD_MERGE_CONTENT_CONCATENATE string = "concatenate"
+)
+
+/* types */
+
+type CardMergeRequest struct {
+    Victim  uint   `json:"victim" binding:"required"`
+    Content string `json:"content"`
+}
+
+type CardScoreHistoryPointRow struct {
+    Card        uint  `db:"card"`
+    OccuredAt   int64 `db:"occured_at"`
+    Success     uint  `db:"success"`
+    Fail        uint  `db:"fail"`
+    PrevSuccess *uint `db:"prev_success"` // nil for points recorded before migration 4
+    PrevFail    *uint `db:"prev_fail"`
+    Reviewed    *bool `db:"reviewed"` // nil for points recorded before migration 7
+}
+
+/* REST Handlers */
+
+// POST /cards/:id/merge
+//
+// Merge the victim card into this card (the survivor). The victim's review history,
+// stash memberships and tags are moved to the survivor, whose score is recomputed from
+// the combined review history. Links to the victim are pointed to the survivor.
+// The victim is then permanently deleted; it's not moved into the trash.
+//
+// Params:
+// id: a unique, positive integer that is the identifier of the surviving card
+//
+// Input:
+// victim: a unique, positive integer that is the identifier of the card to merge
+// content: how the content of the cards is combined; one of:
+//          keep (default): the survivor's content is kept
+//          concatenate: the victim's description, front and back are appended to the
+//                       survivor's. the survivor's title is kept.
+func CardMergePOST(db *sqlx.DB, ctx *gin.Context) {
+
+    var err error
+
+    // parse and validate id param
+    var cardIDString string = strings.ToLower(ctx.Param("id"))
+
+    _cardID, err := strconv.ParseUint(cardIDString, 10, 32)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "given id is invalid",
+        })
+        ctx.Error(err)
+        return
+    }
+    var cardID uint = uint(_cardID)
+
+    var jsonRequest CardMergeRequest
+    err = ctx.BindJSON(&jsonRequest)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "bad JSON input",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    switch jsonRequest.Content {
+    case "":
+        jsonRequest.Content = CARD_MERGE_CONTENT_KEEP
+    case CARD_MERGE_CONTENT_KEEP:
+    case CARD_MERGE_CONTENT_CONCATENATE:
+    default:
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": "invalid content option",
+            "userMessage":      "content must be one of: keep, concatenate",
+        })
+        return
+    }
+
+    if jsonRequest.Victim == cardID {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": ErrCardMergeSelf.Error(),
+            "userMessage":      ErrCardMergeSelf.Error(),
+        })
+        ctx.Error(ErrCardMergeSelf)
+        return
+    }
+
+    var survivor *CardRow
+    survivor, err = GetCard(db, cardID)
+    switch {
+    case err == ErrCardNoSuchCard:
+        ctx.JSON(http.StatusNotFound, gin.H{
+            "status":           http.StatusNotFound,
+            "developerMessage": err.Error(),
+            "userMessage":      "cannot find card by id",
+        })
+        ctx.Error(err)
+        return
+    case err != nil:
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve card",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var victim *CardRow
+    victim, err = GetCard(db, jsonRequest.Victim)
+    switch {
+    case err == ErrCardNoSuchCard:
+        ctx.JSON(http.StatusNotFound, gin.H{
+            "status":           http.StatusNotFound,
+            "developerMessage": err.Error(),
+            "userMessage":      "cannot find victim card by id",
+        })
+        ctx.Error(err)
+        return
+    case err != nil:
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve victim card",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var tx *sqlx.Tx
+    tx, err = db.Beginx()
+    if err == nil {
+        err = MergeCards(tx, survivor, victim, jsonRequest.Content)
+        if err == nil {
+            err = tx.Commit()
+        } else {
+            tx.Rollback()
+        }
+    }
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to merge cards",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    // fetch merged card
+
+    survivor, err = GetCard(db, cardID)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve card",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var fetchedCardScore *CardScoreRow
+    fetchedCardScore, err = GetCardScoreRecord(db, cardID)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve card score record",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var fetchedStashes []uint
+    fetchedStashes, err = StashesByCard(db, cardID)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve card stashes",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var links gin.H
+    links, err = CardLinksToResponse(db, cardID)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve card links",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var cardrow gin.H = CardRowToResponse(db, survivor)
+    var cardscore gin.H = CardScoreToResponse(fetchedCardScore)
+
+    ctx.JSON(http.StatusOK, MergeResponses(
+        &cardrow,
+        &gin.H{"review": cardscore},
+        &gin.H{"stashes": fetchedStashes},
+        &links,
+        &gin.H{"merged": victim.ID},
+    ))
+}
+
+/* helpers */
+
+// merge the victim card into the survivor, and delete the victim
+func MergeCards(db sqlx.Ext, survivor *CardRow, victim *CardRow, content string) error {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    if content == CARD_MERGE_CONTENT_CONCATENATE {
+
+        err = PatchCard(db, survivor.ID, &StringMap{
+            "description": concatenateCardText(survivor.Description, victim.Description),
+            "front":       concatenateCardText(survivor.Front, victim.Front),
+            "back":        concatenateCardText(survivor.Back, victim.Back),
+        })
+        if err != nil && err != ErrCardNotPatched {
+            return err
+        }
+    }
+
+    // the score is recomputed from the history of both cards; fetch it before it's moved
+    var history []CardScoreHistoryPointRow
+    history, err = GetMergedCardScoreHistory(db, survivor.ID, victim.ID)
+    if err != nil {
+        return err
+    }
+
+    var survivorScore *CardScoreRow
+    survivorScore, err = GetCardScoreRecord(db, survivor.ID)
+    if err != nil {
+        return err
+    }
+
+    var victimScore *CardScoreRow
+    victimScore, err = GetCardScoreRecord(db, victim.ID)
+    if err != nil {
+        return err
+    }
+
+    _, err = RetargetCardLinks(db, victim.ID, survivor.ID)
+    if err != nil {
+        return err
+    }
+
+    query, args, err = QueryApply(MERGE_CARD_INTO_SURVIVOR_QUERY, &StringMap{
+        "survivor": survivor.ID,
+        "victim":   victim.ID,
+    })
+    if err != nil {
+        return err
+    }
+
+    _, err = db.Exec(query, args...)
+    if err != nil {
+        return err
+    }
+
+    // the times reviewed are set on their own; such that the merge isn't recorded as a
+    // review in the survivor's score history
+    err = UpdateCardScore(db, survivor.ID, &StringMap{
+        "times_reviewed": survivorScore.TimesReviewed + victimScore.TimesReviewed,
+    })
+    if err != nil {
+        return err
+    }
+
+    var success, fail uint = ReplayCardScoreHistory(history)
+
+    err = UpdateCardScore(db, survivor.ID, &StringMap{
+        "success":   success,
+        "fail":      fail,
+        "score":     calculateScore(success, fail),
+        "changelog": fmt.Sprintf("merged card %d", victim.ID),
+    })
+    if err != nil {
+        return err
+    }
+
+    // the survivor may be cached as a review card with its old score
+    err = DeleteCachedReviewCard(db, survivor.ID)
+    if err != nil {
+        return err
+    }
+
+    return DeleteCard(db, victim.ID)
+}
+
+func GetMergedCardScoreHistory(db sqlx.Ext, survivorID uint, victimID uint) ([]CardScoreHistoryPointRow, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(FETCH_MERGED_CARD_SCORE_HISTORY_QUERY, &StringMap{
+        "survivor": survivorID,
+        "victim":   victimID,
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    var history []CardScoreHistoryPointRow = []CardScoreHistoryPointRow{}
+    err = sqlx.Select(db, &history, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    return history, nil
+}
+
+// combine success and fail counts of score histories of several cards, in the order
+// they were recorded. reviews add to the combined counts; resets (e.g. forgot) of any
+// card replace them. a point is compared with the counts it was recorded over; or, for
+// older points, with the previous point of its card.
+func ReplayCardScoreHistory(history []CardScoreHistoryPointRow) (uint, uint) {
+
+    type counts struct {
+        success uint
+        fail    uint
+    }
+
+    var (
+        previous map[uint]counts = make(map[uint]counts)
+        combined counts
+    )
+
+    for _, point := range history {
+
+        var last counts = previous[point.Card]
+        if point.PrevSuccess != nil && point.PrevFail != nil {
+            last = counts{success: *point.PrevSuccess, fail: *point.PrevFail}
+        }
+
+        if point.Success >= last.success && point.Fail >= last.fail {
+            combined.success += point.Success - last.success
+            combined.fail += point.Fail - last.fail
+        } else {
+            combined = counts{success: point.Success, fail: point.Fail}
+        }
+
+        previous[point.Card] = counts{success: point.Success, fail: point.Fail}
+    }
+
+    return combined.success, combined.fail
+}
+
+// count the reviews of a score history; points recorded before migration 7 count as
+// reviews when they raised the success or fail count of their card.
+func CountCardScoreReviews(history []CardScoreHistoryPointRow) uint {
+
+    type counts struct {
+        success uint
+        fail    uint
+    }
+
+    var (
+        previous map[uint]counts = make(map[uint]counts)
+        reviews  uint
+    )
+
+    for _, point := range history {
+
+        var last counts = previous[point.Card]
+        if point.PrevSuccess != nil && point.PrevFail != nil {
+            last = counts{success: *point.PrevSuccess, fail: *point.PrevFail}
+        }
+
+        switch {
+        case point.Reviewed != nil:
+            if *point.Reviewed {
+                reviews++
+            }
+        case point.Success > last.success || point.Fail > last.fail:
+            reviews++
+        }
+
+        previous[point.Card] = counts{success: point.Success, fail: point.Fail}
+    }
+
+    return reviews
+}
+
+func concatenateCardText(texts ...string) string {
+
+    var parts []string = make([]string, 0, len(texts))
+
+    for _, text := range texts {
+        if len(strings.TrimSpace(text)) <= 0 {
+            continue
+        }
+        parts = append(parts, text)
+    }
+
+    return strings.Join(parts, "\n\n")
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "time"
+
+    // 3rd-party
+    "github.com/jmoiron/sqlx"
+)
+
+/* variables */
+
+// errors
+var ErrMigrationSchemaTooNew = errors.New("migrations: database schema is newer than this version of grokdb")
+var ErrMigrationNoSuchProfile = errors.New("migrations: no database of given profile name")
+
+// migrations upgrade the schema of a database; they're applied in order of version,
+// once for each database. never change a released migration; add a new one instead.
+var migrations []Migration = []Migration{
+    {
+        Version: 1,
+        Name:    "initial schema",
+        Up: migrateQueries(
+            SETUP_CONFIG_TABLE_QUERY,
+            SETUP_DECKS_TABLE_QUERY,
+            SETUP_CARDS_TABLE_QUERY,
+            STASHES_TABLE_QUERY,
+            MARKDOWN_SYNC_TABLE_QUERY,
+            TRASH_TABLE_QUERY,
+            MEDIA_TABLE_QUERY,
+            SMART_STASHES_TABLE_QUERY,
+            NESTED_STASHES_TABLE_QUERY,
+            STASH_DEADLINES_TABLE_QUERY,
+            ORDERED_STASHES_TABLE_QUERY,
+            CARD_LINKS_TABLE_QUERY,
+        ),
+    },
+    {
+        Version: 2,
+        Name:    "global ids for sync",
+        Up:      migrateQueries(SYNC_TABLES_QUERY),
+    },
+    {
+        Version: 3,
+        Name:    "markdown sync deck directories",
+        Up:      migrateQueries(MARKDOWN_SYNC_DECKS_TABLE_QUERY),
+    },
+    {
+        Version: 4,
+        Name:    "previous counts of score history",
+        Up:      migrateQueries(SCORE_HISTORY_PREVIOUS_COUNTS_QUERY),
+    },
+    {
+        Version: 5,
+        Name:    "card links of existing link markers",
+        Up:      migrateQueries(CARD_LINKS_BACKFILL_QUERY),
+    },
+    {
+        Version: 6,
+        Name:    "modification times of stash settings",
+        Up:      migrateQueries(STASH_SETTINGS_UPDATED_AT_QUERY),
+    },
+    {
+        Version: 7,
+        Name:    "reviews of score history",
+        Up:      migrateQueries(SCORE_HISTORY_REVIEWED_QUERY),
+    },
+    {
+        Version: 8,
+        Name:    "review modes of cached stash review cards",
+        Up:      migrateQueries(STASH_REVIEW_CARD_CACHE_MODE_QUERY),
+    },
+}
+
+/* types */
+
+type Migration struct {
+    Version uint
+    Name    string
+    Up      func(tx *sqlx.Tx) error
+}
+
+type SchemaVersionRow struct {
+    Version   uint   `db:"version"`
+    Name      string `db:"name"`
+    AppliedAt int64  `db:"applied_at"`
+}
+
+type MigrationStatus struct {
+    Migration Migration
+    Applied   *SchemaVersionRow
+}
+
+/* helpers */
+
+// migration that runs the given queries
+func migrateQueries(queries ...string) func(tx *sqlx.Tx) error {
+    return func(tx *sqlx.Tx) error {
+
+        for _, query := range queries {
+            _, err := tx.Exec(query)
+            if err != nil {
+                return err
+            }
+        }
+
+        return nil
+    }
+}
+
+func LatestSchemaVersion() uint {
+
+    var latest uint = 0
+
+    for _, migration := range migrations {
+        if migration.Version > latest {
+            latest = migration.Version
+        }
+    }
+
+    return latest
+}
+
+func GetSchemaVersions(db sqlx.Ext) ([]SchemaVersionRow, error) {
+
+    query, args, err := QueryApply(FETCH_SCHEMA_VERSIONS_QUERY)
+    if err != nil {
+        return nil, err
+    }
+
+    var versions []SchemaVersionRow = []SchemaVersionRow{}
+    err = sqlx.Select(db, &versions, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    return versions, nil
+}
+
+// version of the database's schema; 0 if no migration was applied
+func (db *Database) SchemaVersion() (uint, error) {
+
+    versions, err := GetSchemaVersions(db.instance)
+    if err != nil {
+        return 0, err
+    }
+
+    var current uint = 0
+    for _, version := range versions {
+        if version.Version > current {
+            current = version.Version
+        }
+    }
+
+    return current, nil
+}
+
+// every known migration, and when it was applied (if it was)
+func (db *Database) MigrationStatus() ([]MigrationStatus, error) {
+
+    versions, err := GetSchemaVersions(db.instance)
+    if err != nil {
+        return nil, err
+    }
+
+    var applied map[uint]*SchemaVersionRow = make(map[uint]*SchemaVersionRow)
+    for idx := range versions {
+        applied[versions[idx].Version] = &versions[idx]
+    }
+
+    var statuses []MigrationStatus = make([]MigrationStatus, 0, len(migrations))
+    for _, migration := range migrations {
+        statuses = append(statuses, MigrationStatus{
+            Migration: migration,
+            Applied:   applied[migration.Version],
+        })
+    }
+
+    return statuses, nil
+}
+
+// migrations yet to be applied, in order
+func (db *Database) PendingMigrations() ([]Migration, error) {
+
+    current, err := db.SchemaVersion()
+    if err != nil {
+        return nil, err
+    }
+
+    if current > LatestSchemaVersion() {
+        return nil, ErrMigrationSchemaTooNew
+    }
+
+    var pending []Migration = []Migration{}
+    for _, migration := range migrations {
+        if migration.Version > current {
+            pending = append(pending, migration)
+        }
+    }
+
+    return pending, nil
+}
+
+// apply pending migrations; returns the migrations that were (or, if dryRun, would be)
+// applied. each migration is applied in its own transaction, and a backup is taken
+// before each migration changes the schema of an existing database; so a failed
+// migration can be rolled back to the schema just before it.
+func (db *Database) Migrate(dryRun bool) ([]Migration, error) {
+
+    var err error
+
+    var pending []Migration
+    pending, err = db.PendingMigrations()
+    if err != nil {
+        return nil, err
+    }
+
+    if dryRun || len(pending) <= 0 {
+        return pending, nil
+    }
+
+    // a new database has nothing worth backing up, not even between its migrations
+    var numTables int
+    query, args, err := QueryApply(COUNT_SCHEMA_TABLES_QUERY)
+    if err != nil {
+        return nil, err
+    }
+
+    err = db.instance.QueryRowx(query, args...).Scan(&numTables)
+    if err != nil {
+        return nil, err
+    }
+
+    for _, migration := range pending {
+
+        if numTables > 0 {
+            _, err = db.Backup()
+            if err != nil {
+                return nil, fmt.Errorf("migrations: unable to back up before migration %d (%s): %s",
+                    migration.Version, migration.Name, err.Error())
+            }
+        }
+
+        err = applyMigration(db.instance, migration)
+        if err != nil {
+            return nil, fmt.Errorf("migrations: unable to apply migration %d (%s): %s",
+                migration.Version, migration.Name, err.Error())
+        }
+    }
+
+    return pending, nil
+}
+
+func applyMigration(db *sqlx.DB, migration Migration) error {
+
+    tx, err := db.Beginx()
+    if err != nil {
+        return err
+    }
+
+    err = migration.Up(tx)
+    if err == nil {
+
+        var query string
+        var args []interface{}
+        query, args, err = QueryApply(INSERT_SCHEMA_VERSION_QUERY, &StringMap{
+            "version": migration.Version,
+            "name":    migration.Name,
+        })
+        if err == nil {
+            _, err = tx.Exec(query, args...)
+        }
+    }
+
+    if err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    return tx.Commit()
+}
+
+// grokdb migrate [--status] [--dry-run] <profile>
+func migrateCommand(profileName string, options *DatabaseOptions, status bool, dryRun bool) {
+
+    var err error
+
+    // don't create a database for a mistyped profile name
+    _, err = os.Stat(ProfileFileName(options.DataDir, profileName))
+    if os.IsNotExist(err) {
+        exitIfErr(ErrMigrationNoSuchProfile, 1)
+    }
+    exitIfErr(err, 1)
+
+    var db *Database
+    db, err = OpenDatabase(profileName, options)
+    exitIfErr(err, 1)
+    defer db.CleanUp()
+
+    if status {
+
+        var statuses []MigrationStatus
+        statuses, err = db.MigrationStatus()
+        exitIfErr(err, 1)
+
+        var current uint
+        current, err = db.SchemaVersion()
+        exitIfErr(err, 1)
+
+        fmt.Printf("schema version: %d (latest: %d)\n", current, LatestSchemaVersion())
+
+        for _, migration := range statuses {
+            if migration.Applied != nil {
+                fmt.Printf("  %d  %s  applied %s\n", migration.Migration.Version, migration.Migration.Name,
+                    time.Unix(migration.Applied.AppliedAt, 0).Format(time.RFC3339))
+            } else {
+                fmt.Printf("  %d  %s  pending\n", migration.Migration.Version, migration.Migration.Name)
+            }
+        }
+
+        return
+    }
+
+    var applied []Migration
+    applied, err = db.Migrate(dryRun)
+    exitIfErr(err, 1)
+
+    if len(applied) <= 0 {
+        fmt.Println("schema is up to date")
+        return
+    }
+
+    for _, migration := range applied {
+        if dryRun {
+            fmt.Printf("would apply %d  %s\n", migration.Version, migration.Name)
+        } else {
+            fmt.Printf("applied %d  %s\n", migration.Version, migration.Name)
+        }
+    }
+}
@@ -0,0 +1,569 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "os"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strings"
+    "sync"
+
+    // 3rd-party
+    "github.com/gin-gonic/gin"
+    "github.com/jmoiron/sqlx"
+    sqlite "github.com/mattn/go-sqlite3"
+)
+
+/* variables */
+
+// errors
+var ErrProfileInvalidName = errors.New("profiles: name must be non-empty and consist of letters, digits, dashes or underscores")
+var ErrProfileNoSuchProfile = errors.New("profiles: no profile of given name")
+var ErrProfileExists = errors.New("profiles: profile of given name already exists")
+var ErrProfileSwitchPinned = errors.New("profiles: cannot switch profiles while syncing with a Markdown folder")
+var ErrProfileInUse = errors.New("profiles: database of the profile is in use; e.g. by a running grokdb")
+
+// profile names are file names within the data directory; backups of a profile
+// (e.g. <name>.<timestamp>.db) aren't profiles since they contain dots
+var profileNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_\-]+$`)
+
+// extension of database files
+const DATABASE_FILE_EXT string = ".db"
+
+// files that sqlite may keep alongside a database file
+var databaseSideFileSuffixes []string = []string{"-wal", "-shm", "-journal"}
+
+/* types */
+
+// the database of the profile in use by the server. each request uses the database
+// of the profile that was in use when it began; switching waits on these requests.
+type Profiles struct {
+    options DatabaseOptions
+    lock    sync.RWMutex
+    current *Database
+
+    // the profile in use cannot be switched
+    pinned bool
+}
+
+type ProfileRequest struct {
+    Name string `json:"name" binding:"required"`
+}
+
+/* REST Handlers */
+
+// GET /profiles
+//
+// List profiles within the data directory, and the profile in use.
+func ProfilesGET(profiles *Profiles, ctx *gin.Context) {
+
+    names, err := ListProfiles(profiles.options.DataDir)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to list profiles",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    ctx.JSON(http.StatusOK, gin.H{
+        "current":  profiles.Current(),
+        "profiles": names,
+    })
+}
+
+// POST /profiles
+//
+// Create a profile within the data directory.
+//
+// Input:
+// name: name of the profile; letters, digits, dashes or underscores
+func ProfilePOST(profiles *Profiles, ctx *gin.Context) {
+
+    var jsonRequest ProfileRequest
+    err := ctx.BindJSON(&jsonRequest)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "bad JSON input",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    err = CreateProfile(&profiles.options, jsonRequest.Name)
+    switch {
+    case err == ErrProfileInvalidName:
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      err.Error(),
+        })
+        ctx.Error(err)
+        return
+    case err == ErrProfileExists:
+        ctx.JSON(http.StatusConflict, gin.H{
+            "status":           http.StatusConflict,
+            "developerMessage": err.Error(),
+            "userMessage":      err.Error(),
+        })
+        ctx.Error(err)
+        return
+    case err != nil:
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to create profile",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    ctx.JSON(http.StatusCreated, gin.H{
+        "name": jsonRequest.Name,
+    })
+}
+
+// PUT /profiles/current
+//
+// Switch the profile in use by the server. Requests in progress are finished with
+// the previous profile.
+//
+// Input:
+// name: name of a profile within the data directory
+func ProfileCurrentPUT(profiles *Profiles, ctx *gin.Context) {
+
+    var jsonRequest ProfileRequest
+    err := ctx.BindJSON(&jsonRequest)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "bad JSON input",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    err = profiles.Switch(jsonRequest.Name)
+    switch {
+    case err == ErrProfileInvalidName:
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      err.Error(),
+        })
+        ctx.Error(err)
+        return
+    case err == ErrProfileNoSuchProfile:
+        ctx.JSON(http.StatusNotFound, gin.H{
+            "status":           http.StatusNotFound,
+            "developerMessage": err.Error(),
+            "userMessage":      "cannot find profile by name",
+        })
+        ctx.Error(err)
+        return
+    case err == ErrProfileSwitchPinned:
+        ctx.JSON(http.StatusConflict, gin.H{
+            "status":           http.StatusConflict,
+            "developerMessage": err.Error(),
+            "userMessage":      err.Error(),
+        })
+        ctx.Error(err)
+        return
+    case err != nil:
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to switch profile",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    ctx.JSON(http.StatusOK, gin.H{
+        "current": profiles.Current(),
+    })
+}
+
+/* helpers */
+
+// open the database of the profile (a name or a path) to be in use
+func NewProfiles(options *DatabaseOptions, profileName string) (*Profiles, error) {
+
+    var profiles *Profiles = &Profiles{options: *options}
+
+    db, err := openProfile(&profiles.options, profileName)
+    if err != nil {
+        return nil, err
+    }
+
+    profiles.current = db
+
+    return profiles, nil
+}
+
+// fetch the database; and bootstrap its data
+func openProfile(options *DatabaseOptions, profileName string) (*Database, error) {
+
+    db, err := FetchDatabase(profileName, options)
+    if err != nil {
+        return nil, err
+    }
+
+    _, err = GetRootDeck(db.instance)
+    if err != nil {
+        db.CleanUp()
+        return nil, err
+    }
+
+    return db, nil
+}
+
+// run f with the database of the profile in use; switching waits until f returns
+func (profiles *Profiles) Use(f func(db *Database)) {
+    profiles.lock.RLock()
+    defer profiles.lock.RUnlock()
+
+    f(profiles.current)
+}
+
+// run f with the database of the profile in use; other requests wait until f returns
+func (profiles *Profiles) UseExclusive(f func(db *Database)) {
+    profiles.lock.Lock()
+    defer profiles.lock.Unlock()
+
+    f(profiles.current)
+}
+
+// name of the profile in use
+func (profiles *Profiles) Current() string {
+    profiles.lock.RLock()
+    defer profiles.lock.RUnlock()
+
+    return profiles.current.name
+}
+
+// disallow switching the profile in use
+func (profiles *Profiles) Pin() {
+    profiles.lock.Lock()
+    defer profiles.lock.Unlock()
+
+    profiles.pinned = true
+}
+
+func (profiles *Profiles) Switch(profileName string) error {
+
+    var err error
+
+    err = ValidateProfileName(profileName)
+    if err != nil {
+        return err
+    }
+
+    profiles.lock.RLock()
+    var pinned bool = profiles.pinned
+    var current string = profiles.current.name
+    profiles.lock.RUnlock()
+
+    if pinned {
+        return ErrProfileSwitchPinned
+    }
+
+    if profileName == current {
+        return nil
+    }
+
+    if !ProfileExists(profiles.options.DataDir, profileName) {
+        return ErrProfileNoSuchProfile
+    }
+
+    // open (and migrate) the profile before waiting on requests in progress
+    var db *Database
+    db, err = openProfile(&profiles.options, profileName)
+    if err != nil {
+        return err
+    }
+
+    profiles.lock.Lock()
+    var previous *Database = profiles.current
+    profiles.current = db
+    profiles.lock.Unlock()
+
+    previous.CleanUp()
+
+    return nil
+}
+
+func (profiles *Profiles) CleanUp() {
+    profiles.lock.Lock()
+    defer profiles.lock.Unlock()
+
+    profiles.current.CleanUp()
+}
+
+func ValidateProfileName(profileName string) error {
+
+    if !profileNameRegexp.MatchString(profileName) {
+        return ErrProfileInvalidName
+    }
+
+    return nil
+}
+
+// a profile name is a path to the database file if it isn't a plain name;
+// e.g. ~/cards/spanish.db or ./spanish
+func IsProfilePath(profileName string) bool {
+    return strings.HasSuffix(profileName, DATABASE_FILE_EXT) || strings.ContainsRune(profileName, os.PathSeparator)
+}
+
+func ProfileFileName(dataDir string, profileName string) string {
+
+    if IsProfilePath(profileName) {
+        if strings.HasSuffix(profileName, DATABASE_FILE_EXT) {
+            return profileName
+        }
+        return profileName + DATABASE_FILE_EXT
+    }
+
+    return filepath.Join(dataDir, profileName+DATABASE_FILE_EXT)
+}
+
+func ProfileExists(dataDir string, profileName string) bool {
+    _, err := os.Stat(ProfileFileName(dataDir, profileName))
+    return err == nil
+}
+
+// names of profiles within the data directory; sorted
+func ListProfiles(dataDir string) ([]string, error) {
+
+    var dir string = dataDir
+    if len(dir) <= 0 {
+        dir = "."
+    }
+
+    files, err := ioutil.ReadDir(dir)
+    if err != nil {
+        return nil, err
+    }
+
+    var names []string = []string{}
+
+    for _, file := range files {
+
+        if file.IsDir() || !strings.HasSuffix(file.Name(), DATABASE_FILE_EXT) {
+            continue
+        }
+
+        var name string = strings.TrimSuffix(file.Name(), DATABASE_FILE_EXT)
+        if ValidateProfileName(name) != nil {
+            continue
+        }
+
+        names = append(names, name)
+    }
+
+    sort.Strings(names)
+
+    return names, nil
+}
+
+func CreateProfile(options *DatabaseOptions, profileName string) error {
+
+    var err error
+
+    err = ValidateProfileName(profileName)
+    if err != nil {
+        return err
+    }
+
+    if ProfileExists(options.DataDir, profileName) {
+        return ErrProfileExists
+    }
+
+    if len(options.DataDir) > 0 {
+        err = os.MkdirAll(options.DataDir, 0755)
+        if err != nil {
+            return err
+        }
+    }
+
+    db, err := openProfile(options, profileName)
+    if err != nil {
+        return err
+    }
+    db.CleanUp()
+
+    return nil
+}
+
+// rename the database file of the profile; along with any files sqlite keeps alongside it.
+// backups of the profile keep their name. refused while the database is in use.
+func RenameProfile(dataDir string, profileName string, newName string) error {
+
+    var err error
+
+    for _, name := range []string{profileName, newName} {
+        err = ValidateProfileName(name)
+        if err != nil {
+            return err
+        }
+    }
+
+    if !ProfileExists(dataDir, profileName) {
+        return ErrProfileNoSuchProfile
+    }
+
+    if ProfileExists(dataDir, newName) {
+        return ErrProfileExists
+    }
+
+    err = ensureProfileNotInUse(ProfileFileName(dataDir, profileName))
+    if err != nil {
+        return err
+    }
+
+    var (
+        filename    string = ProfileFileName(dataDir, profileName)
+        newFilename string = ProfileFileName(dataDir, newName)
+    )
+
+    err = os.Rename(filename, newFilename)
+    if err != nil {
+        return err
+    }
+
+    for _, suffix := range databaseSideFileSuffixes {
+        err = os.Rename(filename+suffix, newFilename+suffix)
+        if err != nil && !os.IsNotExist(err) {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// delete the database file of the profile; along with any files sqlite keeps alongside it.
+// backups of the profile are kept. refused while the database is in use.
+func DeleteProfile(dataDir string, profileName string) error {
+
+    var err error
+
+    err = ValidateProfileName(profileName)
+    if err != nil {
+        return err
+    }
+
+    if !ProfileExists(dataDir, profileName) {
+        return ErrProfileNoSuchProfile
+    }
+
+    var filename string = ProfileFileName(dataDir, profileName)
+
+    err = ensureProfileNotInUse(filename)
+    if err != nil {
+        return err
+    }
+
+    err = os.Remove(filename)
+    if err != nil {
+        return err
+    }
+
+    for _, suffix := range databaseSideFileSuffixes {
+        err = os.Remove(filename + suffix)
+        if err != nil && !os.IsNotExist(err) {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// ErrProfileInUse if another connection has the database file open; e.g. a running
+// grokdb. a connection in WAL mode holds a shared lock for as long as it's open, so an
+// exclusive lock can only be taken once no other connection is left.
+func ensureProfileNotInUse(filename string) error {
+
+    var params url.Values = url.Values{}
+    params.Set("mode", "rw")
+    params.Set("_busy_timeout", "0")
+    params.Set("_locking_mode", "EXCLUSIVE")
+    params.Set("_txlock", "exclusive")
+
+    var isLocked = func(err error) bool {
+        sqliteErr, ok := err.(sqlite.Error)
+        return ok && (sqliteErr.Code == sqlite.ErrBusy || sqliteErr.Code == sqlite.ErrLocked)
+    }
+
+    db, err := sqlx.Connect(SQLITE_DRIVER_NAME, "file:"+filename+"?"+params.Encode())
+    if isLocked(err) {
+        return ErrProfileInUse
+    }
+    if err != nil {
+        return err
+    }
+    defer db.Close()
+
+    tx, err := db.Beginx()
+    if isLocked(err) {
+        return ErrProfileInUse
+    }
+    if err != nil {
+        return err
+    }
+
+    return tx.Rollback()
+}
+
+// grokdb profiles list|create|rename|delete
+func profilesCommand(options *DatabaseOptions, action string, args []string) {
+
+    var err error
+
+    var expectedArgs map[string]int = map[string]int{
+        "list":   0,
+        "create": 1,
+        "rename": 2,
+        "delete": 1,
+    }
+
+    if len(args) < expectedArgs[action] {
+        exitIfErr(fmt.Errorf("Error: %s expects %d profile name(s)", action, expectedArgs[action]), 1)
+    }
+
+    switch action {
+    case "list":
+
+        var names []string
+        names, err = ListProfiles(options.DataDir)
+        exitIfErr(err, 1)
+
+        for _, name := range names {
+            fmt.Println(name)
+        }
+
+    case "create":
+        err = CreateProfile(options, args[0])
+        exitIfErr(err, 1)
+        fmt.Printf("created profile %s\n", args[0])
+
+    case "rename":
+        err = RenameProfile(options.DataDir, args[0], args[1])
+        exitIfErr(err, 1)
+        fmt.Printf("renamed profile %s to %s\n", args[0], args[1])
+
+    case "delete":
+        err = DeleteProfile(options.DataDir, args[0])
+        exitIfErr(err, 1)
+        fmt.Printf("deleted profile %s\n", args[0])
+    }
+}
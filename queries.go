@@ -19,6 +19,52 @@ const BOOTSTRAP_QUERY string = `
 PRAGMA foreign_keys=ON;
 `
 
+/* schema version; a row for each applied migration */
+const SCHEMA_VERSION_TABLE_QUERY string = `
+CREATE TABLE IF NOT EXISTS SchemaVersion (
+    version INTEGER PRIMARY KEY NOT NULL,
+    name TEXT NOT NULL,
+    applied_at INT NOT NULL DEFAULT (strftime('%s', 'now'))
+);
+`
+
+var FETCH_SCHEMA_VERSIONS_QUERY = (func() PipeInput {
+    const __FETCH_SCHEMA_VERSIONS_QUERY string = `
+    SELECT version, name, applied_at FROM SchemaVersion ORDER BY version ASC;
+    `
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_SCHEMA_VERSIONS_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+var INSERT_SCHEMA_VERSION_QUERY = (func() PipeInput {
+    const __INSERT_SCHEMA_VERSION_QUERY string = `
+    INSERT INTO SchemaVersion(version, name) VALUES (:version, :name);
+    `
+
+    var requiredInputCols []string = []string{"version", "name"}
+
+    return composePipes(
+        MakeCtxMaker(__INSERT_SCHEMA_VERSION_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// number of tables besides SchemaVersion; a new database has none
+var COUNT_SCHEMA_TABLES_QUERY = (func() PipeInput {
+    const __COUNT_SCHEMA_TABLES_QUERY string = `
+    SELECT COUNT(1) FROM sqlite_master WHERE type = 'table' AND name != 'SchemaVersion';
+    `
+
+    return composePipes(
+        MakeCtxMaker(__COUNT_SCHEMA_TABLES_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
 /* config table */
 const SETUP_CONFIG_TABLE_QUERY string = `
 CREATE TABLE IF NOT EXISTS Config (
@@ -326,6 +372,50 @@ var DECK_ANCESTORS_QUERY = (func() PipeInput {
     )
 }())
 
+// fetch direct child with the given name (if any)
+var DECK_CHILD_BY_NAME_QUERY = (func() PipeInput {
+    const __DECK_CHILD_BY_NAME_QUERY string = `
+    SELECT d.deck_id, d.name, d.description
+    FROM DecksClosure AS dc
+
+    INNER JOIN Decks AS d
+    ON d.deck_id = dc.descendent
+
+    WHERE
+    dc.ancestor = :parent
+    AND dc.depth = 1
+    AND d.name = :name
+    LIMIT 1;
+    `
+
+    var requiredInputCols []string = []string{"parent", "name"}
+
+    return composePipes(
+        MakeCtxMaker(__DECK_CHILD_BY_NAME_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// fetch all descendents (including itself) from nearest to farthest
+var DECK_DESCENDENTS_QUERY = (func() PipeInput {
+    const __DECK_DESCENDENTS_QUERY string = `
+    SELECT ancestor, descendent, depth
+    FROM DecksClosure
+    WHERE
+    ancestor = :parent
+    ORDER BY depth ASC;
+    `
+
+    var requiredInputCols []string = []string{"parent"}
+
+    return composePipes(
+        MakeCtxMaker(__DECK_DESCENDENTS_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
 /* cards table */
 const SETUP_CARDS_TABLE_QUERY string = `
 CREATE TABLE IF NOT EXISTS Cards (
@@ -441,6 +531,60 @@ CREATE TABLE IF NOT EXISTS ReviewCardCache (
     FOREIGN KEY (deck) REFERENCES Decks(deck_id) ON DELETE CASCADE,
     FOREIGN KEY (card) REFERENCES Cards(card_id) ON DELETE CASCADE
 );
+
+/* suspended cards are excluded from reviews */
+CREATE TABLE IF NOT EXISTS CardsSuspended (
+    card INTEGER NOT NULL,
+    suspended_at INT NOT NULL DEFAULT (strftime('%s', 'now')),
+
+    PRIMARY KEY(card),
+
+    FOREIGN KEY (card) REFERENCES Cards(card_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS CardsTags (
+    card INTEGER NOT NULL,
+    tag TEXT NOT NULL,
+
+    CHECK (tag <> ''), /* ensure not empty */
+    PRIMARY KEY(card, tag),
+
+    FOREIGN KEY (card) REFERENCES Cards(card_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS CardsTags_tag_Index ON CardsTags (tag);
+
+/* previous versions of the card's content */
+CREATE TABLE IF NOT EXISTS CardRevisions (
+    revision_id INTEGER PRIMARY KEY NOT NULL,
+
+    title TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    front TEXT NOT NULL DEFAULT '',
+    back TEXT NOT NULL DEFAULT '',
+
+    updated_at INT NOT NULL, /* note: time when this version was saved */
+    revised_at INT NOT NULL DEFAULT (strftime('%s', 'now')), /* note: time when this version was replaced */
+
+    card INTEGER NOT NULL,
+
+    FOREIGN KEY (card) REFERENCES Cards(card_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS CardRevisions_card_Index ON CardRevisions (card);
+
+CREATE TRIGGER IF NOT EXISTS record_card_revision AFTER UPDATE
+OF title, description, front, back
+ON Cards
+WHEN
+    OLD.title IS NOT NEW.title OR
+    OLD.description IS NOT NEW.description OR
+    OLD.front IS NOT NEW.front OR
+    OLD.back IS NOT NEW.back
+BEGIN
+    INSERT INTO CardRevisions(title, description, front, back, updated_at, card)
+    VALUES (OLD.title, OLD.description, OLD.front, OLD.back, OLD.updated_at, OLD.card_id);
+END;
 `
 
 var CREATE_NEW_CARD_QUERY = (func() PipeInput {
@@ -740,6 +884,171 @@ var FETCH_CARDS_BY_DECK_TIMES_REVIEWED_QUERY = func(sort string) PipeInput {
     )
 }
 
+// fetch every card within the deck subtree; used for exporting
+var FETCH_ALL_CARDS_BY_DECK_QUERY = (func() PipeInput {
+    const __FETCH_ALL_CARDS_BY_DECK_QUERY string = `
+        SELECT
+            c.card_id, c.title, c.description, c.front, c.back, c.deck, c.created_at, c.updated_at
+        FROM DecksClosure AS dc
+
+        INNER JOIN Cards AS c
+        ON c.deck = dc.descendent
+
+        WHERE dc.ancestor = :deck_id
+        ORDER BY dc.depth ASC, c.deck ASC, c.created_at ASC;
+    `
+
+    var requiredInputCols []string = []string{"deck_id"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_ALL_CARDS_BY_DECK_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// fetch ids of cards matching every given criteria; a criteria is ignored if it's zero
+// or an empty string. search is a full-text search query over the cards' content.
+var FETCH_CARD_IDS_BY_FILTER_QUERY = (func() PipeInput {
+    const __FETCH_CARD_IDS_BY_FILTER_QUERY string = `
+        SELECT c.card_id
+        FROM Cards AS c
+        WHERE
+            (:deck_id = 0 OR c.deck IN (SELECT descendent FROM DecksClosure WHERE ancestor = :deck_id))
+        AND
+            (:stash_id = 0 OR c.card_id IN (SELECT card FROM StashTreeMembers WHERE stash = :stash_id))
+        AND
+            (:tag = '' OR c.card_id IN (SELECT card FROM CardsTags WHERE tag = :tag))
+        AND
+            (:search = '' OR c.card_id IN (SELECT docid FROM CardsFTS WHERE CardsFTS MATCH :search))
+        ORDER BY c.card_id ASC;
+    `
+
+    var requiredInputCols []string = []string{"deck_id", "stash_id", "tag", "search"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_CARD_IDS_BY_FILTER_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var CARD_IS_SUSPENDED_QUERY = (func() PipeInput {
+    const __CARD_IS_SUSPENDED_QUERY string = `
+    SELECT COUNT(1) FROM CardsSuspended WHERE card = :card_id LIMIT 1;
+    `
+
+    var requiredInputCols []string = []string{"card_id"}
+
+    return composePipes(
+        MakeCtxMaker(__CARD_IS_SUSPENDED_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var SUSPEND_CARD_QUERY = (func() PipeInput {
+    const __SUSPEND_CARD_QUERY string = `
+    INSERT OR IGNORE INTO CardsSuspended(card) VALUES (:card_id);
+    `
+
+    var requiredInputCols []string = []string{"card_id"}
+
+    return composePipes(
+        MakeCtxMaker(__SUSPEND_CARD_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var UNSUSPEND_CARD_QUERY = (func() PipeInput {
+    const __UNSUSPEND_CARD_QUERY string = `
+    DELETE FROM CardsSuspended WHERE card = :card_id;
+    `
+
+    var requiredInputCols []string = []string{"card_id"}
+
+    return composePipes(
+        MakeCtxMaker(__UNSUSPEND_CARD_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var FETCH_CARD_TAGS_QUERY = (func() PipeInput {
+    const __FETCH_CARD_TAGS_QUERY string = `
+    SELECT tag FROM CardsTags WHERE card = :card_id ORDER BY tag ASC;
+    `
+
+    var requiredInputCols []string = []string{"card_id"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_CARD_TAGS_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var ADD_CARD_TAG_QUERY = (func() PipeInput {
+    const __ADD_CARD_TAG_QUERY string = `
+    INSERT OR IGNORE INTO CardsTags(card, tag) VALUES (:card_id, :tag);
+    `
+
+    var requiredInputCols []string = []string{"card_id", "tag"}
+
+    return composePipes(
+        MakeCtxMaker(__ADD_CARD_TAG_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var REMOVE_CARD_TAG_QUERY = (func() PipeInput {
+    const __REMOVE_CARD_TAG_QUERY string = `
+    DELETE FROM CardsTags WHERE card = :card_id AND tag = :tag;
+    `
+
+    var requiredInputCols []string = []string{"card_id", "tag"}
+
+    return composePipes(
+        MakeCtxMaker(__REMOVE_CARD_TAG_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// fetch revisions of the card; newest first
+var FETCH_CARD_REVISIONS_QUERY = (func() PipeInput {
+    const __FETCH_CARD_REVISIONS_QUERY string = `
+    SELECT revision_id, title, description, front, back, updated_at, revised_at, card FROM CardRevisions
+    WHERE card = :card_id
+    ORDER BY revision_id DESC;
+    `
+
+    var requiredInputCols []string = []string{"card_id"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_CARD_REVISIONS_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var FETCH_CARD_REVISION_QUERY = (func() PipeInput {
+    const __FETCH_CARD_REVISION_QUERY string = `
+    SELECT revision_id, title, description, front, back, updated_at, revised_at, card FROM CardRevisions
+    WHERE card = :card_id AND revision_id = :revision_id;
+    `
+
+    var requiredInputCols []string = []string{"card_id", "revision_id"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_CARD_REVISION_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
 var FETCH_CARD_SCORE = (func() PipeInput {
     const __FETCH_CARD_SCORE string = `
     SELECT success, fail, score, times_reviewed, updated_at, card FROM CardsScore
@@ -765,7 +1074,8 @@ var COUNT_REVIEW_CARDS_BY_DECK = (func() PipeInput {
         INNER JOIN Cards AS c
         ON c.deck = dc.descendent
 
-        WHERE dc.ancestor = :deck_id;
+        WHERE dc.ancestor = :deck_id
+        AND c.card_id NOT IN (SELECT card FROM CardsSuspended);
     `
 
     var requiredInputCols []string = []string{"deck_id"}
@@ -791,6 +1101,8 @@ var DECK_HAS_NEW_CARDS_QUERY = (func() PipeInput {
 
         WHERE
             dc.ancestor = :deck_id
+        AND
+            c.card_id NOT IN (SELECT card FROM CardsSuspended)
         AND
             (c.created_at - cs.updated_at) = 0
         LIMIT 1;
@@ -819,6 +1131,8 @@ var DECK_COUNT_NEW_CARDS_QUERY = (func() PipeInput {
 
         WHERE
             dc.ancestor = :deck_id
+        AND
+            c.card_id NOT IN (SELECT card FROM CardsSuspended)
         AND
             (c.created_at - cs.updated_at) = 0;
     `
@@ -846,6 +1160,8 @@ var DECK_SELECT_NEWEST_CARD_FOR_REVIEW_QUERY = (func() PipeInput {
 
         WHERE
             dc.ancestor = :deck_id
+        AND
+            c.card_id NOT IN (SELECT card FROM CardsSuspended)
         AND
             (c.created_at - cs.updated_at) = 0
         LIMIT :purgatory_size
@@ -875,6 +1191,8 @@ var DECK_HAS_CARD_OLD_ENOUGH_FOR_REVIEW_QUERY = (func() PipeInput {
 
         WHERE
             dc.ancestor = :deck_id
+        AND
+            c.card_id NOT IN (SELECT card FROM CardsSuspended)
         AND
             (strftime('%s','now') - cs.updated_at) >= :age_of_consent
         LIMIT 1;
@@ -905,6 +1223,8 @@ var DECK_COUNT_CARD_OLD_ENOUGH_FOR_REVIEW_QUERY = (func() PipeInput {
 
         WHERE
             dc.ancestor = :deck_id
+        AND
+            c.card_id NOT IN (SELECT card FROM CardsSuspended)
         AND
             (strftime('%s','now') - cs.updated_at) >= :age_of_consent;
     `
@@ -936,6 +1256,8 @@ var FETCH_NEXT_OLD_ENOUGH_REVIEW_CARD_BY_DECK_ORDER_BY_NORM_SCORE = (func() Pipe
 
         WHERE
             dc.ancestor = :deck_id
+        AND
+            c.card_id NOT IN (SELECT card FROM CardsSuspended)
         AND
             (strftime('%s','now') - cs.updated_at) >= :age_of_consent
         ORDER BY
@@ -971,6 +1293,8 @@ var FETCH_NEXT_OLD_ENOUGH_REVIEW_CARD_BY_DECK_ORDER_BY_NOTHING = (func() PipeInp
 
         WHERE
             dc.ancestor = :deck_id
+        AND
+            c.card_id NOT IN (SELECT card FROM CardsSuspended)
         AND
             (strftime('%s','now') - cs.updated_at) >= :age_of_consent
         LIMIT :purgatory_size
@@ -1002,6 +1326,8 @@ var FETCH_NEXT_REVIEW_CARD_BY_DECK_ORDER_BY_AGE = (func() PipeInput {
 
         WHERE
             dc.ancestor = :deck_id
+        AND
+            c.card_id NOT IN (SELECT card FROM CardsSuspended)
         ORDER BY
             (strftime('%s','now') - cs.updated_at) DESC
         LIMIT :purgatory_size
@@ -1040,6 +1366,8 @@ var FETCH_NEXT_REVIEW_CARD_BY_DECK_ORDER_BY_NORM_SCORE = (func() PipeInput {
 
             WHERE
                 dc.ancestor = :deck_id
+            AND
+                c.card_id NOT IN (SELECT card FROM CardsSuspended)
             ORDER BY
                 (strftime('%s','now') - cs.updated_at) DESC
             LIMIT :purgatory_size
@@ -1083,6 +1411,29 @@ var UPDATE_CARD_SCORE_QUERY = (func() PipeInput {
     )
 }())
 
+// reset card score such that the card is treated as a new card.
+// note: updated_at is restored in a separate statement; since cardsscore_updated_score
+// trigger sets it whenever success, fail or score are updated.
+var RESET_CARD_SCORE_QUERY = (func() PipeInput {
+    const __RESET_CARD_SCORE_QUERY string = `
+    UPDATE CardsScore
+    SET success = 0, fail = 0, score = 0.5, times_reviewed = 0, changelog = 'reset review'
+    WHERE card = :card_id;
+
+    UPDATE CardsScore
+    SET updated_at = (SELECT created_at FROM Cards WHERE card_id = :card_id)
+    WHERE card = :card_id;
+    `
+
+    var requiredInputCols []string = []string{"card_id"}
+
+    return composePipes(
+        MakeCtxMaker(__RESET_CARD_SCORE_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
 var GET_CACHED_REVIEWCARD_BY_DECK_QUERY = (func() PipeInput {
     const __GET_CACHED_REVIEWCARD_BY_DECK_QUERY string = `
         SELECT deck, card, created_at FROM ReviewCardCache
@@ -1262,7 +1613,7 @@ var COUNT_CARDS_BY_STASH_QUERY = (func() PipeInput {
     const __COUNT_CARDS_BY_STASH_QUERY string = `
         SELECT
             COUNT(1)
-        FROM StashCards AS sc
+        FROM StashTreeMembers AS sc
 
         WHERE sc.stash = :stash_id;
     `
@@ -1307,7 +1658,7 @@ var FETCH_CARDS_BY_STASH_SORT_CREATED_QUERY = func(sort string) PipeInput {
     const __FETCH_CARDS_BY_STASH_SORT_CREATED_QUERY_RAW string = `
         SELECT
             c.card_id, c.title, c.description, c.front, c.back, c.deck, c.created_at, c.updated_at
-        FROM StashCards AS sc
+        FROM StashTreeMembers AS sc
 
         INNER JOIN Cards AS c
         ON c.card_id = sc.card
@@ -1316,7 +1667,7 @@ var FETCH_CARDS_BY_STASH_SORT_CREATED_QUERY = func(sort string) PipeInput {
         c.oid NOT IN (
             SELECT
                 sc.card
-            FROM StashCards AS sc
+            FROM StashTreeMembers AS sc
 
             INNER JOIN Cards AS c
             ON c.card_id = sc.card
@@ -1345,7 +1696,7 @@ var FETCH_CARDS_BY_STASH_SORT_UPDATED_QUERY = func(sort string) PipeInput {
     const __FETCH_CARDS_BY_STASH_SORT_UPDATED_QUERY_RAW string = `
         SELECT
             c.card_id, c.title, c.description, c.front, c.back, c.deck, c.created_at, c.updated_at
-        FROM StashCards AS sc
+        FROM StashTreeMembers AS sc
 
         INNER JOIN Cards AS c
         ON c.card_id = sc.card
@@ -1354,7 +1705,7 @@ var FETCH_CARDS_BY_STASH_SORT_UPDATED_QUERY = func(sort string) PipeInput {
         c.oid NOT IN (
             SELECT
                 sc.card
-            FROM StashCards AS sc
+            FROM StashTreeMembers AS sc
 
             INNER JOIN Cards AS c
             ON c.card_id = sc.card
@@ -1383,7 +1734,7 @@ var FETCH_CARDS_BY_STASH_SORT_TITLE_QUERY = func(sort string) PipeInput {
     const __FETCH_CARDS_BY_STASH_SORT_TITLE_QUERY_RAW string = `
         SELECT
             c.card_id, c.title, c.description, c.front, c.back, c.deck, c.created_at, c.updated_at
-        FROM StashCards AS sc
+        FROM StashTreeMembers AS sc
 
         INNER JOIN Cards AS c
         ON c.card_id = sc.card
@@ -1392,7 +1743,7 @@ var FETCH_CARDS_BY_STASH_SORT_TITLE_QUERY = func(sort string) PipeInput {
         c.oid NOT IN (
             SELECT
                 sc.card
-            FROM StashCards AS sc
+            FROM StashTreeMembers AS sc
 
             INNER JOIN Cards AS c
             ON c.card_id = sc.card
@@ -1421,7 +1772,7 @@ var FETCH_CARDS_BY_STASH_REVIEWED_DATE_QUERY = func(sort string) PipeInput {
     const __FETCH_CARDS_BY_STASH_REVIEWED_DATE_QUERY_RAW string = `
         SELECT
             c.card_id, c.title, c.description, c.front, c.back, c.deck, c.created_at, c.updated_at
-        FROM StashCards AS sc
+        FROM StashTreeMembers AS sc
 
         INNER JOIN Cards AS c
         ON c.card_id = sc.card
@@ -1433,7 +1784,7 @@ var FETCH_CARDS_BY_STASH_REVIEWED_DATE_QUERY = func(sort string) PipeInput {
         c.oid NOT IN (
             SELECT
                 sc.card
-            FROM StashCards AS sc
+            FROM StashTreeMembers AS sc
 
             INNER JOIN Cards AS c
             ON c.card_id = sc.card
@@ -1465,7 +1816,7 @@ var FETCH_CARDS_BY_STASH_TIMES_REVIEWED_QUERY = func(sort string) PipeInput {
     const __FETCH_CARDS_BY_STASH_TIMES_REVIEWED_QUERY_RAW string = `
         SELECT
             c.card_id, c.title, c.description, c.front, c.back, c.deck, c.created_at, c.updated_at
-        FROM StashCards AS sc
+        FROM StashTreeMembers AS sc
 
         INNER JOIN Cards AS c
         ON c.card_id = sc.card
@@ -1477,7 +1828,7 @@ var FETCH_CARDS_BY_STASH_TIMES_REVIEWED_QUERY = func(sort string) PipeInput {
         c.oid NOT IN (
             SELECT
                 sc.card
-            FROM StashCards AS sc
+            FROM StashTreeMembers AS sc
 
             INNER JOIN Cards AS c
             ON c.card_id = sc.card
@@ -1504,13 +1855,36 @@ var FETCH_CARDS_BY_STASH_TIMES_REVIEWED_QUERY = func(sort string) PipeInput {
     )
 }
 
+// fetch every card within the stash; used for exporting
+var FETCH_ALL_CARDS_BY_STASH_QUERY = (func() PipeInput {
+    const __FETCH_ALL_CARDS_BY_STASH_QUERY string = `
+        SELECT
+            c.card_id, c.title, c.description, c.front, c.back, c.deck, c.created_at, c.updated_at
+        FROM StashTreeMembers AS sc
+
+        INNER JOIN Cards AS c
+        ON c.card_id = sc.card
+
+        WHERE sc.stash = :stash_id
+        ORDER BY sc.added_at ASC;
+    `
+
+    var requiredInputCols []string = []string{"stash_id"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_ALL_CARDS_BY_STASH_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
 var FETCH_NEXT_REVIEW_CARD_BY_STASH_ORDER_BY_AGE = (func() PipeInput {
     const __FETCH_NEXT_REVIEW_CARD_BY_STASH_ORDER_BY_AGE string = `
         SELECT
 
         c.card_id, c.title, c.description, c.front, c.back, c.deck, c.created_at, c.updated_at
 
-        FROM StashCards AS sc
+        FROM StashTreeMembers AS sc
 
         INNER JOIN Cards AS c
         ON c.card_id = sc.card
@@ -1520,6 +1894,8 @@ var FETCH_NEXT_REVIEW_CARD_BY_STASH_ORDER_BY_AGE = (func() PipeInput {
 
         WHERE
             sc.stash = :stash_id
+        AND
+            c.card_id NOT IN (SELECT card FROM CardsSuspended)
         ORDER BY
             (strftime('%s','now') - cs.updated_at) DESC
         LIMIT :purgatory_size
@@ -1548,7 +1924,7 @@ var FETCH_NEXT_REVIEW_CARD_BY_STASH_ORDER_BY_NORM_SCORE = (func() PipeInput {
             c.card_id, c.title, c.description, c.front, c.back, c.deck, c.created_at, c.updated_at,
             cs.times_reviewed, cs.success, cs.fail, cs.updated_at AS cs_updated_at
 
-            FROM StashCards AS sc
+            FROM StashTreeMembers AS sc
 
             INNER JOIN Cards AS c
             ON c.card_id = sc.card
@@ -1558,6 +1934,8 @@ var FETCH_NEXT_REVIEW_CARD_BY_STASH_ORDER_BY_NORM_SCORE = (func() PipeInput {
 
             WHERE
                 sc.stash = :stash_id
+            AND
+                c.card_id NOT IN (SELECT card FROM CardsSuspended)
             ORDER BY
                 (strftime('%s','now') - cs.updated_at) DESC
             LIMIT :purgatory_size
@@ -1582,7 +1960,7 @@ var FETCH_NEXT_REVIEW_CARD_BY_STASH_ORDER_BY_NORM_SCORE = (func() PipeInput {
 var STASH_HAS_CARD_QUERY = (func() PipeInput {
     const __STASH_HAS_CARD_QUERY string = `
     SELECT COUNT(1)
-    FROM StashCards
+    FROM StashMembers
     WHERE
     stash = :stash_id
     AND
@@ -1629,11 +2007,11 @@ var DISCONNECT_STASH_FROM_CARD_QUERY = (func() PipeInput {
 
 var GET_CACHED_REVIEWCARD_BY_STASH_QUERY = (func() PipeInput {
     const __GET_CACHED_REVIEWCARD_BY_STASH_QUERY string = `
-        SELECT stash, card, created_at FROM ReviewCardStashCache
-        WHERE stash = :stash_id;
+        SELECT stash, card, mode, created_at FROM ReviewCardStashCache
+        WHERE stash = :stash_id AND mode = :mode;
     `
 
-    var requiredInputCols []string = []string{"stash_id"}
+    var requiredInputCols []string = []string{"stash_id", "mode"}
 
     return composePipes(
         MakeCtxMaker(__GET_CACHED_REVIEWCARD_BY_STASH_QUERY),
@@ -1656,6 +2034,20 @@ var DELETE_CACHED_REVIEWCARD_BY_STASH_QUERY = (func() PipeInput {
     )
 }())
 
+var DELETE_CACHED_REVIEWCARD_BY_STASH_CARD_QUERY = (func() PipeInput {
+    const __DELETE_CACHED_REVIEWCARD_BY_STASH_CARD_QUERY string = `
+    DELETE FROM ReviewCardStashCache WHERE stash = :stash_id AND card = :card_id;
+    `
+
+    var requiredInputCols []string = []string{"stash_id", "card_id"}
+
+    return composePipes(
+        MakeCtxMaker(__DELETE_CACHED_REVIEWCARD_BY_STASH_CARD_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
 var DELETE_CACHED_STASH_REVIEWCARD_BY_CARD_QUERY = (func() PipeInput {
     const __DELETE_CACHED_STASH_REVIEWCARD_BY_CARD_QUERY string = `
     DELETE FROM ReviewCardStashCache WHERE card = :card_id;
@@ -1672,9 +2064,9 @@ var DELETE_CACHED_STASH_REVIEWCARD_BY_CARD_QUERY = (func() PipeInput {
 
 var INSERT_CACHED_REVIEWCARD_BY_STASH_QUERY = (func() PipeInput {
     const __INSERT_CACHED_REVIEWCARD_BY_STASH_QUERY string = `
-    INSERT INTO ReviewCardStashCache(stash, card) VALUES (:stash_id, :card_id);
+    INSERT OR REPLACE INTO ReviewCardStashCache(stash, card, mode) VALUES (:stash_id, :card_id, :mode);
     `
-    var requiredInputCols []string = []string{"stash_id", "card_id"}
+    var requiredInputCols []string = []string{"stash_id", "card_id", "mode"}
 
     return composePipes(
         MakeCtxMaker(__INSERT_CACHED_REVIEWCARD_BY_STASH_QUERY),
@@ -1686,7 +2078,7 @@ var INSERT_CACHED_REVIEWCARD_BY_STASH_QUERY = (func() PipeInput {
 var GET_STASHES_BY_CARD_QUERY = (func() PipeInput {
     const __GET_STASHES_BY_CARD_QUERY string = `
         SELECT stash
-        FROM StashCards
+        FROM StashMembers
         WHERE card = :card_id;
     `
 
@@ -1699,6 +2091,2786 @@ var GET_STASHES_BY_CARD_QUERY = (func() PipeInput {
     )
 }())
 
+/* markdown sync table */
+
+// records the state of each card file as of the last markdown directory sync.
+// note: rows are intentionally not cascade deleted with their card; they're used to
+// tell apart cards deleted within the app from card files edited on disk.
+const MARKDOWN_SYNC_TABLE_QUERY string = `
+CREATE TABLE IF NOT EXISTS MarkdownSync (
+    card INTEGER PRIMARY KEY NOT NULL,
+    path TEXT NOT NULL, /* relative to the sync directory */
+    card_updated_at INT NOT NULL, /* updated_at of the card when it was synced */
+    card_hash TEXT NOT NULL, /* hash of the rendered card when it was synced */
+    file_hash TEXT NOT NULL, /* hash of the card file when it was synced */
+    synced_at INT NOT NULL DEFAULT (strftime('%s', 'now'))
+);
+`
+
+var FETCH_MARKDOWN_SYNC_STATES_QUERY = (func() PipeInput {
+    const __FETCH_MARKDOWN_SYNC_STATES_QUERY string = `
+    SELECT card, path, card_updated_at, card_hash, file_hash, synced_at FROM MarkdownSync;
+    `
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_MARKDOWN_SYNC_STATES_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+var SET_MARKDOWN_SYNC_STATE_QUERY = (func() PipeInput {
+    const __SET_MARKDOWN_SYNC_STATE_QUERY string = `
+    INSERT OR REPLACE INTO MarkdownSync(card, path, card_updated_at, card_hash, file_hash)
+    VALUES (:card_id, :path, :card_updated_at, :card_hash, :file_hash);
+    `
+
+    var requiredInputCols []string = []string{"card_id", "path", "card_updated_at", "card_hash", "file_hash"}
+
+    return composePipes(
+        MakeCtxMaker(__SET_MARKDOWN_SYNC_STATE_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var DELETE_MARKDOWN_SYNC_STATE_QUERY = (func() PipeInput {
+    const __DELETE_MARKDOWN_SYNC_STATE_QUERY string = `
+    DELETE FROM MarkdownSync WHERE card = :card_id;
+    `
+
+    var requiredInputCols []string = []string{"card_id"}
+
+    return composePipes(
+        MakeCtxMaker(__DELETE_MARKDOWN_SYNC_STATE_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+/* markdown sync decks table */
+
+// records the directory of each deck as of the last markdown directory sync.
+// note: rows are intentionally not cascade deleted with their deck; they're used to
+// tell apart directories of decks deleted within the app from new directories on disk.
+const MARKDOWN_SYNC_DECKS_TABLE_QUERY string = `
+CREATE TABLE IF NOT EXISTS MarkdownSyncDecks (
+    deck INTEGER PRIMARY KEY NOT NULL,
+    path TEXT NOT NULL, /* relative to the sync directory */
+    synced_at INT NOT NULL DEFAULT (strftime('%s', 'now'))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS MARKDOWN_SYNC_DECKS_PATH_INDEX ON MarkdownSyncDecks (path);
+`
+
+var FETCH_MARKDOWN_SYNC_DECKS_QUERY = (func() PipeInput {
+    const __FETCH_MARKDOWN_SYNC_DECKS_QUERY string = `
+    SELECT deck, path FROM MarkdownSyncDecks;
+    `
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_MARKDOWN_SYNC_DECKS_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+var SET_MARKDOWN_SYNC_DECK_QUERY = (func() PipeInput {
+    const __SET_MARKDOWN_SYNC_DECK_QUERY string = `
+    INSERT OR REPLACE INTO MarkdownSyncDecks(deck, path) VALUES (:deck_id, :path);
+    `
+
+    var requiredInputCols []string = []string{"deck_id", "path"}
+
+    return composePipes(
+        MakeCtxMaker(__SET_MARKDOWN_SYNC_DECK_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var DELETE_MARKDOWN_SYNC_DECK_QUERY = (func() PipeInput {
+    const __DELETE_MARKDOWN_SYNC_DECK_QUERY string = `
+    DELETE FROM MarkdownSyncDecks WHERE deck = :deck_id;
+    `
+
+    var requiredInputCols []string = []string{"deck_id"}
+
+    return composePipes(
+        MakeCtxMaker(__DELETE_MARKDOWN_SYNC_DECK_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// move recorded paths within directory old_path into directory new_path
+var RENAME_MARKDOWN_SYNC_CARD_PATHS_QUERY = (func() PipeInput {
+    const __RENAME_MARKDOWN_SYNC_CARD_PATHS_QUERY string = `
+    UPDATE MarkdownSync
+    SET path = :new_path || substr(path, length(:old_path) + 1)
+    WHERE substr(path, 1, length(:old_path) + 1) = :old_path || '/';
+    `
+
+    var requiredInputCols []string = []string{"old_path", "new_path"}
+
+    return composePipes(
+        MakeCtxMaker(__RENAME_MARKDOWN_SYNC_CARD_PATHS_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var RENAME_MARKDOWN_SYNC_DECK_PATHS_QUERY = (func() PipeInput {
+    const __RENAME_MARKDOWN_SYNC_DECK_PATHS_QUERY string = `
+    UPDATE MarkdownSyncDecks
+    SET path = :new_path || substr(path, length(:old_path) + 1)
+    WHERE path = :old_path OR substr(path, 1, length(:old_path) + 1) = :old_path || '/';
+    `
+
+    var requiredInputCols []string = []string{"old_path", "new_path"}
+
+    return composePipes(
+        MakeCtxMaker(__RENAME_MARKDOWN_SYNC_DECK_PATHS_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+/* trash table */
+
+// trashed cards and deck subtrees; each is stored as a JSON snapshot of its rows such
+// that it may be restored with its review history.
+const TRASH_TABLE_QUERY string = `
+CREATE TABLE IF NOT EXISTS Trash (
+    trash_id INTEGER PRIMARY KEY NOT NULL,
+
+    kind TEXT NOT NULL, /* card or deck */
+    item INTEGER NOT NULL, /* id of the trashed card or deck */
+    title TEXT NOT NULL, /* title of the card or name of the deck */
+    parent INTEGER NOT NULL, /* deck of the card, or parent of the deck */
+    snapshot TEXT NOT NULL,
+
+    deleted_at INT NOT NULL DEFAULT (strftime('%s', 'now')),
+
+    CHECK (kind IN ('card', 'deck'))
+);
+
+CREATE INDEX IF NOT EXISTS Trash_deleted_at_Index ON Trash (deleted_at DESC);
+`
+
+var CREATE_TRASH_QUERY = (func() PipeInput {
+    const __CREATE_TRASH_QUERY string = `
+    INSERT INTO Trash(kind, item, title, parent, snapshot)
+    VALUES (:kind, :item, :title, :parent, :snapshot);
+    `
+
+    var requiredInputCols []string = []string{"kind", "item", "title", "parent", "snapshot"}
+
+    return composePipes(
+        MakeCtxMaker(__CREATE_TRASH_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var FETCH_TRASH_QUERY = (func() PipeInput {
+    const __FETCH_TRASH_QUERY string = `
+    SELECT trash_id, kind, item, title, parent, snapshot, deleted_at FROM Trash
+    WHERE trash_id = :trash_id;
+    `
+
+    var requiredInputCols []string = []string{"trash_id"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_TRASH_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var FETCH_TRASH_LIST_QUERY = (func() PipeInput {
+    const __FETCH_TRASH_LIST_QUERY string = `
+    SELECT trash_id, kind, item, title, parent, '' AS snapshot, deleted_at FROM Trash
+    ORDER BY deleted_at DESC, trash_id DESC;
+    `
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_TRASH_LIST_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+var DELETE_TRASH_QUERY = (func() PipeInput {
+    const __DELETE_TRASH_QUERY string = `
+    DELETE FROM Trash WHERE trash_id = :trash_id;
+    `
+
+    var requiredInputCols []string = []string{"trash_id"}
+
+    return composePipes(
+        MakeCtxMaker(__DELETE_TRASH_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var DELETE_ALL_TRASH_QUERY = (func() PipeInput {
+    const __DELETE_ALL_TRASH_QUERY string = `
+    DELETE FROM Trash;
+    `
+
+    return composePipes(
+        MakeCtxMaker(__DELETE_ALL_TRASH_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+// retention shall be integer in seconds
+var DELETE_EXPIRED_TRASH_QUERY = (func() PipeInput {
+    const __DELETE_EXPIRED_TRASH_QUERY string = `
+    DELETE FROM Trash WHERE (strftime('%s','now') - deleted_at) >= :retention;
+    `
+
+    var requiredInputCols []string = []string{"retention"}
+
+    return composePipes(
+        MakeCtxMaker(__DELETE_EXPIRED_TRASH_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// snapshot queries; used to trash cards and decks
+
+// fetch every deck within the deck subtree, along with its parent; parents are
+// fetched before their children
+var FETCH_DECK_SUBTREE_QUERY = (func() PipeInput {
+    const __FETCH_DECK_SUBTREE_QUERY string = `
+        SELECT
+            d.deck_id, d.name, d.description, IFNULL(p.ancestor, 0) AS parent
+        FROM DecksClosure AS dc
+
+        INNER JOIN Decks AS d
+        ON d.deck_id = dc.descendent
+
+        LEFT JOIN DecksClosure AS p
+        ON p.descendent = d.deck_id AND p.depth = 1
+
+        WHERE dc.ancestor = :deck_id
+        ORDER BY dc.depth ASC, d.deck_id ASC;
+    `
+
+    var requiredInputCols []string = []string{"deck_id"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_DECK_SUBTREE_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var FETCH_FULL_CARD_SCORE_QUERY = (func() PipeInput {
+    const __FETCH_FULL_CARD_SCORE_QUERY string = `
+    SELECT success, fail, score, times_reviewed, updated_at, changelog FROM CardsScore
+    WHERE card = :card_id;
+    `
+
+    var requiredInputCols []string = []string{"card_id"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_FULL_CARD_SCORE_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var FETCH_CARD_SCORE_HISTORY_QUERY = (func() PipeInput {
+    const __FETCH_CARD_SCORE_HISTORY_QUERY string = `
+    SELECT occured_at, success, fail, score, changelog FROM CardsScoreHistory
+    WHERE card = :card_id
+    ORDER BY rowid ASC;
+    `
+
+    var requiredInputCols []string = []string{"card_id"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_CARD_SCORE_HISTORY_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var FETCH_STASH_LINKS_BY_CARD_QUERY = (func() PipeInput {
+    const __FETCH_STASH_LINKS_BY_CARD_QUERY string = `
+    SELECT
+        sc.stash,
+        sc.added_at,
+        (
+            SELECT COUNT(1) FROM StashCardPositions AS o
+            WHERE
+                o.stash = sp.stash
+            AND
+                (o.position < sp.position OR (o.position = sp.position AND o.card <= sp.card))
+        ) AS position
+    FROM StashCards AS sc
+    LEFT JOIN StashCardPositions AS sp
+    ON sp.stash = sc.stash AND sp.card = sc.card
+    WHERE sc.card = :card_id;
+    `
+
+    var requiredInputCols []string = []string{"card_id"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_STASH_LINKS_BY_CARD_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var FETCH_CARD_SUSPENDED_AT_QUERY = (func() PipeInput {
+    const __FETCH_CARD_SUSPENDED_AT_QUERY string = `
+    SELECT suspended_at FROM CardsSuspended
+    WHERE card = :card_id;
+    `
+
+    var requiredInputCols []string = []string{"card_id"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_CARD_SUSPENDED_AT_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// restore queries; used to restore cards and decks from the trash.
+// ids may be given as NULL to assign new ids.
+
+var RESTORE_DECK_QUERY = (func() PipeInput {
+    const __RESTORE_DECK_QUERY string = `
+    INSERT INTO Decks(deck_id, name, description) VALUES (:deck_id, :name, :description);
+    `
+
+    var requiredInputCols []string = []string{"deck_id", "name", "description"}
+
+    return composePipes(
+        MakeCtxMaker(__RESTORE_DECK_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var RESTORE_CARD_QUERY = (func() PipeInput {
+    const __RESTORE_CARD_QUERY string = `
+    INSERT INTO Cards(card_id, title, description, front, back, deck, created_at, updated_at)
+    VALUES (:card_id, :title, :description, :front, :back, :deck, :created_at, :updated_at);
+    `
+
+    var requiredInputCols []string = []string{
+        "card_id",
+        "title",
+        "description",
+        "front",
+        "back",
+        "deck",
+        "created_at",
+        "updated_at",
+    }
+
+    return composePipes(
+        MakeCtxMaker(__RESTORE_CARD_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// note: replaces the score record created by cardsscore_new_score trigger; inserting
+// (rather than updating) the record skips the triggers on CardsScore updates
+var RESTORE_CARD_SCORE_QUERY = (func() PipeInput {
+    const __RESTORE_CARD_SCORE_QUERY string = `
+    INSERT OR REPLACE INTO CardsScore(card, success, fail, score, times_reviewed, updated_at, changelog)
+    VALUES (:card_id, :success, :fail, :score, :times_reviewed, :updated_at, :changelog);
+    `
+
+    var requiredInputCols []string = []string{
+        "card_id",
+        "success",
+        "fail",
+        "score",
+        "times_reviewed",
+        "updated_at",
+        "changelog",
+    }
+
+    return composePipes(
+        MakeCtxMaker(__RESTORE_CARD_SCORE_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var RESTORE_CARD_SCORE_HISTORY_QUERY = (func() PipeInput {
+    const __RESTORE_CARD_SCORE_HISTORY_QUERY string = `
+    INSERT INTO CardsScoreHistory(occured_at, success, fail, score, changelog, card)
+    VALUES (:occured_at, :success, :fail, :score, :changelog, :card_id);
+    `
+
+    var requiredInputCols []string = []string{"occured_at", "success", "fail", "score", "changelog", "card_id"}
+
+    return composePipes(
+        MakeCtxMaker(__RESTORE_CARD_SCORE_HISTORY_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var RESTORE_CARD_REVISION_QUERY = (func() PipeInput {
+    const __RESTORE_CARD_REVISION_QUERY string = `
+    INSERT INTO CardRevisions(title, description, front, back, updated_at, revised_at, card)
+    VALUES (:title, :description, :front, :back, :updated_at, :revised_at, :card_id);
+    `
+
+    var requiredInputCols []string = []string{"title", "description", "front", "back", "updated_at", "revised_at", "card_id"}
+
+    return composePipes(
+        MakeCtxMaker(__RESTORE_CARD_REVISION_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// note: the card is only restored into stashes that still exist
+var RESTORE_STASH_LINK_QUERY = (func() PipeInput {
+    const __RESTORE_STASH_LINK_QUERY string = `
+    INSERT OR IGNORE INTO StashCards(stash, card, added_at)
+    SELECT stash_id, :card_id, :added_at FROM Stashes WHERE stash_id = :stash_id;
+    `
+
+    var requiredInputCols []string = []string{"stash_id", "card_id", "added_at"}
+
+    return composePipes(
+        MakeCtxMaker(__RESTORE_STASH_LINK_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var RESTORE_CARD_SUSPENDED_QUERY = (func() PipeInput {
+    const __RESTORE_CARD_SUSPENDED_QUERY string = `
+    INSERT OR IGNORE INTO CardsSuspended(card, suspended_at) VALUES (:card_id, :suspended_at);
+    `
+
+    var requiredInputCols []string = []string{"card_id", "suspended_at"}
+
+    return composePipes(
+        MakeCtxMaker(__RESTORE_CARD_SUSPENDED_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+/* smart stashes */
+
+// a smart stash's cards are given by its saved filter rather than by StashCards.
+// every given criteria of the filter must match; NULL criteria are ignored.
+const SMART_STASHES_TABLE_QUERY string = `
+CREATE TABLE IF NOT EXISTS StashFilters (
+    stash INTEGER PRIMARY KEY NOT NULL,
+
+    deck INTEGER, /* cards within the deck subtree; no foreign key so a deleted deck matches nothing */
+    search TEXT, /* full-text search query over the cards' content */
+    score_min REAL,
+    score_max REAL,
+    times_reviewed_min INT,
+    times_reviewed_max INT,
+    reviewed_before INT, /* unix timestamp */
+    reviewed_after INT, /* unix timestamp */
+
+    FOREIGN KEY (stash) REFERENCES Stashes(stash_id) ON DELETE CASCADE
+);
+
+/* cards must have every tag */
+CREATE TABLE IF NOT EXISTS StashFilterTags (
+    stash INTEGER NOT NULL,
+    tag TEXT NOT NULL,
+
+    PRIMARY KEY(stash, tag),
+
+    FOREIGN KEY (stash) REFERENCES StashFilters(stash) ON DELETE CASCADE
+);
+
+/* cards of every stash; manual stashes via StashCards, smart stashes via their filter */
+CREATE VIEW IF NOT EXISTS StashMembers AS
+SELECT
+    sc.stash AS stash, sc.card AS card, sc.added_at AS added_at
+FROM StashCards AS sc
+
+UNION ALL
+
+SELECT
+    sf.stash AS stash, c.card_id AS card, c.created_at AS added_at
+FROM StashFilters AS sf
+
+INNER JOIN Cards AS c
+
+INNER JOIN CardsScore AS cs
+ON cs.card = c.card_id
+
+WHERE
+    (sf.deck IS NULL OR c.deck IN (SELECT descendent FROM DecksClosure WHERE ancestor = sf.deck))
+AND
+    NOT EXISTS (
+        SELECT 1 FROM StashFilterTags AS sft
+        WHERE
+            sft.stash = sf.stash
+        AND
+            NOT EXISTS (SELECT 1 FROM CardsTags AS ct WHERE ct.card = c.card_id AND ct.tag = sft.tag)
+    )
+AND
+    (sf.search IS NULL OR c.card_id IN (SELECT docid FROM CardsFTS WHERE CardsFTS MATCH sf.search))
+AND
+    (sf.score_min IS NULL OR cs.score >= sf.score_min)
+AND
+    (sf.score_max IS NULL OR cs.score <= sf.score_max)
+AND
+    (sf.times_reviewed_min IS NULL OR cs.times_reviewed >= sf.times_reviewed_min)
+AND
+    (sf.times_reviewed_max IS NULL OR cs.times_reviewed <= sf.times_reviewed_max)
+AND
+    (sf.reviewed_before IS NULL OR cs.updated_at < sf.reviewed_before)
+AND
+    (sf.reviewed_after IS NULL OR cs.updated_at > sf.reviewed_after);
+`
+
+var FETCH_STASH_FILTER_QUERY = (func() PipeInput {
+    const __FETCH_STASH_FILTER_QUERY string = `
+    SELECT
+        deck, search, score_min, score_max, times_reviewed_min, times_reviewed_max,
+        reviewed_before, reviewed_after
+    FROM StashFilters
+    WHERE stash = :stash_id;
+    `
+
+    var requiredInputCols []string = []string{"stash_id"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_STASH_FILTER_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var FETCH_STASH_FILTER_TAGS_QUERY = (func() PipeInput {
+    const __FETCH_STASH_FILTER_TAGS_QUERY string = `
+    SELECT tag FROM StashFilterTags
+    WHERE stash = :stash_id
+    ORDER BY tag ASC;
+    `
+
+    var requiredInputCols []string = []string{"stash_id"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_STASH_FILTER_TAGS_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// replaces the stash's filter; including its tags
+var SET_STASH_FILTER_QUERY = (func() PipeInput {
+    const __SET_STASH_FILTER_QUERY string = `
+    INSERT OR REPLACE INTO StashFilters(
+        stash, deck, search, score_min, score_max, times_reviewed_min, times_reviewed_max,
+        reviewed_before, reviewed_after
+    )
+    VALUES (
+        :stash_id, :deck, :search, :score_min, :score_max, :times_reviewed_min, :times_reviewed_max,
+        :reviewed_before, :reviewed_after
+    );
+
+    DELETE FROM StashFilterTags WHERE stash = :stash_id;
+    `
+
+    var requiredInputCols []string = []string{
+        "stash_id", "deck", "search", "score_min", "score_max", "times_reviewed_min",
+        "times_reviewed_max", "reviewed_before", "reviewed_after",
+    }
+
+    return composePipes(
+        MakeCtxMaker(__SET_STASH_FILTER_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var ADD_STASH_FILTER_TAG_QUERY = (func() PipeInput {
+    const __ADD_STASH_FILTER_TAG_QUERY string = `
+    INSERT OR IGNORE INTO StashFilterTags(stash, tag) VALUES (:stash_id, :tag);
+    `
+
+    var requiredInputCols []string = []string{"stash_id", "tag"}
+
+    return composePipes(
+        MakeCtxMaker(__ADD_STASH_FILTER_TAG_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// used to validate a full-text search query
+var COUNT_CARDS_BY_SEARCH_QUERY = (func() PipeInput {
+    const __COUNT_CARDS_BY_SEARCH_QUERY string = `
+    SELECT COUNT(1) FROM CardsFTS WHERE CardsFTS MATCH :search;
+    `
+
+    var requiredInputCols []string = []string{"search"}
+
+    return composePipes(
+        MakeCtxMaker(__COUNT_CARDS_BY_SEARCH_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+/* nested stashes */
+
+const NESTED_STASHES_TABLE_QUERY string = `
+/* closure table and associated triggers for Stashes */
+
+CREATE TABLE IF NOT EXISTS StashesClosure (
+    ancestor INTEGER NOT NULL,
+    descendent INTEGER NOT NULL,
+    depth INTEGER NOT NULL,
+    PRIMARY KEY(ancestor, descendent),
+    FOREIGN KEY (ancestor) REFERENCES Stashes(stash_id) ON DELETE CASCADE,
+    FOREIGN KEY (descendent) REFERENCES Stashes(stash_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS StashesClosure_descendent_Index ON StashesClosure (descendent);
+
+CREATE TRIGGER IF NOT EXISTS stashes_closure_new_stash AFTER INSERT
+ON Stashes
+BEGIN
+    INSERT OR IGNORE INTO StashesClosure(ancestor, descendent, depth) VALUES (NEW.stash_id, NEW.stash_id, 0);
+END;
+
+/* stashes created before stashes were nested */
+INSERT OR IGNORE INTO StashesClosure(ancestor, descendent, depth)
+SELECT stash_id, stash_id, 0 FROM Stashes;
+
+/* cards of every stash including cards of its descendent stashes; without duplicates */
+CREATE VIEW IF NOT EXISTS StashTreeMembers AS
+SELECT
+    scl.ancestor AS stash, sm.card AS card, MIN(sm.added_at) AS added_at
+FROM StashesClosure AS scl
+
+INNER JOIN StashMembers AS sm
+ON sm.stash = scl.descendent
+
+GROUP BY scl.ancestor, sm.card;
+`
+
+var ASSOCIATE_STASH_AS_CHILD_QUERY = (func() PipeInput {
+    const __ASSOCIATE_STASH_AS_CHILD_QUERY string = `
+    INSERT OR IGNORE INTO StashesClosure(ancestor, descendent, depth)
+
+    /* for every ancestor of parent, make it an ancestor of child */
+    SELECT t.ancestor, :child, t.depth+1
+    FROM StashesClosure AS t
+    WHERE t.descendent = :parent
+
+    UNION ALL
+
+    /* child is an ancestor of itself with a depth of 0 */
+    SELECT :child, :child, 0;
+    `
+
+    var requiredInputCols []string = []string{"parent", "child"}
+
+    return composePipes(
+        MakeCtxMaker(__ASSOCIATE_STASH_AS_CHILD_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// detach the stash subtree of :child from its ancestors
+var SPLICE_STASH_SUBTREE_DELETE_QUERY = (func() PipeInput {
+    const __SPLICE_STASH_SUBTREE_DELETE_QUERY string = `
+    DELETE FROM StashesClosure
+
+    /* select all descendents of child */
+    WHERE descendent IN (
+        SELECT descendent
+        FROM StashesClosure
+        WHERE ancestor = :child
+    )
+    AND
+
+    /* select all ancestors of child but not child itself */
+    ancestor IN (
+        SELECT ancestor
+        FROM StashesClosure
+        WHERE descendent = :child
+        AND ancestor != descendent
+    );
+    `
+
+    var requiredInputCols []string = []string{"child"}
+
+    return composePipes(
+        MakeCtxMaker(__SPLICE_STASH_SUBTREE_DELETE_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// attach the stash subtree of :child under :parent
+var SPLICE_STASH_SUBTREE_ADD_QUERY = (func() PipeInput {
+    const __SPLICE_STASH_SUBTREE_ADD_QUERY string = `
+    INSERT OR IGNORE INTO StashesClosure(ancestor, descendent, depth)
+    SELECT p.ancestor, c.descendent, p.depth+c.depth+1
+    FROM StashesClosure AS p, StashesClosure AS c
+    WHERE
+    p.descendent = :parent
+    AND c.ancestor = :child;
+    `
+
+    var requiredInputCols []string = []string{"child", "parent"}
+
+    return composePipes(
+        MakeCtxMaker(__SPLICE_STASH_SUBTREE_ADD_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// fetch direct children
+var STASH_CHILDREN_QUERY = (func() PipeInput {
+    const __STASH_CHILDREN_QUERY string = `
+    SELECT descendent
+    FROM StashesClosure
+    WHERE
+    ancestor = :parent
+    AND depth = 1
+    ORDER BY descendent ASC;
+    `
+
+    var requiredInputCols []string = []string{"parent"}
+
+    return composePipes(
+        MakeCtxMaker(__STASH_CHILDREN_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// fetch direct parent (if any)
+var STASH_PARENT_QUERY = (func() PipeInput {
+    const __STASH_PARENT_QUERY string = `
+    SELECT ancestor
+    FROM StashesClosure
+    WHERE
+    descendent = :child
+    AND depth = 1;
+    `
+
+    var requiredInputCols []string = []string{"child"}
+
+    return composePipes(
+        MakeCtxMaker(__STASH_PARENT_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// test lineage
+var TEST_STASH_LINEAGE_QUERY = (func() PipeInput {
+    const __TEST_STASH_LINEAGE_QUERY string = `
+    SELECT COUNT(1)
+    FROM StashesClosure
+    WHERE
+    ancestor = :parent
+    AND
+    descendent = :descendent
+    LIMIT 1;
+    `
+
+    var requiredInputCols []string = []string{"parent", "descendent"}
+
+    return composePipes(
+        MakeCtxMaker(__TEST_STASH_LINEAGE_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// test whether the card is within the stash or any of its descendent stashes
+var STASH_TREE_HAS_CARD_QUERY = (func() PipeInput {
+    const __STASH_TREE_HAS_CARD_QUERY string = `
+    SELECT COUNT(1)
+    FROM StashTreeMembers
+    WHERE
+    stash = :stash_id
+    AND
+    card = :card_id
+    LIMIT 1;
+    `
+
+    var requiredInputCols []string = []string{"stash_id", "card_id"}
+
+    return composePipes(
+        MakeCtxMaker(__STASH_TREE_HAS_CARD_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+/* media table */
+
+// content-addressed media (e.g. images) referenced by cards as /media/<hash>
+const MEDIA_TABLE_QUERY string = `
+CREATE TABLE IF NOT EXISTS Media (
+    hash TEXT PRIMARY KEY NOT NULL, /* hex encoded sha-256 of data */
+    mime TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    data BLOB NOT NULL,
+    created_at INT NOT NULL DEFAULT (strftime('%s', 'now')),
+
+    CHECK (length(hash) = 64)
+);
+
+/* media referenced within the card's fields; media need not exist */
+CREATE TABLE IF NOT EXISTS CardMedia (
+    card INTEGER NOT NULL,
+    media TEXT NOT NULL,
+
+    PRIMARY KEY(card, media),
+
+    FOREIGN KEY (card) REFERENCES Cards(card_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS CardMedia_media_Index ON CardMedia (media);
+`
+
+var INSERT_MEDIA_QUERY = (func() PipeInput {
+    const __INSERT_MEDIA_QUERY string = `
+    INSERT OR IGNORE INTO Media(hash, mime, size, data) VALUES (:hash, :mime, :size, :data);
+    `
+
+    var requiredInputCols []string = []string{"hash", "mime", "size", "data"}
+
+    return composePipes(
+        MakeCtxMaker(__INSERT_MEDIA_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var FETCH_MEDIA_QUERY = (func() PipeInput {
+    const __FETCH_MEDIA_QUERY string = `
+    SELECT hash, mime, size, data, created_at FROM Media
+    WHERE hash = :hash;
+    `
+
+    var requiredInputCols []string = []string{"hash"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_MEDIA_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// fetch media without its data
+var FETCH_MEDIA_META_QUERY = (func() PipeInput {
+    const __FETCH_MEDIA_META_QUERY string = `
+    SELECT
+        m.hash, m.mime, m.size, m.created_at,
+        (SELECT COUNT(1) FROM CardMedia WHERE media = m.hash) AS refs
+    FROM Media AS m
+    WHERE m.hash = :hash;
+    `
+
+    var requiredInputCols []string = []string{"hash"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_MEDIA_META_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// list media without their data; newest first
+var FETCH_MEDIA_LIST_QUERY = (func() PipeInput {
+    const __FETCH_MEDIA_LIST_QUERY string = `
+    SELECT
+        m.hash, m.mime, m.size, m.created_at,
+        (SELECT COUNT(1) FROM CardMedia WHERE media = m.hash) AS refs
+    FROM Media AS m
+    ORDER BY m.created_at DESC, m.hash ASC;
+    `
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_MEDIA_LIST_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+// media that isn't referenced by any card, card revision or trashed item; and is at
+// least grace_period seconds old (media is uploaded before the card referencing it is saved)
+var FETCH_UNREFERENCED_MEDIA_QUERY = (func() PipeInput {
+    const __FETCH_UNREFERENCED_MEDIA_QUERY string = `
+    SELECT
+        m.hash, m.mime, m.size, m.created_at, 0 AS refs
+    FROM Media AS m
+    WHERE
+        (strftime('%s','now') - m.created_at) >= :grace_period
+    AND
+        NOT EXISTS (SELECT 1 FROM CardMedia WHERE media = m.hash)
+    AND
+        NOT EXISTS (
+            SELECT 1 FROM CardRevisions AS r
+            WHERE
+                instr(r.title, m.hash) > 0 OR
+                instr(r.description, m.hash) > 0 OR
+                instr(r.front, m.hash) > 0 OR
+                instr(r.back, m.hash) > 0
+        )
+    AND
+        NOT EXISTS (SELECT 1 FROM Trash AS t WHERE instr(t.snapshot, m.hash) > 0);
+    `
+
+    var requiredInputCols []string = []string{"grace_period"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_UNREFERENCED_MEDIA_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var DELETE_MEDIA_QUERY = (func() PipeInput {
+    const __DELETE_MEDIA_QUERY string = `
+    DELETE FROM Media WHERE hash = :hash;
+    `
+
+    var requiredInputCols []string = []string{"hash"}
+
+    return composePipes(
+        MakeCtxMaker(__DELETE_MEDIA_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var FETCH_MEDIA_BY_CARD_QUERY = (func() PipeInput {
+    const __FETCH_MEDIA_BY_CARD_QUERY string = `
+    SELECT media FROM CardMedia
+    WHERE card = :card_id
+    ORDER BY media ASC;
+    `
+
+    var requiredInputCols []string = []string{"card_id"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_MEDIA_BY_CARD_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var DELETE_CARD_MEDIA_QUERY = (func() PipeInput {
+    const __DELETE_CARD_MEDIA_QUERY string = `
+    DELETE FROM CardMedia WHERE card = :card_id;
+    `
+
+    var requiredInputCols []string = []string{"card_id"}
+
+    return composePipes(
+        MakeCtxMaker(__DELETE_CARD_MEDIA_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var INSERT_CARD_MEDIA_QUERY = (func() PipeInput {
+    const __INSERT_CARD_MEDIA_QUERY string = `
+    INSERT OR IGNORE INTO CardMedia(card, media) VALUES (:card_id, :hash);
+    `
+
+    var requiredInputCols []string = []string{"card_id", "hash"}
+
+    return composePipes(
+        MakeCtxMaker(__INSERT_CARD_MEDIA_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+/* stash deadlines */
+
+const STASH_DEADLINES_TABLE_QUERY string = `
+CREATE TABLE IF NOT EXISTS StashDeadlines (
+    stash INTEGER PRIMARY KEY NOT NULL,
+
+    deadline INT NOT NULL, /* unix timestamp */
+    min_reviews INT NOT NULL DEFAULT 1, /* each card is to be reviewed at least this many times before the deadline */
+
+    created_at INT NOT NULL DEFAULT (strftime('%s', 'now')), /* reviews towards min_reviews are counted from this time */
+    archived_at INT, /* time when the stash was archived; set once the deadline has passed */
+
+    CHECK (min_reviews > 0),
+
+    FOREIGN KEY (stash) REFERENCES Stashes(stash_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS StashDeadlines_deadline_Index ON StashDeadlines (deadline);
+`
+
+var FETCH_STASH_DEADLINE_QUERY = (func() PipeInput {
+    const __FETCH_STASH_DEADLINE_QUERY string = `
+    SELECT
+        stash, deadline, min_reviews, created_at, archived_at
+    FROM StashDeadlines
+    WHERE stash = :stash_id;
+    `
+
+    var requiredInputCols []string = []string{"stash_id"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_STASH_DEADLINE_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// set or move the deadline; reviews already counted towards the deadline are kept.
+// moving the deadline into the future unarchives the stash.
+// moving the deadline starts its pacing (and the count of reviews towards it) anew
+var SET_STASH_DEADLINE_QUERY = (func() PipeInput {
+    const __SET_STASH_DEADLINE_QUERY string = `
+    INSERT OR IGNORE INTO StashDeadlines(stash, deadline, min_reviews) VALUES (:stash_id, :deadline, :min_reviews);
+
+    UPDATE StashDeadlines
+    SET
+        created_at = CASE WHEN deadline != :deadline THEN strftime('%s', 'now') ELSE created_at END,
+        deadline = :deadline,
+        min_reviews = :min_reviews,
+        archived_at = CASE WHEN :deadline > CAST(strftime('%s', 'now') AS INTEGER) THEN NULL ELSE archived_at END
+    WHERE stash = :stash_id;
+    `
+
+    var requiredInputCols []string = []string{"stash_id", "deadline", "min_reviews"}
+
+    return composePipes(
+        MakeCtxMaker(__SET_STASH_DEADLINE_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var DELETE_STASH_DEADLINE_QUERY = (func() PipeInput {
+    const __DELETE_STASH_DEADLINE_QUERY string = `
+    DELETE FROM StashDeadlines WHERE stash = :stash_id;
+    `
+
+    var requiredInputCols []string = []string{"stash_id"}
+
+    return composePipes(
+        MakeCtxMaker(__DELETE_STASH_DEADLINE_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var ARCHIVE_EXPIRED_STASHES_QUERY = (func() PipeInput {
+    const __ARCHIVE_EXPIRED_STASHES_QUERY string = `
+    UPDATE StashDeadlines
+    SET
+        archived_at = strftime('%s', 'now')
+    WHERE
+        archived_at IS NULL
+    AND
+        deadline <= strftime('%s', 'now');
+    `
+
+    return composePipes(
+        MakeCtxMaker(__ARCHIVE_EXPIRED_STASHES_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+var FETCH_UNARCHIVED_STASHES_QUERY = (func() PipeInput {
+    const __FETCH_UNARCHIVED_STASHES_QUERY string = `
+        SELECT
+            stash_id, name, description, created_at, updated_at
+        FROM Stashes
+        WHERE
+            stash_id NOT IN (SELECT stash FROM StashDeadlines WHERE archived_at IS NOT NULL);
+    `
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_UNARCHIVED_STASHES_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+// number of reviews of each card of the stash tree since the given time
+const __STASH_CARDS_REVIEWS_SINCE string = `
+    SELECT
+        sc.card AS card,
+        (SELECT COUNT(1) FROM CardsScoreHistory AS h WHERE h.card = sc.card AND h.occured_at >= :since) AS seen
+    FROM StashTreeMembers AS sc
+    WHERE
+        sc.stash = :stash_id
+    AND
+        sc.card NOT IN (SELECT card FROM CardsSuspended)
+`
+
+var STASH_DEADLINE_PROGRESS_QUERY = (func() PipeInput {
+    var __STASH_DEADLINE_PROGRESS_QUERY string = `
+    SELECT
+        COUNT(1) AS cards,
+        COALESCE(SUM(CASE WHEN r.seen >= :min_reviews THEN 1 ELSE 0 END), 0) AS cards_done,
+        COALESCE(SUM(MAX(0, :min_reviews - r.seen)), 0) AS remaining_reviews
+    FROM (` + __STASH_CARDS_REVIEWS_SINCE + `) AS r;
+    `
+
+    var requiredInputCols []string = []string{"stash_id", "since", "min_reviews"}
+
+    return composePipes(
+        MakeCtxMaker(__STASH_DEADLINE_PROGRESS_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// the least reviewed card that has yet to be reviewed min_reviews times; ties are
+// broken by the card reviewed longest ago
+var FETCH_NEXT_REVIEW_CARD_BY_STASH_DEADLINE_QUERY = (func() PipeInput {
+    var __FETCH_NEXT_REVIEW_CARD_BY_STASH_DEADLINE_QUERY string = `
+    SELECT
+        c.card_id, c.title, c.description, c.front, c.back, c.deck, c.created_at, c.updated_at
+    FROM (` + __STASH_CARDS_REVIEWS_SINCE + `) AS r
+
+    INNER JOIN Cards AS c
+    ON c.card_id = r.card
+
+    INNER JOIN CardsScore AS cs
+    ON cs.card = c.card_id
+
+    WHERE
+        r.seen < :min_reviews
+    ORDER BY
+        r.seen ASC, cs.updated_at ASC
+    LIMIT 1;
+    `
+
+    var requiredInputCols []string = []string{"stash_id", "since", "min_reviews"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_NEXT_REVIEW_CARD_BY_STASH_DEADLINE_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+/* ordered stashes */
+
+const ORDERED_STASHES_TABLE_QUERY string = `
+/* position of each card within a manual stash; cards are appended as they're added */
+CREATE TABLE IF NOT EXISTS StashCardPositions (
+    stash INTEGER NOT NULL,
+    card INTEGER NOT NULL,
+
+    position INTEGER NOT NULL,
+
+    PRIMARY KEY(stash, card),
+
+    FOREIGN KEY (stash, card) REFERENCES StashCards(stash, card) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS StashCardPositions_position_Index ON StashCardPositions (stash, position);
+
+CREATE TRIGGER IF NOT EXISTS stash_cards_position_new_card AFTER INSERT
+ON StashCards
+BEGIN
+    INSERT OR IGNORE INTO StashCardPositions(stash, card, position)
+    VALUES (
+        NEW.stash,
+        NEW.card,
+        COALESCE((SELECT MAX(position) FROM StashCardPositions WHERE stash = NEW.stash), 0) + 1
+    );
+END;
+
+/* cards added before stashes were ordered; in the order they were added */
+INSERT OR IGNORE INTO StashCardPositions(stash, card, position)
+SELECT
+    sc.stash,
+    sc.card,
+    (
+        SELECT COUNT(1) FROM StashCards AS o
+        WHERE
+            o.stash = sc.stash
+        AND
+            (o.added_at < sc.added_at OR (o.added_at = sc.added_at AND o.card <= sc.card))
+    )
+FROM StashCards AS sc
+WHERE NOT EXISTS (SELECT 1 FROM StashCardPositions AS sp WHERE sp.stash = sc.stash);
+
+/* last card served by sequential review of a stash */
+CREATE TABLE IF NOT EXISTS StashReviewCursor (
+    stash INTEGER PRIMARY KEY NOT NULL,
+    card INTEGER NOT NULL,
+
+    FOREIGN KEY (stash) REFERENCES Stashes(stash_id) ON DELETE CASCADE,
+    FOREIGN KEY (card) REFERENCES Cards(card_id) ON DELETE CASCADE
+);
+`
+
+// sort by position. cards without a position within the stash (i.e. cards of descendent
+// stashes or of a smart stash's filter) always come after, in the order they were added.
+var FETCH_CARDS_BY_STASH_SORT_POSITION_QUERY = func(sort string) PipeInput {
+    const __FETCH_CARDS_BY_STASH_SORT_POSITION_QUERY_RAW string = `
+        SELECT
+            c.card_id, c.title, c.description, c.front, c.back, c.deck, c.created_at, c.updated_at
+        FROM StashTreeMembers AS sc
+
+        INNER JOIN Cards AS c
+        ON c.card_id = sc.card
+
+        LEFT JOIN StashCardPositions AS sp
+        ON sp.stash = sc.stash AND sp.card = sc.card
+
+        WHERE
+        c.oid NOT IN (
+            SELECT
+                sc.card
+            FROM StashTreeMembers AS sc
+
+            LEFT JOIN StashCardPositions AS sp
+            ON sp.stash = sc.stash AND sp.card = sc.card
+
+            WHERE sc.stash = :stash_id
+            ORDER BY sp.position IS NULL, sp.position %s, sc.added_at %s, sc.card %s LIMIT :offset
+        )
+        AND
+        sc.stash = :stash_id
+        ORDER BY sp.position IS NULL, sp.position %s, sc.added_at %s, sc.card %s LIMIT :per_page;
+    `
+
+    var __FETCH_CARDS_BY_STASH_SORT_POSITION_QUERY string = fmt.Sprintf(__FETCH_CARDS_BY_STASH_SORT_POSITION_QUERY_RAW,
+        sort, sort, sort, sort, sort, sort)
+
+    var requiredInputCols []string = []string{"stash_id", "offset", "per_page"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_CARDS_BY_STASH_SORT_POSITION_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}
+
+// cards of the stash tree to be reviewed in sequence; see FETCH_CARDS_BY_STASH_SORT_POSITION_QUERY
+var FETCH_REVIEW_CARD_IDS_BY_STASH_SEQUENCE_QUERY = (func() PipeInput {
+    const __FETCH_REVIEW_CARD_IDS_BY_STASH_SEQUENCE_QUERY string = `
+        SELECT
+            sc.card
+        FROM StashTreeMembers AS sc
+
+        LEFT JOIN StashCardPositions AS sp
+        ON sp.stash = sc.stash AND sp.card = sc.card
+
+        WHERE
+            sc.stash = :stash_id
+        AND
+            sc.card NOT IN (SELECT card FROM CardsSuspended)
+        ORDER BY sp.position IS NULL, sp.position ASC, sc.added_at ASC, sc.card ASC;
+    `
+
+    var requiredInputCols []string = []string{"stash_id"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_REVIEW_CARD_IDS_BY_STASH_SEQUENCE_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// cards added directly into the stash; by position
+var FETCH_STASH_CARD_IDS_BY_POSITION_QUERY = (func() PipeInput {
+    const __FETCH_STASH_CARD_IDS_BY_POSITION_QUERY string = `
+        SELECT
+            card
+        FROM StashCardPositions
+        WHERE stash = :stash_id
+        ORDER BY position ASC, card ASC;
+    `
+
+    var requiredInputCols []string = []string{"stash_id"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_STASH_CARD_IDS_BY_POSITION_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var SET_STASH_CARD_POSITION_QUERY = (func() PipeInput {
+    const __SET_STASH_CARD_POSITION_QUERY string = `
+    UPDATE StashCardPositions
+    SET
+        position = :position
+    WHERE
+        stash = :stash_id
+    AND
+        card = :card_id;
+    `
+
+    var requiredInputCols []string = []string{"stash_id", "card_id", "position"}
+
+    return composePipes(
+        MakeCtxMaker(__SET_STASH_CARD_POSITION_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var FETCH_STASH_REVIEW_CURSOR_QUERY = (func() PipeInput {
+    const __FETCH_STASH_REVIEW_CURSOR_QUERY string = `
+    SELECT card FROM StashReviewCursor WHERE stash = :stash_id;
+    `
+
+    var requiredInputCols []string = []string{"stash_id"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_STASH_REVIEW_CURSOR_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var SET_STASH_REVIEW_CURSOR_QUERY = (func() PipeInput {
+    const __SET_STASH_REVIEW_CURSOR_QUERY string = `
+    INSERT OR REPLACE INTO StashReviewCursor(stash, card) VALUES (:stash_id, :card_id);
+    `
+
+    var requiredInputCols []string = []string{"stash_id", "card_id"}
+
+    return composePipes(
+        MakeCtxMaker(__SET_STASH_REVIEW_CURSOR_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+/* card links */
+
+const CARD_LINKS_TABLE_QUERY string = `
+/* cards referenced within the card's fields; e.g. [[card:123]]. the target need not exist */
+CREATE TABLE IF NOT EXISTS CardLinks (
+    card INTEGER NOT NULL,
+    target INTEGER NOT NULL,
+
+    PRIMARY KEY(card, target),
+
+    FOREIGN KEY (card) REFERENCES Cards(card_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS CardLinks_target_Index ON CardLinks (target);
+`
+
+var DELETE_CARD_LINKS_QUERY = (func() PipeInput {
+    const __DELETE_CARD_LINKS_QUERY string = `
+    DELETE FROM CardLinks WHERE card = :card_id;
+    `
+
+    var requiredInputCols []string = []string{"card_id"}
+
+    return composePipes(
+        MakeCtxMaker(__DELETE_CARD_LINKS_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var INSERT_CARD_LINK_QUERY = (func() PipeInput {
+    const __INSERT_CARD_LINK_QUERY string = `
+    INSERT OR IGNORE INTO CardLinks(card, target) VALUES (:card_id, :target);
+    `
+
+    var requiredInputCols []string = []string{"card_id", "target"}
+
+    return composePipes(
+        MakeCtxMaker(__INSERT_CARD_LINK_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// cards the card links to; title is null if the target doesn't exist
+var FETCH_CARD_LINKS_QUERY = (func() PipeInput {
+    const __FETCH_CARD_LINKS_QUERY string = `
+    SELECT
+        l.card AS card, l.target AS target, c.title AS title
+    FROM CardLinks AS l
+
+    LEFT JOIN Cards AS c
+    ON c.card_id = l.target
+
+    WHERE l.card = :card_id
+    ORDER BY l.target ASC;
+    `
+
+    var requiredInputCols []string = []string{"card_id"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_CARD_LINKS_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// cards linking to the card
+var FETCH_CARD_BACKLINKS_QUERY = (func() PipeInput {
+    const __FETCH_CARD_BACKLINKS_QUERY string = `
+    SELECT
+        l.card AS card, l.target AS target, c.title AS title
+    FROM CardLinks AS l
+
+    INNER JOIN Cards AS c
+    ON c.card_id = l.card
+
+    WHERE l.target = :card_id
+    ORDER BY l.card ASC;
+    `
+
+    var requiredInputCols []string = []string{"card_id"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_CARD_BACKLINKS_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// links whose target doesn't exist; title is of the linking card
+var FETCH_DANGLING_CARD_LINKS_QUERY = (func() PipeInput {
+    const __FETCH_DANGLING_CARD_LINKS_QUERY string = `
+    SELECT
+        l.card AS card, l.target AS target, c.title AS title
+    FROM CardLinks AS l
+
+    INNER JOIN Cards AS c
+    ON c.card_id = l.card
+
+    WHERE l.target NOT IN (SELECT card_id FROM Cards)
+    ORDER BY l.target ASC, l.card ASC;
+    `
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_DANGLING_CARD_LINKS_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+/* duplicate cards */
+
+// cards whose content matches the full-text search query; for duplicate candidates
+var FETCH_DUPLICATE_CANDIDATES_QUERY = (func() PipeInput {
+    const __FETCH_DUPLICATE_CANDIDATES_QUERY string = `
+    SELECT
+        c.card_id, c.title, c.description, c.front, c.back, c.deck, c.created_at, c.updated_at,
+        cs.times_reviewed
+    FROM Cards AS c
+
+    INNER JOIN CardsScore AS cs
+    ON cs.card = c.card_id
+
+    WHERE
+        c.card_id IN (SELECT docid FROM CardsFTS WHERE CardsFTS MATCH :search LIMIT :limit);
+    `
+
+    var requiredInputCols []string = []string{"search", "limit"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_DUPLICATE_CANDIDATES_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// cards within the deck subtree; for scanning duplicates
+var FETCH_DUPLICATE_CANDIDATES_BY_DECK_QUERY = (func() PipeInput {
+    const __FETCH_DUPLICATE_CANDIDATES_BY_DECK_QUERY string = `
+    SELECT
+        c.card_id, c.title, c.description, c.front, c.back, c.deck, c.created_at, c.updated_at,
+        cs.times_reviewed
+    FROM Cards AS c
+
+    INNER JOIN CardsScore AS cs
+    ON cs.card = c.card_id
+
+    WHERE
+        c.deck IN (SELECT descendent FROM DecksClosure WHERE ancestor = :deck_id)
+    ORDER BY c.card_id ASC;
+    `
+
+    var requiredInputCols []string = []string{"deck_id"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_DUPLICATE_CANDIDATES_BY_DECK_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+/* card merge */
+
+// score history of both cards; in the order it was recorded
+var FETCH_MERGED_CARD_SCORE_HISTORY_QUERY = (func() PipeInput {
+    const __FETCH_MERGED_CARD_SCORE_HISTORY_QUERY string = `
+    SELECT card, occured_at, success, fail, prev_success, prev_fail FROM CardsScoreHistory
+    WHERE card IN (:survivor, :victim)
+    ORDER BY occured_at ASC, rowid ASC;
+    `
+
+    var requiredInputCols []string = []string{"survivor", "victim"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_MERGED_CARD_SCORE_HISTORY_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// move score history, stash memberships and tags of the victim onto the survivor
+var MERGE_CARD_INTO_SURVIVOR_QUERY = (func() PipeInput {
+    const __MERGE_CARD_INTO_SURVIVOR_QUERY string = `
+    UPDATE CardsScoreHistory SET card = :survivor WHERE card = :victim;
+
+    INSERT OR IGNORE INTO StashCards(stash, card, added_at)
+    SELECT stash, :survivor, added_at FROM StashCards WHERE card = :victim;
+
+    INSERT OR IGNORE INTO CardsTags(card, tag)
+    SELECT :survivor, tag FROM CardsTags WHERE card = :victim;
+    `
+
+    var requiredInputCols []string = []string{"survivor", "victim"}
+
+    return composePipes(
+        MakeCtxMaker(__MERGE_CARD_INTO_SURVIVOR_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+/* deck merge and clone */
+
+var FETCH_CARDS_OF_DECK_QUERY = (func() PipeInput {
+    const __FETCH_CARDS_OF_DECK_QUERY string = `
+    SELECT card_id, title, description, front, back, deck, created_at, updated_at FROM Cards
+    WHERE deck = :deck_id
+    ORDER BY card_id ASC;
+    `
+
+    var requiredInputCols []string = []string{"deck_id"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_CARDS_OF_DECK_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// move cards directly within the deck (i.e. not its descendents) into another deck
+var MOVE_CARDS_OF_DECK_QUERY = (func() PipeInput {
+    const __MOVE_CARDS_OF_DECK_QUERY string = `
+    UPDATE Cards SET deck = :target WHERE deck = :deck_id;
+    `
+
+    var requiredInputCols []string = []string{"deck_id", "target"}
+
+    return composePipes(
+        MakeCtxMaker(__MOVE_CARDS_OF_DECK_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// review cards cached for the deck and its ancestors
+var DELETE_CACHED_REVIEWCARD_BY_DECK_ANCESTORS_QUERY = (func() PipeInput {
+    const __DELETE_CACHED_REVIEWCARD_BY_DECK_ANCESTORS_QUERY string = `
+    DELETE FROM ReviewCardCache
+    WHERE deck IN (
+        SELECT ancestor FROM DecksClosure WHERE descendent = :deck_id
+    );
+    `
+
+    var requiredInputCols []string = []string{"deck_id"}
+
+    return composePipes(
+        MakeCtxMaker(__DELETE_CACHED_REVIEWCARD_BY_DECK_ANCESTORS_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var COPY_CARD_TAGS_QUERY = (func() PipeInput {
+    const __COPY_CARD_TAGS_QUERY string = `
+    INSERT OR IGNORE INTO CardsTags(card, tag)
+    SELECT :card_id, tag FROM CardsTags WHERE card = :source;
+    `
+
+    var requiredInputCols []string = []string{"card_id", "source"}
+
+    return composePipes(
+        MakeCtxMaker(__COPY_CARD_TAGS_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// copy score and suspension of :source onto :card_id.
+// updated_at is copied separately since updating the score resets it.
+var COPY_CARD_REVIEW_STATE_QUERY = (func() PipeInput {
+    const __COPY_CARD_REVIEW_STATE_QUERY string = `
+    UPDATE CardsScore
+    SET (success, fail, score, times_reviewed) = (
+        SELECT success, fail, score, times_reviewed FROM CardsScore WHERE card = :source
+    )
+    WHERE card = :card_id;
+
+    UPDATE CardsScore
+    SET updated_at = (SELECT updated_at FROM CardsScore WHERE card = :source)
+    WHERE card = :card_id;
+
+    INSERT OR IGNORE INTO CardsSuspended(card)
+    SELECT :card_id FROM CardsSuspended WHERE card = :source;
+    `
+
+    var requiredInputCols []string = []string{"card_id", "source"}
+
+    return composePipes(
+        MakeCtxMaker(__COPY_CARD_REVIEW_STATE_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+/* backups */
+
+// number of reviews since the given time
+var COUNT_REVIEWS_SINCE_QUERY = (func() PipeInput {
+    const __COUNT_REVIEWS_SINCE_QUERY string = `
+    SELECT COUNT(1) FROM CardsScoreHistory WHERE occured_at > :since;
+    `
+
+    var requiredInputCols []string = []string{"since"}
+
+    return composePipes(
+        MakeCtxMaker(__COUNT_REVIEWS_SINCE_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var QUICK_CHECK_QUERY = (func() PipeInput {
+    const __QUICK_CHECK_QUERY string = `
+    PRAGMA quick_check;
+    `
+
+    return composePipes(
+        MakeCtxMaker(__QUICK_CHECK_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+var INTEGRITY_CHECK_QUERY = (func() PipeInput {
+    const __INTEGRITY_CHECK_QUERY string = `
+    PRAGMA integrity_check;
+    `
+
+    return composePipes(
+        MakeCtxMaker(__INTEGRITY_CHECK_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+/* fsck */
+
+var FOREIGN_KEY_CHECK_QUERY = (func() PipeInput {
+    const __FOREIGN_KEY_CHECK_QUERY string = `
+    PRAGMA foreign_key_check;
+    `
+
+    return composePipes(
+        MakeCtxMaker(__FOREIGN_KEY_CHECK_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+// table names can't be bound; the table is one reported by PRAGMA foreign_key_check
+const DELETE_ROW_BY_ROWID_QUERY_FORMAT string = `DELETE FROM "%s" WHERE rowid = ?;`
+
+var MOVE_CARD_INTO_DECK_QUERY = (func() PipeInput {
+    const __MOVE_CARD_INTO_DECK_QUERY string = `
+    UPDATE Cards SET deck = :deck_id WHERE card_id = :card_id;
+    `
+
+    var requiredInputCols []string = []string{"card_id", "deck_id"}
+
+    return composePipes(
+        MakeCtxMaker(__MOVE_CARD_INTO_DECK_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var FETCH_DECK_IDS_QUERY = (func() PipeInput {
+    const __FETCH_DECK_IDS_QUERY string = `
+    SELECT deck_id FROM Decks ORDER BY deck_id;
+    `
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_DECK_IDS_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+var FETCH_DECKS_CLOSURE_QUERY = (func() PipeInput {
+    const __FETCH_DECKS_CLOSURE_QUERY string = `
+    SELECT ancestor, descendent, depth FROM DecksClosure ORDER BY descendent, depth;
+    `
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_DECKS_CLOSURE_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+var DELETE_DECKS_CLOSURE_ROW_QUERY = (func() PipeInput {
+    const __DELETE_DECKS_CLOSURE_ROW_QUERY string = `
+    DELETE FROM DecksClosure WHERE ancestor = :ancestor AND descendent = :descendent;
+    `
+
+    var requiredInputCols []string = []string{"ancestor", "descendent"}
+
+    return composePipes(
+        MakeCtxMaker(__DELETE_DECKS_CLOSURE_ROW_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var INSERT_DECKS_CLOSURE_ROW_QUERY = (func() PipeInput {
+    const __INSERT_DECKS_CLOSURE_ROW_QUERY string = `
+    INSERT OR REPLACE INTO DecksClosure(ancestor, descendent, depth) VALUES (:ancestor, :descendent, :depth);
+    `
+
+    var requiredInputCols []string = []string{"ancestor", "descendent", "depth"}
+
+    return composePipes(
+        MakeCtxMaker(__INSERT_DECKS_CLOSURE_ROW_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var FETCH_STASH_IDS_QUERY = (func() PipeInput {
+    const __FETCH_STASH_IDS_QUERY string = `
+    SELECT stash_id FROM Stashes ORDER BY stash_id;
+    `
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_STASH_IDS_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+var FETCH_STASHES_CLOSURE_QUERY = (func() PipeInput {
+    const __FETCH_STASHES_CLOSURE_QUERY string = `
+    SELECT ancestor, descendent, depth FROM StashesClosure ORDER BY descendent, depth;
+    `
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_STASHES_CLOSURE_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+var DELETE_STASHES_CLOSURE_ROW_QUERY = (func() PipeInput {
+    const __DELETE_STASHES_CLOSURE_ROW_QUERY string = `
+    DELETE FROM StashesClosure WHERE ancestor = :ancestor AND descendent = :descendent;
+    `
+
+    var requiredInputCols []string = []string{"ancestor", "descendent"}
+
+    return composePipes(
+        MakeCtxMaker(__DELETE_STASHES_CLOSURE_ROW_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var INSERT_STASHES_CLOSURE_ROW_QUERY = (func() PipeInput {
+    const __INSERT_STASHES_CLOSURE_ROW_QUERY string = `
+    INSERT OR REPLACE INTO StashesClosure(ancestor, descendent, depth) VALUES (:ancestor, :descendent, :depth);
+    `
+
+    var requiredInputCols []string = []string{"ancestor", "descendent", "depth"}
+
+    return composePipes(
+        MakeCtxMaker(__INSERT_STASHES_CLOSURE_ROW_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var FETCH_CARDS_WITHOUT_SCORE_QUERY = (func() PipeInput {
+    const __FETCH_CARDS_WITHOUT_SCORE_QUERY string = `
+    SELECT card_id FROM Cards WHERE card_id NOT IN (SELECT card FROM CardsScore) ORDER BY card_id;
+    `
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_CARDS_WITHOUT_SCORE_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+// score rows are restored from the latest score history of the card (if any)
+var INSERT_MISSING_CARDS_SCORE_QUERY = (func() PipeInput {
+    const __INSERT_MISSING_CARDS_SCORE_QUERY string = `
+    INSERT INTO CardsScore(card, success, fail, score, times_reviewed)
+    SELECT
+        c.card_id,
+        COALESCE(h.success, 0),
+        COALESCE(h.fail, 0),
+        COALESCE(h.score, 0.5),
+        (SELECT COUNT(1) FROM CardsScoreHistory WHERE card = c.card_id)
+    FROM Cards AS c
+    LEFT JOIN CardsScoreHistory AS h
+    ON h.rowid = (
+        SELECT rowid FROM CardsScoreHistory
+        WHERE card = c.card_id
+        ORDER BY occured_at DESC, rowid DESC
+        LIMIT 1
+    )
+    WHERE c.card_id NOT IN (SELECT card FROM CardsScore);
+    `
+
+    return composePipes(
+        MakeCtxMaker(__INSERT_MISSING_CARDS_SCORE_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+// cached review cards that are no longer within the deck, or are suspended
+var FETCH_STALE_REVIEW_CARD_CACHE_QUERY = (func() PipeInput {
+    const __FETCH_STALE_REVIEW_CARD_CACHE_QUERY string = `
+    SELECT rc.deck, rc.card, rc.created_at
+    FROM ReviewCardCache AS rc
+    WHERE
+        NOT EXISTS (
+            SELECT 1
+            FROM Cards AS c
+            INNER JOIN DecksClosure AS dc
+            ON dc.descendent = c.deck
+            WHERE c.card_id = rc.card AND dc.ancestor = rc.deck
+        )
+        OR rc.card IN (SELECT card FROM CardsSuspended)
+    ORDER BY rc.deck;
+    `
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_STALE_REVIEW_CARD_CACHE_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+// cached review cards of stashes that are no longer within the stash (or its descendent
+// stashes), or are suspended
+var FETCH_STALE_REVIEW_CARD_STASH_CACHE_QUERY = (func() PipeInput {
+    const __FETCH_STALE_REVIEW_CARD_STASH_CACHE_QUERY string = `
+    SELECT rc.stash, rc.card, rc.created_at
+    FROM ReviewCardStashCache AS rc
+    WHERE
+        NOT EXISTS (
+            SELECT 1 FROM StashTreeMembers AS sc
+            WHERE sc.stash = rc.stash AND sc.card = rc.card
+        )
+        OR rc.card IN (SELECT card FROM CardsSuspended)
+    ORDER BY rc.stash;
+    `
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_STALE_REVIEW_CARD_STASH_CACHE_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+// cards missing from (or outdated in) the full-text index, and indexed cards that don't exist
+var FETCH_CARDS_FTS_MISMATCH_QUERY = (func() PipeInput {
+    const __FETCH_CARDS_FTS_MISMATCH_QUERY string = `
+    SELECT c.card_id
+    FROM Cards AS c
+    LEFT JOIN CardsFTS AS f
+    ON f.docid = c.card_id
+    WHERE
+        f.docid IS NULL
+        OR f.title IS NOT c.title
+        OR f.description IS NOT c.description
+        OR f.front IS NOT c.front
+        OR f.back IS NOT c.back
+    UNION
+    SELECT docid FROM CardsFTS WHERE docid NOT IN (SELECT card_id FROM Cards)
+    ORDER BY 1;
+    `
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_CARDS_FTS_MISMATCH_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+var REBUILD_CARDS_FTS_QUERY = (func() PipeInput {
+    const __REBUILD_CARDS_FTS_QUERY string = `
+    DELETE FROM CardsFTS;
+
+    INSERT INTO CardsFTS(docid, title, description, front, back)
+    SELECT card_id, title, description, front, back FROM Cards;
+    `
+
+    return composePipes(
+        MakeCtxMaker(__REBUILD_CARDS_FTS_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+/* merge-based sync between databases */
+
+// rows are identified across databases by a global uid; deleted rows leave a tombstone.
+// migration 2; see migrations.go
+const SYNC_TABLES_QUERY string = `
+ALTER TABLE Decks ADD COLUMN uid TEXT;
+ALTER TABLE Decks ADD COLUMN updated_at INT NOT NULL DEFAULT 0;
+ALTER TABLE Cards ADD COLUMN uid TEXT;
+ALTER TABLE Stashes ADD COLUMN uid TEXT;
+ALTER TABLE CardsScoreHistory ADD COLUMN uid TEXT;
+
+UPDATE Decks SET uid = lower(hex(randomblob(16))), updated_at = strftime('%s', 'now');
+UPDATE Cards SET uid = lower(hex(randomblob(16)));
+UPDATE Stashes SET uid = lower(hex(randomblob(16)));
+UPDATE CardsScoreHistory SET uid = lower(hex(randomblob(16)));
+
+CREATE UNIQUE INDEX IF NOT EXISTS Decks_uid_Index ON Decks (uid);
+CREATE UNIQUE INDEX IF NOT EXISTS Cards_uid_Index ON Cards (uid);
+CREATE UNIQUE INDEX IF NOT EXISTS Stashes_uid_Index ON Stashes (uid);
+CREATE UNIQUE INDEX IF NOT EXISTS CardsScoreHistory_uid_Index ON CardsScoreHistory (uid);
+
+/* rows created by sync are given the uid of the row they're copied from */
+
+CREATE TRIGGER IF NOT EXISTS decks_uid_new_deck AFTER INSERT
+ON Decks
+WHEN NEW.uid IS NULL
+BEGIN
+    UPDATE Decks SET uid = lower(hex(randomblob(16))), updated_at = strftime('%s', 'now') WHERE deck_id = NEW.deck_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS cards_uid_new_card AFTER INSERT
+ON Cards
+WHEN NEW.uid IS NULL
+BEGIN
+    UPDATE Cards SET uid = lower(hex(randomblob(16))) WHERE card_id = NEW.card_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS stashes_uid_new_stash AFTER INSERT
+ON Stashes
+WHEN NEW.uid IS NULL
+BEGIN
+    UPDATE Stashes SET uid = lower(hex(randomblob(16))) WHERE stash_id = NEW.stash_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS cardsscorehistory_uid_new_point AFTER INSERT
+ON CardsScoreHistory
+WHEN NEW.uid IS NULL
+BEGIN
+    UPDATE CardsScoreHistory SET uid = lower(hex(randomblob(16))) WHERE rowid = NEW.rowid;
+END;
+
+/* modification times of decks and stashes; moving a deck modifies it */
+
+CREATE TRIGGER IF NOT EXISTS decks_updated_deck AFTER UPDATE OF
+name, description
+ON Decks
+BEGIN
+    UPDATE Decks SET updated_at = strftime('%s', 'now') WHERE deck_id = NEW.deck_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS decks_updated_parent AFTER INSERT
+ON DecksClosure
+WHEN NEW.depth = 1
+BEGIN
+    UPDATE Decks SET updated_at = strftime('%s', 'now') WHERE deck_id = NEW.descendent;
+END;
+
+CREATE TRIGGER IF NOT EXISTS stashes_updated_added_card AFTER INSERT
+ON StashCards
+BEGIN
+    UPDATE Stashes SET updated_at = strftime('%s', 'now') WHERE stash_id = NEW.stash;
+END;
+
+CREATE TRIGGER IF NOT EXISTS stashes_updated_removed_card AFTER DELETE
+ON StashCards
+BEGIN
+    UPDATE Stashes SET updated_at = strftime('%s', 'now') WHERE stash_id = OLD.stash;
+END;
+
+/* deleted rows */
+
+CREATE TABLE IF NOT EXISTS SyncTombstones (
+    uid TEXT PRIMARY KEY NOT NULL,
+    kind TEXT NOT NULL, /* deck, card or stash */
+    deleted_at INT NOT NULL DEFAULT (strftime('%s', 'now')),
+
+    CHECK (kind IN ('deck', 'card', 'stash'))
+);
+
+CREATE TRIGGER IF NOT EXISTS sync_tombstone_deleted_deck AFTER DELETE
+ON Decks
+WHEN OLD.uid IS NOT NULL
+BEGIN
+    INSERT OR REPLACE INTO SyncTombstones(uid, kind) VALUES (OLD.uid, 'deck');
+END;
+
+CREATE TRIGGER IF NOT EXISTS sync_tombstone_deleted_card AFTER DELETE
+ON Cards
+WHEN OLD.uid IS NOT NULL
+BEGIN
+    INSERT OR REPLACE INTO SyncTombstones(uid, kind) VALUES (OLD.uid, 'card');
+END;
+
+CREATE TRIGGER IF NOT EXISTS sync_tombstone_deleted_stash AFTER DELETE
+ON Stashes
+WHEN OLD.uid IS NOT NULL
+BEGIN
+    INSERT OR REPLACE INTO SyncTombstones(uid, kind) VALUES (OLD.uid, 'stash');
+END;
+
+/* rows recreated by sync; i.e. modified elsewhere after they were deleted */
+
+CREATE TRIGGER IF NOT EXISTS sync_tombstone_recreated_deck AFTER INSERT
+ON Decks
+WHEN NEW.uid IS NOT NULL
+BEGIN
+    DELETE FROM SyncTombstones WHERE uid = NEW.uid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS sync_tombstone_recreated_card AFTER INSERT
+ON Cards
+WHEN NEW.uid IS NOT NULL
+BEGIN
+    DELETE FROM SyncTombstones WHERE uid = NEW.uid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS sync_tombstone_recreated_stash AFTER INSERT
+ON Stashes
+WHEN NEW.uid IS NOT NULL
+BEGIN
+    DELETE FROM SyncTombstones WHERE uid = NEW.uid;
+END;
+
+/* databases synced with; i.e. by their sync_id config setting */
+CREATE TABLE IF NOT EXISTS SyncPeers (
+    peer TEXT PRIMARY KEY NOT NULL,
+    synced_at INT NOT NULL DEFAULT (strftime('%s', 'now'))
+);
+`
+
+// score history points record the counts they were recorded over; such that reviews
+// made on several databases are added up when their histories are merged.
+// points recorded before have no previous counts.
+// migration 4; see migrations.go
+const SCORE_HISTORY_PREVIOUS_COUNTS_QUERY string = `
+ALTER TABLE CardsScoreHistory ADD COLUMN prev_success INTEGER;
+ALTER TABLE CardsScoreHistory ADD COLUMN prev_fail INTEGER;
+
+DROP TRIGGER IF EXISTS record_cardscore;
+
+CREATE TRIGGER IF NOT EXISTS record_cardscore AFTER UPDATE
+OF success, fail, score, changelog
+ON CardsScore
+BEGIN
+   INSERT INTO CardsScoreHistory(occured_at, success, fail, score, changelog, card, prev_success, prev_fail)
+   VALUES (strftime('%s', 'now'), NEW.success, NEW.fail, NEW.score, NEW.changelog, NEW.card, OLD.success, OLD.fail);
+END;
+`
+
+// score history points record whether they came from a review; i.e. the update raised
+// the times the card was reviewed. points recorded before don't know.
+// migration 7; see migrations.go
+const SCORE_HISTORY_REVIEWED_QUERY string = `
+ALTER TABLE CardsScoreHistory ADD COLUMN reviewed INTEGER;
+
+DROP TRIGGER IF EXISTS record_cardscore;
+
+CREATE TRIGGER IF NOT EXISTS record_cardscore AFTER UPDATE
+OF success, fail, score, changelog
+ON CardsScore
+BEGIN
+   INSERT INTO CardsScoreHistory(occured_at, success, fail, score, changelog, card, prev_success, prev_fail, reviewed)
+   VALUES (strftime('%s', 'now'), NEW.success, NEW.fail, NEW.score, NEW.changelog, NEW.card, OLD.success, OLD.fail, NEW.times_reviewed > OLD.times_reviewed);
+END;
+`
+
+// rebuild the links of every card from the link markers ([[card:<id>]]) of its text; e.g.
+// of cards whose link markers were written before links were tracked. the text after each
+// marker prefix is found recursively; markers whose id isn't a number are skipped, as are
+// links of a card to itself.
+// migration 5; see migrations.go
+const CARD_LINKS_BACKFILL_QUERY string = `
+DELETE FROM CardLinks;
+
+WITH RECURSIVE
+markers(card, is_marker, rest) AS (
+    SELECT
+        card_id,
+        0,
+        title || char(10) || description || char(10) || front || char(10) || back
+    FROM Cards
+
+    UNION ALL
+
+    SELECT
+        card,
+        1,
+        substr(rest, instr(rest, '[[card:') + length('[[card:'))
+    FROM markers
+    WHERE instr(rest, '[[card:') > 0
+),
+targets(card, target) AS (
+    SELECT card, substr(rest, 1, instr(rest, ']]') - 1)
+    FROM markers
+    WHERE is_marker = 1 AND instr(rest, ']]') > 1
+)
+INSERT OR IGNORE INTO CardLinks(card, target)
+SELECT card, CAST(target AS INTEGER)
+FROM targets
+WHERE
+    target NOT GLOB '*[^0-9]*'
+AND
+    CAST(target AS INTEGER) > 0
+AND
+    CAST(target AS INTEGER) != card;
+`
+
+// a stash has a cached review card for each review mode; such that switching modes
+// doesn't serve the card chosen by the other mode. cards cached before were chosen by score.
+// migration 8; see migrations.go
+const STASH_REVIEW_CARD_CACHE_MODE_QUERY string = `
+CREATE TABLE IF NOT EXISTS ReviewCardStashModeCache (
+    stash INTEGER NOT NULL,
+    card INTEGER NOT NULL,
+    mode TEXT NOT NULL,
+    created_at INT NOT NULL DEFAULT (strftime('%s', 'now')),
+
+    PRIMARY KEY(stash, mode),
+
+    FOREIGN KEY (stash) REFERENCES Stashes(stash_id) ON DELETE CASCADE,
+    FOREIGN KEY (card) REFERENCES Cards(card_id) ON DELETE CASCADE
+);
+
+INSERT INTO ReviewCardStashModeCache(stash, card, mode, created_at)
+SELECT stash, card, 'score', created_at FROM ReviewCardStashCache;
+
+DROP TABLE ReviewCardStashCache;
+
+ALTER TABLE ReviewCardStashModeCache RENAME TO ReviewCardStashCache;
+`
+
+// changing the filter, parent, deadline or order of cards of a stash modifies it; such
+// that syncing picks the most recent settings.
+// migration 6; see migrations.go
+const STASH_SETTINGS_UPDATED_AT_QUERY string = `
+CREATE TRIGGER IF NOT EXISTS stashes_updated_new_filter AFTER INSERT
+ON StashFilters
+BEGIN
+    UPDATE Stashes SET updated_at = strftime('%s', 'now') WHERE stash_id = NEW.stash;
+END;
+
+CREATE TRIGGER IF NOT EXISTS stashes_updated_filter AFTER UPDATE
+ON StashFilters
+BEGIN
+    UPDATE Stashes SET updated_at = strftime('%s', 'now') WHERE stash_id = NEW.stash;
+END;
+
+CREATE TRIGGER IF NOT EXISTS stashes_updated_removed_filter AFTER DELETE
+ON StashFilters
+BEGIN
+    UPDATE Stashes SET updated_at = strftime('%s', 'now') WHERE stash_id = OLD.stash;
+END;
+
+CREATE TRIGGER IF NOT EXISTS stashes_updated_new_filter_tag AFTER INSERT
+ON StashFilterTags
+BEGIN
+    UPDATE Stashes SET updated_at = strftime('%s', 'now') WHERE stash_id = NEW.stash;
+END;
+
+CREATE TRIGGER IF NOT EXISTS stashes_updated_removed_filter_tag AFTER DELETE
+ON StashFilterTags
+BEGIN
+    UPDATE Stashes SET updated_at = strftime('%s', 'now') WHERE stash_id = OLD.stash;
+END;
+
+CREATE TRIGGER IF NOT EXISTS stashes_updated_new_parent AFTER INSERT
+ON StashesClosure
+WHEN NEW.depth = 1
+BEGIN
+    UPDATE Stashes SET updated_at = strftime('%s', 'now') WHERE stash_id = NEW.descendent;
+END;
+
+CREATE TRIGGER IF NOT EXISTS stashes_updated_removed_parent AFTER DELETE
+ON StashesClosure
+WHEN OLD.depth = 1
+BEGIN
+    UPDATE Stashes SET updated_at = strftime('%s', 'now') WHERE stash_id = OLD.descendent;
+END;
+
+CREATE TRIGGER IF NOT EXISTS stashes_updated_new_deadline AFTER INSERT
+ON StashDeadlines
+BEGIN
+    UPDATE Stashes SET updated_at = strftime('%s', 'now') WHERE stash_id = NEW.stash;
+END;
+
+CREATE TRIGGER IF NOT EXISTS stashes_updated_deadline AFTER UPDATE OF
+deadline, min_reviews, created_at
+ON StashDeadlines
+BEGIN
+    UPDATE Stashes SET updated_at = strftime('%s', 'now') WHERE stash_id = NEW.stash;
+END;
+
+CREATE TRIGGER IF NOT EXISTS stashes_updated_removed_deadline AFTER DELETE
+ON StashDeadlines
+BEGIN
+    UPDATE Stashes SET updated_at = strftime('%s', 'now') WHERE stash_id = OLD.stash;
+END;
+
+CREATE TRIGGER IF NOT EXISTS stashes_updated_card_position AFTER UPDATE OF
+position
+ON StashCardPositions
+BEGIN
+    UPDATE Stashes SET updated_at = strftime('%s', 'now') WHERE stash_id = NEW.stash;
+END;
+`
+
+// decks other than the root deck; parents come before their children.
+// children of the root deck have no parent uid. content_updated_at is the latest
+// modification of the deck, its descendents, or their cards.
+var FETCH_SYNC_DECKS_QUERY = (func() PipeInput {
+    const __FETCH_SYNC_DECKS_QUERY string = `
+    SELECT
+        d.deck_id,
+        d.uid,
+        CASE WHEN dc.ancestor = :root_deck_id THEN '' ELSE COALESCE(p.uid, '') END AS parent,
+        d.name,
+        d.description,
+        d.updated_at,
+        MAX(
+            (SELECT MAX(sd.updated_at) FROM DecksClosure AS sc INNER JOIN Decks AS sd ON sd.deck_id = sc.descendent WHERE sc.ancestor = d.deck_id),
+            COALESCE((SELECT MAX(c.updated_at) FROM DecksClosure AS cc INNER JOIN Cards AS c ON c.deck = cc.descendent WHERE cc.ancestor = d.deck_id), 0)
+        ) AS content_updated_at
+    FROM Decks AS d
+    LEFT JOIN DecksClosure AS dc
+    ON dc.descendent = d.deck_id AND dc.depth = 1
+    LEFT JOIN Decks AS p
+    ON p.deck_id = dc.ancestor
+    WHERE d.deck_id <> :root_deck_id
+    ORDER BY (SELECT MAX(depth) FROM DecksClosure WHERE descendent = d.deck_id), d.deck_id;
+    `
+
+    var requiredInputCols []string = []string{"root_deck_id"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_SYNC_DECKS_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var FETCH_SYNC_CARDS_QUERY = (func() PipeInput {
+    const __FETCH_SYNC_CARDS_QUERY string = `
+    SELECT
+        c.card_id,
+        c.uid,
+        CASE WHEN c.deck = :root_deck_id THEN '' ELSE d.uid END AS deck,
+        c.title,
+        c.description,
+        c.front,
+        c.back,
+        c.created_at,
+        c.updated_at,
+        COALESCE(s.times_reviewed, 0) AS times_reviewed
+    FROM Cards AS c
+    INNER JOIN Decks AS d
+    ON d.deck_id = c.deck
+    LEFT JOIN CardsScore AS s
+    ON s.card = c.card_id
+    ORDER BY c.card_id;
+    `
+
+    var requiredInputCols []string = []string{"root_deck_id"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_SYNC_CARDS_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var FETCH_SYNC_CARD_SCORE_HISTORY_QUERY = (func() PipeInput {
+    const __FETCH_SYNC_CARD_SCORE_HISTORY_QUERY string = `
+    SELECT
+        h.uid,
+        c.uid AS card,
+        h.occured_at,
+        h.success,
+        h.fail,
+        h.score,
+        h.changelog,
+        h.prev_success,
+        h.prev_fail,
+        h.reviewed
+    FROM CardsScoreHistory AS h
+    INNER JOIN Cards AS c
+    ON c.card_id = h.card
+    ORDER BY h.occured_at, h.uid;
+    `
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_SYNC_CARD_SCORE_HISTORY_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+// parents come before their children; top-level stashes have no parent uid
+var FETCH_SYNC_STASHES_QUERY = (func() PipeInput {
+    const __FETCH_SYNC_STASHES_QUERY string = `
+    SELECT
+        s.stash_id,
+        s.uid,
+        s.name,
+        s.description,
+        s.created_at,
+        s.updated_at,
+        COALESCE(p.uid, '') AS parent
+    FROM Stashes AS s
+
+    LEFT JOIN StashesClosure AS sc
+    ON sc.descendent = s.stash_id AND sc.depth = 1
+
+    LEFT JOIN Stashes AS p
+    ON p.stash_id = sc.ancestor
+
+    ORDER BY (SELECT MAX(depth) FROM StashesClosure WHERE descendent = s.stash_id), s.stash_id;
+    `
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_SYNC_STASHES_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+// cards of each stash; by position
+var FETCH_SYNC_STASH_CARDS_QUERY = (func() PipeInput {
+    const __FETCH_SYNC_STASH_CARDS_QUERY string = `
+    SELECT s.uid AS stash, c.uid AS card
+    FROM StashCards AS sc
+    INNER JOIN Stashes AS s
+    ON s.stash_id = sc.stash
+    INNER JOIN Cards AS c
+    ON c.card_id = sc.card
+    LEFT JOIN StashCardPositions AS sp
+    ON sp.stash = sc.stash AND sp.card = sc.card
+    ORDER BY s.uid, sp.position, c.uid;
+    `
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_SYNC_STASH_CARDS_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+var FETCH_SYNC_STASH_FILTERS_QUERY = (func() PipeInput {
+    const __FETCH_SYNC_STASH_FILTERS_QUERY string = `
+    SELECT
+        s.uid AS stash, sf.deck, sf.search, sf.score_min, sf.score_max, sf.times_reviewed_min,
+        sf.times_reviewed_max, sf.reviewed_before, sf.reviewed_after
+    FROM StashFilters AS sf
+    INNER JOIN Stashes AS s
+    ON s.stash_id = sf.stash
+    ORDER BY s.uid;
+    `
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_SYNC_STASH_FILTERS_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+var FETCH_SYNC_STASH_FILTER_TAGS_QUERY = (func() PipeInput {
+    const __FETCH_SYNC_STASH_FILTER_TAGS_QUERY string = `
+    SELECT s.uid AS stash, sft.tag AS tag
+    FROM StashFilterTags AS sft
+    INNER JOIN Stashes AS s
+    ON s.stash_id = sft.stash
+    ORDER BY s.uid, sft.tag;
+    `
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_SYNC_STASH_FILTER_TAGS_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+// whether a stash is archived follows from its deadline; so it isn't synced
+var FETCH_SYNC_STASH_DEADLINES_QUERY = (func() PipeInput {
+    const __FETCH_SYNC_STASH_DEADLINES_QUERY string = `
+    SELECT s.uid AS stash, sd.deadline, sd.min_reviews, sd.created_at
+    FROM StashDeadlines AS sd
+    INNER JOIN Stashes AS s
+    ON s.stash_id = sd.stash
+    ORDER BY s.uid;
+    `
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_SYNC_STASH_DEADLINES_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+// media referenced by cards
+var FETCH_SYNC_MEDIA_QUERY = (func() PipeInput {
+    const __FETCH_SYNC_MEDIA_QUERY string = `
+    SELECT hash, mime, data FROM Media
+    WHERE hash IN (SELECT media FROM CardMedia)
+    ORDER BY hash;
+    `
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_SYNC_MEDIA_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+var FETCH_MISSING_CARD_MEDIA_QUERY = (func() PipeInput {
+    const __FETCH_MISSING_CARD_MEDIA_QUERY string = `
+    SELECT DISTINCT media FROM CardMedia
+    WHERE media NOT IN (SELECT hash FROM Media)
+    ORDER BY media;
+    `
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_MISSING_CARD_MEDIA_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+var FETCH_SYNC_TOMBSTONES_QUERY = (func() PipeInput {
+    const __FETCH_SYNC_TOMBSTONES_QUERY string = `
+    SELECT uid, kind, deleted_at FROM SyncTombstones ORDER BY deleted_at, uid;
+    `
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_SYNC_TOMBSTONES_QUERY),
+        BuildQueryPipe,
+    )
+}())
+
+// deleted rows are deleted as of the earliest deletion
+var MERGE_SYNC_TOMBSTONE_QUERY = (func() PipeInput {
+    const __MERGE_SYNC_TOMBSTONE_QUERY string = `
+    INSERT OR REPLACE INTO SyncTombstones(uid, kind, deleted_at)
+    VALUES (
+        :uid,
+        :kind,
+        MIN(:deleted_at, COALESCE((SELECT deleted_at FROM SyncTombstones WHERE uid = :uid), :deleted_at))
+    );
+    `
+
+    var requiredInputCols []string = []string{"uid", "kind", "deleted_at"}
+
+    return composePipes(
+        MakeCtxMaker(__MERGE_SYNC_TOMBSTONE_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var FETCH_SYNC_PEER_QUERY = (func() PipeInput {
+    const __FETCH_SYNC_PEER_QUERY string = `
+    SELECT synced_at FROM SyncPeers WHERE peer = :peer;
+    `
+
+    var requiredInputCols []string = []string{"peer"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_SYNC_PEER_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var UPSERT_SYNC_PEER_QUERY = (func() PipeInput {
+    const __UPSERT_SYNC_PEER_QUERY string = `
+    INSERT OR REPLACE INTO SyncPeers(peer, synced_at) VALUES (:peer, strftime('%s', 'now'));
+    `
+
+    var requiredInputCols []string = []string{"peer"}
+
+    return composePipes(
+        MakeCtxMaker(__UPSERT_SYNC_PEER_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var CREATE_SYNC_DECK_QUERY = (func() PipeInput {
+    const __CREATE_SYNC_DECK_QUERY string = `
+    INSERT INTO Decks(uid, name, description) VALUES (:uid, :name, :description);
+    `
+
+    var requiredInputCols []string = []string{"uid", "name", "description"}
+
+    return composePipes(
+        MakeCtxMaker(__CREATE_SYNC_DECK_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var UPDATE_SYNC_DECK_QUERY = (func() PipeInput {
+    const __UPDATE_SYNC_DECK_QUERY string = `
+    UPDATE Decks SET name = :name, description = :description WHERE deck_id = :deck_id;
+    `
+
+    var requiredInputCols []string = []string{"deck_id", "name", "description"}
+
+    return composePipes(
+        MakeCtxMaker(__UPDATE_SYNC_DECK_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// set after the deck is changed; changing a deck sets its modification time
+var SET_DECK_UPDATED_AT_QUERY = (func() PipeInput {
+    const __SET_DECK_UPDATED_AT_QUERY string = `
+    UPDATE Decks SET updated_at = :updated_at WHERE deck_id = :deck_id;
+    `
+
+    var requiredInputCols []string = []string{"deck_id", "updated_at"}
+
+    return composePipes(
+        MakeCtxMaker(__SET_DECK_UPDATED_AT_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var CREATE_SYNC_CARD_QUERY = (func() PipeInput {
+    const __CREATE_SYNC_CARD_QUERY string = `
+    INSERT INTO Cards(uid, title, description, front, back, deck, created_at, updated_at)
+    VALUES (:uid, :title, :description, :front, :back, :deck, :created_at, :updated_at);
+    `
+
+    var requiredInputCols []string = []string{"uid", "title", "description", "front", "back", "deck", "created_at", "updated_at"}
+
+    return composePipes(
+        MakeCtxMaker(__CREATE_SYNC_CARD_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var UPDATE_SYNC_CARD_QUERY = (func() PipeInput {
+    const __UPDATE_SYNC_CARD_QUERY string = `
+    UPDATE Cards
+    SET title = :title, description = :description, front = :front, back = :back, deck = :deck
+    WHERE card_id = :card_id;
+    `
+
+    var requiredInputCols []string = []string{"card_id", "title", "description", "front", "back", "deck"}
+
+    return composePipes(
+        MakeCtxMaker(__UPDATE_SYNC_CARD_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// set after the card is changed; changing a card sets its modification time
+var SET_CARD_UPDATED_AT_QUERY = (func() PipeInput {
+    const __SET_CARD_UPDATED_AT_QUERY string = `
+    UPDATE Cards SET updated_at = :updated_at WHERE card_id = :card_id;
+    `
+
+    var requiredInputCols []string = []string{"card_id", "updated_at"}
+
+    return composePipes(
+        MakeCtxMaker(__SET_CARD_UPDATED_AT_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var CREATE_SYNC_CARD_SCORE_HISTORY_QUERY = (func() PipeInput {
+    const __CREATE_SYNC_CARD_SCORE_HISTORY_QUERY string = `
+    INSERT INTO CardsScoreHistory(uid, card, occured_at, success, fail, score, changelog, prev_success, prev_fail, reviewed)
+    VALUES (:uid, :card, :occured_at, :success, :fail, :score, :changelog, :prev_success, :prev_fail, :reviewed);
+    `
+
+    var requiredInputCols []string = []string{"uid", "card", "occured_at", "success", "fail", "score", "changelog", "prev_success", "prev_fail", "reviewed"}
+
+    return composePipes(
+        MakeCtxMaker(__CREATE_SYNC_CARD_SCORE_HISTORY_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var MOVE_SYNC_CARD_SCORE_HISTORY_QUERY = (func() PipeInput {
+    const __MOVE_SYNC_CARD_SCORE_HISTORY_QUERY string = `
+    UPDATE CardsScoreHistory SET card = :card WHERE uid = :uid;
+    `
+
+    var requiredInputCols []string = []string{"uid", "card"}
+
+    return composePipes(
+        MakeCtxMaker(__MOVE_SYNC_CARD_SCORE_HISTORY_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// score history of a card; in the same order within every database
+var FETCH_SYNC_CARD_REPLAY_HISTORY_QUERY = (func() PipeInput {
+    const __FETCH_SYNC_CARD_REPLAY_HISTORY_QUERY string = `
+    SELECT card, occured_at, success, fail, prev_success, prev_fail, reviewed FROM CardsScoreHistory
+    WHERE card = :card
+    ORDER BY occured_at, uid;
+    `
+
+    var requiredInputCols []string = []string{"card"}
+
+    return composePipes(
+        MakeCtxMaker(__FETCH_SYNC_CARD_REPLAY_HISTORY_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// replace the card's score without recording score history; the latest point of its
+// history gives its modification time and changelog
+var REPLAY_SYNC_CARD_SCORE_QUERY = (func() PipeInput {
+    const __REPLAY_SYNC_CARD_SCORE_QUERY string = `
+    INSERT OR REPLACE INTO CardsScore(card, success, fail, score, times_reviewed, updated_at, changelog)
+    SELECT card, :success, :fail, :score, :times_reviewed, occured_at, changelog
+    FROM CardsScoreHistory
+    WHERE card = :card
+    ORDER BY occured_at DESC, uid DESC
+    LIMIT 1;
+    `
+
+    var requiredInputCols []string = []string{"card", "success", "fail", "score", "times_reviewed"}
+
+    return composePipes(
+        MakeCtxMaker(__REPLAY_SYNC_CARD_SCORE_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var CREATE_SYNC_STASH_QUERY = (func() PipeInput {
+    const __CREATE_SYNC_STASH_QUERY string = `
+    INSERT INTO Stashes(uid, name, description, created_at) VALUES (:uid, :name, :description, :created_at);
+    `
+
+    var requiredInputCols []string = []string{"uid", "name", "description", "created_at"}
+
+    return composePipes(
+        MakeCtxMaker(__CREATE_SYNC_STASH_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+var UPDATE_SYNC_STASH_QUERY = (func() PipeInput {
+    const __UPDATE_SYNC_STASH_QUERY string = `
+    UPDATE Stashes SET name = :name, description = :description WHERE stash_id = :stash_id;
+    `
+
+    var requiredInputCols []string = []string{"stash_id", "name", "description"}
+
+    return composePipes(
+        MakeCtxMaker(__UPDATE_SYNC_STASH_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// a deadline moved into the future unarchives the stash; a passed deadline is archived
+// as usual (see ArchiveExpiredStashes)
+var SET_SYNC_STASH_DEADLINE_QUERY = (func() PipeInput {
+    const __SET_SYNC_STASH_DEADLINE_QUERY string = `
+    INSERT OR IGNORE INTO StashDeadlines(stash, deadline, min_reviews, created_at)
+    VALUES (:stash_id, :deadline, :min_reviews, :created_at);
+
+    UPDATE StashDeadlines
+    SET
+        deadline = :deadline,
+        min_reviews = :min_reviews,
+        created_at = :created_at,
+        archived_at = CASE WHEN :deadline > CAST(strftime('%s', 'now') AS INTEGER) THEN NULL ELSE archived_at END
+    WHERE stash = :stash_id;
+    `
+
+    var requiredInputCols []string = []string{"stash_id", "deadline", "min_reviews", "created_at"}
+
+    return composePipes(
+        MakeCtxMaker(__SET_SYNC_STASH_DEADLINE_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// turn a smart stash into a manual stash; its filter tags are deleted along with it
+var DELETE_SYNC_STASH_FILTER_QUERY = (func() PipeInput {
+    const __DELETE_SYNC_STASH_FILTER_QUERY string = `
+    DELETE FROM StashFilters WHERE stash = :stash_id;
+    `
+
+    var requiredInputCols []string = []string{"stash_id"}
+
+    return composePipes(
+        MakeCtxMaker(__DELETE_SYNC_STASH_FILTER_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
+// set after the stash is changed; changing a stash sets its modification time
+var SET_STASH_UPDATED_AT_QUERY = (func() PipeInput {
+    const __SET_STASH_UPDATED_AT_QUERY string = `
+    UPDATE Stashes SET updated_at = :updated_at WHERE stash_id = :stash_id;
+    `
+
+    var requiredInputCols []string = []string{"stash_id", "updated_at"}
+
+    return composePipes(
+        MakeCtxMaker(__SET_STASH_UPDATED_AT_QUERY),
+        EnsureInputColsPipe(requiredInputCols),
+        BuildQueryPipe,
+    )
+}())
+
 /* helpers */
 
 type StringMap map[string]interface{}
@@ -1730,7 +4902,8 @@ func JSON2Map(rawJSON []byte) (*StringMap, error) {
 
 func MakeCtxMaker(_baseQuery string) func() *QueryContext {
 
-    var baseQuery string = "PRAGMA foreign_keys=ON; " + _baseQuery
+    // note: foreign_keys is set for each connection; see setupConnection
+    var baseQuery string = _baseQuery
 
     return func() *QueryContext {
         var ctx QueryContext
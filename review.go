@@ -41,7 +41,8 @@ type CachedDeckReviewCardRow struct {
 
 // GET /decks/:id/review
 //
-// get card within the deck to be reviewed
+// get card within the deck to be reviewed. assets lists the card's audio and
+// text-to-speech markers (see CardAssets).
 func ReviewDeckGET(db *sqlx.DB, ctx *gin.Context) {
 
     // parse id param
@@ -163,6 +164,7 @@ func ReviewDeckGET(db *sqlx.DB, ctx *gin.Context) {
         &cardrow,
         &gin.H{"review": cardscore},
         &gin.H{"stashes": fetchedStashes},
+        &gin.H{"assets": CardAssets(db, fetchedReviewCardRow)},
     ))
 }
 
@@ -444,7 +446,7 @@ func CardScoreToResponse(cardscore *CardScoreRow) gin.H {
     })
 }
 
-func GetCardScoreRecord(db *sqlx.DB, cardID uint) (*CardScoreRow, error) {
+func GetCardScoreRecord(db sqlx.Ext, cardID uint) (*CardScoreRow, error) {
 
     var (
         err   error
@@ -772,7 +774,7 @@ func GetNextReviewCardOfDeck(db *sqlx.DB, deckID uint, _purgatory_size int) (*Ca
     }
 }
 
-func UpdateCardScore(db *sqlx.DB, cardID uint, patch *StringMap) error {
+func UpdateCardScore(db sqlx.Ext, cardID uint, patch *StringMap) error {
 
     var (
         err   error
@@ -804,6 +806,28 @@ func UpdateCardScore(db *sqlx.DB, cardID uint, patch *StringMap) error {
     return nil
 }
 
+// reset card score such that the card is reviewed as a new card
+func ResetCardScore(db sqlx.Ext, cardID uint) error {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(RESET_CARD_SCORE_QUERY, &StringMap{"card_id": cardID})
+    if err != nil {
+        return err
+    }
+
+    _, err = db.Exec(query, args...)
+    if err != nil {
+        return err
+    }
+
+    return nil
+}
+
 func CountReviewCardsByDeck(db *sqlx.DB, deckID uint) (int, error) {
 
     var (
@@ -892,7 +916,7 @@ func SetCachedReviewCardByDeck(db *sqlx.DB, deckID uint, cardID uint) error {
     return nil
 }
 
-func DeleteCachedReviewCard(db *sqlx.DB, cardID uint) error {
+func DeleteCachedReviewCard(db sqlx.Ext, cardID uint) error {
 
     var (
         err error
@@ -911,7 +935,7 @@ func DeleteCachedReviewCard(db *sqlx.DB, cardID uint) error {
     return nil
 }
 
-func DeleteCachedDeckReviewCard(db *sqlx.DB, cardID uint) error {
+func DeleteCachedDeckReviewCard(db sqlx.Ext, cardID uint) error {
 
     var (
         err   error
@@ -0,0 +1,357 @@
+package main
+
+import (
+    "database/sql"
+    "errors"
+    "net/http"
+    "strconv"
+    "strings"
+
+    // 3rd-party
+    "github.com/gin-gonic/gin"
+    "github.com/jmoiron/sqlx"
+)
+
+/* variables */
+
+// errors
+var ErrCardNoSuchRevision = errors.New("cards: no such revision of given id for card")
+
+/* types */
+
+type CardRevisionRow struct {
+    ID          uint   `db:"revision_id" json:"id"`
+    Title       string `db:"title" json:"title"`
+    Description string `db:"description" json:"description"`
+    Front       string `db:"front" json:"front"`
+    Back        string `db:"back" json:"back"`
+    UpdatedAt   int64  `db:"updated_at" json:"updated_at"`
+    RevisedAt   int64  `db:"revised_at" json:"revised_at"`
+    Card        uint   `db:"card" json:"card"`
+}
+
+/* REST Handlers */
+
+// GET /cards/:id/revisions
+//
+// List previous versions of the card; newest first. Each revision lists the fields
+// that were changed by the version that replaced it.
+//
+// Params:
+// id: a unique, positive integer that is the identifier of the assocoated card
+func CardRevisionsGET(db *sqlx.DB, ctx *gin.Context) {
+
+    var err error
+
+    // parse and validate id param
+    var cardIDString string = strings.ToLower(ctx.Param("id"))
+
+    _cardID, err := strconv.ParseUint(cardIDString, 10, 32)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "given id is invalid",
+        })
+        ctx.Error(err)
+        return
+    }
+    var cardID uint = uint(_cardID)
+
+    var fetchedCardRow *CardRow
+    fetchedCardRow, err = GetCard(db, cardID)
+    switch {
+    case err == ErrCardNoSuchCard:
+        ctx.JSON(http.StatusNotFound, gin.H{
+            "status":           http.StatusNotFound,
+            "developerMessage": err.Error(),
+            "userMessage":      "cannot find card by id",
+        })
+        ctx.Error(err)
+        return
+    case err != nil:
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve card",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var revisions []CardRevisionRow
+    revisions, err = CardRevisions(db, cardID)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve card revisions",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    // the newest revision was replaced by the card's current version
+    var newer CardRevisionRow = CardRevisionRow{
+        Title:       fetchedCardRow.Title,
+        Description: fetchedCardRow.Description,
+        Front:       fetchedCardRow.Front,
+        Back:        fetchedCardRow.Back,
+        UpdatedAt:   fetchedCardRow.UpdatedAt,
+        Card:        fetchedCardRow.ID,
+    }
+
+    var response []gin.H = make([]gin.H, 0, len(revisions))
+
+    for _, revision := range revisions {
+        response = append(response, gin.H{
+            "id":          revision.ID,
+            "card":        revision.Card,
+            "title":       revision.Title,
+            "description": revision.Description,
+            "front":       revision.Front,
+            "back":        revision.Back,
+            "updated_at":  revision.UpdatedAt,
+            "revised_at":  revision.RevisedAt,
+            "changes":     DiffCardRevisions(&revision, &newer),
+        })
+
+        newer = revision
+    }
+
+    ctx.JSON(http.StatusOK, gin.H{
+        "card":      cardID,
+        "revisions": response,
+    })
+}
+
+// POST /cards/:id/revisions/:rev/revert
+//
+// Restore the card's content to the given revision. The card's current version is
+// recorded as a revision; so a revert may be reverted.
+//
+// Params:
+// id: a unique, positive integer that is the identifier of the assocoated card
+// rev: a unique, positive integer that is the identifier of the card's revision
+func CardRevisionRevertPOST(db *sqlx.DB, ctx *gin.Context) {
+
+    var err error
+
+    // parse and validate id params
+    var cardIDString string = strings.ToLower(ctx.Param("id"))
+
+    _cardID, err := strconv.ParseUint(cardIDString, 10, 32)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "given id is invalid",
+        })
+        ctx.Error(err)
+        return
+    }
+    var cardID uint = uint(_cardID)
+
+    var revisionIDString string = strings.ToLower(ctx.Param("rev"))
+
+    _revisionID, err := strconv.ParseUint(revisionIDString, 10, 32)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "given revision id is invalid",
+        })
+        ctx.Error(err)
+        return
+    }
+    var revisionID uint = uint(_revisionID)
+
+    _, err = GetCard(db, cardID)
+    switch {
+    case err == ErrCardNoSuchCard:
+        ctx.JSON(http.StatusNotFound, gin.H{
+            "status":           http.StatusNotFound,
+            "developerMessage": err.Error(),
+            "userMessage":      "cannot find card by id",
+        })
+        ctx.Error(err)
+        return
+    case err != nil:
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve card",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var revision *CardRevisionRow
+    revision, err = GetCardRevision(db, cardID, revisionID)
+    switch {
+    case err == ErrCardNoSuchRevision:
+        ctx.JSON(http.StatusNotFound, gin.H{
+            "status":           http.StatusNotFound,
+            "developerMessage": err.Error(),
+            "userMessage":      "cannot find revision of card by id",
+        })
+        ctx.Error(err)
+        return
+    case err != nil:
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve card revision",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    err = PatchCard(db, cardID, &StringMap{
+        "title":       revision.Title,
+        "description": revision.Description,
+        "front":       revision.Front,
+        "back":        revision.Back,
+    })
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to revert card",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var fetchedCardRow *CardRow
+    fetchedCardRow, err = GetCard(db, cardID)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve card",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    // fetch card score
+    var fetchedCardScore *CardScoreRow
+    fetchedCardScore, err = GetCardScoreRecord(db, cardID)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve card score record",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var fetchedStashes []uint
+    fetchedStashes, err = StashesByCard(db, cardID)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve card stashes",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var cardrow gin.H = CardRowToResponse(db, fetchedCardRow)
+    var cardscore gin.H = CardScoreToResponse(fetchedCardScore)
+
+    ctx.JSON(http.StatusOK, MergeResponses(
+        &cardrow,
+        &gin.H{"review": cardscore},
+        &gin.H{"stashes": fetchedStashes},
+    ))
+}
+
+/* helpers */
+
+// list fields that differ between the older and newer versions of a card
+func DiffCardRevisions(older *CardRevisionRow, newer *CardRevisionRow) []gin.H {
+
+    var changes []gin.H = []gin.H{}
+
+    var fields = []struct {
+        name  string
+        older string
+        newer string
+    }{
+        {"title", older.Title, newer.Title},
+        {"description", older.Description, newer.Description},
+        {"front", older.Front, newer.Front},
+        {"back", older.Back, newer.Back},
+    }
+
+    for _, field := range fields {
+        if field.older == field.newer {
+            continue
+        }
+
+        changes = append(changes, gin.H{
+            "field": field.name,
+            "from":  field.older,
+            "to":    field.newer,
+        })
+    }
+
+    return changes
+}
+
+// fetch revisions of the card; newest first
+func CardRevisions(db sqlx.Ext, cardID uint) ([]CardRevisionRow, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(FETCH_CARD_REVISIONS_QUERY, &StringMap{"card_id": cardID})
+    if err != nil {
+        return nil, err
+    }
+
+    var revisions []CardRevisionRow = []CardRevisionRow{}
+    err = sqlx.Select(db, &revisions, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    return revisions, nil
+}
+
+func GetCardRevision(db sqlx.Ext, cardID uint, revisionID uint) (*CardRevisionRow, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(FETCH_CARD_REVISION_QUERY, &StringMap{
+        "card_id":     cardID,
+        "revision_id": revisionID,
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    var fetchedRevision *CardRevisionRow = &CardRevisionRow{}
+
+    err = db.QueryRowx(query, args...).StructScan(fetchedRevision)
+
+    switch {
+    case err == sql.ErrNoRows:
+        return nil, ErrCardNoSuchRevision
+    case err != nil:
+        return nil, err
+    default:
+        return fetchedRevision, nil
+    }
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+    "database/sql"
+    "errors"
+    "net/http"
+    "strconv"
+    "strings"
+
+    // 3rd-party
+    "github.com/gin-gonic/gin"
+    "github.com/jmoiron/sqlx"
+)
+
+/* variables */
+
+// errors
+var ErrStashIsSmart = errors.New("stashes: cards of a smart stash are given by its filter; cards cannot be added or removed")
+var ErrStashNotSmart = errors.New("stashes: stash is not a smart stash")
+var ErrStashEmptyFilter = errors.New("stashes: filter must have at least one of: deck, tags, search, score_min, score_max, times_reviewed_min, times_reviewed_max, reviewed_before, reviewed_after")
+var ErrStashInvalidScoreRange = errors.New("stashes: score_min and score_max must be within [0, 1] with score_min <= score_max")
+var ErrStashInvalidReviewRange = errors.New("stashes: times_reviewed_min must be <= times_reviewed_max")
+var ErrStashInvalidSearch = errors.New("stashes: filter search is not a valid full-text search query")
+
+/* types */
+
+// saved filter of a smart stash. every given criteria must match; nil criteria are ignored.
+type StashFilter struct {
+    Deck             *uint    `db:"deck" json:"deck"`
+    Tags             []string `db:"-" json:"tags"`
+    Search           *string  `db:"search" json:"search"`
+    ScoreMin         *float64 `db:"score_min" json:"score_min"`
+    ScoreMax         *float64 `db:"score_max" json:"score_max"`
+    TimesReviewedMin *uint    `db:"times_reviewed_min" json:"times_reviewed_min"`
+    TimesReviewedMax *uint    `db:"times_reviewed_max" json:"times_reviewed_max"`
+    ReviewedBefore   *int64   `db:"reviewed_before" json:"reviewed_before"`
+    ReviewedAfter    *int64   `db:"reviewed_after" json:"reviewed_after"`
+}
+
+/* REST Handlers */
+
+// PUT /stashes/:id/filter
+//
+// Replace the saved filter of a smart stash.
+//
+// Params:
+// id: a unique, positive integer that is the identifier of the smart stash
+//
+// Input:
+// deck: cards within the deck subtree
+// tags: cards with every tag
+// search: full-text search query over the cards' content
+// score_min, score_max: range of the cards' review score; within [0, 1]
+// times_reviewed_min, times_reviewed_max: range of the number of times cards were reviewed
+// reviewed_before, reviewed_after: unix timestamps; cards last reviewed (or created, if never
+//                                  reviewed) before/after the given time
+func StashFilterPUT(db *sqlx.DB, ctx *gin.Context) {
+
+    var err error
+
+    // parse and validate id param
+    var stashIDString string = strings.ToLower(ctx.Param("id"))
+
+    _stashID, err := strconv.ParseUint(stashIDString, 10, 32)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "given id is invalid",
+        })
+        ctx.Error(err)
+        return
+    }
+    var stashID uint = uint(_stashID)
+
+    var filter StashFilter
+    err = ctx.BindJSON(&filter)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "bad JSON input",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var tx *sqlx.Tx
+    tx, err = db.Beginx()
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to update stash filter",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var fetchedStashRow *StashRow
+    fetchedStashRow, err = GetStash(tx, stashID)
+    switch {
+    case err == ErrStashNoSuchStash:
+        tx.Rollback()
+        ctx.JSON(http.StatusNotFound, gin.H{
+            "status":           http.StatusNotFound,
+            "developerMessage": err.Error(),
+            "userMessage":      "cannot find stash by id",
+        })
+        ctx.Error(err)
+        return
+    case err != nil:
+        tx.Rollback()
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve stash",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    _, err = GetStashFilter(tx, stashID)
+    switch {
+    case err == ErrStashNotSmart:
+        tx.Rollback()
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      err.Error(),
+        })
+        ctx.Error(err)
+        return
+    case err != nil:
+        tx.Rollback()
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve stash filter",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    err = ValidateStashFilter(tx, &filter)
+    if err != nil {
+        tx.Rollback()
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      err.Error(),
+        })
+        ctx.Error(err)
+        return
+    }
+
+    err = SetStashFilter(tx, stashID, &filter)
+    if err != nil {
+        tx.Rollback()
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to update stash filter",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    // the cached review card may no longer match the filter
+    err = DeleteCachedReviewCardByStash(tx, stashID)
+    if err != nil {
+        tx.Rollback()
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to update stash filter",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    err = tx.Commit()
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to update stash filter",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    ctx.JSON(http.StatusOK, StashRowToResponse(db, fetchedStashRow))
+}
+
+/* helpers */
+
+// validate and normalize filter in-place
+func ValidateStashFilter(db sqlx.Ext, filter *StashFilter) error {
+
+    var err error
+
+    if filter.Search != nil {
+        var search string = strings.TrimSpace(*filter.Search)
+
+        if len(search) <= 0 {
+            filter.Search = nil
+        } else {
+            filter.Search = &search
+        }
+    }
+
+    var tags []string = make([]string, 0, len(filter.Tags))
+    for _, tag := range filter.Tags {
+        tag, err = NormalizeTag(tag)
+        if err != nil {
+            return err
+        }
+        tags = append(tags, tag)
+    }
+    filter.Tags = tags
+
+    if filter.Deck == nil && len(filter.Tags) <= 0 && filter.Search == nil &&
+        filter.ScoreMin == nil && filter.ScoreMax == nil &&
+        filter.TimesReviewedMin == nil && filter.TimesReviewedMax == nil &&
+        filter.ReviewedBefore == nil && filter.ReviewedAfter == nil {
+        return ErrStashEmptyFilter
+    }
+
+    for _, score := range []*float64{filter.ScoreMin, filter.ScoreMax} {
+        if score != nil && (*score < 0 || *score > 1) {
+            return ErrStashInvalidScoreRange
+        }
+    }
+
+    if filter.ScoreMin != nil && filter.ScoreMax != nil && *filter.ScoreMin > *filter.ScoreMax {
+        return ErrStashInvalidScoreRange
+    }
+
+    if filter.TimesReviewedMin != nil && filter.TimesReviewedMax != nil &&
+        *filter.TimesReviewedMin > *filter.TimesReviewedMax {
+        return ErrStashInvalidReviewRange
+    }
+
+    if filter.Deck != nil {
+        _, err = GetDeck(db, *filter.Deck)
+        if err != nil {
+            return err
+        }
+    }
+
+    if filter.Search != nil {
+
+        var (
+            query string
+            args  []interface{}
+        )
+
+        query, args, err = QueryApply(COUNT_CARDS_BY_SEARCH_QUERY, &StringMap{"search": *filter.Search})
+        if err != nil {
+            return err
+        }
+
+        var count uint
+        err = db.QueryRowx(query, args...).Scan(&count)
+        if err != nil {
+            return ErrStashInvalidSearch
+        }
+    }
+
+    return nil
+}
+
+// fetch the saved filter of a smart stash; ErrStashNotSmart if the stash is a manual stash
+func GetStashFilter(db sqlx.Ext, stashID uint) (*StashFilter, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(FETCH_STASH_FILTER_QUERY, &StringMap{"stash_id": stashID})
+    if err != nil {
+        return nil, err
+    }
+
+    var filter *StashFilter = &StashFilter{}
+
+    err = db.QueryRowx(query, args...).StructScan(filter)
+
+    switch {
+    case err == sql.ErrNoRows:
+        return nil, ErrStashNotSmart
+    case err != nil:
+        return nil, err
+    }
+
+    query, args, err = QueryApply(FETCH_STASH_FILTER_TAGS_QUERY, &StringMap{"stash_id": stashID})
+    if err != nil {
+        return nil, err
+    }
+
+    filter.Tags = []string{}
+    err = sqlx.Select(db, &filter.Tags, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    return filter, nil
+}
+
+// save the filter of the stash; making it a smart stash. the filter is assumed to be validated.
+func SetStashFilter(db sqlx.Ext, stashID uint, filter *StashFilter) error {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(SET_STASH_FILTER_QUERY, &StringMap{
+        "stash_id":           stashID,
+        "deck":               filter.Deck,
+        "search":             filter.Search,
+        "score_min":          filter.ScoreMin,
+        "score_max":          filter.ScoreMax,
+        "times_reviewed_min": filter.TimesReviewedMin,
+        "times_reviewed_max": filter.TimesReviewedMax,
+        "reviewed_before":    filter.ReviewedBefore,
+        "reviewed_after":     filter.ReviewedAfter,
+    })
+    if err != nil {
+        return err
+    }
+
+    _, err = db.Exec(query, args...)
+    if err != nil {
+        return err
+    }
+
+    for _, tag := range filter.Tags {
+
+        query, args, err = QueryApply(ADD_STASH_FILTER_TAG_QUERY, &StringMap{
+            "stash_id": stashID,
+            "tag":      tag,
+        })
+        if err != nil {
+            return err
+        }
+
+        _, err = db.Exec(query, args...)
+        if err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+func StashIsSmart(db sqlx.Ext, stashID uint) (bool, error) {
+
+    _, err := GetStashFilter(db, stashID)
+
+    switch {
+    case err == ErrStashNotSmart:
+        return false, nil
+    case err != nil:
+        return false, err
+    }
+
+    return true, nil
+}
@@ -19,6 +19,8 @@ var ErrStashNoSuchStash = errors.New("stashes: no such stash of given id")
 var ErrStashNoCardsByStash = errors.New("stashes: stash has no cards")
 var ErrStashHasNoCachedReviewCard = errors.New("stash: no cached review card for stash")
 var ErrStashNoStashes = errors.New("stash: no stashes")
+var ErrStashInvalidSelection = errors.New("stash: exactly one of card_id, cards, or deck/search must be given")
+var ErrStashHasNoParent = errors.New("stashes: stash has no parent")
 
 /* types */
 
@@ -36,19 +38,33 @@ type StashRow struct {
 }
 
 type StashPOSTRequest struct {
-    Name        string `json:"name" binding:"required"`
-    Description string `json:"description"`
+    Name        string       `json:"name" binding:"required"`
+    Description string       `json:"description"`
+    Filter      *StashFilter `json:"filter"`
+    Parent      uint         `json:"parent"`
+    Deadline    int64        `json:"deadline"`
+    MinReviews  uint         `json:"min_reviews"`
 }
 
 type StashPUTRequest struct {
     Action string `json:"action" binding:"required"`
-    CardID uint   `json:"card_id" binding:"required"`
+    CardID uint   `json:"card_id"`
+    Cards  []uint `json:"cards"`
+    Deck   uint   `json:"deck"`
+    Search string `json:"search"`
+}
+
+type StashRelationship struct {
+    Ancestor   uint
+    Descendent uint
+    Depth      uint
 }
 
 type CachedStashReviewCardRow struct {
-    Card      uint  `db:"card"`
-    Stash     uint  `db:"stash"`
-    CreatedAt int64 `db:"created_at"`
+    Card      uint   `db:"card"`
+    Stash     uint   `db:"stash"`
+    Mode      string `db:"mode"`
+    CreatedAt int64  `db:"created_at"`
 }
 
 /* REST Handlers */
@@ -72,6 +88,17 @@ func StashGET(db *sqlx.DB, ctx *gin.Context) {
     }
     var stashID uint = uint(_stashID)
 
+    err = ArchiveExpiredStashes(db)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to archive expired stashes",
+        })
+        ctx.Error(err)
+        return
+    }
+
     // fetch stash row from the db
 
     var fetchedStashRow *StashRow
@@ -98,12 +125,39 @@ func StashGET(db *sqlx.DB, ctx *gin.Context) {
     ctx.JSON(http.StatusOK, StashRowToResponse(db, fetchedStashRow))
 }
 
+// GET /stashes
+//
+// Query params:
+// archived: if true, include stashes archived once their deadline passed (default: false)
 func StashListGET(db *sqlx.DB, ctx *gin.Context) {
 
     var err error
 
+    var includeArchived bool
+    includeArchived, err = strconv.ParseBool(ctx.DefaultQuery("archived", "false"))
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "archived must be a boolean",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    err = ArchiveExpiredStashes(db)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to archive expired stashes",
+        })
+        ctx.Error(err)
+        return
+    }
+
     var stashes *([]StashRow)
-    stashes, err = StashList(db)
+    stashes, err = StashList(db, includeArchived)
     switch {
     case err == ErrStashNoStashes:
         ctx.JSON(http.StatusNotFound, gin.H{
@@ -132,6 +186,13 @@ func StashListGET(db *sqlx.DB, ctx *gin.Context) {
     ctx.JSON(http.StatusOK, response)
 }
 
+// POST /stashes
+//
+// Input:
+// name: name of the stash
+// description: description of the stash
+// filter: if given, the stash is a smart stash whose cards are those matching the filter;
+//         see StashFilterPUT
 func StashPOST(db *sqlx.DB, ctx *gin.Context) {
 
     // parse request
@@ -152,13 +213,140 @@ func StashPOST(db *sqlx.DB, ctx *gin.Context) {
         return
     }
 
+    var tx *sqlx.Tx
+    tx, err = db.Beginx()
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to create new stash",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    // a smart stash's cards are given by its filter
+    if jsonRequest.Filter != nil {
+        err = ValidateStashFilter(tx, jsonRequest.Filter)
+        if err != nil {
+            tx.Rollback()
+            ctx.JSON(http.StatusBadRequest, gin.H{
+                "status":           http.StatusBadRequest,
+                "developerMessage": err.Error(),
+                "userMessage":      err.Error(),
+            })
+            ctx.Error(err)
+            return
+        }
+    }
+
+    // validate deadline, if given
+    var deadline *StashDeadlineRequest
+    if jsonRequest.Deadline != 0 {
+        deadline = &StashDeadlineRequest{
+            Deadline:   jsonRequest.Deadline,
+            MinReviews: jsonRequest.MinReviews,
+        }
+
+        err = ValidateStashDeadline(deadline)
+        if err != nil {
+            tx.Rollback()
+            ctx.JSON(http.StatusBadRequest, gin.H{
+                "status":           http.StatusBadRequest,
+                "developerMessage": err.Error(),
+                "userMessage":      err.Error(),
+            })
+            ctx.Error(err)
+            return
+        }
+    }
+
+    // validate parent stash, if given
+    if jsonRequest.Parent > 0 {
+        _, err = GetStash(tx, jsonRequest.Parent)
+        switch {
+        case err == ErrStashNoSuchStash:
+            tx.Rollback()
+            ctx.JSON(http.StatusBadRequest, gin.H{
+                "status":           http.StatusBadRequest,
+                "developerMessage": err.Error(),
+                "userMessage":      "given parent id is invalid",
+            })
+            ctx.Error(err)
+            return
+        case err != nil:
+            tx.Rollback()
+            ctx.JSON(http.StatusInternalServerError, gin.H{
+                "status":           http.StatusInternalServerError,
+                "developerMessage": err.Error(),
+                "userMessage":      "unable to retrieve parent stash",
+            })
+            ctx.Error(err)
+            return
+        }
+    }
+
     // create stash
     var newStashRow *StashRow
 
-    newStashRow, err = CreateStash(db, &StashProps{
+    newStashRow, err = CreateStash(tx, &StashProps{
         Name:        jsonRequest.Name,
         Description: jsonRequest.Description,
     })
+    if err != nil {
+        tx.Rollback()
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to create new stash",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    if jsonRequest.Filter != nil {
+        err = SetStashFilter(tx, newStashRow.ID, jsonRequest.Filter)
+        if err != nil {
+            tx.Rollback()
+            ctx.JSON(http.StatusInternalServerError, gin.H{
+                "status":           http.StatusInternalServerError,
+                "developerMessage": err.Error(),
+                "userMessage":      "unable to create new stash",
+            })
+            ctx.Error(err)
+            return
+        }
+    }
+
+    if jsonRequest.Parent > 0 {
+        err = CreateStashRelationship(tx, jsonRequest.Parent, newStashRow.ID)
+        if err != nil {
+            tx.Rollback()
+            ctx.JSON(http.StatusInternalServerError, gin.H{
+                "status":           http.StatusInternalServerError,
+                "developerMessage": err.Error(),
+                "userMessage":      "unable to create new stash",
+            })
+            ctx.Error(err)
+            return
+        }
+    }
+
+    if deadline != nil {
+        err = SetStashDeadline(tx, newStashRow.ID, deadline)
+        if err != nil {
+            tx.Rollback()
+            ctx.JSON(http.StatusInternalServerError, gin.H{
+                "status":           http.StatusInternalServerError,
+                "developerMessage": err.Error(),
+                "userMessage":      "unable to create new stash",
+            })
+            ctx.Error(err)
+            return
+        }
+    }
+
+    err = tx.Commit()
     if err != nil {
         ctx.JSON(http.StatusInternalServerError, gin.H{
             "status":           http.StatusInternalServerError,
@@ -213,8 +401,17 @@ func StashDELETE(db *sqlx.DB, ctx *gin.Context) {
         return
     }
 
-    // delete stash
-    err = DeleteStash(db, stashID)
+    // delete stash; child stashes become top-level stashes
+    var tx *sqlx.Tx
+    tx, err = db.Beginx()
+    if err == nil {
+        err = DeleteStash(tx, stashID)
+        if err == nil {
+            err = tx.Commit()
+        } else {
+            tx.Rollback()
+        }
+    }
     if err != nil {
         ctx.JSON(http.StatusInternalServerError, gin.H{
             "status":           http.StatusInternalServerError,
@@ -359,57 +556,166 @@ func StashPATCH(db *sqlx.DB, ctx *gin.Context) {
         return
     }
 
-    // generate SQL to patch stash
+    // case: move stash subtree under another stash; or make it a top-level stash
     var (
-        query string
-        args  []interface{}
+        movedSubtree bool = false
+        skipPatch    bool = false
     )
 
-    query, args, err = QueryApply(UPDATE_STASH_QUERY, &StringMap{"stash_id": stashID}, patch)
-    if err != nil {
-        ctx.JSON(http.StatusInternalServerError, gin.H{
-            "status":           http.StatusInternalServerError,
-            "developerMessage": err.Error(),
-            "userMessage":      "unable to generate patch stash SQL",
-        })
-        ctx.Error(err)
-        return
-    }
+    if _, hasParentKey := (*patch)["parent"]; hasParentKey == true {
+
+        // validate parent param; 0 or null for a top-level stash
+        var parentID uint
+        parentID, err = (func() (uint, error) {
+            switch _parentID := (*patch)["parent"].(type) {
+            case nil:
+                return 0, nil
+            // JSON numbers are converted to float64
+            case float64:
+                if _parentID >= 0 && _parentID == float64(uint(_parentID)) {
+                    return uint(_parentID), nil
+                }
+            }
+            return 0, errors.New("target parent is invalid")
+        }())
 
-    var res sql.Result
-    res, err = db.Exec(query, args...)
-    if err != nil {
-        // TODO: transaction rollback
-        ctx.JSON(http.StatusInternalServerError, gin.H{
-            "status":           http.StatusInternalServerError,
-            "developerMessage": err.Error(),
-            "userMessage":      "unable to patch stash",
-        })
-        ctx.Error(err)
-        return
-    }
+        if err != nil {
+            ctx.JSON(http.StatusBadRequest, gin.H{
+                "status":           http.StatusBadRequest,
+                "developerMessage": err.Error(),
+                "userMessage":      err.Error(),
+            })
+            ctx.Error(err)
+            return
+        }
 
-    // ensure stash is patched
-    num, err := res.RowsAffected()
-    if err != nil {
-        // TODO: transaction rollback
-        ctx.JSON(http.StatusInternalServerError, gin.H{
-            "status":           http.StatusInternalServerError,
-            "developerMessage": err.Error(),
-            "userMessage":      "unable to patch stash",
-        })
-        ctx.Error(err)
-        return
+        if parentID > 0 {
+
+            // validate target parent exists
+            _, err = GetStash(db, parentID)
+            switch {
+            case err == ErrStashNoSuchStash:
+                ctx.JSON(http.StatusBadRequest, gin.H{
+                    "status":           http.StatusBadRequest,
+                    "developerMessage": err.Error(),
+                    "userMessage":      "given parent id is invalid",
+                })
+                ctx.Error(err)
+                return
+            case err != nil:
+                ctx.JSON(http.StatusInternalServerError, gin.H{
+                    "status":           http.StatusInternalServerError,
+                    "developerMessage": err.Error(),
+                    "userMessage":      "unable to retrieve stash",
+                })
+                ctx.Error(err)
+                return
+            }
+
+            // cannot move stash under itself or any of its descendents
+            var isDescendent bool
+            isDescendent, err = StashHasDescendent(db, stashID, parentID)
+            if err != nil {
+                ctx.JSON(http.StatusInternalServerError, gin.H{
+                    "status":           http.StatusInternalServerError,
+                    "developerMessage": err.Error(),
+                    "userMessage":      "unable to move stash",
+                })
+                ctx.Error(err)
+                return
+            }
+
+            if isDescendent {
+                ctx.JSON(http.StatusBadRequest, gin.H{
+                    "status":           http.StatusBadRequest,
+                    "developerMessage": "cannot move stash under itself or any of its descendents",
+                    "userMessage":      "cannot move stash under itself or any of its descendents",
+                })
+                return
+            }
+        }
+
+        // move stash
+        var tx *sqlx.Tx
+        tx, err = db.Beginx()
+        if err == nil {
+            err = MoveStash(tx, stashID, parentID)
+            if err == nil {
+                err = tx.Commit()
+            } else {
+                tx.Rollback()
+            }
+        }
+        if err != nil {
+            ctx.JSON(http.StatusInternalServerError, gin.H{
+                "status":           http.StatusInternalServerError,
+                "developerMessage": err.Error(),
+                "userMessage":      "unable to move stash",
+            })
+            ctx.Error(err)
+            return
+        }
+
+        // control flow
+        movedSubtree = true
+        if len(*patch) <= 1 {
+            skipPatch = true
+        }
     }
 
-    if num <= 0 {
-        // TODO: transaction rollback
-        ctx.JSON(http.StatusBadRequest, gin.H{
-            "status":           http.StatusBadRequest,
-            "developerMessage": "given JSON is invalid",
-            "userMessage":      "given JSON is invalid",
-        })
-        return
+    // generate SQL to patch stash
+    if !skipPatch {
+        var (
+            query string
+            args  []interface{}
+        )
+
+        query, args, err = QueryApply(UPDATE_STASH_QUERY, &StringMap{"stash_id": stashID}, patch)
+        if err != nil {
+            ctx.JSON(http.StatusInternalServerError, gin.H{
+                "status":           http.StatusInternalServerError,
+                "developerMessage": err.Error(),
+                "userMessage":      "unable to generate patch stash SQL",
+            })
+            ctx.Error(err)
+            return
+        }
+
+        var res sql.Result
+        res, err = db.Exec(query, args...)
+        if err != nil {
+            // TODO: transaction rollback
+            ctx.JSON(http.StatusInternalServerError, gin.H{
+                "status":           http.StatusInternalServerError,
+                "developerMessage": err.Error(),
+                "userMessage":      "unable to patch stash",
+            })
+            ctx.Error(err)
+            return
+        }
+
+        // ensure stash is patched
+        num, err := res.RowsAffected()
+        if err != nil {
+            // TODO: transaction rollback
+            ctx.JSON(http.StatusInternalServerError, gin.H{
+                "status":           http.StatusInternalServerError,
+                "developerMessage": err.Error(),
+                "userMessage":      "unable to patch stash",
+            })
+            ctx.Error(err)
+            return
+        }
+
+        if num <= 0 && !movedSubtree {
+            // TODO: transaction rollback
+            ctx.JSON(http.StatusBadRequest, gin.H{
+                "status":           http.StatusBadRequest,
+                "developerMessage": "given JSON is invalid",
+                "userMessage":      "given JSON is invalid",
+            })
+            return
+        }
     }
 
     var (
@@ -441,6 +747,17 @@ func StashPATCH(db *sqlx.DB, ctx *gin.Context) {
     ctx.JSON(http.StatusOK, StashRowToResponse(db, fetchedStashRow))
 }
 
+// PUT /stashes/:id
+//
+// Add or remove cards of a manual stash within a single transaction. Responds with
+// 204 for a single card_id; otherwise with a count of changed cards.
+//
+// Input:
+// action: one of: add, remove
+// card_id: a card id
+// cards: list of card ids; instead of card_id
+// deck: cards within the deck subtree; instead of card_id or cards
+// search: cards matching the full-text search query; may be combined with deck
 func StashPUT(db *sqlx.DB, ctx *gin.Context) {
 
     var err error
@@ -509,42 +826,191 @@ func StashPUT(db *sqlx.DB, ctx *gin.Context) {
         return
     }
 
-    // ensure card id exists
+    // ensure stash is a manual stash
 
-    _, err = GetCard(db, jsonRequest.CardID)
+    var smart bool
+    smart, err = StashIsSmart(db, stashID)
     switch {
-    case err == ErrCardNoSuchCard:
-        ctx.JSON(http.StatusNotFound, gin.H{
-            "status":           http.StatusNotFound,
+    case err != nil:
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
             "developerMessage": err.Error(),
-            "userMessage":      "cannot find card by id",
+            "userMessage":      "unable to retrieve stash",
         })
         ctx.Error(err)
         return
-    case err != nil:
+    case smart:
+        err = ErrStashIsSmart
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      err.Error(),
+        })
+        ctx.Error(err)
+        return
+    }
+
+    // resolve cards to process
+
+    var (
+        cardIDs   []uint = dedupeCardIDs(jsonRequest.Cards)
+        byFilter  bool   = jsonRequest.Deck > 0 || len(strings.TrimSpace(jsonRequest.Search)) > 0
+        selection int    = 0
+    )
+
+    if jsonRequest.CardID > 0 {
+        selection++
+        cardIDs = []uint{jsonRequest.CardID}
+    }
+
+    if len(jsonRequest.Cards) > 0 {
+        selection++
+    }
+
+    if byFilter {
+        selection++
+    }
+
+    if selection != 1 {
+        err = ErrStashInvalidSelection
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      err.Error(),
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var tx *sqlx.Tx
+    tx, err = db.Beginx()
+    if err != nil {
         ctx.JSON(http.StatusInternalServerError, gin.H{
             "status":           http.StatusInternalServerError,
             "developerMessage": err.Error(),
-            "userMessage":      "unable to retrieve card",
+            "userMessage":      "unable to process cards with stash",
         })
         ctx.Error(err)
         return
     }
 
-    err = ProcessCardWithStash(db, stashID, jsonRequest.Action, jsonRequest.CardID)
+    if byFilter {
+
+        if jsonRequest.Deck > 0 {
+            _, err = GetDeck(tx, jsonRequest.Deck)
+            switch {
+            case err == ErrDeckNoSuchDeck:
+                tx.Rollback()
+                ctx.JSON(http.StatusNotFound, gin.H{
+                    "status":           http.StatusNotFound,
+                    "developerMessage": err.Error(),
+                    "userMessage":      "cannot find deck by id",
+                })
+                ctx.Error(err)
+                return
+            case err != nil:
+                tx.Rollback()
+                ctx.JSON(http.StatusInternalServerError, gin.H{
+                    "status":           http.StatusInternalServerError,
+                    "developerMessage": err.Error(),
+                    "userMessage":      "unable to retrieve deck",
+                })
+                ctx.Error(err)
+                return
+            }
+        }
+
+        cardIDs, err = CardIDsByFilter(tx, jsonRequest.Deck, 0, "", strings.TrimSpace(jsonRequest.Search))
+        if err != nil {
+            tx.Rollback()
+            ctx.JSON(http.StatusBadRequest, gin.H{
+                "status":           http.StatusBadRequest,
+                "developerMessage": err.Error(),
+                "userMessage":      "unable to find cards with given deck or search",
+            })
+            ctx.Error(err)
+            return
+        }
+    }
+
+    var (
+        changed   uint = 0
+        unchanged uint = 0
+    )
+
+    for _, cardID := range cardIDs {
+
+        // ensure card id exists
 
+        _, err = GetCard(tx, cardID)
+        switch {
+        case err == ErrCardNoSuchCard:
+            tx.Rollback()
+            ctx.JSON(http.StatusNotFound, gin.H{
+                "status":           http.StatusNotFound,
+                "developerMessage": fmt.Sprintf("%s: %d", err.Error(), cardID),
+                "userMessage":      "cannot find card by id",
+            })
+            ctx.Error(err)
+            return
+        case err != nil:
+            tx.Rollback()
+            ctx.JSON(http.StatusInternalServerError, gin.H{
+                "status":           http.StatusInternalServerError,
+                "developerMessage": err.Error(),
+                "userMessage":      "unable to retrieve card",
+            })
+            ctx.Error(err)
+            return
+        }
+
+        var connected bool
+        connected, err = CardConnectedWithStash(tx, stashID, cardID)
+        if err == nil {
+            err = ProcessCardWithStash(tx, stashID, jsonRequest.Action, cardID)
+        }
+
+        if err != nil {
+            tx.Rollback()
+            ctx.JSON(http.StatusInternalServerError, gin.H{
+                "status":           http.StatusInternalServerError,
+                "developerMessage": err.Error(),
+                "userMessage":      "unable to process card with stash",
+            })
+            ctx.Error(err)
+            return
+        }
+
+        if connected == (jsonRequest.Action == "add") {
+            unchanged++
+        } else {
+            changed++
+        }
+    }
+
+    err = tx.Commit()
     if err != nil {
         ctx.JSON(http.StatusInternalServerError, gin.H{
             "status":           http.StatusInternalServerError,
             "developerMessage": err.Error(),
-            "userMessage":      "unable to process card with stash",
+            "userMessage":      "unable to process cards with stash",
         })
         ctx.Error(err)
         return
     }
 
-    // success
-    ctx.Writer.WriteHeader(http.StatusNoContent)
+    if jsonRequest.CardID > 0 {
+        // success
+        ctx.Writer.WriteHeader(http.StatusNoContent)
+        return
+    }
+
+    ctx.JSON(http.StatusOK, gin.H{
+        "action":    jsonRequest.Action,
+        "total":     len(cardIDs),
+        "changed":   changed,
+        "unchanged": unchanged,
+    })
 }
 
 func StashCardsCountGET(db *sqlx.DB, ctx *gin.Context) {
@@ -653,8 +1119,16 @@ func StashCardsGET(db *sqlx.DB, ctx *gin.Context) {
     }
     var per_page uint = uint(_per_page)
 
-    // parse sort order
-    var orderQueryString string = strings.ToUpper(ctx.DefaultQuery("order", "DESC"))
+    // parse sort metric query
+    var sortQueryString string = ctx.DefaultQuery("sort", "reviewed_at")
+
+    // parse sort order; cards sorted by position are listed from first to last by default
+    var defaultOrder string = "DESC"
+    if sortQueryString == "position" {
+        defaultOrder = "ASC"
+    }
+
+    var orderQueryString string = strings.ToUpper(ctx.DefaultQuery("order", defaultOrder))
 
     switch {
     case orderQueryString == "DESC":
@@ -668,11 +1142,10 @@ func StashCardsGET(db *sqlx.DB, ctx *gin.Context) {
         return
     }
 
-    // parse sort metric query
-    var sortQueryString string = ctx.DefaultQuery("sort", "reviewed_at")
-
     var query PipeInput
     switch {
+    case sortQueryString == "position":
+        query = FETCH_CARDS_BY_STASH_SORT_POSITION_QUERY(orderQueryString)
     case sortQueryString == "created_at":
         query = FETCH_CARDS_BY_STASH_SORT_CREATED_QUERY(orderQueryString)
     case sortQueryString == "updated_at":
@@ -805,6 +1278,21 @@ func ReviewStashGET(db *sqlx.DB, ctx *gin.Context) {
     }
     var stashID uint = uint(_stashID)
 
+    // parse review mode
+    var mode string = strings.ToLower(ctx.DefaultQuery("mode", STASH_REVIEW_MODE_SCORE))
+
+    switch mode {
+    case STASH_REVIEW_MODE_SCORE:
+    case STASH_REVIEW_MODE_SEQUENTIAL:
+    default:
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": "invalid mode query",
+            "userMessage":      "invalid mode query",
+        })
+        return
+    }
+
     // ensure stash exists
     _, err = GetStash(db, stashID)
     switch {
@@ -853,7 +1341,11 @@ func ReviewStashGET(db *sqlx.DB, ctx *gin.Context) {
 
     // fetch review card
     var fetchedReviewCardRow *CardRow
-    fetchedReviewCardRow, err = GetNextReviewCardOfStash(db, stashID, purgatory_size)
+    if mode == STASH_REVIEW_MODE_SEQUENTIAL {
+        fetchedReviewCardRow, err = GetNextSequentialReviewCardOfStash(db, stashID)
+    } else {
+        fetchedReviewCardRow, err = GetNextReviewCardOfStash(db, stashID, purgatory_size)
+    }
 
     switch {
     case err == ErrCardNoSuchCard:
@@ -907,6 +1399,7 @@ func ReviewStashGET(db *sqlx.DB, ctx *gin.Context) {
         &cardrow,
         &gin.H{"review": cardscore},
         &gin.H{"stashes": fetchedStashes},
+        &gin.H{"assets": CardAssets(db, fetchedReviewCardRow)},
     ))
 }
 
@@ -919,6 +1412,13 @@ func StashResponse(overrides *gin.H) gin.H {
         "description": "",
         "created_at":  0,
         "updated_at":  0,
+        "kind":        "manual",
+        "filter":      nil,
+        "parent":      0,
+        "hasParent":   false,
+        "children":    []uint{},
+        "deadline":    nil,
+        "archived":    false,
     }
 
     return MergeResponse(defaultResponse, overrides)
@@ -937,15 +1437,55 @@ func StashRowToResponse(db *sqlx.DB, stashRow *StashRow) gin.H {
     var err error
 
     // TODO: error absorbed
-    cardsCount, err = CountCardsByStash(db, stashRow.ID)
+    cardsCount, err = CountCardsByStash(db, stashRow.ID)
+
+    if err != nil {
+        cardsCount = 0
+    }
+
+    var response gin.H = MergeResponse(&sr, &gin.H{
+        "cardsCount": cardsCount,
+    })
+
+    // TODO: error absorbed
+    filter, err := GetStashFilter(db, stashRow.ID)
+    if err == nil {
+        response = MergeResponse(&response, &gin.H{
+            "kind":   "smart",
+            "filter": filter,
+        })
+    }
+
+    // TODO: error absorbed
+    parentID, err := GetStashParent(db, stashRow.ID)
+    if err == nil {
+        response = MergeResponse(&response, &gin.H{
+            "parent":    parentID,
+            "hasParent": true,
+        })
+    }
+
+    // TODO: error absorbed
+    children, err := GetStashChildren(db, stashRow.ID)
+    if err == nil {
+        response = MergeResponse(&response, &gin.H{
+            "children": children,
+        })
+    }
 
-    if err != nil {
-        cardsCount = 0
+    // TODO: error absorbed
+    deadline, err := GetStashDeadline(db, stashRow.ID)
+    if err == nil {
+        deadlineResponse, err := StashDeadlineToResponse(db, deadline)
+        if err == nil {
+            response = MergeResponse(&response, &gin.H{
+                "deadline": deadlineResponse,
+                "archived": deadline.ArchivedAt != nil,
+            })
+        }
     }
 
-    return MergeResponse(&sr, &gin.H{
-        "cardsCount": cardsCount,
-    })
+    return response
 }
 
 func ValidateStashProps(props *StashProps) error {
@@ -957,7 +1497,7 @@ func ValidateStashProps(props *StashProps) error {
     return nil
 }
 
-func CreateStash(db *sqlx.DB, props *StashProps) (*StashRow, error) {
+func CreateStash(db sqlx.Ext, props *StashProps) (*StashRow, error) {
 
     var err error
 
@@ -996,7 +1536,7 @@ func CreateStash(db *sqlx.DB, props *StashProps) (*StashRow, error) {
     return GetStash(db, uint(insertID))
 }
 
-func GetStash(db *sqlx.DB, stashID uint) (*StashRow, error) {
+func GetStash(db sqlx.Ext, stashID uint) (*StashRow, error) {
 
     var (
         err   error
@@ -1023,7 +1563,7 @@ func GetStash(db *sqlx.DB, stashID uint) (*StashRow, error) {
     }
 }
 
-func DeleteStash(db *sqlx.DB, stashID uint) error {
+func DeleteStash(db sqlx.Ext, stashID uint) error {
 
     var (
         err   error
@@ -1031,6 +1571,20 @@ func DeleteStash(db *sqlx.DB, stashID uint) error {
         args  []interface{}
     )
 
+    // detach child stashes (and their subtrees) from the stash's ancestors
+    var children []uint
+    children, err = GetStashChildren(db, stashID)
+    if err != nil {
+        return err
+    }
+
+    for _, child := range children {
+        err = MoveStash(db, child, 0)
+        if err != nil {
+            return err
+        }
+    }
+
     query, args, err = QueryApply(DELETE_STASH_QUERY, &StringMap{"stash_id": stashID})
     if err != nil {
         return err
@@ -1044,7 +1598,144 @@ func DeleteStash(db *sqlx.DB, stashID uint) error {
     return nil
 }
 
-func ProcessCardWithStash(db *sqlx.DB, stashID uint, action string, cardID uint) error {
+func GetStashChildren(db sqlx.Ext, parentID uint) ([]uint, error) {
+
+    var (
+        err      error
+        query    string
+        args     []interface{}
+        children []uint = []uint{}
+    )
+
+    query, args, err = QueryApply(STASH_CHILDREN_QUERY, &StringMap{"parent": parentID})
+    if err != nil {
+        return nil, err
+    }
+
+    err = sqlx.Select(db, &children, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    return children, nil
+}
+
+func GetStashParent(db sqlx.Ext, childID uint) (uint, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(STASH_PARENT_QUERY, &StringMap{"child": childID})
+    if err != nil {
+        return 0, err
+    }
+
+    var sr *StashRelationship = &StashRelationship{}
+
+    err = db.QueryRowx(query, args...).StructScan(sr)
+
+    switch {
+    case err == sql.ErrNoRows:
+        return 0, ErrStashHasNoParent
+    case err != nil:
+        return 0, err
+    default:
+        return sr.Ancestor, nil
+    }
+}
+
+func CreateStashRelationship(db sqlx.Ext, parent uint, child uint) error {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(ASSOCIATE_STASH_AS_CHILD_QUERY, &StringMap{"parent": parent, "child": child})
+    if err != nil {
+        return err
+    }
+
+    _, err = db.Exec(query, args...)
+    if err != nil {
+        return err
+    }
+
+    return nil
+}
+
+// move the stash subtree under the new parent; or make it a top-level stash if newParent is 0
+func MoveStash(db sqlx.Ext, child uint, newParent uint) error {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    // delete subtree connections
+
+    query, args, err = QueryApply(SPLICE_STASH_SUBTREE_DELETE_QUERY, &StringMap{"child": child})
+    if err != nil {
+        return err
+    }
+
+    _, err = db.Exec(query, args...)
+    if err != nil {
+        return err
+    }
+
+    if newParent <= 0 {
+        return nil
+    }
+
+    // add new subtree connections
+
+    query, args, err = QueryApply(SPLICE_STASH_SUBTREE_ADD_QUERY, &StringMap{"child": child, "parent": newParent})
+    if err != nil {
+        return err
+    }
+
+    _, err = db.Exec(query, args...)
+    if err != nil {
+        return err
+    }
+
+    return nil
+}
+
+// test whether childID is parentID or within its subtree
+func StashHasDescendent(db sqlx.Ext, parentID uint, childID uint) (bool, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(TEST_STASH_LINEAGE_QUERY, &StringMap{
+        "parent":     parentID,
+        "descendent": childID,
+    })
+    if err != nil {
+        return false, err
+    }
+
+    var count int
+    err = db.QueryRowx(query, args...).Scan(&count)
+
+    if err != nil {
+        return false, err
+    }
+
+    return (count > 0), nil
+}
+
+func ProcessCardWithStash(db sqlx.Ext, stashID uint, action string, cardID uint) error {
 
     var (
         err       error
@@ -1059,6 +1750,15 @@ func ProcessCardWithStash(db *sqlx.DB, stashID uint, action string, cardID uint)
         return errors.New("unknown given action for ProcessCardWithStash")
     }
 
+    var smart bool
+    smart, err = StashIsSmart(db, stashID)
+    switch {
+    case err != nil:
+        return err
+    case smart:
+        return ErrStashIsSmart
+    }
+
     // check if card is connected with stash
     connected, err = CardConnectedWithStash(db, stashID, cardID)
 
@@ -1088,23 +1788,10 @@ func ProcessCardWithStash(db *sqlx.DB, stashID uint, action string, cardID uint)
             return err
         }
 
-        // case: if this was being reviewed
-        var fetchedRow *CachedStashReviewCardRow
-        fetchedRow, err = GetCachedReviewCardByStash(db, stashID)
-
-        switch {
-        case err == ErrStashHasNoCachedReviewCard:
-            // noop
-        case err != nil:
+        // case: if this was being reviewed, by any review mode
+        err = DeleteCachedReviewCardOfStash(db, stashID, cardID)
+        if err != nil {
             return err
-        case fetchedRow != nil:
-            if fetchedRow.Card == cardID {
-                err = DeleteCachedReviewCardByStash(db, stashID)
-
-                if err != nil {
-                    return err
-                }
-            }
         }
 
     }
@@ -1112,7 +1799,7 @@ func ProcessCardWithStash(db *sqlx.DB, stashID uint, action string, cardID uint)
     return nil
 }
 
-func CardConnectedWithStash(db *sqlx.DB, stashID uint, cardID uint) (bool, error) {
+func CardConnectedWithStash(db sqlx.Ext, stashID uint, cardID uint) (bool, error) {
 
     var (
         err   error
@@ -1138,7 +1825,34 @@ func CardConnectedWithStash(db *sqlx.DB, stashID uint, cardID uint) (bool, error
     return (count > 0), nil
 }
 
-func ConnectCardToStash(db *sqlx.DB, stashID uint, cardID uint) error {
+// test whether the card is within the stash or any of its descendent stashes
+func CardInStashTree(db sqlx.Ext, stashID uint, cardID uint) (bool, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(STASH_TREE_HAS_CARD_QUERY, &StringMap{
+        "stash_id": stashID,
+        "card_id":  cardID,
+    })
+    if err != nil {
+        return false, err
+    }
+
+    var count int
+    err = db.QueryRowx(query, args...).Scan(&count)
+
+    if err != nil {
+        return false, err
+    }
+
+    return (count > 0), nil
+}
+
+func ConnectCardToStash(db sqlx.Ext, stashID uint, cardID uint) error {
 
     var (
         err   error
@@ -1159,7 +1873,7 @@ func ConnectCardToStash(db *sqlx.DB, stashID uint, cardID uint) error {
     return nil
 }
 
-func DisconnectCardFromStash(db *sqlx.DB, stashID uint, cardID uint) error {
+func DisconnectCardFromStash(db sqlx.Ext, stashID uint, cardID uint) error {
 
     var (
         err   error
@@ -1260,30 +1974,47 @@ func GetNextReviewCardOfStash(db *sqlx.DB, stashID uint, _purgatory_size int) (*
         args    []interface{}
     )
 
-    var fetchedRow *CachedStashReviewCardRow
-    fetchedRow, err = GetCachedReviewCardByStash(db, stashID)
+    var cachedReviewCard *CardRow
+    cachedReviewCard, err = getCachedReviewCardOfStash(db, stashID, STASH_REVIEW_MODE_SCORE)
+    if err != nil {
+        return nil, err
+    }
 
+    if cachedReviewCard != nil {
+        return cachedReviewCard, nil
+    }
+
+    // no cached review card
+
+    // until the stash's deadline, spread reviews so that every card is reviewed
+    // at least min_reviews times
+    var deadline *StashDeadlineRow
+    deadline, err = GetStashDeadline(db, stashID)
     switch {
-    case err == ErrStashHasNoCachedReviewCard:
+    case err == ErrStashNoDeadline:
         // noop
     case err != nil:
         return nil, err
-    case fetchedRow != nil:
+    case StashDeadlineIsActive(deadline):
         var fetchedReviewCard *CardRow
-        fetchedReviewCard, err = GetCard(db, fetchedRow.Card)
+        fetchedReviewCard, err = GetNextDeadlineReviewCardOfStash(db, deadline)
         switch {
         case err == ErrCardNoSuchCard:
-            // card may have been deleted
-            break
+            // every card has been reviewed enough; choose as usual
         case err != nil:
             return nil, err
         default:
+
+            // cache card
+            err = SetCachedReviewCardByStash(db, stashID, fetchedReviewCard.ID, STASH_REVIEW_MODE_SCORE)
+            if err != nil {
+                return nil, err
+            }
+
             return fetchedReviewCard, nil
         }
     }
 
-    // no cached review card
-
     if _purgatory_size <= 0 {
         return nil, errors.New("invalid _purgatory_size")
     }
@@ -1341,7 +2072,7 @@ func GetNextReviewCardOfStash(db *sqlx.DB, stashID uint, _purgatory_size int) (*
     default:
 
         // cache card
-        err = SetCachedReviewCardByStash(db, stashID, fetchedReviewCard.ID)
+        err = SetCachedReviewCardByStash(db, stashID, fetchedReviewCard.ID, STASH_REVIEW_MODE_SCORE)
         if err != nil {
             return nil, err
         }
@@ -1350,7 +2081,47 @@ func GetNextReviewCardOfStash(db *sqlx.DB, stashID uint, _purgatory_size int) (*
     }
 }
 
-func GetCachedReviewCardByStash(db *sqlx.DB, stashID uint) (*CachedStashReviewCardRow, error) {
+// fetch the cached review card of the review mode if it's still within the stash; nil otherwise
+func getCachedReviewCardOfStash(db sqlx.Ext, stashID uint, mode string) (*CardRow, error) {
+
+    var err error
+
+    var fetchedRow *CachedStashReviewCardRow
+    fetchedRow, err = GetCachedReviewCardByStash(db, stashID, mode)
+
+    switch {
+    case err == ErrStashHasNoCachedReviewCard:
+        return nil, nil
+    case err != nil:
+        return nil, err
+    }
+
+    var fetchedReviewCard *CardRow
+    fetchedReviewCard, err = GetCard(db, fetchedRow.Card)
+    switch {
+    case err == ErrCardNoSuchCard:
+        // card may have been deleted
+        return nil, nil
+    case err != nil:
+        return nil, err
+    }
+
+    // card may no longer match the filter of a smart stash, or may have
+    // been within a child stash that was since moved
+    var connected bool
+    connected, err = CardInStashTree(db, stashID, fetchedReviewCard.ID)
+    if err != nil {
+        return nil, err
+    }
+
+    if !connected {
+        return nil, nil
+    }
+
+    return fetchedReviewCard, nil
+}
+
+func GetCachedReviewCardByStash(db sqlx.Ext, stashID uint, mode string) (*CachedStashReviewCardRow, error) {
 
     var (
         err   error
@@ -1360,6 +2131,7 @@ func GetCachedReviewCardByStash(db *sqlx.DB, stashID uint) (*CachedStashReviewCa
 
     query, args, err = QueryApply(GET_CACHED_REVIEWCARD_BY_STASH_QUERY, &StringMap{
         "stash_id": stashID,
+        "mode":     mode,
     })
     if err != nil {
         return nil, err
@@ -1380,26 +2152,23 @@ func GetCachedReviewCardByStash(db *sqlx.DB, stashID uint) (*CachedStashReviewCa
     }
 }
 
-func SetCachedReviewCardByStash(db *sqlx.DB, stashID uint, cardID uint) error {
-
-    var err error
-
-    err = DeleteCachedReviewCardByStash(db, stashID)
-    if err != nil {
-        return err
-    }
-
-    // insert record into db
+// cache the review card of the stash chosen by the review mode; replaces the cached review
+// card of the mode
+func SetCachedReviewCardByStash(db *sqlx.DB, stashID uint, cardID uint, mode string) error {
 
     var (
+        err   error
         query string
         args  []interface{}
     )
 
+    // insert record into db
+
     query, args, err = QueryApply(INSERT_CACHED_REVIEWCARD_BY_STASH_QUERY,
         &StringMap{
             "stash_id": stashID,
             "card_id":  cardID,
+            "mode":     mode,
         })
     if err != nil {
         return err
@@ -1413,7 +2182,7 @@ func SetCachedReviewCardByStash(db *sqlx.DB, stashID uint, cardID uint) error {
     return nil
 }
 
-func DeleteCachedReviewCardByStash(db *sqlx.DB, stashID uint) error {
+func DeleteCachedReviewCardByStash(db sqlx.Ext, stashID uint) error {
 
     var (
         err   error
@@ -1434,7 +2203,32 @@ func DeleteCachedReviewCardByStash(db *sqlx.DB, stashID uint) error {
     return nil
 }
 
-func DeleteCachedStashReviewCardByCard(db *sqlx.DB, cardID uint) error {
+// uncache the card as the review card of the stash, of any review mode
+func DeleteCachedReviewCardOfStash(db sqlx.Ext, stashID uint, cardID uint) error {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(DELETE_CACHED_REVIEWCARD_BY_STASH_CARD_QUERY, &StringMap{
+        "stash_id": stashID,
+        "card_id":  cardID,
+    })
+    if err != nil {
+        return err
+    }
+
+    _, err = db.Exec(query, args...)
+    if err != nil {
+        return err
+    }
+
+    return nil
+}
+
+func DeleteCachedStashReviewCardByCard(db sqlx.Ext, cardID uint) error {
 
     var (
         err   error
@@ -1477,7 +2271,7 @@ func CountStashes(db *sqlx.DB) (uint, error) {
     return count, nil
 }
 
-func StashList(db *sqlx.DB) (*([]StashRow), error) {
+func StashList(db *sqlx.DB, includeArchived bool) (*([]StashRow), error) {
 
     var (
         err   error
@@ -1495,7 +2289,12 @@ func StashList(db *sqlx.DB) (*([]StashRow), error) {
         return nil, ErrStashNoStashes
     }
 
-    query, args, err = QueryApply(FETCH_STASHES_QUERY)
+    var fetchStashes PipeInput = FETCH_UNARCHIVED_STASHES_QUERY
+    if includeArchived {
+        fetchStashes = FETCH_STASHES_QUERY
+    }
+
+    query, args, err = QueryApply(fetchStashes)
     if err != nil {
         return nil, err
     }
@@ -1513,7 +2312,7 @@ func StashList(db *sqlx.DB) (*([]StashRow), error) {
     return &stashes, nil
 }
 
-func StashesByCard(db *sqlx.DB, cardID uint) ([]uint, error) {
+func StashesByCard(db sqlx.Ext, cardID uint) ([]uint, error) {
 
     var (
         err   error
@@ -1542,3 +2341,26 @@ func StashesByCard(db *sqlx.DB, cardID uint) ([]uint, error) {
 
     return cards, nil
 }
+
+// fetch every card within the stash
+func AllCardsByStash(db sqlx.Ext, stashID uint) ([]CardRow, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(FETCH_ALL_CARDS_BY_STASH_QUERY, &StringMap{"stash_id": stashID})
+    if err != nil {
+        return nil, err
+    }
+
+    var cards []CardRow = []CardRow{}
+    err = sqlx.Select(db, &cards, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    return cards, nil
+}
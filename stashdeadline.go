@@ -0,0 +1,470 @@
+package main
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "math"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    // 3rd-party
+    "github.com/gin-gonic/gin"
+    "github.com/jmoiron/sqlx"
+)
+
+/* variables */
+
+// errors
+var ErrStashNoDeadline = errors.New("stashes: stash has no deadline")
+var ErrStashDeadlinePassed = errors.New("stashes: deadline must be in the future")
+
+/* types */
+
+type StashDeadlineRow struct {
+    Stash      uint   `db:"stash"`
+    Deadline   int64  `db:"deadline"`
+    MinReviews uint   `db:"min_reviews"`
+    CreatedAt  int64  `db:"created_at"`
+    ArchivedAt *int64 `db:"archived_at"`
+}
+
+type StashDeadlineProgressRow struct {
+    Cards            uint `db:"cards"`
+    CardsDone        uint `db:"cards_done"`
+    RemainingReviews uint `db:"remaining_reviews"`
+}
+
+type StashDeadlineRequest struct {
+    Deadline   int64 `json:"deadline" binding:"required"`
+    MinReviews uint  `json:"min_reviews"`
+}
+
+/* REST Handlers */
+
+// PUT /stashes/:id/deadline
+//
+// Set or move the deadline of a stash. Until the deadline, reviewing the stash spreads
+// reviews across its cards so that each card is reviewed at least min_reviews times.
+// Once the deadline passes, the stash is archived.
+//
+// Params:
+// id: a unique, positive integer that is the identifier of the stash
+//
+// Input:
+// deadline: unix timestamp in the future
+// min_reviews: number of times each card is to be reviewed before the deadline (default: 1)
+func StashDeadlinePUT(db *sqlx.DB, ctx *gin.Context) {
+
+    var err error
+
+    // parse and validate id param
+    var stashIDString string = strings.ToLower(ctx.Param("id"))
+
+    _stashID, err := strconv.ParseUint(stashIDString, 10, 32)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "given id is invalid",
+        })
+        ctx.Error(err)
+        return
+    }
+    var stashID uint = uint(_stashID)
+
+    var jsonRequest StashDeadlineRequest
+    err = ctx.BindJSON(&jsonRequest)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "bad JSON input",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    err = ValidateStashDeadline(&jsonRequest)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      err.Error(),
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var fetchedStashRow *StashRow
+    fetchedStashRow, err = GetStash(db, stashID)
+    switch {
+    case err == ErrStashNoSuchStash:
+        ctx.JSON(http.StatusNotFound, gin.H{
+            "status":           http.StatusNotFound,
+            "developerMessage": err.Error(),
+            "userMessage":      "cannot find stash by id",
+        })
+        ctx.Error(err)
+        return
+    case err != nil:
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve stash",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var tx *sqlx.Tx
+    tx, err = db.Beginx()
+    if err == nil {
+        err = SetStashDeadline(tx, stashID, &jsonRequest)
+        if err == nil {
+            // the cached review card may have been chosen without the deadline
+            err = DeleteCachedReviewCardByStash(tx, stashID)
+        }
+        if err == nil {
+            err = tx.Commit()
+        } else {
+            tx.Rollback()
+        }
+    }
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to set stash deadline",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    ctx.JSON(http.StatusOK, StashRowToResponse(db, fetchedStashRow))
+}
+
+// DELETE /stashes/:id/deadline
+//
+// Remove the deadline of a stash; unarchiving the stash.
+//
+// Params:
+// id: a unique, positive integer that is the identifier of the stash
+func StashDeadlineDELETE(db *sqlx.DB, ctx *gin.Context) {
+
+    var err error
+
+    // parse and validate id param
+    var stashIDString string = strings.ToLower(ctx.Param("id"))
+
+    _stashID, err := strconv.ParseUint(stashIDString, 10, 32)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "given id is invalid",
+        })
+        ctx.Error(err)
+        return
+    }
+    var stashID uint = uint(_stashID)
+
+    _, err = GetStashDeadline(db, stashID)
+    switch {
+    case err == ErrStashNoDeadline:
+        ctx.JSON(http.StatusNotFound, gin.H{
+            "status":           http.StatusNotFound,
+            "developerMessage": err.Error(),
+            "userMessage":      "cannot find deadline of stash",
+        })
+        ctx.Error(err)
+        return
+    case err != nil:
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve stash deadline",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var tx *sqlx.Tx
+    tx, err = db.Beginx()
+    if err == nil {
+        err = DeleteStashDeadline(tx, stashID)
+        if err == nil {
+            err = DeleteCachedReviewCardByStash(tx, stashID)
+        }
+        if err == nil {
+            err = tx.Commit()
+        } else {
+            tx.Rollback()
+        }
+    }
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to delete stash deadline",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    ctx.Writer.WriteHeader(http.StatusNoContent)
+}
+
+/* helpers */
+
+// validate and normalize deadline in-place
+func ValidateStashDeadline(request *StashDeadlineRequest) error {
+
+    if request.Deadline <= time.Now().Unix() {
+        return ErrStashDeadlinePassed
+    }
+
+    if request.MinReviews <= 0 {
+        request.MinReviews = 1
+    }
+
+    return nil
+}
+
+func GetStashDeadline(db sqlx.Ext, stashID uint) (*StashDeadlineRow, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(FETCH_STASH_DEADLINE_QUERY, &StringMap{"stash_id": stashID})
+    if err != nil {
+        return nil, err
+    }
+
+    var fetchedDeadline *StashDeadlineRow = &StashDeadlineRow{}
+
+    err = db.QueryRowx(query, args...).StructScan(fetchedDeadline)
+
+    switch {
+    case err == sql.ErrNoRows:
+        return nil, ErrStashNoDeadline
+    case err != nil:
+        return nil, err
+    default:
+        return fetchedDeadline, nil
+    }
+}
+
+// set the deadline of the stash. the deadline is assumed to be validated.
+func SetStashDeadline(db sqlx.Ext, stashID uint, request *StashDeadlineRequest) error {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(SET_STASH_DEADLINE_QUERY, &StringMap{
+        "stash_id":    stashID,
+        "deadline":    request.Deadline,
+        "min_reviews": request.MinReviews,
+    })
+    if err != nil {
+        return err
+    }
+
+    _, err = db.Exec(query, args...)
+    if err != nil {
+        return err
+    }
+
+    return nil
+}
+
+func DeleteStashDeadline(db sqlx.Ext, stashID uint) error {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(DELETE_STASH_DEADLINE_QUERY, &StringMap{"stash_id": stashID})
+    if err != nil {
+        return err
+    }
+
+    _, err = db.Exec(query, args...)
+    if err != nil {
+        return err
+    }
+
+    return nil
+}
+
+// periodically archive the stashes of the profile in use whose deadline has passed
+func RunStashDeadlines(profiles *Profiles, interval time.Duration) {
+
+    for {
+        profiles.Use(func(db *Database) {
+            err := ArchiveExpiredStashes(db.instance)
+            if err != nil {
+                fmt.Println("stash archive:", err)
+            }
+        })
+
+        time.Sleep(interval)
+    }
+}
+
+// archive stashes whose deadline has passed
+func ArchiveExpiredStashes(db sqlx.Ext) error {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(ARCHIVE_EXPIRED_STASHES_QUERY)
+    if err != nil {
+        return err
+    }
+
+    _, err = db.Exec(query, args...)
+    if err != nil {
+        return err
+    }
+
+    return nil
+}
+
+// an active deadline is one that has yet to pass
+func StashDeadlineIsActive(deadline *StashDeadlineRow) bool {
+    return deadline.ArchivedAt == nil && deadline.Deadline > time.Now().Unix()
+}
+
+// reviews are behind when fewer of the required reviews were done than the share of
+// the time elapsed since the deadline was set; this spreads reviews evenly up to the
+// deadline instead of front-loading them
+func StashDeadlineIsBehind(deadline *StashDeadlineRow, progress *StashDeadlineProgressRow, now int64) bool {
+
+    var totalReviews uint = progress.Cards * deadline.MinReviews
+    if progress.RemainingReviews <= 0 || totalReviews <= 0 {
+        return false
+    }
+
+    var duration int64 = deadline.Deadline - deadline.CreatedAt
+    if duration <= 0 || now >= deadline.Deadline {
+        return true
+    }
+
+    var elapsed float64 = float64(now-deadline.CreatedAt) / float64(duration)
+    var dueReviews uint = uint(math.Ceil(float64(totalReviews) * math.Max(elapsed, 0)))
+    var doneReviews uint = totalReviews - progress.RemainingReviews
+
+    return doneReviews < dueReviews
+}
+
+func GetStashDeadlineProgress(db sqlx.Ext, deadline *StashDeadlineRow) (*StashDeadlineProgressRow, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(STASH_DEADLINE_PROGRESS_QUERY, &StringMap{
+        "stash_id":    deadline.Stash,
+        "since":       deadline.CreatedAt,
+        "min_reviews": deadline.MinReviews,
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    var progress *StashDeadlineProgressRow = &StashDeadlineProgressRow{}
+
+    err = db.QueryRowx(query, args...).StructScan(progress)
+    if err != nil {
+        return nil, err
+    }
+
+    return progress, nil
+}
+
+// fetch the next card to be reviewed before the deadline; ErrCardNoSuchCard if the
+// reviews are on pace to meet the deadline, or if every card has been reviewed at
+// least min_reviews times
+func GetNextDeadlineReviewCardOfStash(db sqlx.Ext, deadline *StashDeadlineRow) (*CardRow, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    progress, err := GetStashDeadlineProgress(db, deadline)
+    if err != nil {
+        return nil, err
+    }
+
+    if !StashDeadlineIsBehind(deadline, progress, time.Now().Unix()) {
+        return nil, ErrCardNoSuchCard
+    }
+
+    query, args, err = QueryApply(FETCH_NEXT_REVIEW_CARD_BY_STASH_DEADLINE_QUERY, &StringMap{
+        "stash_id":    deadline.Stash,
+        "since":       deadline.CreatedAt,
+        "min_reviews": deadline.MinReviews,
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    var fetchedReviewCard *CardRow = &CardRow{}
+
+    err = db.QueryRowx(query, args...).StructScan(fetchedReviewCard)
+
+    switch {
+    case err == sql.ErrNoRows:
+        return nil, ErrCardNoSuchCard
+    case err != nil:
+        return nil, err
+    default:
+        return fetchedReviewCard, nil
+    }
+}
+
+func StashDeadlineToResponse(db sqlx.Ext, deadline *StashDeadlineRow) (gin.H, error) {
+
+    var response gin.H = gin.H{
+        "deadline":    deadline.Deadline,
+        "min_reviews": deadline.MinReviews,
+        "created_at":  deadline.CreatedAt,
+        "archived_at": deadline.ArchivedAt,
+    }
+
+    progress, err := GetStashDeadlineProgress(db, deadline)
+    if err != nil {
+        return nil, err
+    }
+
+    response["cards_done"] = progress.CardsDone
+    response["remaining_reviews"] = progress.RemainingReviews
+
+    // pace of reviews needed to meet the deadline
+    if StashDeadlineIsActive(deadline) {
+        var daysLeft float64 = float64(deadline.Deadline-time.Now().Unix()) / (24 * 60 * 60)
+        response["reviews_per_day"] = uint(math.Ceil(float64(progress.RemainingReviews) / math.Max(daysLeft, 1)))
+    } else {
+        response["reviews_per_day"] = 0
+    }
+
+    return response, nil
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+    "database/sql"
+    "errors"
+    "net/http"
+    "strconv"
+    "strings"
+
+    // 3rd-party
+    "github.com/gin-gonic/gin"
+    "github.com/jmoiron/sqlx"
+)
+
+/* variables */
+
+// errors
+var ErrStashCardNotInStash = errors.New("stashes: card is not within the stash")
+var ErrStashDuplicateCards = errors.New("stashes: cards must not be repeated")
+var ErrStashInvalidPosition = errors.New("stashes: position must be within [1, number of cards in the stash]")
+var ErrStashNoReviewCursor = errors.New("stashes: stash has not been reviewed in sequence")
+
+// review modes of a stash
+const (
+    STASH_REVIEW_MODE_SCORE      string = "score"
+    STASH_REVIEW_MODE_SEQUENTIAL string = "sequential"
+)
+
+/* types */
+
+type StashOrderRequest struct {
+    Cards []uint `json:"cards" binding:"required"`
+}
+
+type StashCardPositionRequest struct {
+    Position uint `json:"position" binding:"required"`
+}
+
+/* REST Handlers */
+
+// PUT /stashes/:id/order
+//
+// Reorder cards of a manual stash. The given cards are moved to the front of the stash
+// in the given order; remaining cards follow in their current order.
+//
+// Params:
+// id: a unique, positive integer that is the identifier of the stash
+//
+// Input:
+// cards: list of card ids within the stash
+func StashOrderPUT(db *sqlx.DB, ctx *gin.Context) {
+
+    var err error
+
+    // parse and validate id param
+    var stashIDString string = strings.ToLower(ctx.Param("id"))
+
+    _stashID, err := strconv.ParseUint(stashIDString, 10, 32)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "given id is invalid",
+        })
+        ctx.Error(err)
+        return
+    }
+    var stashID uint = uint(_stashID)
+
+    var jsonRequest StashOrderRequest
+    err = ctx.BindJSON(&jsonRequest)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "bad JSON input",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var tx *sqlx.Tx
+    tx, err = db.Beginx()
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to reorder stash",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var current []uint
+    current, err = orderedStashCards(tx, stashID)
+    if err != nil {
+        tx.Rollback()
+        respondStashOrderError(ctx, err)
+        return
+    }
+
+    var order []uint
+    order, err = ReorderStashCards(current, jsonRequest.Cards)
+    if err != nil {
+        tx.Rollback()
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      err.Error(),
+        })
+        ctx.Error(err)
+        return
+    }
+
+    err = SetStashCardOrder(tx, stashID, order)
+    if err == nil {
+        err = tx.Commit()
+    } else {
+        tx.Rollback()
+    }
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to reorder stash",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    ctx.JSON(http.StatusOK, gin.H{
+        "cards": order,
+    })
+}
+
+// PATCH /stashes/:id/cards/:card
+//
+// Move a card of a manual stash to the given position; shifting cards in between.
+//
+// Params:
+// id: a unique, positive integer that is the identifier of the stash
+// card: a unique, positive integer that is the identifier of the card within the stash
+//
+// Input:
+// position: 1-based position of the card within the stash
+func StashCardPositionPATCH(db *sqlx.DB, ctx *gin.Context) {
+
+    var err error
+
+    // parse and validate id param
+    var stashIDString string = strings.ToLower(ctx.Param("id"))
+
+    _stashID, err := strconv.ParseUint(stashIDString, 10, 32)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "given id is invalid",
+        })
+        ctx.Error(err)
+        return
+    }
+    var stashID uint = uint(_stashID)
+
+    // parse and validate card param
+    var cardIDString string = strings.ToLower(ctx.Param("card"))
+
+    _cardID, err := strconv.ParseUint(cardIDString, 10, 32)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "given card id is invalid",
+        })
+        ctx.Error(err)
+        return
+    }
+    var cardID uint = uint(_cardID)
+
+    var jsonRequest StashCardPositionRequest
+    err = ctx.BindJSON(&jsonRequest)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "bad JSON input",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var tx *sqlx.Tx
+    tx, err = db.Beginx()
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to move card within stash",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var current []uint
+    current, err = orderedStashCards(tx, stashID)
+    if err != nil {
+        tx.Rollback()
+        respondStashOrderError(ctx, err)
+        return
+    }
+
+    var order []uint
+    order, err = MoveStashCard(current, cardID, jsonRequest.Position)
+    switch {
+    case err == ErrStashCardNotInStash:
+        tx.Rollback()
+        ctx.JSON(http.StatusNotFound, gin.H{
+            "status":           http.StatusNotFound,
+            "developerMessage": err.Error(),
+            "userMessage":      "cannot find card within stash",
+        })
+        ctx.Error(err)
+        return
+    case err != nil:
+        tx.Rollback()
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      err.Error(),
+        })
+        ctx.Error(err)
+        return
+    }
+
+    err = SetStashCardOrder(tx, stashID, order)
+    if err == nil {
+        err = tx.Commit()
+    } else {
+        tx.Rollback()
+    }
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to move card within stash",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    ctx.JSON(http.StatusOK, gin.H{
+        "cards": order,
+    })
+}
+
+/* helpers */
+
+// fetch cards of a manual stash by position; for reordering
+func orderedStashCards(db sqlx.Ext, stashID uint) ([]uint, error) {
+
+    var err error
+
+    _, err = GetStash(db, stashID)
+    if err != nil {
+        return nil, err
+    }
+
+    var isSmart bool
+    isSmart, err = StashIsSmart(db, stashID)
+    if err != nil {
+        return nil, err
+    }
+
+    if isSmart {
+        return nil, ErrStashIsSmart
+    }
+
+    return StashCardIDsByPosition(db, stashID)
+}
+
+func respondStashOrderError(ctx *gin.Context, err error) {
+
+    switch {
+    case err == ErrStashNoSuchStash:
+        ctx.JSON(http.StatusNotFound, gin.H{
+            "status":           http.StatusNotFound,
+            "developerMessage": err.Error(),
+            "userMessage":      "cannot find stash by id",
+        })
+    case err == ErrStashIsSmart:
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      err.Error(),
+        })
+    default:
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve cards of stash",
+        })
+    }
+
+    ctx.Error(err)
+}
+
+// cards added directly into the stash; by position
+func StashCardIDsByPosition(db sqlx.Ext, stashID uint) ([]uint, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(FETCH_STASH_CARD_IDS_BY_POSITION_QUERY, &StringMap{"stash_id": stashID})
+    if err != nil {
+        return nil, err
+    }
+
+    var cardIDs []uint = []uint{}
+    err = sqlx.Select(db, &cardIDs, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    return cardIDs, nil
+}
+
+// move the given cards to the front in the given order; remaining cards follow in their
+// current order
+func ReorderStashCards(current []uint, front []uint) ([]uint, error) {
+
+    var inStash map[uint]bool = cardIDSet(current)
+    var moved map[uint]bool = make(map[uint]bool, len(front))
+
+    for _, cardID := range front {
+
+        if !inStash[cardID] {
+            return nil, ErrStashCardNotInStash
+        }
+
+        if moved[cardID] {
+            return nil, ErrStashDuplicateCards
+        }
+
+        moved[cardID] = true
+    }
+
+    var order []uint = make([]uint, 0, len(current))
+    order = append(order, front...)
+
+    for _, cardID := range current {
+        if !moved[cardID] {
+            order = append(order, cardID)
+        }
+    }
+
+    return order, nil
+}
+
+// move the card to the 1-based position
+func MoveStashCard(current []uint, cardID uint, position uint) ([]uint, error) {
+
+    var order []uint = make([]uint, 0, len(current))
+
+    for _, currentCardID := range current {
+        if currentCardID != cardID {
+            order = append(order, currentCardID)
+        }
+    }
+
+    if len(order) == len(current) {
+        return nil, ErrStashCardNotInStash
+    }
+
+    if position <= 0 || position > uint(len(current)) {
+        return nil, ErrStashInvalidPosition
+    }
+
+    order = append(order, 0)
+    copy(order[position:], order[position-1:])
+    order[position-1] = cardID
+
+    return order, nil
+}
+
+// renumber positions of the stash's cards from 1 in the given order
+func SetStashCardOrder(db sqlx.Ext, stashID uint, order []uint) error {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    for idx, cardID := range order {
+
+        query, args, err = QueryApply(SET_STASH_CARD_POSITION_QUERY, &StringMap{
+            "stash_id": stashID,
+            "card_id":  cardID,
+            "position": idx + 1,
+        })
+        if err != nil {
+            return err
+        }
+
+        _, err = db.Exec(query, args...)
+        if err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+func GetStashReviewCursor(db sqlx.Ext, stashID uint) (uint, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(FETCH_STASH_REVIEW_CURSOR_QUERY, &StringMap{"stash_id": stashID})
+    if err != nil {
+        return 0, err
+    }
+
+    var cardID uint
+    err = db.QueryRowx(query, args...).Scan(&cardID)
+
+    switch {
+    case err == sql.ErrNoRows:
+        return 0, ErrStashNoReviewCursor
+    case err != nil:
+        return 0, err
+    default:
+        return cardID, nil
+    }
+}
+
+func SetStashReviewCursor(db sqlx.Ext, stashID uint, cardID uint) error {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(SET_STASH_REVIEW_CURSOR_QUERY, &StringMap{
+        "stash_id": stashID,
+        "card_id":  cardID,
+    })
+    if err != nil {
+        return err
+    }
+
+    _, err = db.Exec(query, args...)
+    if err != nil {
+        return err
+    }
+
+    return nil
+}
+
+// fetch the card after the last card served in sequence; wrapping around after the
+// last card. if the last card served is no longer within the stash, the sequence starts over.
+func GetNextSequentialReviewCardOfStash(db *sqlx.DB, stashID uint) (*CardRow, error) {
+
+    var err error
+
+    var cachedReviewCard *CardRow
+    cachedReviewCard, err = getCachedReviewCardOfStash(db, stashID, STASH_REVIEW_MODE_SEQUENTIAL)
+    if err != nil {
+        return nil, err
+    }
+
+    if cachedReviewCard != nil {
+        return cachedReviewCard, nil
+    }
+
+    var (
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(FETCH_REVIEW_CARD_IDS_BY_STASH_SEQUENCE_QUERY, &StringMap{"stash_id": stashID})
+    if err != nil {
+        return nil, err
+    }
+
+    var cardIDs []uint = []uint{}
+    err = sqlx.Select(db, &cardIDs, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    if len(cardIDs) <= 0 {
+        return nil, ErrCardNoSuchCard
+    }
+
+    var cursor uint
+    cursor, err = GetStashReviewCursor(db, stashID)
+    if err != nil && err != ErrStashNoReviewCursor {
+        return nil, err
+    }
+
+    var next uint = cardIDs[0]
+    for idx, cardID := range cardIDs {
+        if cardID == cursor {
+            next = cardIDs[(idx+1)%len(cardIDs)]
+            break
+        }
+    }
+
+    err = SetStashReviewCursor(db, stashID, next)
+    if err != nil {
+        return nil, err
+    }
+
+    // cache card
+    err = SetCachedReviewCardByStash(db, stashID, next, STASH_REVIEW_MODE_SEQUENTIAL)
+    if err != nil {
+        return nil, err
+    }
+
+    return GetCard(db, next)
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+
+    // 3rd-party
+    "github.com/gin-gonic/gin"
+    "github.com/jmoiron/sqlx"
+)
+
+/* variables */
+
+// errors
+var ErrStashSetInvalidOp = errors.New("stash sets: op must be one of: union, intersection, difference")
+var ErrStashSetInvalidOperand = errors.New("stash sets: each expression must be exactly one of: stash, deck, or an op with args")
+var ErrStashSetNoArgs = errors.New("stash sets: op must have at least one arg")
+
+// operations over sets of cards
+const (
+    STASH_SET_UNION        string = "union"
+    STASH_SET_INTERSECTION string = "intersection"
+    STASH_SET_DIFFERENCE   string = "difference"
+)
+
+/* types */
+
+// set expression over cards of stashes and deck subtrees. an expression is either a
+// stash, a deck, or an op over other expressions; e.g. cards in stash 1 that are also
+// in deck 2 but not in stash 3:
+//
+// {"op": "difference", "args": [
+//     {"op": "intersection", "args": [{"stash": 1}, {"deck": 2}]},
+//     {"stash": 3}
+// ]}
+type StashSetExpr struct {
+    Op    string         `json:"op"`
+    Args  []StashSetExpr `json:"args"`
+    Stash uint           `json:"stash"`
+    Deck  uint           `json:"deck"`
+}
+
+type StashSetSaveRequest struct {
+    Name        string `json:"name"`
+    Description string `json:"description"`
+}
+
+type StashSetRequest struct {
+    Expr *StashSetExpr        `json:"expr" binding:"required"`
+    Save *StashSetSaveRequest `json:"save"`
+}
+
+/* REST Handlers */
+
+// POST /stashes/query
+//
+// Evaluate a set expression over the cards of stashes and deck subtrees. Cards are
+// listed by id; unless save is given, in which case the cards are saved into a new stash.
+//
+// Input:
+// expr: set expression; see StashSetExpr
+// save: if given, create a (manual) stash with the name and description and add the
+//       resulting cards into it
+//
+// Query params:
+// page: page of cards (default: 1)
+// per_page: number of cards per page (default: 25)
+func StashSetQueryPOST(db *sqlx.DB, ctx *gin.Context) {
+
+    var (
+        err         error
+        jsonRequest StashSetRequest
+    )
+
+    // parse page query
+    var pageQueryString string = ctx.DefaultQuery("page", "1")
+    _page, err := strconv.ParseUint(pageQueryString, 10, 32)
+    if err != nil || _page <= 0 {
+        err = errors.New("given page query param is invalid")
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "given page query param is invalid",
+        })
+        ctx.Error(err)
+        return
+    }
+    var page uint = uint(_page)
+
+    // parse per_page query
+    var perpageQueryString string = ctx.DefaultQuery("per_page", "25")
+    _per_page, err := strconv.ParseUint(perpageQueryString, 10, 32)
+    if err != nil || _per_page <= 0 {
+        err = errors.New("given per_page query param is invalid")
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "given per_page query param is invalid",
+        })
+        ctx.Error(err)
+        return
+    }
+    var per_page uint = uint(_per_page)
+
+    err = ctx.BindJSON(&jsonRequest)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "bad JSON input",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    err = ValidateStashSetExpr(jsonRequest.Expr)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      err.Error(),
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var tx *sqlx.Tx
+    tx, err = db.Beginx()
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to evaluate set expression",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var cardIDs []uint
+    cardIDs, err = EvaluateStashSetExpr(tx, jsonRequest.Expr)
+    switch {
+    case err == ErrStashNoSuchStash || err == ErrDeckNoSuchDeck:
+        tx.Rollback()
+        ctx.JSON(http.StatusNotFound, gin.H{
+            "status":           http.StatusNotFound,
+            "developerMessage": err.Error(),
+            "userMessage":      "cannot find stash or deck of set expression",
+        })
+        ctx.Error(err)
+        return
+    case err != nil:
+        tx.Rollback()
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to evaluate set expression",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    // materialise cards into a new stash
+
+    if jsonRequest.Save != nil {
+
+        var newStashRow *StashRow
+        newStashRow, err = CreateStash(tx, &StashProps{
+            Name:        jsonRequest.Save.Name,
+            Description: jsonRequest.Save.Description,
+        })
+        if err != nil {
+            tx.Rollback()
+            ctx.JSON(http.StatusBadRequest, gin.H{
+                "status":           http.StatusBadRequest,
+                "developerMessage": err.Error(),
+                "userMessage":      "unable to create new stash",
+            })
+            ctx.Error(err)
+            return
+        }
+
+        for _, cardID := range cardIDs {
+            err = ConnectCardToStash(tx, newStashRow.ID, cardID)
+            if err != nil {
+                break
+            }
+        }
+
+        if err == nil {
+            err = tx.Commit()
+        } else {
+            tx.Rollback()
+        }
+
+        if err != nil {
+            ctx.JSON(http.StatusInternalServerError, gin.H{
+                "status":           http.StatusInternalServerError,
+                "developerMessage": err.Error(),
+                "userMessage":      "unable to save cards into new stash",
+            })
+            ctx.Error(err)
+            return
+        }
+
+        ctx.JSON(http.StatusCreated, StashRowToResponse(db, newStashRow))
+        return
+    }
+
+    // list page of cards
+
+    var total uint = uint(len(cardIDs))
+    var offset uint = (page - 1) * per_page
+
+    if total > 0 && offset >= total {
+        tx.Rollback()
+        err = ErrCardPageOutOfBounds
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "page is out of bound",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var end uint = offset + per_page
+    if end > total {
+        end = total
+    }
+
+    var response []gin.H = []gin.H{}
+
+    if offset < total {
+        for _, cardID := range cardIDs[offset:end] {
+
+            var fetchedCardRow *CardRow
+            fetchedCardRow, err = GetCard(tx, cardID)
+            if err != nil {
+                break
+            }
+
+            var fetchedCardScore *CardScoreRow
+            fetchedCardScore, err = GetCardScoreRecord(tx, cardID)
+            if err != nil {
+                break
+            }
+
+            var fetchedStashes []uint
+            fetchedStashes, err = StashesByCard(tx, cardID)
+            if err != nil {
+                break
+            }
+
+            var cardrow gin.H = CardRowToResponse(db, fetchedCardRow)
+            var cardscore gin.H = CardScoreToResponse(fetchedCardScore)
+
+            response = append(response, MergeResponses(
+                &cardrow,
+                &gin.H{"review": cardscore},
+                &gin.H{"stashes": fetchedStashes},
+            ))
+        }
+    }
+
+    tx.Rollback()
+
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve cards",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    ctx.JSON(http.StatusOK, gin.H{
+        "total":    total,
+        "page":     page,
+        "per_page": per_page,
+        "cards":    response,
+    })
+}
+
+/* helpers */
+
+// validate and normalize expression in-place
+func ValidateStashSetExpr(expr *StashSetExpr) error {
+
+    var operands int = 0
+
+    if expr.Stash > 0 {
+        operands++
+    }
+
+    if expr.Deck > 0 {
+        operands++
+    }
+
+    expr.Op = strings.ToLower(strings.TrimSpace(expr.Op))
+
+    if len(expr.Op) > 0 || len(expr.Args) > 0 {
+        operands++
+    }
+
+    if operands != 1 {
+        return ErrStashSetInvalidOperand
+    }
+
+    if expr.Stash > 0 || expr.Deck > 0 {
+        return nil
+    }
+
+    switch expr.Op {
+    case STASH_SET_UNION:
+    case STASH_SET_INTERSECTION:
+    case STASH_SET_DIFFERENCE:
+    default:
+        return ErrStashSetInvalidOp
+    }
+
+    if len(expr.Args) <= 0 {
+        return ErrStashSetNoArgs
+    }
+
+    for idx := range expr.Args {
+        err := ValidateStashSetExpr(&expr.Args[idx])
+        if err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// evaluate the expression into ids of cards; in ascending order.
+// the expression is assumed to be validated.
+func EvaluateStashSetExpr(db sqlx.Ext, expr *StashSetExpr) ([]uint, error) {
+
+    var (
+        err error
+        set map[uint]bool
+    )
+
+    set, err = evaluateStashSetExpr(db, expr)
+    if err != nil {
+        return nil, err
+    }
+
+    var cardIDs []uint = make([]uint, 0, len(set))
+    for cardID := range set {
+        cardIDs = append(cardIDs, cardID)
+    }
+
+    sort.Slice(cardIDs, func(i, j int) bool {
+        return cardIDs[i] < cardIDs[j]
+    })
+
+    return cardIDs, nil
+}
+
+func evaluateStashSetExpr(db sqlx.Ext, expr *StashSetExpr) (map[uint]bool, error) {
+
+    var err error
+
+    var cardIDs []uint
+
+    switch {
+    case expr.Stash > 0:
+
+        _, err = GetStash(db, expr.Stash)
+        if err != nil {
+            return nil, err
+        }
+
+        cardIDs, err = CardIDsByFilter(db, 0, expr.Stash, "", "")
+        if err != nil {
+            return nil, err
+        }
+
+        return cardIDSet(cardIDs), nil
+
+    case expr.Deck > 0:
+
+        _, err = GetDeck(db, expr.Deck)
+        if err != nil {
+            return nil, err
+        }
+
+        cardIDs, err = CardIDsByFilter(db, expr.Deck, 0, "", "")
+        if err != nil {
+            return nil, err
+        }
+
+        return cardIDSet(cardIDs), nil
+    }
+
+    var result map[uint]bool
+
+    for idx := range expr.Args {
+
+        var set map[uint]bool
+        set, err = evaluateStashSetExpr(db, &expr.Args[idx])
+        if err != nil {
+            return nil, err
+        }
+
+        if idx == 0 {
+            result = set
+            continue
+        }
+
+        switch expr.Op {
+        case STASH_SET_UNION:
+            for cardID := range set {
+                result[cardID] = true
+            }
+        case STASH_SET_INTERSECTION:
+            for cardID := range result {
+                if !set[cardID] {
+                    delete(result, cardID)
+                }
+            }
+        case STASH_SET_DIFFERENCE:
+            for cardID := range set {
+                delete(result, cardID)
+            }
+        default:
+            return nil, fmt.Errorf("stash sets: unknown op: %s", expr.Op)
+        }
+    }
+
+    return result, nil
+}
+
+func cardIDSet(cardIDs []uint) map[uint]bool {
+
+    var set map[uint]bool = make(map[uint]bool, len(cardIDs))
+    for _, cardID := range cardIDs {
+        set[cardID] = true
+    }
+
+    return set
+}
@@ -0,0 +1,1101 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    // 3rd-party
+    "github.com/gin-gonic/gin"
+    "github.com/jmoiron/sqlx"
+)
+
+/* variables */
+
+// errors
+var ErrTrashNoSuchTrash = errors.New("trash: no such trashed item of given id")
+var ErrTrashInvalidRetention = errors.New("trash: retention must be a non-negative integer of days")
+
+// number of days trashed items are kept before they're purged; 0 keeps them forever
+const CONFIG_TRASH_RETENTION string = "trash_retention_days"
+const DEFAULT_TRASH_RETENTION_DAYS uint64 = 30
+
+const TRASH_KIND_CARD string = "card"
+const TRASH_KIND_DECK string = "deck"
+
+/* types */
+
+type TrashRow struct {
+    ID        uint   `db:"trash_id"`
+    Kind      string `db:"kind"`
+    Item      uint   `db:"item"`
+    Title     string `db:"title"`
+    Parent    uint   `db:"parent"`
+    Snapshot  string `db:"snapshot"`
+    DeletedAt int64  `db:"deleted_at"`
+}
+
+// snapshot of a card and its associated rows
+type CardSnapshot struct {
+    ID          uint                       `json:"id"`
+    Title       string                     `json:"title"`
+    Description string                     `json:"description"`
+    Front       string                     `json:"front"`
+    Back        string                     `json:"back"`
+    Deck        uint                       `json:"deck"`
+    CreatedAt   int64                      `json:"created_at"`
+    UpdatedAt   int64                      `json:"updated_at"`
+    Score       CardScoreSnapshot          `json:"score"`
+    History     []CardScoreHistorySnapshot `json:"history"`
+    Stashes     []StashLinkSnapshot        `json:"stashes"`
+    Tags        []string                   `json:"tags"`
+    SuspendedAt int64                      `json:"suspended_at"` // 0 if not suspended
+    Revisions   []CardRevisionRow          `json:"revisions"`
+    Backlinks   []uint                     `json:"backlinks"` // cards linking to the card
+}
+
+type CardScoreSnapshot struct {
+    Success       int     `db:"success" json:"success"`
+    Fail          int     `db:"fail" json:"fail"`
+    Score         float64 `db:"score" json:"score"`
+    TimesReviewed int64   `db:"times_reviewed" json:"times_reviewed"`
+    UpdatedAt     int64   `db:"updated_at" json:"updated_at"`
+    Changelog     string  `db:"changelog" json:"changelog"`
+}
+
+type CardScoreHistorySnapshot struct {
+    OccuredAt int64   `db:"occured_at" json:"occured_at"`
+    Success   int     `db:"success" json:"success"`
+    Fail      int     `db:"fail" json:"fail"`
+    Score     float64 `db:"score" json:"score"`
+    Changelog string  `db:"changelog" json:"changelog"`
+}
+
+type StashLinkSnapshot struct {
+    Stash   uint  `db:"stash" json:"stash"`
+    AddedAt int64 `db:"added_at" json:"added_at"`
+
+    // 1-based position of the card within the stash; nil for cards trashed before it was kept
+    Position *uint `db:"position" json:"position"`
+}
+
+// snapshot of a deck subtree; decks are ordered such that parents come before their children
+type DeckTreeSnapshot struct {
+    Decks []DeckSnapshot `json:"decks"`
+    Cards []CardSnapshot `json:"cards"`
+}
+
+type DeckSnapshot struct {
+    ID          uint   `db:"deck_id" json:"id"`
+    Name        string `db:"name" json:"name"`
+    Description string `db:"description" json:"description"`
+    Parent      uint   `db:"parent" json:"parent"`
+}
+
+/* REST Handlers */
+
+// GET /trash
+//
+// List trashed cards and decks; most recently deleted first.
+// Expired items are purged beforehand.
+func TrashListGET(db *sqlx.DB, ctx *gin.Context) {
+
+    var err error
+
+    _, err = PurgeExpiredTrash(db)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to purge expired trash",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var retentionDays uint64
+    retentionDays, err = GetTrashRetentionDays(db)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve trash retention",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var trashRows []TrashRow
+    trashRows, err = TrashList(db)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve trash",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var response []gin.H = make([]gin.H, 0, len(trashRows))
+
+    for idx := range trashRows {
+        response = append(response, TrashRowToResponse(&trashRows[idx], retentionDays))
+    }
+
+    ctx.JSON(http.StatusOK, response)
+}
+
+// POST /trash/:id/restore
+//
+// Restore trashed card or deck subtree to its original location, along with its review
+// history. If the original location no longer exists, it's restored into the root deck.
+//
+// Params:
+// id: a unique, positive integer that is the identifier of the trashed item
+func TrashRestorePOST(db *sqlx.DB, ctx *gin.Context) {
+
+    var err error
+
+    // parse and validate id param
+    var trashIDString string = strings.ToLower(ctx.Param("id"))
+
+    _trashID, err := strconv.ParseUint(trashIDString, 10, 32)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "given id is invalid",
+        })
+        ctx.Error(err)
+        return
+    }
+    var trashID uint = uint(_trashID)
+
+    var rootDeck *DeckRow
+    rootDeck, err = GetRootDeck(db)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve root deck",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var tx *sqlx.Tx
+    tx, err = db.Beginx()
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to begin restore",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var trashRow *TrashRow
+    trashRow, err = GetTrash(tx, trashID)
+    switch {
+    case err == ErrTrashNoSuchTrash:
+        tx.Rollback()
+        ctx.JSON(http.StatusNotFound, gin.H{
+            "status":           http.StatusNotFound,
+            "developerMessage": err.Error(),
+            "userMessage":      "cannot find trashed item by id",
+        })
+        ctx.Error(err)
+        return
+    case err != nil:
+        tx.Rollback()
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve trashed item",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    var (
+        itemID    uint
+        parentID  uint
+        relocated bool
+    )
+
+    itemID, parentID, relocated, err = RestoreTrash(tx, trashRow, rootDeck.ID)
+    if err != nil {
+        tx.Rollback()
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to restore trashed item",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    err = tx.Commit()
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to restore trashed item",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    ctx.JSON(http.StatusOK, gin.H{
+        "kind":      trashRow.Kind,
+        "id":        itemID,
+        "parent":    parentID,
+        "relocated": relocated,
+    })
+}
+
+// DELETE /trash/:id
+//
+// Permanently delete trashed item.
+//
+// Params:
+// id: a unique, positive integer that is the identifier of the trashed item
+func TrashDELETE(db *sqlx.DB, ctx *gin.Context) {
+
+    var err error
+
+    // parse and validate id param
+    var trashIDString string = strings.ToLower(ctx.Param("id"))
+
+    _trashID, err := strconv.ParseUint(trashIDString, 10, 32)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, gin.H{
+            "status":           http.StatusBadRequest,
+            "developerMessage": err.Error(),
+            "userMessage":      "given id is invalid",
+        })
+        ctx.Error(err)
+        return
+    }
+    var trashID uint = uint(_trashID)
+
+    _, err = GetTrash(db, trashID)
+    switch {
+    case err == ErrTrashNoSuchTrash:
+        ctx.JSON(http.StatusNotFound, gin.H{
+            "status":           http.StatusNotFound,
+            "developerMessage": err.Error(),
+            "userMessage":      "cannot find trashed item by id",
+        })
+        ctx.Error(err)
+        return
+    case err != nil:
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to retrieve trashed item",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    err = PurgeTrash(db, trashID)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to purge trashed item",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    // success
+    ctx.Writer.WriteHeader(http.StatusNoContent)
+}
+
+// DELETE /trash
+//
+// Permanently delete every trashed item.
+func TrashEmptyDELETE(db *sqlx.DB, ctx *gin.Context) {
+
+    var err error
+
+    err = EmptyTrash(db)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, gin.H{
+            "status":           http.StatusInternalServerError,
+            "developerMessage": err.Error(),
+            "userMessage":      "unable to empty trash",
+        })
+        ctx.Error(err)
+        return
+    }
+
+    // success
+    ctx.Writer.WriteHeader(http.StatusNoContent)
+}
+
+/* helpers */
+
+func TrashRowToResponse(trashRow *TrashRow, retentionDays uint64) gin.H {
+
+    // 0 if never expires
+    var expiresAt int64 = 0
+    if retentionDays > 0 {
+        expiresAt = trashRow.DeletedAt + int64(retentionDays)*24*60*60
+    }
+
+    return gin.H{
+        "id":         trashRow.ID,
+        "kind":       trashRow.Kind,
+        "item":       trashRow.Item,
+        "title":      trashRow.Title,
+        "parent":     trashRow.Parent,
+        "deleted_at": trashRow.DeletedAt,
+        "expires_at": expiresAt,
+    }
+}
+
+// periodically purge the trash of the profile in use
+func RunTrashPurge(profiles *Profiles, interval time.Duration) {
+
+    for {
+        profiles.Use(func(db *Database) {
+            _, err := PurgeExpiredTrash(db.instance)
+            if err != nil {
+                fmt.Println("trash purge:", err)
+            }
+        })
+
+        time.Sleep(interval)
+    }
+}
+
+func GetTrashRetentionDays(db *sqlx.DB) (uint64, error) {
+
+    config, err := GetConfig(db, CONFIG_TRASH_RETENTION)
+    switch {
+    case err == ErrConfigNoSuchSetting:
+        return DEFAULT_TRASH_RETENTION_DAYS, nil
+    case err != nil:
+        return 0, err
+    }
+
+    days, err := strconv.ParseUint(strings.TrimSpace(config.Value), 10, 32)
+    if err != nil {
+        return 0, ErrTrashInvalidRetention
+    }
+
+    return days, nil
+}
+
+// purge trashed items older than the retention period; returns number of purged items
+func PurgeExpiredTrash(db *sqlx.DB) (int64, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+        res   sql.Result
+    )
+
+    var retentionDays uint64
+    retentionDays, err = GetTrashRetentionDays(db)
+    if err != nil {
+        return 0, err
+    }
+
+    // keep forever
+    if retentionDays <= 0 {
+        return 0, nil
+    }
+
+    query, args, err = QueryApply(DELETE_EXPIRED_TRASH_QUERY, &StringMap{
+        "retention": retentionDays * 24 * 60 * 60,
+    })
+    if err != nil {
+        return 0, err
+    }
+
+    res, err = db.Exec(query, args...)
+    if err != nil {
+        return 0, err
+    }
+
+    return res.RowsAffected()
+}
+
+func GetTrash(db sqlx.Ext, trashID uint) (*TrashRow, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(FETCH_TRASH_QUERY, &StringMap{"trash_id": trashID})
+    if err != nil {
+        return nil, err
+    }
+
+    var fetchedTrash *TrashRow = &TrashRow{}
+
+    err = db.QueryRowx(query, args...).StructScan(fetchedTrash)
+
+    switch {
+    case err == sql.ErrNoRows:
+        return nil, ErrTrashNoSuchTrash
+    case err != nil:
+        return nil, err
+    default:
+        return fetchedTrash, nil
+    }
+}
+
+// list trashed items; snapshots are not fetched
+func TrashList(db sqlx.Ext) ([]TrashRow, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(FETCH_TRASH_LIST_QUERY)
+    if err != nil {
+        return nil, err
+    }
+
+    var trashRows []TrashRow = []TrashRow{}
+    err = sqlx.Select(db, &trashRows, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    return trashRows, nil
+}
+
+func PurgeTrash(db sqlx.Ext, trashID uint) error {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(DELETE_TRASH_QUERY, &StringMap{"trash_id": trashID})
+    if err != nil {
+        return err
+    }
+
+    _, err = db.Exec(query, args...)
+    if err != nil {
+        return err
+    }
+
+    return nil
+}
+
+func EmptyTrash(db sqlx.Ext) error {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    query, args, err = QueryApply(DELETE_ALL_TRASH_QUERY)
+    if err != nil {
+        return err
+    }
+
+    _, err = db.Exec(query, args...)
+    if err != nil {
+        return err
+    }
+
+    return nil
+}
+
+// move card into the trash
+func TrashCard(db sqlx.Ext, cardID uint) (*TrashRow, error) {
+
+    var err error
+
+    var card *CardRow
+    card, err = GetCard(db, cardID)
+    if err != nil {
+        return nil, err
+    }
+
+    var snapshot *CardSnapshot
+    snapshot, err = SnapshotCard(db, card)
+    if err != nil {
+        return nil, err
+    }
+
+    var trashRow *TrashRow
+    trashRow, err = createTrash(db, TRASH_KIND_CARD, card.ID, card.Title, card.Deck, snapshot)
+    if err != nil {
+        return nil, err
+    }
+
+    err = DeleteCard(db, cardID)
+    if err != nil {
+        return nil, err
+    }
+
+    return trashRow, nil
+}
+
+// move deck subtree, and the cards within it, into the trash
+func TrashDeck(db sqlx.Ext, deckID uint) (*TrashRow, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    var snapshot *DeckTreeSnapshot = &DeckTreeSnapshot{
+        Decks: []DeckSnapshot{},
+        Cards: []CardSnapshot{},
+    }
+
+    query, args, err = QueryApply(FETCH_DECK_SUBTREE_QUERY, &StringMap{"deck_id": deckID})
+    if err != nil {
+        return nil, err
+    }
+
+    err = sqlx.Select(db, &snapshot.Decks, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    if len(snapshot.Decks) <= 0 {
+        return nil, ErrDeckNoSuchDeck
+    }
+
+    var cards []CardRow
+    cards, err = AllCardsByDeck(db, deckID)
+    if err != nil {
+        return nil, err
+    }
+
+    for idx := range cards {
+        var cardSnapshot *CardSnapshot
+        cardSnapshot, err = SnapshotCard(db, &cards[idx])
+        if err != nil {
+            return nil, err
+        }
+
+        snapshot.Cards = append(snapshot.Cards, *cardSnapshot)
+    }
+
+    var topDeck DeckSnapshot = snapshot.Decks[0]
+
+    var trashRow *TrashRow
+    trashRow, err = createTrash(db, TRASH_KIND_DECK, topDeck.ID, topDeck.Name, topDeck.Parent, snapshot)
+    if err != nil {
+        return nil, err
+    }
+
+    err = DeleteDeck(db, deckID)
+    if err != nil {
+        return nil, err
+    }
+
+    return trashRow, nil
+}
+
+func createTrash(db sqlx.Ext, kind string, itemID uint, title string, parentID uint, snapshot interface{}) (*TrashRow, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+        res   sql.Result
+    )
+
+    rawSnapshot, err := json.Marshal(snapshot)
+    if err != nil {
+        return nil, err
+    }
+
+    query, args, err = QueryApply(CREATE_TRASH_QUERY, &StringMap{
+        "kind":     kind,
+        "item":     itemID,
+        "title":    title,
+        "parent":   parentID,
+        "snapshot": string(rawSnapshot),
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    res, err = db.Exec(query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    insertID, err := res.LastInsertId()
+    if err != nil {
+        return nil, err
+    }
+
+    return GetTrash(db, uint(insertID))
+}
+
+// snapshot card along with its score, review history, stashes, tags, suspension and revisions
+func SnapshotCard(db sqlx.Ext, card *CardRow) (*CardSnapshot, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+    )
+
+    var snapshot *CardSnapshot = &CardSnapshot{
+        ID:          card.ID,
+        Title:       card.Title,
+        Description: card.Description,
+        Front:       card.Front,
+        Back:        card.Back,
+        Deck:        card.Deck,
+        CreatedAt:   card.CreatedAt,
+        UpdatedAt:   card.UpdatedAt,
+        History:     []CardScoreHistorySnapshot{},
+        Stashes:     []StashLinkSnapshot{},
+    }
+
+    var cardArgs *StringMap = &StringMap{"card_id": card.ID}
+
+    // score
+    query, args, err = QueryApply(FETCH_FULL_CARD_SCORE_QUERY, cardArgs)
+    if err != nil {
+        return nil, err
+    }
+
+    err = db.QueryRowx(query, args...).StructScan(&snapshot.Score)
+    switch {
+    case err == sql.ErrNoRows:
+        return nil, ErrCardHasNoScore
+    case err != nil:
+        return nil, err
+    }
+
+    // review history
+    query, args, err = QueryApply(FETCH_CARD_SCORE_HISTORY_QUERY, cardArgs)
+    if err != nil {
+        return nil, err
+    }
+
+    err = sqlx.Select(db, &snapshot.History, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    // stashes
+    query, args, err = QueryApply(FETCH_STASH_LINKS_BY_CARD_QUERY, cardArgs)
+    if err != nil {
+        return nil, err
+    }
+
+    err = sqlx.Select(db, &snapshot.Stashes, query, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    // tags
+    snapshot.Tags, err = CardTags(db, card.ID)
+    if err != nil {
+        return nil, err
+    }
+
+    // revisions
+    snapshot.Revisions, err = CardRevisions(db, card.ID)
+    if err != nil {
+        return nil, err
+    }
+
+    // cards linking to the card
+    snapshot.Backlinks, err = CardBacklinkIDs(db, card.ID)
+    if err != nil {
+        return nil, err
+    }
+
+    // suspension
+    query, args, err = QueryApply(FETCH_CARD_SUSPENDED_AT_QUERY, cardArgs)
+    if err != nil {
+        return nil, err
+    }
+
+    err = db.QueryRowx(query, args...).Scan(&snapshot.SuspendedAt)
+    switch {
+    case err == sql.ErrNoRows:
+        snapshot.SuspendedAt = 0
+    case err != nil:
+        return nil, err
+    }
+
+    return snapshot, nil
+}
+
+// restore trashed item and remove it from the trash.
+// returns id of the restored card or deck, the deck it was restored into, and whether
+// it was restored into the root deck since its original location no longer exists.
+//
+// original ids are kept unless they've since been taken.
+func RestoreTrash(db sqlx.Ext, trashRow *TrashRow, rootID uint) (uint, uint, bool, error) {
+
+    var (
+        err       error
+        itemID    uint
+        parentID  uint = trashRow.Parent
+        relocated bool = false
+    )
+
+    // ensure original location exists
+    _, err = GetDeck(db, parentID)
+    switch {
+    case err == ErrDeckNoSuchDeck:
+        parentID = rootID
+        relocated = true
+    case err != nil:
+        return 0, 0, false, err
+    }
+
+    switch trashRow.Kind {
+    case TRASH_KIND_CARD:
+
+        var snapshot CardSnapshot
+        err = json.Unmarshal([]byte(trashRow.Snapshot), &snapshot)
+        if err != nil {
+            return 0, 0, false, err
+        }
+
+        itemID, err = restoreCardSnapshot(db, &snapshot, parentID)
+        if err != nil {
+            return 0, 0, false, err
+        }
+
+    case TRASH_KIND_DECK:
+
+        var snapshot DeckTreeSnapshot
+        err = json.Unmarshal([]byte(trashRow.Snapshot), &snapshot)
+        if err != nil {
+            return 0, 0, false, err
+        }
+
+        // map original deck ids onto restored deck ids
+        var deckIDs map[uint]uint = map[uint]uint{}
+
+        for idx, deck := range snapshot.Decks {
+
+            var deckParent uint = deckIDs[deck.Parent]
+            if idx == 0 {
+                deckParent = parentID
+            }
+
+            var newDeckID uint
+            newDeckID, err = restoreDeckSnapshot(db, &deck, deckParent)
+            if err != nil {
+                return 0, 0, false, err
+            }
+
+            deckIDs[deck.ID] = newDeckID
+        }
+
+        for idx := range snapshot.Cards {
+            var card *CardSnapshot = &snapshot.Cards[idx]
+
+            _, err = restoreCardSnapshot(db, card, deckIDs[card.Deck])
+            if err != nil {
+                return 0, 0, false, err
+            }
+        }
+
+        if len(snapshot.Decks) > 0 {
+            itemID = deckIDs[snapshot.Decks[0].ID]
+        }
+
+    default:
+        return 0, 0, false, errors.New(fmt.Sprintf("trash: unknown kind of trashed item: %s", trashRow.Kind))
+    }
+
+    err = PurgeTrash(db, trashRow.ID)
+    if err != nil {
+        return 0, 0, false, err
+    }
+
+    return itemID, parentID, relocated, nil
+}
+
+func restoreDeckSnapshot(db sqlx.Ext, deck *DeckSnapshot, parentID uint) (uint, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+        res   sql.Result
+    )
+
+    // keep original id unless it's taken
+    var deckID interface{} = deck.ID
+    _, err = GetDeck(db, deck.ID)
+    switch {
+    case err == ErrDeckNoSuchDeck:
+    case err != nil:
+        return 0, err
+    default:
+        deckID = nil
+    }
+
+    query, args, err = QueryApply(RESTORE_DECK_QUERY, &StringMap{
+        "deck_id":     deckID,
+        "name":        deck.Name,
+        "description": deck.Description,
+    })
+    if err != nil {
+        return 0, err
+    }
+
+    res, err = db.Exec(query, args...)
+    if err != nil {
+        return 0, err
+    }
+
+    insertID, err := res.LastInsertId()
+    if err != nil {
+        return 0, err
+    }
+
+    err = CreateDeckRelationship(db, parentID, uint(insertID))
+    if err != nil {
+        return 0, err
+    }
+
+    return uint(insertID), nil
+}
+
+func restoreCardSnapshot(db sqlx.Ext, card *CardSnapshot, deckID uint) (uint, error) {
+
+    var (
+        err   error
+        query string
+        args  []interface{}
+        res   sql.Result
+    )
+
+    // keep original id unless it's taken
+    var cardID interface{} = card.ID
+    _, err = GetCard(db, card.ID)
+    switch {
+    case err == ErrCardNoSuchCard:
+    case err != nil:
+        return 0, err
+    default:
+        cardID = nil
+    }
+
+    query, args, err = QueryApply(RESTORE_CARD_QUERY, &StringMap{
+        "card_id":     cardID,
+        "title":       card.Title,
+        "description": card.Description,
+        "front":       card.Front,
+        "back":        card.Back,
+        "deck":        deckID,
+        "created_at":  card.CreatedAt,
+        "updated_at":  card.UpdatedAt,
+    })
+    if err != nil {
+        return 0, err
+    }
+
+    res, err = db.Exec(query, args...)
+    if err != nil {
+        return 0, err
+    }
+
+    _newCardID, err := res.LastInsertId()
+    if err != nil {
+        return 0, err
+    }
+    var newCardID uint = uint(_newCardID)
+
+    err = SyncCardMedia(db, newCardID)
+    if err != nil {
+        return 0, err
+    }
+
+    err = SyncCardLinks(db, newCardID)
+    if err != nil {
+        return 0, err
+    }
+
+    // links to the card's original id now belong to the card that has since taken it;
+    // only the cards that linked to the card before it was trashed are retargeted
+    if newCardID != card.ID {
+        _, err = RetargetCardLinksFrom(db, card.ID, newCardID, card.Backlinks)
+        if err != nil {
+            return 0, err
+        }
+    }
+
+    // score
+    query, args, err = QueryApply(RESTORE_CARD_SCORE_QUERY, &StringMap{
+        "card_id":        newCardID,
+        "success":        card.Score.Success,
+        "fail":           card.Score.Fail,
+        "score":          card.Score.Score,
+        "times_reviewed": card.Score.TimesReviewed,
+        "updated_at":     card.Score.UpdatedAt,
+        "changelog":      card.Score.Changelog,
+    })
+    if err != nil {
+        return 0, err
+    }
+
+    _, err = db.Exec(query, args...)
+    if err != nil {
+        return 0, err
+    }
+
+    // review history
+    for _, entry := range card.History {
+        query, args, err = QueryApply(RESTORE_CARD_SCORE_HISTORY_QUERY, &StringMap{
+            "card_id":    newCardID,
+            "occured_at": entry.OccuredAt,
+            "success":    entry.Success,
+            "fail":       entry.Fail,
+            "score":      entry.Score,
+            "changelog":  entry.Changelog,
+        })
+        if err != nil {
+            return 0, err
+        }
+
+        _, err = db.Exec(query, args...)
+        if err != nil {
+            return 0, err
+        }
+    }
+
+    // stashes
+    for _, link := range card.Stashes {
+        query, args, err = QueryApply(RESTORE_STASH_LINK_QUERY, &StringMap{
+            "card_id":  newCardID,
+            "stash_id": link.Stash,
+            "added_at": link.AddedAt,
+        })
+        if err != nil {
+            return 0, err
+        }
+
+        var res sql.Result
+        res, err = db.Exec(query, args...)
+        if err != nil {
+            return 0, err
+        }
+
+        var num int64
+        num, err = res.RowsAffected()
+        if err != nil {
+            return 0, err
+        }
+
+        // the card is appended to the stash; move it back to where it was
+        if num <= 0 || link.Position == nil || *link.Position <= 0 {
+            continue
+        }
+
+        err = restoreStashCardPosition(db, link.Stash, newCardID, *link.Position)
+        if err != nil {
+            return 0, err
+        }
+    }
+
+    // tags
+    for _, tag := range card.Tags {
+        _, err = SetCardTag(db, newCardID, tag, true)
+        if err != nil {
+            return 0, err
+        }
+    }
+
+    // revisions; oldest first
+    for idx := len(card.Revisions) - 1; idx >= 0; idx-- {
+        var revision *CardRevisionRow = &card.Revisions[idx]
+
+        query, args, err = QueryApply(RESTORE_CARD_REVISION_QUERY, &StringMap{
+            "card_id":     newCardID,
+            "title":       revision.Title,
+            "description": revision.Description,
+            "front":       revision.Front,
+            "back":        revision.Back,
+            "updated_at":  revision.UpdatedAt,
+            "revised_at":  revision.RevisedAt,
+        })
+        if err != nil {
+            return 0, err
+        }
+
+        _, err = db.Exec(query, args...)
+        if err != nil {
+            return 0, err
+        }
+    }
+
+    // suspension
+    if card.SuspendedAt > 0 {
+        query, args, err = QueryApply(RESTORE_CARD_SUSPENDED_QUERY, &StringMap{
+            "card_id":      newCardID,
+            "suspended_at": card.SuspendedAt,
+        })
+        if err != nil {
+            return 0, err
+        }
+
+        _, err = db.Exec(query, args...)
+        if err != nil {
+            return 0, err
+        }
+    }
+
+    return newCardID, nil
+}
+
+// move a restored card of the stash back to its position; or to the end, if the stash now
+// has fewer cards
+func restoreStashCardPosition(db sqlx.Ext, stashID uint, cardID uint, position uint) error {
+
+    var (
+        err     error
+        current []uint
+    )
+
+    current, err = StashCardIDsByPosition(db, stashID)
+    if err != nil {
+        return err
+    }
+
+    if position > uint(len(current)) {
+        position = uint(len(current))
+    }
+
+    var order []uint
+    order, err = MoveStashCard(current, cardID, position)
+    if err != nil {
+        return err
+    }
+
+    return SetStashCardOrder(db, stashID, order)
+}